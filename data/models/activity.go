@@ -0,0 +1,82 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Actions limited by the posting quotas
+const (
+	ActivityPost         = "post"
+	ActivityComment      = "comment"
+	ActivityConversation = "conversation"
+)
+
+// Activity counts the recent contents of a user
+type Activity struct {
+	Count  int
+	Oldest time.Time // the oldest content counted, when the first slot frees up
+}
+
+// activityQueries select the date of each content of a user (?1) since a
+// datetime modifier (?2)
+var activityQueries = map[string]string{
+	ActivityPost:    `SELECT createDate AS at FROM post WHERE authorID = ?1 AND createDate > datetime('now', ?2)`,
+	ActivityComment: `SELECT createDate AS at FROM comment WHERE authorID = ?1 AND createDate > datetime('now', ?2)`,
+	// A conversation is started by the user when they sent its first message
+	ActivityConversation: `SELECT MIN(m.createDate) AS at FROM message m WHERE m.senderID = ?1 GROUP BY m.receiverID
+		HAVING at > datetime('now', ?2) AND NOT EXISTS (
+			SELECT 1 FROM message r WHERE r.senderID = m.receiverID AND r.receiverID = ?1 AND r.createDate < at)`,
+}
+
+// duplicateQueries select the contents of a user (?1) with the same text (?3)
+var duplicateQueries = map[string]string{
+	ContentPost:    `SELECT createDate AS at FROM post WHERE authorID = ?1 AND createDate > datetime('now', ?2) AND description = ?3`,
+	ContentComment: `SELECT createDate AS at FROM comment WHERE authorID = ?1 AND createDate > datetime('now', ?2) AND text = ?3`,
+	ContentMessage: `SELECT createDate AS at FROM message WHERE senderID = ?1 AND createDate > datetime('now', ?2) AND content = ?3`,
+}
+
+type ActivityRepository struct {
+	db *sql.DB
+}
+
+func NewActivityRepository(db *sql.DB) *ActivityRepository {
+	return &ActivityRepository{
+		db: db,
+	}
+}
+
+// Recent counts the contents a user created for an action within a window
+func (ar *ActivityRepository) Recent(action, userID string, window time.Duration) (Activity, error) {
+	return ar.count(activityQueries[action], userID, window)
+}
+
+// Duplicates counts the contents of a user with the same text within a window
+func (ar *ActivityRepository) Duplicates(contentType, userID, text string, window time.Duration) (Activity, error) {
+	return ar.count(duplicateQueries[contentType], userID, window, text)
+}
+
+// HasConversation checks if two users already exchanged a message
+func (ar *ActivityRepository) HasConversation(userID, otherID string) (bool, error) {
+	var count int
+	row := ar.db.QueryRow(`SELECT COUNT(*) FROM message
+		WHERE (senderID = ?1 AND receiverID = ?2) OR (senderID = ?2 AND receiverID = ?1)`, userID, otherID)
+	err := row.Scan(&count)
+	return count > 0, err
+}
+
+func (ar *ActivityRepository) count(query, userID string, window time.Duration, args ...interface{}) (Activity, error) {
+	var activity Activity
+	var oldest int64
+	row := ar.db.QueryRow(`SELECT COUNT(*), COALESCE(CAST(strftime('%s', MIN(at)) AS INTEGER), 0) FROM (`+query+`)`,
+		append([]interface{}{userID, secondsAgo(window)}, args...)...)
+	if err := row.Scan(&activity.Count, &oldest); err != nil {
+		return activity, err
+	}
+	if activity.Count > 0 {
+		activity.Oldest = time.Unix(oldest, 0)
+	}
+	return activity, nil
+}
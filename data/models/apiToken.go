@@ -0,0 +1,195 @@
+package models
+
+import (
+	"database/sql"
+	"real-time-forum/lib"
+	"strings"
+	"time"
+
+	uuid "github.com/gofrs/uuid"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// APITokenPrefix starts every API token, so that a leaked one is recognized
+const APITokenPrefix = "rtf_"
+
+// TokenScopes are the permissions an API token may carry. The others, like
+// managing the account or moderating, need a session.
+var TokenScopes = []Permission{PermissionRead, PermissionPost, PermissionMessage}
+
+// tokenUseInterval is the least time between two updates of the last use of
+// a token, so that a busy script doesn't write on every request
+const tokenUseInterval = time.Minute
+
+// APIToken is a personal token authenticating the scripts of a user, or a bot
+type APIToken struct {
+	ID         string       `json:"id"`
+	UserID     string       `json:"userID"`
+	Name       string       `json:"name"`
+	Scopes     []Permission `json:"scopes"`
+	ExpireAt   *time.Time   `json:"expireAt"`
+	LastUsedAt *time.Time   `json:"lastUsedAt"`
+	LastUsedIP string       `json:"lastUsedIP"`
+	CreateDate time.Time    `json:"createDate"`
+}
+
+// HasScope checks if the token was granted a permission
+func (t *APIToken) HasScope(permission Permission) bool {
+	for _, scope := range t.Scopes {
+		if scope == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// IsValidScope checks if a permission may be granted to a token
+func IsValidScope(permission Permission) bool {
+	for _, scope := range TokenScopes {
+		if scope == permission {
+			return true
+		}
+	}
+	return false
+}
+
+type APITokenRepository struct {
+	db *sql.DB
+}
+
+func NewAPITokenRepository(db *sql.DB) *APITokenRepository {
+	return &APITokenRepository{
+		db: db,
+	}
+}
+
+// CreateToken hands out a token acting as a user with some scopes, until it
+// expires or forever with a zero ttl. Only its hash is stored.
+func (tr *APITokenRepository) CreateToken(userID, name string, scopes []Permission, ttl time.Duration) (string, *APIToken, error) {
+	secret, _, err := lib.NewToken()
+	if err != nil {
+		return "", nil, err
+	}
+	ID, err := uuid.NewV4()
+	if err != nil {
+		return "", nil, err
+	}
+	token := APITokenPrefix + secret
+	apiToken := &APIToken{ID: ID.String(), UserID: userID, Name: name, Scopes: scopes, CreateDate: time.Now()}
+	var expireAt sql.NullString // datetime('now', NULL) is NULL, never expiring
+	if ttl != 0 {
+		expiry := time.Now().Add(ttl)
+		apiToken.ExpireAt = &expiry
+		expireAt = sql.NullString{String: secondsFromNow(ttl), Valid: true}
+	}
+	_, err = tr.db.Exec("INSERT INTO api_token (id, userID, name, tokenHash, scopes, expireAt) VALUES (?, ?, ?, ?, ?, datetime('now', ?))",
+		apiToken.ID, userID, name, lib.HashToken(token), joinScopes(scopes), expireAt)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, apiToken, nil
+}
+
+// GetTokens lists the tokens of a user, expired ones included
+func (tr *APITokenRepository) GetTokens(userID string) ([]APIToken, error) {
+	rows, err := tr.db.Query(`SELECT id, userID, name, scopes,
+		COALESCE(CAST(strftime('%s', expireAt) AS INTEGER), 0),
+		COALESCE(CAST(strftime('%s', lastUsedAt) AS INTEGER), 0),
+		COALESCE(lastUsedIP, ''),
+		COALESCE(CAST(strftime('%s', createDate) AS INTEGER), 0)
+		FROM api_token WHERE userID = ? ORDER BY createDate DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tokens := []APIToken{}
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *token)
+	}
+	return tokens, rows.Err()
+}
+
+// CountTokens counts the tokens of a user
+func (tr *APITokenRepository) CountTokens(userID string) (int, error) {
+	var count int
+	err := tr.db.QueryRow("SELECT COUNT(*) FROM api_token WHERE userID = ?", userID).Scan(&count)
+	return count, err
+}
+
+// DeleteToken revokes a token of a user, returning false if they have none
+// with this ID
+func (tr *APITokenRepository) DeleteToken(tokenID, userID string) (bool, error) {
+	result, err := tr.db.Exec("DELETE FROM api_token WHERE id = ? AND userID = ?", tokenID, userID)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// Authenticate returns the unexpired token matching a secret, nil if there
+// is none, recording that it was used from an IP
+func (tr *APITokenRepository) Authenticate(token, ip string) (*APIToken, error) {
+	row := tr.db.QueryRow(`SELECT id, userID, name, scopes,
+		COALESCE(CAST(strftime('%s', expireAt) AS INTEGER), 0),
+		COALESCE(CAST(strftime('%s', lastUsedAt) AS INTEGER), 0),
+		COALESCE(lastUsedIP, ''),
+		COALESCE(CAST(strftime('%s', createDate) AS INTEGER), 0)
+		FROM api_token WHERE tokenHash = ? AND (expireAt IS NULL OR expireAt > datetime('now'))`, lib.HashToken(token))
+	apiToken, err := scanAPIToken(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if apiToken.LastUsedAt == nil || time.Since(*apiToken.LastUsedAt) >= tokenUseInterval || apiToken.LastUsedIP != ip {
+		_, err = tr.db.Exec("UPDATE api_token SET lastUsedAt = CURRENT_TIMESTAMP, lastUsedIP = ? WHERE id = ?", ip, apiToken.ID)
+	}
+	return apiToken, err
+}
+
+// GetTokenUserID returns the user of an unexpired token, an empty string if
+// there is none. Unlike Authenticate, it doesn't record the use.
+func (tr *APITokenRepository) GetTokenUserID(token string) string {
+	var userID string
+	tr.db.QueryRow("SELECT userID FROM api_token WHERE tokenHash = ? AND (expireAt IS NULL OR expireAt > datetime('now'))",
+		lib.HashToken(token)).Scan(&userID)
+	return userID
+}
+
+func scanAPIToken(row interface{ Scan(...any) error }) (*APIToken, error) {
+	var token APIToken
+	var scopes string
+	var expireAt, lastUsedAt, createDate int64
+	if err := row.Scan(&token.ID, &token.UserID, &token.Name, &scopes, &expireAt, &lastUsedAt, &token.LastUsedIP, &createDate); err != nil {
+		return nil, err
+	}
+	for _, scope := range strings.Split(scopes, ",") {
+		if scope != "" {
+			token.Scopes = append(token.Scopes, Permission(scope))
+		}
+	}
+	if expireAt != 0 {
+		expiry := time.Unix(expireAt, 0)
+		token.ExpireAt = &expiry
+	}
+	if lastUsedAt != 0 {
+		lastUsed := time.Unix(lastUsedAt, 0)
+		token.LastUsedAt = &lastUsed
+	}
+	token.CreateDate = time.Unix(createDate, 0)
+	return &token, nil
+}
+
+func joinScopes(scopes []Permission) string {
+	names := make([]string, len(scopes))
+	for i, scope := range scopes {
+		names[i] = string(scope)
+	}
+	return strings.Join(names, ",")
+}
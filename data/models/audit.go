@@ -0,0 +1,141 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"strings"
+
+	uuid "github.com/gofrs/uuid"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Audited actions
+const (
+	AuditRoleGrant     = "role.grant"
+	AuditReportAssign  = "report.assign"
+	AuditReportResolve = "report.resolve"
+	AuditUserSanction  = "user.sanction"
+	AuditUserLift      = "user.lift"
+	AuditFilterCreate  = "filter.create"
+	AuditFilterDelete  = "filter.delete"
+)
+
+// Types of the targets of the audited actions
+const (
+	AuditTargetUser   = "user"
+	AuditTargetReport = "report"
+	AuditTargetFilter = "filter"
+)
+
+// AuditEntry records an action of a moderator or an admin, with the state of
+// its target before and after it as JSON
+type AuditEntry struct {
+	ID         string          `json:"id"`
+	ActorID    string          `json:"actorID"`
+	ActorName  string          `json:"actorName"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"targetType"`
+	TargetID   string          `json:"targetID"`
+	Before     json.RawMessage `json:"before"`
+	After      json.RawMessage `json:"after"`
+	IP         string          `json:"ip"`
+	CreateDate string          `json:"createDate"`
+}
+
+// AuditFilter selects entries of the audit log. Empty fields don't filter,
+// the dates are inclusive.
+type AuditFilter struct {
+	ActorID    string
+	Action     string
+	TargetType string
+	TargetID   string
+	From       string
+	To         string
+	Offset     int
+	Limit      int // no limit when 0
+}
+
+type AuditRepository struct {
+	db *sql.DB
+}
+
+func NewAuditRepository(db *sql.DB) *AuditRepository {
+	return &AuditRepository{
+		db: db,
+	}
+}
+
+// Record appends an entry to the audit log
+func (ar *AuditRepository) Record(entry *AuditEntry) error {
+	ID, err := uuid.NewV4()
+	if err != nil {
+		log.Printf("❌ Failed to generate UUID: %v", err)
+	}
+	entry.ID = ID.String()
+	if len(entry.Before) == 0 {
+		entry.Before = json.RawMessage("null")
+	}
+	if len(entry.After) == 0 {
+		entry.After = json.RawMessage("null")
+	}
+	_, err = ar.db.Exec(`INSERT INTO audit (id, actorID, action, targetType, targetID, before, after, ip)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		entry.ID, entry.ActorID, entry.Action, entry.TargetType, entry.TargetID, string(entry.Before), string(entry.After), entry.IP)
+	return err
+}
+
+// GetEntries lists the entries matching a filter, the latest first
+func (ar *AuditRepository) GetEntries(filter AuditFilter) ([]AuditEntry, error) {
+	var conditions []string
+	var args []interface{}
+	for _, condition := range []struct {
+		clause string
+		value  string
+	}{
+		{"a.actorID = ?", filter.ActorID},
+		{"a.action = ?", filter.Action},
+		{"a.targetType = ?", filter.TargetType},
+		{"a.targetID = ?", filter.TargetID},
+		{"date(a.createDate) >= date(?)", filter.From},
+		{"date(a.createDate) <= date(?)", filter.To},
+	} {
+		if condition.value != "" {
+			conditions = append(conditions, condition.clause)
+			args = append(args, condition.value)
+		}
+	}
+
+	query := `SELECT a.id, a.actorID, COALESCE(u.nickname, ''), a.action, a.targetType, a.targetID,
+		COALESCE(a.before, 'null'), COALESCE(a.after, 'null'), COALESCE(a.ip, ''), a.createDate
+		FROM audit a
+		LEFT JOIN user u ON a.actorID = u.id`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY a.createDate DESC, a.rowid DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, filter.Limit, filter.Offset)
+	}
+
+	rows, err := ar.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var entry AuditEntry
+		var before, after string
+		err := rows.Scan(&entry.ID, &entry.ActorID, &entry.ActorName, &entry.Action, &entry.TargetType, &entry.TargetID,
+			&before, &after, &entry.IP, &entry.CreateDate)
+		if err != nil {
+			return nil, err
+		}
+		entry.Before, entry.After = json.RawMessage(before), json.RawMessage(after)
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
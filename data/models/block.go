@@ -0,0 +1,102 @@
+package models
+
+import (
+	"database/sql"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type Block struct {
+	BlockedID   string `json:"blockedID"`
+	Nickname    string `json:"nickname"`
+	HideContent bool   `json:"hideContent"`
+	CreateDate  string `json:"createDate"`
+}
+
+type BlockRepository struct {
+	db *sql.DB
+}
+
+func NewBlockRepository(db *sql.DB) *BlockRepository {
+	return &BlockRepository{
+		db: db,
+	}
+}
+
+// Block a user, hiding their posts and comments from the blocker if asked
+func (br *BlockRepository) Block(blockerID, blockedID string, hideContent bool) error {
+	_, err := br.db.Exec(`INSERT INTO block (blockerID, blockedID, hideContent) VALUES (?, ?, ?)
+		ON CONFLICT (blockerID, blockedID) DO UPDATE SET hideContent = excluded.hideContent`,
+		blockerID, blockedID, hideContent)
+	return err
+}
+
+// Unblock a user
+func (br *BlockRepository) Unblock(blockerID, blockedID string) error {
+	_, err := br.db.Exec("DELETE FROM block WHERE blockerID = ? AND blockedID = ?", blockerID, blockedID)
+	return err
+}
+
+// IsBlockedBetween checks if one of the two users blocked the other
+func (br *BlockRepository) IsBlockedBetween(user1ID, user2ID string) (bool, error) {
+	var count int
+	row := br.db.QueryRow(`SELECT COUNT(*) FROM block
+		WHERE (blockerID = ? AND blockedID = ?) OR (blockerID = ? AND blockedID = ?)`,
+		user1ID, user2ID, user2ID, user1ID)
+	err := row.Scan(&count)
+	return count > 0, err
+}
+
+// GetBlocks lists the users blocked by a user
+func (br *BlockRepository) GetBlocks(blockerID string) ([]Block, error) {
+	rows, err := br.db.Query(`
+		SELECT b.blockedID, u.nickname, b.hideContent, b.createDate
+		FROM block b
+		JOIN user u ON b.blockedID = u.id
+		WHERE b.blockerID = ?
+		ORDER BY b.createDate DESC
+	`, blockerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	blocks := []Block{}
+	for rows.Next() {
+		var block Block
+		if err := rows.Scan(&block.BlockedID, &block.Nickname, &block.HideContent, &block.CreateDate); err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, rows.Err()
+}
+
+// GetBlockedIDs lists the IDs of the users blocked by a user
+func (br *BlockRepository) GetBlockedIDs(blockerID string) ([]string, error) {
+	return br.selectIDs("SELECT blockedID FROM block WHERE blockerID = ?", blockerID)
+}
+
+// GetUsersHidingContentOf lists the IDs of the users who blocked an author
+// and asked to hide their posts and comments
+func (br *BlockRepository) GetUsersHidingContentOf(authorID string) ([]string, error) {
+	return br.selectIDs("SELECT blockerID FROM block WHERE blockedID = ? AND hideContent = 1", authorID)
+}
+
+func (br *BlockRepository) selectIDs(query string, args ...interface{}) ([]string, error) {
+	rows, err := br.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var IDs []string
+	for rows.Next() {
+		var ID string
+		if err := rows.Scan(&ID); err != nil {
+			return nil, err
+		}
+		IDs = append(IDs, ID)
+	}
+	return IDs, rows.Err()
+}
@@ -15,6 +15,7 @@ type Comment struct {
 	AuthorID   string `json:"authorID"`
 	PostID     string `json:"postID"`
 	CreateDate string `json:"createDate"`
+	Held       bool   `json:"-"` // held for review by the word filter
 }
 
 type CommentItem struct {
@@ -43,8 +44,8 @@ func (cr *CommentRepository) CreateComment(comment *Comment) error {
 		log.Printf("❌ Failed to generate UUID: %v", err)
 	}
 	comment.ID = ID.String()
-	_, err = cr.db.Exec("INSERT INTO comment (id, text, authorID, postID) VALUES (?, ?, ?, ?)",
-		comment.ID, comment.Text, comment.AuthorID, comment.PostID)
+	_, err = cr.db.Exec("INSERT INTO comment (id, text, authorID, postID, hidden) VALUES (?, ?, ?, ?, ?)",
+		comment.ID, comment.Text, comment.AuthorID, comment.PostID, comment.Held)
 	return err
 }
 
@@ -62,10 +63,14 @@ func (cr *CommentRepository) GetCommentByID(id string) (CommentItem, error) {
 	return comment, nil
 }
 
-func (cr *CommentRepository) GetCommentsOfPost(postID string) ([]*CommentItem, error) {
+// Get the comments of a post, without the ones of the authors the viewer
+// asked to hide
+func (cr *CommentRepository) GetCommentsOfPost(postID, viewerID string) ([]*CommentItem, error) {
 	var comments []*CommentItem
 
-	rows, err := cr.db.Query("SELECT c.id, c.text, c.authorID, c.createDate, u.nickName, u.avatarURL FROM comment c LEFT JOIN user u ON c.authorID = u.ID WHERE c.PostID = ? ORDER BY createDate DESC", postID)
+	rows, err := cr.db.Query(`SELECT c.id, c.text, c.authorID, c.createDate, u.nickName, u.avatarURL FROM comment c LEFT JOIN user u ON c.authorID = u.ID
+		WHERE c.PostID = ? AND c.hidden = 0 AND c.authorID NOT IN (SELECT blockedID FROM block WHERE blockerID = ? AND hideContent = 1)
+		ORDER BY c.createDate DESC`, postID, viewerID)
 	if err != nil {
 		return nil, err
 	}
@@ -87,3 +92,22 @@ func (cr *CommentRepository) GetCommentsOfPost(postID string) ([]*CommentItem, e
 
 	return comments, nil
 }
+
+// GetPostIDOfComment returns the ID of the post a comment is on
+func (cr *CommentRepository) GetPostIDOfComment(commentID string) (string, error) {
+	var postID string
+	err := cr.db.QueryRow("SELECT postID FROM comment WHERE id = ?", commentID).Scan(&postID)
+	return postID, err
+}
+
+// SetHidden hides or shows again a comment
+func (cr *CommentRepository) SetHidden(commentID string, hidden bool) error {
+	_, err := cr.db.Exec("UPDATE comment SET hidden = ? WHERE id = ?", hidden, commentID)
+	return err
+}
+
+// DeleteComment deletes a comment
+func (cr *CommentRepository) DeleteComment(commentID string) error {
+	_, err := cr.db.Exec("DELETE FROM comment WHERE id = ?", commentID)
+	return err
+}
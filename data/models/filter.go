@@ -0,0 +1,81 @@
+package models
+
+import (
+	"database/sql"
+	"log"
+
+	uuid "github.com/gofrs/uuid"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// FilterRule is a banned or flagged term of the word filter, with the action
+// taken on the content matching it: "reject", "mask" or "hold"
+type FilterRule struct {
+	ID         string `json:"id"`
+	Pattern    string `json:"pattern"`
+	Regex      bool   `json:"regex"`
+	Action     string `json:"action"`
+	AuthorID   string `json:"authorID"`
+	CreateDate string `json:"createDate"`
+}
+
+type FilterRuleRepository struct {
+	db *sql.DB
+}
+
+func NewFilterRuleRepository(db *sql.DB) *FilterRuleRepository {
+	return &FilterRuleRepository{
+		db: db,
+	}
+}
+
+// Create a new rule in the database
+func (fr *FilterRuleRepository) CreateRule(rule *FilterRule) error {
+	ID, err := uuid.NewV4()
+	if err != nil {
+		log.Printf("❌ Failed to generate UUID: %v", err)
+	}
+	rule.ID = ID.String()
+	_, err = fr.db.Exec("INSERT INTO filter_rule (id, pattern, regex, action, authorID) VALUES (?, ?, ?, ?, ?)",
+		rule.ID, rule.Pattern, rule.Regex, rule.Action, rule.AuthorID)
+	return err
+}
+
+// Get a rule by ID from the database
+func (fr *FilterRuleRepository) GetRuleByID(ruleID string) (*FilterRule, error) {
+	var rule FilterRule
+	row := fr.db.QueryRow("SELECT id, pattern, regex, action, COALESCE(authorID, ''), createDate FROM filter_rule WHERE id = ?", ruleID)
+	err := row.Scan(&rule.ID, &rule.Pattern, &rule.Regex, &rule.Action, &rule.AuthorID, &rule.CreateDate)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // Rule not found
+		}
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// GetRules lists every rule of the word filter
+func (fr *FilterRuleRepository) GetRules() ([]FilterRule, error) {
+	rows, err := fr.db.Query("SELECT id, pattern, regex, action, COALESCE(authorID, ''), createDate FROM filter_rule ORDER BY createDate")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []FilterRule{}
+	for rows.Next() {
+		var rule FilterRule
+		if err := rows.Scan(&rule.ID, &rule.Pattern, &rule.Regex, &rule.Action, &rule.AuthorID, &rule.CreateDate); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// DeleteRule removes a rule from the word filter
+func (fr *FilterRuleRepository) DeleteRule(ruleID string) error {
+	_, err := fr.db.Exec("DELETE FROM filter_rule WHERE id = ?", ruleID)
+	return err
+}
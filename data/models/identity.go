@@ -0,0 +1,54 @@
+package models
+
+import (
+	"database/sql"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type IdentityRepository struct {
+	db *sql.DB
+}
+
+func NewIdentityRepository(db *sql.DB) *IdentityRepository {
+	return &IdentityRepository{
+		db: db,
+	}
+}
+
+// GetUserID returns the user an account of a provider is linked to, an empty
+// string if it isn't
+func (ir *IdentityRepository) GetUserID(provider, subject string) (string, error) {
+	var userID string
+	err := ir.db.QueryRow("SELECT userID FROM oidc_identity WHERE provider = ? AND subject = ?", provider, subject).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return userID, err
+}
+
+// Link links an account of a provider to a user. An account can only be
+// linked to one user, and a user to one account per provider: linking
+// another one fails with a constraint error.
+func (ir *IdentityRepository) Link(provider, subject, userID string) error {
+	_, err := ir.db.Exec("INSERT INTO oidc_identity (provider, subject, userID) VALUES (?, ?, ?)", provider, subject, userID)
+	return err
+}
+
+// GetProviders lists the providers a user linked an account of
+func (ir *IdentityRepository) GetProviders(userID string) ([]string, error) {
+	rows, err := ir.db.Query("SELECT provider FROM oidc_identity WHERE userID = ? ORDER BY createDate", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	providers := []string{}
+	for rows.Next() {
+		var provider string
+		if err := rows.Scan(&provider); err != nil {
+			return nil, err
+		}
+		providers = append(providers, provider)
+	}
+	return providers, rows.Err()
+}
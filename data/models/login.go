@@ -0,0 +1,66 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// loginFailureRetention is how long the failed sign-in attempts are kept
+const loginFailureRetention = 24 * time.Hour
+
+// LoginFailures counts the recent failed sign-in attempts of an account or an IP
+type LoginFailures struct {
+	Count int
+	Last  time.Time
+}
+
+type LoginRepository struct {
+	db *sql.DB
+}
+
+func NewLoginRepository(db *sql.DB) *LoginRepository {
+	return &LoginRepository{
+		db: db,
+	}
+}
+
+// RecordFailure records a failed sign-in attempt, forgetting the old ones
+func (lr *LoginRepository) RecordFailure(account, ip string) error {
+	if _, err := lr.db.Exec("INSERT INTO login_failure (account, ip) VALUES (?, ?)", account, ip); err != nil {
+		return err
+	}
+	_, err := lr.db.Exec("DELETE FROM login_failure WHERE createDate < datetime('now', ?)", secondsAgo(loginFailureRetention))
+	return err
+}
+
+// AccountFailures counts the failed attempts on an account within a window
+func (lr *LoginRepository) AccountFailures(account string, window time.Duration) (LoginFailures, error) {
+	return lr.failures("account", account, window)
+}
+
+// IPFailures counts the failed attempts from an IP within a window
+func (lr *LoginRepository) IPFailures(ip string, window time.Duration) (LoginFailures, error) {
+	return lr.failures("ip", ip, window)
+}
+
+// ClearFailures forgets the failed attempts on an account after a success
+func (lr *LoginRepository) ClearFailures(account string) error {
+	_, err := lr.db.Exec("DELETE FROM login_failure WHERE account = ?", account)
+	return err
+}
+
+func (lr *LoginRepository) failures(column, value string, window time.Duration) (LoginFailures, error) {
+	var failures LoginFailures
+	var last int64
+	row := lr.db.QueryRow(`SELECT COUNT(*), COALESCE(CAST(strftime('%s', MAX(createDate)) AS INTEGER), 0)
+		FROM login_failure WHERE `+column+` = ? AND createDate > datetime('now', ?)`, value, secondsAgo(window))
+	if err := row.Scan(&failures.Count, &last); err != nil {
+		return failures, err
+	}
+	if failures.Count > 0 {
+		failures.Last = time.Unix(last, 0)
+	}
+	return failures, nil
+}
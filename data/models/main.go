@@ -2,9 +2,13 @@ package models
 
 import (
 	"database/sql"
+	"fmt"
 	"log"
 	"os"
 	"real-time-forum/lib"
+	"strings"
+	"sync"
+	"time"
 )
 
 var (
@@ -14,6 +18,26 @@ var (
 	CategoryRepo     *CategoryRepository
 	PostCategoryRepo *PostCategoryRepository
 	MessageRepo      *MessageRepository
+	BlockRepo        *BlockRepository
+	MuteRepo         *MuteRepository
+	ReportRepo       *ReportRepository
+	AuditRepo        *AuditRepository
+	FilterRuleRepo   *FilterRuleRepository
+	ActivityRepo     *ActivityRepository
+	LoginRepo        *LoginRepository
+	ResetRepo        *PasswordResetRepository
+	VerificationRepo *EmailVerificationRepository
+	TwoFactorRepo    *TwoFactorRepository
+	IdentityRepo     *IdentityRepository
+	TokenRepo        *APITokenRepository
+)
+
+var (
+	// database is the connection shared by the repositories
+	database *sql.DB
+	// closing stops the background cleanups once closed by Close
+	closing   = make(chan struct{})
+	closeOnce sync.Once
 )
 
 func init() {
@@ -44,6 +68,11 @@ func init() {
 	if _, err = db.Exec(string(query)); err != nil {
 		log.Fatal("❌ Database setup wasn't successful:", err)
 	}
+	if err = migrate(db); err != nil {
+		log.Fatal("❌ Database migration wasn't successful:", err)
+	}
+
+	database = db
 
 	// Set up repository instances
 	UserRepo = NewUserRepository(db)
@@ -52,6 +81,63 @@ func init() {
 	CategoryRepo = NewCategoryRepository(db)
 	PostCategoryRepo = NewPostCategoryRepository(db)
 	MessageRepo = NewMessageRepository(db)
+	BlockRepo = NewBlockRepository(db)
+	MuteRepo = NewMuteRepository(db)
+	ReportRepo = NewReportRepository(db)
+	AuditRepo = NewAuditRepository(db)
+	FilterRuleRepo = NewFilterRuleRepository(db)
+	ActivityRepo = NewActivityRepository(db)
+	LoginRepo = NewLoginRepository(db)
+	ResetRepo = NewPasswordResetRepository(db)
+	VerificationRepo = NewEmailVerificationRepository(db)
+	TwoFactorRepo = NewTwoFactorRepository(db)
+	IdentityRepo = NewIdentityRepository(db)
+	TokenRepo = NewAPITokenRepository(db)
 
 	log.Println("✅ Database initialized successfully")
 }
+
+// Close stops the background cleanups and closes the database, once the
+// server stopped serving requests.
+func Close() error {
+	closeOnce.Do(func() { close(closing) })
+	return database.Close()
+}
+
+// migrations add the columns introduced after a database was created.
+// init.sql holds the complete schema for new databases.
+var migrations = []string{
+	`ALTER TABLE "user" ADD COLUMN lastSeen TIMESTAMP`,
+	`ALTER TABLE "user" ADD COLUMN role VARCHAR NOT NULL DEFAULT 'member'`,
+	`ALTER TABLE "user" ADD COLUMN bannedAt TIMESTAMP`,
+	`ALTER TABLE "user" ADD COLUMN banReason TEXT`,
+	`ALTER TABLE "user" ADD COLUMN suspendedUntil TIMESTAMP`,
+	`ALTER TABLE "user" ADD COLUMN createDate TIMESTAMP`,
+	// The accounts created before the verification are trusted
+	`ALTER TABLE "user" ADD COLUMN emailVerified BOOLEAN NOT NULL DEFAULT 1`,
+	`ALTER TABLE "user" ADD COLUMN bot BOOLEAN NOT NULL DEFAULT 0`,
+	`ALTER TABLE "user" ADD COLUMN ownerID VARCHAR`,
+	`ALTER TABLE "post" ADD COLUMN hidden BOOLEAN NOT NULL DEFAULT 0`,
+	`ALTER TABLE "comment" ADD COLUMN hidden BOOLEAN NOT NULL DEFAULT 0`,
+	`ALTER TABLE "message" ADD COLUMN hidden BOOLEAN NOT NULL DEFAULT 0`,
+}
+
+// migrate runs the migrations, skipping the columns that already exist.
+func migrate(db *sql.DB) error {
+	for _, migration := range migrations {
+		if _, err := db.Exec(migration); err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+			return err
+		}
+	}
+	return nil
+}
+
+// secondsAgo is the SQLite datetime modifier going back a duration
+func secondsAgo(duration time.Duration) string {
+	return secondsFromNow(-duration)
+}
+
+// secondsFromNow is the SQLite datetime modifier going forward a duration
+func secondsFromNow(duration time.Duration) string {
+	return fmt.Sprintf("%+d seconds", int(duration.Seconds()))
+}
@@ -16,6 +16,7 @@ type Message struct {
 	ReceiverID string `json:"receiverID"`
 	Content    string `json:"text"`
 	CreateDate string `json:"createDate"`
+	Held       bool   `json:"-"` // held for review by the word filter
 }
 
 type MessageRepository struct {
@@ -35,8 +36,8 @@ func (rr *MessageRepository) CreateMessage(message *Message) error {
 		log.Printf("❌ Failed to generate UUID: %v", err)
 	}
 	message.ID = ID.String()
-	_, err = rr.db.Exec("INSERT INTO message (id, senderID, receiverID, content) VALUES (?, ?, ?, ?)",
-		message.ID, message.SenderID, message.ReceiverID, message.Content)
+	_, err = rr.db.Exec("INSERT INTO message (id, senderID, receiverID, content, hidden) VALUES (?, ?, ?, ?, ?)",
+		message.ID, message.SenderID, message.ReceiverID, message.Content, message.Held)
 	if err != nil {
 		log.Printf("❌ Failed to insert message into the database: %v", err)
 		return err
@@ -51,7 +52,7 @@ func (rr *MessageRepository) GetDiscussionsBetweenUsersWithPagination(user1ID, u
 	rows, err := rr.db.Query(`
 		SELECT id, senderID, receiverID, content, createDate
 		FROM message
-		WHERE (senderID = ? AND receiverID = ?) OR (senderID = ? AND receiverID = ?)
+		WHERE ((senderID = ? AND receiverID = ?) OR (senderID = ? AND receiverID = ?)) AND hidden = 0
 		ORDER BY createDate DESC
 		LIMIT ? OFFSET ?
 	`, user1ID, user2ID, user2ID, user1ID, limit, offset)
@@ -129,3 +130,15 @@ func (rr *MessageRepository) GetMessageByID(messageID string) (*Message, error)
 	}
 	return &message, nil
 }
+
+// SetHidden hides or shows again a message
+func (mr *MessageRepository) SetHidden(messageID string, hidden bool) error {
+	_, err := mr.db.Exec("UPDATE message SET hidden = ? WHERE id = ?", hidden, messageID)
+	return err
+}
+
+// DeleteMessage deletes a message
+func (mr *MessageRepository) DeleteMessage(messageID string) error {
+	_, err := mr.db.Exec("DELETE FROM message WHERE id = ?", messageID)
+	return err
+}
@@ -0,0 +1,37 @@
+package models
+
+import (
+	"database/sql"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type MuteRepository struct {
+	db *sql.DB
+}
+
+func NewMuteRepository(db *sql.DB) *MuteRepository {
+	return &MuteRepository{
+		db: db,
+	}
+}
+
+// Mute the notifications of the conversation of a user with a talker
+func (mr *MuteRepository) Mute(userID, talkerID string) error {
+	_, err := mr.db.Exec("INSERT OR IGNORE INTO mute (userID, talkerID) VALUES (?, ?)", userID, talkerID)
+	return err
+}
+
+// Unmute the notifications of a conversation
+func (mr *MuteRepository) Unmute(userID, talkerID string) error {
+	_, err := mr.db.Exec("DELETE FROM mute WHERE userID = ? AND talkerID = ?", userID, talkerID)
+	return err
+}
+
+// IsMuted checks if a user muted the conversation with a talker
+func (mr *MuteRepository) IsMuted(userID, talkerID string) (bool, error) {
+	var count int
+	row := mr.db.QueryRow("SELECT COUNT(*) FROM mute WHERE userID = ? AND talkerID = ?", userID, talkerID)
+	err := row.Scan(&count)
+	return count > 0, err
+}
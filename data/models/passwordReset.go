@@ -0,0 +1,61 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"real-time-forum/lib"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var ErrInvalidResetToken = errors.New("invalid or expired reset token")
+
+type PasswordResetRepository struct {
+	db *sql.DB
+}
+
+func NewPasswordResetRepository(db *sql.DB) *PasswordResetRepository {
+	return &PasswordResetRepository{
+		db: db,
+	}
+}
+
+// CreateToken hands out a token resetting the password of a user until it
+// expires, replacing their unused ones. Only its hash is stored.
+func (pr *PasswordResetRepository) CreateToken(userID string, ttl time.Duration) (string, error) {
+	token, tokenHash, err := lib.NewToken()
+	if err != nil {
+		return "", err
+	}
+	if _, err := pr.db.Exec("DELETE FROM password_reset WHERE userID = ? AND usedAt IS NULL", userID); err != nil {
+		return "", err
+	}
+	_, err = pr.db.Exec("INSERT INTO password_reset (tokenHash, userID, expireAt) VALUES (?, ?, datetime('now', ?))",
+		tokenHash, userID, secondsFromNow(ttl))
+	return token, err
+}
+
+// RecentlyCreated checks if a token was handed out to a user within a window
+func (pr *PasswordResetRepository) RecentlyCreated(userID string, window time.Duration) (bool, error) {
+	var count int
+	row := pr.db.QueryRow("SELECT COUNT(*) FROM password_reset WHERE userID = ? AND createDate > datetime('now', ?)",
+		userID, secondsAgo(window))
+	err := row.Scan(&count)
+	return count > 0, err
+}
+
+// UseToken spends a valid token, returning the ID of its user. A token can
+// only be used once, even by concurrent requests.
+func (pr *PasswordResetRepository) UseToken(token string) (string, error) {
+	var userID string
+	row := pr.db.QueryRow(`UPDATE password_reset SET usedAt = CURRENT_TIMESTAMP
+		WHERE tokenHash = ? AND usedAt IS NULL AND expireAt > datetime('now') RETURNING userID`, lib.HashToken(token))
+	if err := row.Scan(&userID); err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrInvalidResetToken
+		}
+		return "", err
+	}
+	return userID, nil
+}
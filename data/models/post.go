@@ -32,6 +32,7 @@ type Post struct {
 	Description string `json:"description"`
 	AuthorID    string `json:"authorID"`
 	CreateDate  string `json:"createDate"`
+	Hidden      bool   `json:"hidden"`
 }
 
 type PostCreation struct {
@@ -43,6 +44,7 @@ type PostCreation struct {
 	ImageURL    string   `json:"imageURL"`
 	Categories  []string `json:"categories"`
 	CreateDate  string   `json:"createDate"`
+	Held        bool     `json:"-"` // held for review by the word filter
 }
 
 type PostRepository struct {
@@ -62,16 +64,16 @@ func (pr *PostRepository) CreatePost(post *PostCreation) error {
 		log.Printf("❌ Failed to generate UUID: %v", err)
 	}
 	post.ID = ID.String()
-	_, err = pr.db.Exec("INSERT INTO post (id, title, slug, description, authorID) VALUES (?, ?, ?, ?, ?)",
-		post.ID, post.Title, post.Slug, post.Description, post.AuthorID)
+	_, err = pr.db.Exec("INSERT INTO post (id, title, slug, description, authorID, hidden) VALUES (?, ?, ?, ?, ?, ?)",
+		post.ID, post.Title, post.Slug, post.Description, post.AuthorID, post.Held)
 	return err
 }
 
 // Get a post by ID from the database
 func (pr *PostRepository) GetPostByID(postID string) (*CompletePost, error) {
 	var post CompletePost
-	row := pr.db.QueryRow("SELECT id, title, slug, description,authorID, createDate FROM post WHERE id = ?", postID)
-	err := row.Scan(&post.ID, &post.Title, &post.Slug, &post.Description, &post.AuthorID, &post.CreateDate)
+	row := pr.db.QueryRow("SELECT id, title, slug, description,authorID, createDate, hidden FROM post WHERE id = ?", postID)
+	err := row.Scan(&post.ID, &post.Title, &post.Slug, &post.Description, &post.AuthorID, &post.CreateDate, &post.Hidden)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, err // Post not found
@@ -88,7 +90,7 @@ func (pr *PostRepository) GetUserOwnPosts(userId, nickName string) ([]PostItem,
 	rows, err := pr.db.Query(`
 	SELECT p.id AS id, title, slug, description, p.authorID AS authorID, p.createDate AS createDate, COUNT(*) AS numberComment FROM post p
 	LEFT JOIN comment c ON c.postID = p.ID
-	WHERE p.authorID = ? 
+	WHERE p.authorID = ? AND p.hidden = 0
 	GROUP BY p.ID ;
 	`, userId)
 	if err != nil {
@@ -134,8 +136,8 @@ func (pr *PostRepository) GetUserOwnPosts(userId, nickName string) ([]PostItem,
 // Get a post by TITLE from the database
 func (pr *PostRepository) GetPostBySlug(slug string) (*CompletePost, error) {
 	var post CompletePost
-	row := pr.db.QueryRow("SELECT id, title, slug, description, authorID, createDate FROM post WHERE slug = ?", slug)
-	err := row.Scan(&post.ID, &post.Title, &post.Slug, &post.Description, &post.AuthorID, &post.CreateDate)
+	row := pr.db.QueryRow("SELECT id, title, slug, description, authorID, createDate, hidden FROM post WHERE slug = ?", slug)
+	err := row.Scan(&post.ID, &post.Title, &post.Slug, &post.Description, &post.AuthorID, &post.CreateDate, &post.Hidden)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, err // Post not found
@@ -146,8 +148,9 @@ func (pr *PostRepository) GetPostBySlug(slug string) (*CompletePost, error) {
 	return &post, nil
 }
 
-// Get all posts as PostItems with author name and category names
-func (pr *PostRepository) GetAllPosts() ([]*PostItem, error) {
+// Get all posts as PostItems with author name and category names, without
+// the posts of the authors the viewer asked to hide
+func (pr *PostRepository) GetAllPosts(viewerID string) ([]*PostItem, error) {
 	var postItems []*PostItem
 	request := `
 		SELECT 
@@ -160,10 +163,11 @@ func (pr *PostRepository) GetAllPosts() ([]*PostItem, error) {
 		JOIN user u ON p.authorID = u.id
 		LEFT JOIN post_category pc ON p.id = pc.postID
 		LEFT JOIN category c ON pc.categoryID = c.id
+		WHERE p.hidden = 0 AND p.authorID NOT IN (SELECT blockedID FROM block WHERE blockerID = ? AND hideContent = 1)
 		GROUP BY p.id
 		ORDER BY p.createDate DESC
 	`
-	rows, err := pr.db.Query(request)
+	rows, err := pr.db.Query(request, viewerID)
 	if err != nil {
 		return nil, err
 	}
@@ -248,3 +252,28 @@ func (pr *PostRepository) GetNumberOfPosts() int {
 	}
 	return numberOfPosts
 }
+
+// SetHidden hides or shows again a post
+func (pr *PostRepository) SetHidden(postID string, hidden bool) error {
+	_, err := pr.db.Exec("UPDATE post SET hidden = ? WHERE id = ?", hidden, postID)
+	return err
+}
+
+// DeletePost deletes a post with its comments and categories
+func (pr *PostRepository) DeletePost(postID string) error {
+	tx, err := pr.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, query := range []string{
+		"DELETE FROM comment WHERE postID = ?",
+		"DELETE FROM post_category WHERE postID = ?",
+		"DELETE FROM post WHERE id = ?",
+	} {
+		if _, err := tx.Exec(query, postID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
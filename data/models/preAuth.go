@@ -0,0 +1,78 @@
+package models
+
+import (
+	"real-time-forum/lib"
+	"sync"
+	"time"
+)
+
+// PreAuthExpiry is how long a user has to enter their second factor once
+// their password was checked
+const PreAuthExpiry = 5 * time.Minute
+
+// maxPreAuthAttempts is the number of codes a pre-auth token may be tried with
+const maxPreAuthAttempts = 5
+
+// PreAuth is a sign-in waiting for its second factor
+type PreAuth struct {
+	UserID   string
+	Account  string // key of the failed sign-in attempts
+	ExpireAt time.Time
+	attempts int
+}
+
+// preAuths are stored by the hash of their token, in memory like the sessions
+var preAuths = struct {
+	sync.Mutex
+	tokens map[string]*PreAuth
+}{tokens: make(map[string]*PreAuth)}
+
+// NewPreAuthToken hands out a short-lived token proving that a user entered
+// their password, to be exchanged for a session with their second factor.
+func NewPreAuthToken(userID, account string) (string, error) {
+	token, tokenHash, err := lib.NewToken()
+	if err != nil {
+		return "", err
+	}
+	preAuths.Lock()
+	defer preAuths.Unlock()
+	preAuths.tokens[tokenHash] = &PreAuth{UserID: userID, Account: account, ExpireAt: time.Now().Add(PreAuthExpiry)}
+	return token, nil
+}
+
+// TryPreAuthToken counts an attempt with a pre-auth token, returning its
+// sign-in while it is unexpired and has attempts left.
+func TryPreAuthToken(token string) (PreAuth, bool) {
+	tokenHash := lib.HashToken(token)
+	preAuths.Lock()
+	defer preAuths.Unlock()
+	preAuth, ok := preAuths.tokens[tokenHash]
+	if !ok {
+		return PreAuth{}, false
+	}
+	if preAuth.ExpireAt.Before(time.Now()) || preAuth.attempts >= maxPreAuthAttempts {
+		delete(preAuths.tokens, tokenHash)
+		return PreAuth{}, false
+	}
+	preAuth.attempts++
+	return *preAuth, true
+}
+
+// DeletePreAuthToken ends a pre-auth token once the sign-in completed
+func DeletePreAuthToken(token string) {
+	preAuths.Lock()
+	defer preAuths.Unlock()
+	delete(preAuths.tokens, lib.HashToken(token))
+}
+
+// deleteExpiredPreAuths forgets the pre-auth tokens nobody used in time
+func deleteExpiredPreAuths() {
+	now := time.Now()
+	preAuths.Lock()
+	defer preAuths.Unlock()
+	for tokenHash, preAuth := range preAuths.tokens {
+		if preAuth.ExpireAt.Before(now) {
+			delete(preAuths.tokens, tokenHash)
+		}
+	}
+}
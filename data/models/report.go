@@ -0,0 +1,225 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+
+	uuid "github.com/gofrs/uuid"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Types of the content that can be reported
+const (
+	ContentPost    = "post"
+	ContentComment = "comment"
+	ContentMessage = "message"
+)
+
+// Statuses of a report
+const (
+	ReportOpen     = "open"
+	ReportResolved = "resolved"
+)
+
+// Actions resolving a report
+const (
+	ResolutionDismiss = "dismiss"
+	ResolutionHide    = "hide"
+	ResolutionDelete  = "delete"
+	ResolutionWarn    = "warn"
+	ResolutionBan     = "ban"
+)
+
+// ReportReasons are the reason codes a user can choose from
+var ReportReasons = []string{"spam", "harassment", "hate", "violence", "sexual", "other"}
+
+// ReasonHeld is the reason of the reports opened by the word filter on the
+// content it holds for review. Dismissing them publishes the content.
+const ReasonHeld = "held"
+
+var ErrAlreadyReported = errors.New("content already reported")
+
+type Report struct {
+	ID           string `json:"id"`
+	ReporterID   string `json:"reporterID"`
+	ReporterName string `json:"reporterName"`
+	ContentType  string `json:"contentType"`
+	ContentID    string `json:"contentID"`
+	AuthorID     string `json:"authorID"`
+	AuthorName   string `json:"authorName"`
+	Excerpt      string `json:"excerpt"`
+	Reason       string `json:"reason"`
+	Details      string `json:"details"`
+	Status       string `json:"status"`
+	AssigneeID   string `json:"assigneeID"`
+	AssigneeName string `json:"assigneeName"`
+	Resolution   string `json:"resolution"`
+	Note         string `json:"note"`
+	ResolverID   string `json:"resolverID"`
+	CreateDate   string `json:"createDate"`
+	ResolveDate  string `json:"resolveDate"`
+}
+
+// ReportFilter selects the reports of the moderation queue. Empty fields
+// don't filter.
+type ReportFilter struct {
+	Status      string
+	ContentType string
+	Reason      string
+	AssigneeID  string
+	Unassigned  bool
+	Offset      int
+	Limit       int
+}
+
+type ReportRepository struct {
+	db *sql.DB
+}
+
+func NewReportRepository(db *sql.DB) *ReportRepository {
+	return &ReportRepository{
+		db: db,
+	}
+}
+
+// IsValidReason checks if a reason code exists
+func IsValidReason(reason string) bool {
+	for _, valid := range ReportReasons {
+		if reason == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// Create a new report in the database, unless the reporter already has an
+// open report on the same content
+func (rr *ReportRepository) CreateReport(report *Report) error {
+	var count int
+	row := rr.db.QueryRow("SELECT COUNT(*) FROM report WHERE reporterID = ? AND contentType = ? AND contentID = ? AND status = ?",
+		report.ReporterID, report.ContentType, report.ContentID, ReportOpen)
+	if err := row.Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrAlreadyReported
+	}
+
+	ID, err := uuid.NewV4()
+	if err != nil {
+		log.Printf("❌ Failed to generate UUID: %v", err)
+	}
+	report.ID = ID.String()
+	report.Status = ReportOpen
+	_, err = rr.db.Exec(`INSERT INTO report (id, reporterID, contentType, contentID, authorID, excerpt, reason, details, status)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		report.ID, report.ReporterID, report.ContentType, report.ContentID, report.AuthorID, report.Excerpt, report.Reason, report.Details, report.Status)
+	return err
+}
+
+const selectReports = `
+	SELECT r.id, r.reporterID, COALESCE(rep.nickname, ''), r.contentType, r.contentID,
+		COALESCE(r.authorID, ''), COALESCE(a.nickname, ''), COALESCE(r.excerpt, ''), r.reason, COALESCE(r.details, ''),
+		r.status, COALESCE(r.assigneeID, ''), COALESCE(asg.nickname, ''), COALESCE(r.resolution, ''), COALESCE(r.note, ''),
+		COALESCE(r.resolverID, ''), r.createDate, COALESCE(r.resolveDate, '')
+	FROM report r
+	LEFT JOIN user rep ON r.reporterID = rep.id
+	LEFT JOIN user a ON r.authorID = a.id
+	LEFT JOIN user asg ON r.assigneeID = asg.id`
+
+// GetReports lists the reports matching a filter, the oldest first
+func (rr *ReportRepository) GetReports(filter ReportFilter) ([]Report, error) {
+	var conditions []string
+	var args []interface{}
+	for column, value := range map[string]string{
+		"r.status":      filter.Status,
+		"r.contentType": filter.ContentType,
+		"r.reason":      filter.Reason,
+		"r.assigneeID":  filter.AssigneeID,
+	} {
+		if value != "" {
+			conditions = append(conditions, column+" = ?")
+			args = append(args, value)
+		}
+	}
+	if filter.Unassigned {
+		conditions = append(conditions, "r.assigneeID IS NULL")
+	}
+
+	query := selectReports
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY r.createDate ASC LIMIT ? OFFSET ?"
+	args = append(args, filter.Limit, filter.Offset)
+
+	rows, err := rr.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reports := []Report{}
+	for rows.Next() {
+		report, err := scanReport(rows)
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, *report)
+	}
+	return reports, rows.Err()
+}
+
+// Get a report by ID from the database
+func (rr *ReportRepository) GetReportByID(reportID string) (*Report, error) {
+	report, err := scanReport(rr.db.QueryRow(selectReports+" WHERE r.id = ?", reportID))
+	if err == sql.ErrNoRows {
+		return nil, nil // Report not found
+	}
+	return report, err
+}
+
+// Assign a report to a moderator, or to nobody with an empty assignee
+func (rr *ReportRepository) Assign(reportID, assigneeID string) error {
+	var assignee interface{}
+	if assigneeID != "" {
+		assignee = assigneeID
+	}
+	_, err := rr.db.Exec("UPDATE report SET assigneeID = ? WHERE id = ?", assignee, reportID)
+	return err
+}
+
+// Resolve closes every open report on the content of a report
+func (rr *ReportRepository) Resolve(report *Report, resolverID, resolution, note string) error {
+	_, err := rr.db.Exec(`UPDATE report SET status = ?, resolution = ?, note = ?, resolverID = ?, resolveDate = CURRENT_TIMESTAMP
+		WHERE contentType = ? AND contentID = ? AND status = ?`,
+		ReportResolved, resolution, note, resolverID, report.ContentType, report.ContentID, ReportOpen)
+	return err
+}
+
+// IsHeld checks if the word filter holds a content for review
+func (rr *ReportRepository) IsHeld(contentType, contentID string) (bool, error) {
+	var count int
+	row := rr.db.QueryRow("SELECT COUNT(*) FROM report WHERE contentType = ? AND contentID = ? AND reason = ? AND status = ?",
+		contentType, contentID, ReasonHeld, ReportOpen)
+	err := row.Scan(&count)
+	return count > 0, err
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanReport(row scanner) (*Report, error) {
+	var report Report
+	err := row.Scan(&report.ID, &report.ReporterID, &report.ReporterName, &report.ContentType, &report.ContentID,
+		&report.AuthorID, &report.AuthorName, &report.Excerpt, &report.Reason, &report.Details,
+		&report.Status, &report.AssigneeID, &report.AssigneeName, &report.Resolution, &report.Note,
+		&report.ResolverID, &report.CreateDate, &report.ResolveDate)
+	if err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
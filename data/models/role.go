@@ -0,0 +1,91 @@
+package models
+
+import "errors"
+
+// Roles of the users, from the most to the least privileged
+const (
+	RoleAdmin     = "admin"
+	RoleModerator = "moderator"
+	RoleMember    = "member"
+)
+
+// Permission is an action a role may be allowed to do
+type Permission string
+
+const (
+	// PermissionRead allows reading the forum and the user's conversations
+	PermissionRead Permission = "read"
+	// PermissionPost allows writing posts and comments
+	PermissionPost Permission = "post"
+	// PermissionMessage allows sending private messages
+	PermissionMessage Permission = "message"
+	// PermissionModerate allows handling the content of other users
+	PermissionModerate Permission = "moderate"
+	// PermissionManageRoles allows granting roles
+	PermissionManageRoles Permission = "manage_roles"
+	// PermissionAudit allows reading the audit log
+	PermissionAudit Permission = "audit"
+	// PermissionManageFilters allows changing the rules of the word filter
+	PermissionManageFilters Permission = "manage_filters"
+	// PermissionAccount allows managing the security of one's account: the
+	// password, the second factor, the API tokens and the bots
+	PermissionAccount Permission = "account"
+)
+
+var rolePermissions = map[string][]Permission{
+	RoleMember:    {PermissionRead, PermissionPost, PermissionMessage, PermissionAccount},
+	RoleModerator: {PermissionRead, PermissionPost, PermissionMessage, PermissionAccount, PermissionModerate},
+	RoleAdmin:     {PermissionRead, PermissionPost, PermissionMessage, PermissionAccount, PermissionModerate, PermissionManageRoles, PermissionAudit, PermissionManageFilters},
+}
+
+// unverifiedDenied are the permissions withheld from the users until they
+// verify their email
+var unverifiedDenied = map[Permission]bool{PermissionMessage: true}
+
+// SetUnverifiedRestrictions sets the permissions withheld from the users
+// until they verify their email
+func SetUnverifiedRestrictions(permissions []Permission) error {
+	denied := make(map[Permission]bool)
+	for _, permission := range permissions {
+		if !isValidPermission(permission) {
+			return errors.New("unknown permission: " + string(permission))
+		}
+		denied[permission] = true
+	}
+	unverifiedDenied = denied
+	return nil
+}
+
+func isValidPermission(permission Permission) bool {
+	for _, granted := range rolePermissions[RoleAdmin] {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// IsValidRole checks if a role exists
+func IsValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// NeedsVerification checks if a permission is withheld from the user until
+// they verify their email
+func (u *User) NeedsVerification(permission Permission) bool {
+	return !u.EmailVerified && unverifiedDenied[permission]
+}
+
+// Can checks if the role of the user grants a permission
+func (u *User) Can(permission Permission) bool {
+	if u.NeedsVerification(permission) {
+		return false
+	}
+	for _, granted := range rolePermissions[u.Role] {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -15,11 +15,19 @@ const SessionExpiry = 2 * time.Hour
 // AllSessions is a concurrent map to store active sessions.
 var AllSessions sync.Map
 
+// CSRFCookie holds the CSRF token of the session, readable by the scripts of
+// the forum so that they send it back in the CSRFHeader of their requests.
+const (
+	CSRFCookie = "csrf_token"
+	CSRFHeader = "X-CSRF-Token"
+)
+
 // Session represents a user session.
 type Session struct {
-	UserID   string    `json:"user_id"`
-	Nickname string    `json:"nickname"`
-	ExpireAt time.Time `json:"exp"`
+	UserID    string    `json:"user_id"`
+	Nickname  string    `json:"nickname"`
+	ExpireAt  time.Time `json:"exp"`
+	CSRFToken string    `json:"csrf_token"`
 }
 
 // isExpired checks if the session has expired.
@@ -50,6 +58,20 @@ func GetUserFromSession(req *http.Request) *User {
 	return &user
 }
 
+// GetSessionUserID returns the ID of the user of an unexpired session, or an
+// empty string. Unlike ValidSession, it doesn't query the database.
+func GetSessionUserID(req *http.Request) string {
+	cookie, err := req.Cookie("auth_session")
+	if err != nil {
+		return ""
+	}
+	session, ok := AllSessions.Load(cookie.Value)
+	if !ok || session.(Session).isExpired() {
+		return ""
+	}
+	return session.(Session).UserID
+}
+
 // NewSessionToken creates a new session token and sets it as a cookie.
 func NewSessionToken(res http.ResponseWriter, UserID, Nickname string) {
 	sessionToken := generateSessionToken()
@@ -57,17 +79,42 @@ func NewSessionToken(res http.ResponseWriter, UserID, Nickname string) {
 	deleteSessionIfExist(Nickname)
 
 	ExpireAt := time.Now().Add(SessionExpiry)
-	AllSessions.Store(sessionToken, Session{UserID, Nickname, ExpireAt})
+	csrfToken := generateSessionToken()
+	AllSessions.Store(sessionToken, Session{UserID, Nickname, ExpireAt, csrfToken})
 
 	http.SetCookie(res, &http.Cookie{
 		Name:     "auth_session",
 		Value:    sessionToken,
+		Path:     "/", // also set by the nested routes, like /sign-in/two-factor
 		HttpOnly: true,
 		Expires:  ExpireAt,
 		Secure:   true, // Set to true if served over HTTPS
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.SetCookie(res, &http.Cookie{
+		Name:     CSRFCookie,
+		Value:    csrfToken,
+		Path:     "/",
+		Expires:  ExpireAt,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
 	})
 }
 
+// GetSessionCSRFToken returns the CSRF token of the unexpired session of a
+// request, and false when the request carries no such session.
+func GetSessionCSRFToken(req *http.Request) (string, bool) {
+	cookie, err := req.Cookie("auth_session")
+	if err != nil {
+		return "", false
+	}
+	session, ok := AllSessions.Load(cookie.Value)
+	if !ok || session.(Session).isExpired() {
+		return "", false
+	}
+	return session.(Session).CSRFToken, true
+}
+
 // deleteSessionIfExist deletes existing sessions for a given nickname.
 func deleteSessionIfExist(nickname string) {
 	AllSessions.Range(func(key, value interface{}) bool {
@@ -78,12 +125,36 @@ func deleteSessionIfExist(nickname string) {
 	})
 }
 
-// isValidSession checks if a session is valid.
+// isValidSession checks if a session is valid, ending the sessions of the
+// suspended or banned users.
 func isValidSession(sessionToken string) bool {
-	if session, ok := AllSessions.Load(sessionToken); ok {
-		return !session.(Session).isExpired()
+	session, ok := AllSessions.Load(sessionToken)
+	if !ok || session.(Session).isExpired() {
+		return false
 	}
-	return false
+	if sanction, err := UserRepo.GetSanction(session.(Session).UserID); err == nil && sanction != nil {
+		AllSessions.Delete(sessionToken)
+		return false
+	}
+	return true
+}
+
+// GetSessionSanction returns the sanction of the user of the session, if any.
+func GetSessionSanction(req *http.Request) *Sanction {
+	cookie, err := req.Cookie("auth_session")
+	if err != nil {
+		return nil
+	}
+	session, ok := AllSessions.Load(cookie.Value)
+	if !ok {
+		return nil
+	}
+	sanction, err := UserRepo.GetSanction(session.(Session).UserID)
+	if err != nil {
+		log.Println("❌ Failed to retrieve the sanction:", err)
+		return nil
+	}
+	return sanction
 }
 
 // generateSessionToken generates a new session token.
@@ -107,15 +178,24 @@ func CheckIfSessionExist(nickname string) bool {
 	return exist
 }
 
-// DeleteExpiredSessions periodically deletes expired sessions.
+// DeleteExpiredSessions periodically deletes expired sessions, and the
+// expired pre-auth tokens, until Close is called.
 func DeleteExpiredSessions() {
-	for range time.Tick(10 * time.Second) {
-		AllSessions.Range(func(key, value interface{}) bool {
-			if value.(Session).isExpired() {
-				AllSessions.Delete(key)
-			}
-			return true
-		})
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-closing:
+			return
+		case <-ticker.C:
+			AllSessions.Range(func(key, value interface{}) bool {
+				if value.(Session).isExpired() {
+					AllSessions.Delete(key)
+				}
+				return true
+			})
+			deleteExpiredPreAuths()
+		}
 	}
 }
 
@@ -129,3 +209,27 @@ func DeleteSession(req *http.Request) bool {
 	}
 	return false
 }
+
+// DeleteOtherSessions deletes the sessions of a user but the one of the request.
+func DeleteOtherSessions(req *http.Request, userID string) {
+	current := ""
+	if cookie, err := req.Cookie("auth_session"); err == nil {
+		current = cookie.Value
+	}
+	AllSessions.Range(func(key, value interface{}) bool {
+		if value.(Session).UserID == userID && key != current {
+			AllSessions.Delete(key)
+		}
+		return true
+	})
+}
+
+// DeleteUserSessions deletes every session of a user.
+func DeleteUserSessions(userID string) {
+	AllSessions.Range(func(key, value interface{}) bool {
+		if value.(Session).UserID == userID {
+			AllSessions.Delete(key)
+		}
+		return true
+	})
+}
@@ -0,0 +1,156 @@
+package models
+
+import (
+	"database/sql"
+	"real-time-forum/lib"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// TwoFactor is the TOTP setup of a user
+type TwoFactor struct {
+	Secret   string
+	Enabled  bool
+	LastStep int64
+}
+
+type TwoFactorRepository struct {
+	db *sql.DB
+}
+
+func NewTwoFactorRepository(db *sql.DB) *TwoFactorRepository {
+	return &TwoFactorRepository{
+		db: db,
+	}
+}
+
+// Get returns the TOTP setup of a user, nil if they never started one
+func (tr *TwoFactorRepository) Get(userID string) (*TwoFactor, error) {
+	var twoFactor TwoFactor
+	row := tr.db.QueryRow("SELECT secret, enabled, lastStep FROM two_factor WHERE userID = ?", userID)
+	if err := row.Scan(&twoFactor.Secret, &twoFactor.Enabled, &twoFactor.LastStep); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &twoFactor, nil
+}
+
+// IsEnabled checks if signing in to an account asks for a second factor
+func (tr *TwoFactorRepository) IsEnabled(userID string) (bool, error) {
+	twoFactor, err := tr.Get(userID)
+	return twoFactor != nil && twoFactor.Enabled, err
+}
+
+// SetPending stores a secret waiting for a code to confirm it, replacing the
+// unconfirmed one. It returns false when two-factor authentication is
+// already enabled.
+func (tr *TwoFactorRepository) SetPending(userID, secret string) (bool, error) {
+	result, err := tr.db.Exec(`INSERT INTO two_factor (userID, secret) VALUES (?, ?)
+		ON CONFLICT(userID) DO UPDATE SET secret = excluded.secret, lastStep = 0, createDate = CURRENT_TIMESTAMP
+		WHERE enabled = 0`, userID, secret)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// Enable turns two-factor authentication on with the pending secret, once a
+// code of the given time step confirmed it, and stores the recovery codes.
+func (tr *TwoFactorRepository) Enable(userID string, step int64, recoveryCodes []string) (bool, error) {
+	tx, err := tr.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("UPDATE two_factor SET enabled = 1, lastStep = ? WHERE userID = ? AND enabled = 0", step, userID)
+	if err != nil {
+		return false, err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return false, err
+	}
+	if err := replaceRecoveryCodes(tx, userID, recoveryCodes); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// Disable turns two-factor authentication off, forgetting the secret and the
+// recovery codes
+func (tr *TwoFactorRepository) Disable(userID string) error {
+	tx, err := tr.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM two_factor WHERE userID = ?", userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM recovery_code WHERE userID = ?", userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseStep records that the code of a time step was used, returning false if
+// it, or a later one, already was. Each code thus signs in once.
+func (tr *TwoFactorRepository) UseStep(userID string, step int64) (bool, error) {
+	result, err := tr.db.Exec("UPDATE two_factor SET lastStep = ? WHERE userID = ? AND enabled = 1 AND lastStep < ?", step, userID, step)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// ReplaceRecoveryCodes stores new recovery codes, the previous ones no longer
+// working
+func (tr *TwoFactorRepository) ReplaceRecoveryCodes(userID string, recoveryCodes []string) error {
+	tx, err := tr.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(tx, userID, recoveryCodes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseRecoveryCode spends an unused recovery code of a user
+func (tr *TwoFactorRepository) UseRecoveryCode(userID, code string) (bool, error) {
+	result, err := tr.db.Exec("UPDATE recovery_code SET usedAt = CURRENT_TIMESTAMP WHERE codeHash = ? AND userID = ? AND usedAt IS NULL",
+		lib.HashRecoveryCode(code), userID)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// RecoveryCodesLeft counts the unused recovery codes of a user
+func (tr *TwoFactorRepository) RecoveryCodesLeft(userID string) (int, error) {
+	var count int
+	err := tr.db.QueryRow("SELECT COUNT(*) FROM recovery_code WHERE userID = ? AND usedAt IS NULL", userID).Scan(&count)
+	return count, err
+}
+
+// replaceRecoveryCodes stores the hashes of the recovery codes of a user in
+// place of the previous ones
+func replaceRecoveryCodes(tx *sql.Tx, userID string, recoveryCodes []string) error {
+	if _, err := tx.Exec("DELETE FROM recovery_code WHERE userID = ?", userID); err != nil {
+		return err
+	}
+	for _, code := range recoveryCodes {
+		if _, err := tx.Exec("INSERT INTO recovery_code (codeHash, userID) VALUES (?, ?)", lib.HashRecoveryCode(code), userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
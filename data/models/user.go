@@ -5,6 +5,7 @@ import (
 	"log"
 	"real-time-forum/lib"
 	"strings"
+	"time"
 
 	uuid "github.com/gofrs/uuid"
 	_ "github.com/mattn/go-sqlite3"
@@ -20,6 +21,32 @@ type User struct {
 	Email     string `json:"email"`
 	Password  string `json:"password"`
 	AvatarURL string `json:"avatar_url"`
+	Role      string `json:"role"`
+	// EmailVerified tells if the user proved they own their email
+	EmailVerified bool `json:"email_verified"`
+	// Bot tells if the account is run by a script of its owner, through API
+	// tokens only
+	Bot     bool   `json:"bot"`
+	OwnerID string `json:"owner_id,omitempty"`
+}
+
+// Sanction keeps a user out of the forum, for good or until a date
+type Sanction struct {
+	Banned bool       `json:"banned"`
+	Until  *time.Time `json:"until,omitempty"`
+	Reason string     `json:"reason"`
+}
+
+// Message describes the sanction to the sanctioned user
+func (s *Sanction) Message() string {
+	message := "Your account is banned"
+	if !s.Banned {
+		message = "Your account is suspended until " + s.Until.Local().Format("2006-01-02 15:04")
+	}
+	if s.Reason != "" {
+		message += ": " + s.Reason
+	}
+	return message
 }
 
 type UserSignIn struct {
@@ -29,21 +56,32 @@ type UserSignIn struct {
 
 // TODO: Use the same struct for auth user and user item
 type AuthUser struct {
-	ID         string `json:"id"`
-	Nickname   string `json:"nickname"`
-	Firstname  string `json:"firstname"`
-	Lastname   string `json:"lastname"`
-	Age        int    `json:"age"`
-	Gender     string `json:"gender"`
-	IsLoggedIn bool   `json:"is_logged_in"`
-	Email      string `json:"email"`
-	AvatarURL  string `json:"avatar_url"`
+	ID            string `json:"id"`
+	Nickname      string `json:"nickname"`
+	Firstname     string `json:"firstname"`
+	Lastname      string `json:"lastname"`
+	Age           int    `json:"age"`
+	Gender        string `json:"gender"`
+	IsLoggedIn    bool   `json:"is_logged_in"`
+	Email         string `json:"email"`
+	AvatarURL     string `json:"avatar_url"`
+	Role          string `json:"role"`
+	EmailVerified bool   `json:"email_verified"`
+	Bot           bool   `json:"bot"`
 }
 
+// LastSeenLayout is the format of the date a user was last seen, in UTC
+const LastSeenLayout = "2006-01-02 15:04:05"
+
 type UserItem struct {
 	ID              string `json:"id"`
 	Nickname        string `json:"nickname"`
 	IsConnected     bool   `json:"is_connected"`
+	IsBlocked       bool   `json:"is_blocked"`
+	IsMuted         bool   `json:"is_muted"`
+	IsBot           bool   `json:"is_bot"`
+	Presence        string `json:"presence"`
+	LastSeen        string `json:"last_seen"`
 	LastMessage     string `json:"last_message"`
 	LastMessageTime string `json:"last_message_time"`
 }
@@ -69,7 +107,8 @@ func (ur *UserRepository) CreateUser(user *User) error {
 	user.ID = ID.String()
 	user.Email = strings.ToLower(user.Email)
 	user.Nickname = strings.ToLower(user.Nickname)
-	_, err = ur.db.Exec("INSERT INTO user (id, nickname, firstname, lastname, age, gender, email, password, avatarURL) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+	user.Role = RoleMember
+	_, err = ur.db.Exec("INSERT INTO user (id, nickname, firstname, lastname, age, gender, email, password, avatarURL, role, createDate, emailVerified) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, 0)",
 		user.ID,
 		user.Nickname,
 		user.Firstname,
@@ -79,15 +118,50 @@ func (ur *UserRepository) CreateUser(user *User) error {
 		user.Email,
 		user.Password,
 		user.AvatarURL,
+		user.Role,
 	)
 	return err
 }
 
+// CreateBot creates a bot account run by a user. A bot has neither email nor
+// password: it only acts through the API tokens its owner creates.
+func (ur *UserRepository) CreateBot(bot *User, ownerID string) error {
+	ID, err := uuid.NewV4()
+	if err != nil {
+		return err
+	}
+	bot.ID = ID.String()
+	bot.Nickname = strings.ToLower(bot.Nickname)
+	bot.Role = RoleMember
+	bot.Bot, bot.OwnerID, bot.EmailVerified = true, ownerID, true
+	_, err = ur.db.Exec("INSERT INTO user (id, nickname, firstname, lastname, age, gender, password, avatarURL, role, createDate, emailVerified, bot, ownerID) VALUES (?, ?, '', '', 0, '', '', ?, ?, CURRENT_TIMESTAMP, 1, 1, ?)",
+		bot.ID, bot.Nickname, bot.AvatarURL, bot.Role, ownerID)
+	return err
+}
+
+// GetBots lists the bots run by a user
+func (ur *UserRepository) GetBots(ownerID string) ([]User, error) {
+	rows, err := ur.db.Query("SELECT id, nickname, avatarURL, role FROM user WHERE bot = 1 AND ownerID = ? ORDER BY nickname", ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	bots := []User{}
+	for rows.Next() {
+		bot := User{Bot: true, OwnerID: ownerID}
+		if err := rows.Scan(&bot.ID, &bot.Nickname, &bot.AvatarURL, &bot.Role); err != nil {
+			return nil, err
+		}
+		bots = append(bots, bot)
+	}
+	return bots, rows.Err()
+}
+
 // Get a user by ID from the database
 func (ur *UserRepository) GetUserByID(userID string) (*User, error) {
 	var user User
-	row := ur.db.QueryRow("SELECT id, nickname, firstname, lastname, age, gender, email, avatarURL FROM user WHERE id = ?", userID)
-	err := row.Scan(&user.ID, &user.Nickname, &user.Firstname, &user.Lastname, &user.Age, &user.Gender, &user.Email, &user.AvatarURL)
+	row := ur.db.QueryRow("SELECT id, nickname, firstname, lastname, age, gender, COALESCE(email, ''), avatarURL, role, emailVerified, bot, COALESCE(ownerID, '') FROM user WHERE id = ?", userID)
+	err := row.Scan(&user.ID, &user.Nickname, &user.Firstname, &user.Lastname, &user.Age, &user.Gender, &user.Email, &user.AvatarURL, &user.Role, &user.EmailVerified, &user.Bot, &user.OwnerID)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil // User not found
@@ -100,8 +174,8 @@ func (ur *UserRepository) GetUserByID(userID string) (*User, error) {
 // Get a user by email from the database
 func (ur *UserRepository) GetUserByEmail(email string) (*User, error) {
 	var user User
-	row := ur.db.QueryRow("SELECT id, nickname,firstname,lastname,age,gender, email, avatarURL FROM user WHERE email = ?", email)
-	err := row.Scan(&user.ID, &user.Nickname, &user.Email, &user.AvatarURL)
+	row := ur.db.QueryRow("SELECT id, nickname, firstname, lastname, age, gender, email, avatarURL, role, emailVerified FROM user WHERE email = ?", strings.ToLower(email))
+	err := row.Scan(&user.ID, &user.Nickname, &user.Firstname, &user.Lastname, &user.Age, &user.Gender, &user.Email, &user.AvatarURL, &user.Role, &user.EmailVerified)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil // User not found
@@ -111,11 +185,11 @@ func (ur *UserRepository) GetUserByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
-// Get a user by email from the database
+// Get a user by nickname from the database
 func (ur *UserRepository) GetUserByNickname(nickname string) (*User, error) {
 	var user User
-	row := ur.db.QueryRow("SELECT id, nickname, firstname, lastname, age, gender, email, avatarURL FROM user WHERE nickname = ?", nickname)
-	err := row.Scan(&user.ID, &user.Nickname, &user.Email, &user.AvatarURL)
+	row := ur.db.QueryRow("SELECT id, nickname, firstname, lastname, age, gender, COALESCE(email, ''), avatarURL, role, emailVerified FROM user WHERE nickname = ?", strings.ToLower(nickname))
+	err := row.Scan(&user.ID, &user.Nickname, &user.Firstname, &user.Lastname, &user.Age, &user.Gender, &user.Email, &user.AvatarURL, &user.Role, &user.EmailVerified)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil // User not found
@@ -125,13 +199,17 @@ func (ur *UserRepository) GetUserByNickname(nickname string) (*User, error) {
 	return &user, nil
 }
 
-// Select All users
+// Select All users, except the ones who blocked the user
 func (ur *UserRepository) SelectAllUsers(userID string) ([]UserItem, error) {
 	var users []UserItem
 	rows, err := ur.db.Query(`
 	SELECT
 		u.ID,
 		u.nickname,
+		COALESCE(u.lastSeen, '') AS last_seen,
+		EXISTS (SELECT 1 FROM block b WHERE b.blockerID = ? AND b.blockedID = u.ID) AS is_blocked,
+		EXISTS (SELECT 1 FROM mute mu WHERE mu.userID = ? AND mu.talkerID = u.ID) AS is_muted,
+		u.bot,
 		COALESCE(m.content, '') AS last_message,
 		COALESCE(m.createDate, '') AS last_message_time
 	FROM user u
@@ -143,21 +221,23 @@ func (ur *UserRepository) SelectAllUsers(userID string) ([]UserItem, error) {
 			END AS otherUserID,
 			MAX(createDate) AS maxCreateDate
 		FROM message
-		WHERE senderID = ? OR receiverID = ?
+		WHERE (senderID = ? OR receiverID = ?) AND hidden = 0
 		GROUP BY otherUserID
 	) latestMessages ON u.ID = latestMessages.otherUserID
 	LEFT JOIN message m ON (latestMessages.otherUserID = m.senderID OR latestMessages.otherUserID = m.receiverID) AND latestMessages.maxCreateDate = m.createDate
+	WHERE u.ID NOT IN (SELECT blockerID FROM block WHERE blockedID = ?)
 	ORDER BY last_message_time DESC, u.nickname 
-	`, userID, userID, userID, userID)
+	`, userID, userID, userID, userID, userID, userID, userID)
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var ID, nickname, lastMessage, lastMessageTime string
+		var ID, nickname, lastSeen, lastMessage, lastMessageTime string
+		var isBlocked, isMuted, isBot bool
 
-		err = rows.Scan(&ID, &nickname, &lastMessage, &lastMessageTime)
+		err = rows.Scan(&ID, &nickname, &lastSeen, &isBlocked, &isMuted, &isBot, &lastMessage, &lastMessageTime)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -165,6 +245,10 @@ func (ur *UserRepository) SelectAllUsers(userID string) ([]UserItem, error) {
 		user := UserItem{
 			ID:              ID,
 			Nickname:        nickname,
+			IsBlocked:       isBlocked,
+			IsMuted:         isMuted,
+			IsBot:           isBot,
+			LastSeen:        formatLastSeen(lastSeen),
 			LastMessage:     lastMessage,
 			LastMessageTime: lastMessageTime,
 		}
@@ -178,6 +262,134 @@ func (ur *UserRepository) SelectAllUsers(userID string) ([]UserItem, error) {
 	return users, nil
 }
 
+// formatLastSeen formats the lastSeen column, empty for the users never seen
+func formatLastSeen(value string) string {
+	for _, layout := range []string{time.RFC3339, LastSeenLayout} {
+		if lastSeen, err := time.Parse(layout, value); err == nil {
+			return lastSeen.UTC().Format(LastSeenLayout)
+		}
+	}
+	return ""
+}
+
+// UpdateLastSeen records that the user was connected just now
+func (ur *UserRepository) UpdateLastSeen(userID string) error {
+	_, err := ur.db.Exec("UPDATE user SET lastSeen = CURRENT_TIMESTAMP WHERE id = ?", userID)
+	return err
+}
+
+// SetRole grants a role to a user
+func (ur *UserRepository) SetRole(userID, role string) error {
+	_, err := ur.db.Exec("UPDATE user SET role = ? WHERE id = ?", role, userID)
+	return err
+}
+
+// GrantAdmins makes admins of the users with the given nicknames or emails
+func (ur *UserRepository) GrantAdmins(identifiants []string) error {
+	for _, identifiant := range identifiants {
+		identifiant = strings.ToLower(strings.TrimSpace(identifiant))
+		if identifiant == "" {
+			continue
+		}
+		_, err := ur.db.Exec("UPDATE user SET role = ? WHERE email = ? OR nickname = ?", RoleAdmin, identifiant, identifiant)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VerifyEmail marks the email of a user as verified, if it is still theirs
+func (ur *UserRepository) VerifyEmail(userID, email string) (bool, error) {
+	result, err := ur.db.Exec("UPDATE user SET emailVerified = 1 WHERE id = ? AND email = ?", userID, email)
+	if err != nil {
+		return false, err
+	}
+	count, err := result.RowsAffected()
+	return count > 0, err
+}
+
+// GetPasswordHash returns the password hash of a user
+func (ur *UserRepository) GetPasswordHash(userID string) (string, error) {
+	var hashedPassword string
+	err := ur.db.QueryRow("SELECT password FROM user WHERE id = ?", userID).Scan(&hashedPassword)
+	return hashedPassword, err
+}
+
+// SetPassword replaces the password hash of a user
+func (ur *UserRepository) SetPassword(userID, hashedPassword string) error {
+	_, err := ur.db.Exec("UPDATE user SET password = ? WHERE id = ?", hashedPassword, userID)
+	return err
+}
+
+// Suspend keeps a user out until a date
+func (ur *UserRepository) Suspend(userID string, until time.Time, reason string) error {
+	_, err := ur.db.Exec("UPDATE user SET suspendedUntil = ?, bannedAt = NULL, banReason = ? WHERE id = ?", until.UTC(), reason, userID)
+	return err
+}
+
+// Ban keeps a user out for good
+func (ur *UserRepository) Ban(userID, reason string) error {
+	_, err := ur.db.Exec("UPDATE user SET bannedAt = CURRENT_TIMESTAMP, suspendedUntil = NULL, banReason = ? WHERE id = ?", reason, userID)
+	return err
+}
+
+// LiftSanction ends the suspension or the ban of a user
+func (ur *UserRepository) LiftSanction(userID string) error {
+	_, err := ur.db.Exec("UPDATE user SET bannedAt = NULL, suspendedUntil = NULL, banReason = NULL WHERE id = ?", userID)
+	return err
+}
+
+// GetSanction returns the ban or the running suspension of a user, nil if
+// they are free to use the forum
+func (ur *UserRepository) GetSanction(userID string) (*Sanction, error) {
+	var bannedAt, suspendedUntil sql.NullTime
+	var reason sql.NullString
+	row := ur.db.QueryRow("SELECT bannedAt, suspendedUntil, banReason FROM user WHERE id = ?", userID)
+	if err := row.Scan(&bannedAt, &suspendedUntil, &reason); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil // User not found
+		}
+		return nil, err
+	}
+	if bannedAt.Valid {
+		return &Sanction{Banned: true, Reason: reason.String}, nil
+	}
+	if suspendedUntil.Valid && suspendedUntil.Time.After(time.Now()) {
+		return &Sanction{Until: &suspendedUntil.Time, Reason: reason.String}, nil
+	}
+	return nil, nil
+}
+
+// IsNewAccount checks if a user signed up less than a duration ago. The
+// accounts created before the sign up date was recorded are not new.
+func (ur *UserRepository) IsNewAccount(userID string, age time.Duration) (bool, error) {
+	var count int
+	row := ur.db.QueryRow("SELECT COUNT(*) FROM user WHERE id = ? AND createDate > datetime('now', ?)",
+		userID, secondsAgo(age))
+	err := row.Scan(&count)
+	return count > 0, err
+}
+
+// GetStaff lists the moderators and admins
+func (ur *UserRepository) GetStaff() ([]User, error) {
+	rows, err := ur.db.Query("SELECT id, nickname, COALESCE(email, ''), avatarURL, role FROM user WHERE role != ? ORDER BY role, nickname", RoleMember)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.ID, &user.Nickname, &user.Email, &user.AvatarURL, &user.Role); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
 // Select All users
 func (ur *UserRepository) SelectAllUsersOfPost(postID string) ([]User, error) {
 	var user []User
@@ -211,8 +423,8 @@ func (ur *UserRepository) SelectAllUsersOfPost(postID string) ([]User, error) {
 func (ur *UserRepository) IsExistedByIdentifiant(identifiant string) (*User, bool) {
 	var user User
 	identifiant = strings.ToLower(identifiant)
-	row := ur.db.QueryRow("SELECT id, nickname, firstname, lastname, age, gender, email, avatarURL, password FROM user WHERE email = ? OR nickname = ?", identifiant, identifiant)
-	err := row.Scan(&user.ID, &user.Nickname, &user.Firstname, &user.Lastname, &user.Age, &user.Gender, &user.Email, &user.AvatarURL, &user.Password)
+	row := ur.db.QueryRow("SELECT id, nickname, firstname, lastname, age, gender, COALESCE(email, ''), avatarURL, role, emailVerified, COALESCE(password, '') FROM user WHERE email = ? OR nickname = ?", identifiant, identifiant)
+	err := row.Scan(&user.ID, &user.Nickname, &user.Firstname, &user.Lastname, &user.Age, &user.Gender, &user.Email, &user.AvatarURL, &user.Role, &user.EmailVerified, &user.Password)
 	if err != nil {
 		log.Println("❌ ", err)
 		if err == sql.ErrNoRows {
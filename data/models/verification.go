@@ -0,0 +1,63 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"real-time-forum/lib"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var ErrInvalidVerificationToken = errors.New("invalid or expired verification token")
+
+type EmailVerificationRepository struct {
+	db *sql.DB
+}
+
+func NewEmailVerificationRepository(db *sql.DB) *EmailVerificationRepository {
+	return &EmailVerificationRepository{
+		db: db,
+	}
+}
+
+// CreateToken hands out a token verifying the email of a user until it
+// expires, replacing their unused ones. Only its hash is stored.
+func (vr *EmailVerificationRepository) CreateToken(userID, email string, ttl time.Duration) (string, error) {
+	token, tokenHash, err := lib.NewToken()
+	if err != nil {
+		return "", err
+	}
+	if _, err := vr.db.Exec("DELETE FROM email_verification WHERE userID = ? AND usedAt IS NULL", userID); err != nil {
+		return "", err
+	}
+	_, err = vr.db.Exec("INSERT INTO email_verification (tokenHash, userID, email, expireAt) VALUES (?, ?, ?, datetime('now', ?))",
+		tokenHash, userID, email, secondsFromNow(ttl))
+	return token, err
+}
+
+// LastCreated returns when the last token of a user was handed out, the zero
+// time if none was
+func (vr *EmailVerificationRepository) LastCreated(userID string) (time.Time, error) {
+	var last int64
+	row := vr.db.QueryRow("SELECT COALESCE(CAST(strftime('%s', MAX(createDate)) AS INTEGER), 0) FROM email_verification WHERE userID = ?", userID)
+	if err := row.Scan(&last); err != nil || last == 0 {
+		return time.Time{}, err
+	}
+	return time.Unix(last, 0), nil
+}
+
+// UseToken spends a valid token, returning the user and the email it
+// verifies. A token can only be used once.
+func (vr *EmailVerificationRepository) UseToken(token string) (string, string, error) {
+	var userID, email string
+	row := vr.db.QueryRow(`UPDATE email_verification SET usedAt = CURRENT_TIMESTAMP
+		WHERE tokenHash = ? AND usedAt IS NULL AND expireAt > datetime('now') RETURNING userID, email`, lib.HashToken(token))
+	if err := row.Scan(&userID, &email); err != nil {
+		if err == sql.ErrNoRows {
+			return "", "", ErrInvalidVerificationToken
+		}
+		return "", "", err
+	}
+	return userID, email, nil
+}
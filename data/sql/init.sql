@@ -8,7 +8,16 @@ CREATE TABLE IF NOT EXISTS "user" (
     gender VARCHAR,
     email VARCHAR UNIQUE,
     password TEXT,
-    avatarURL VARCHAR
+    avatarURL VARCHAR,
+    lastSeen TIMESTAMP,
+    role VARCHAR NOT NULL DEFAULT 'member',
+    bannedAt TIMESTAMP,
+    suspendedUntil TIMESTAMP,
+    banReason TEXT,
+    createDate TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    emailVerified BOOLEAN NOT NULL DEFAULT 0,
+    bot BOOLEAN NOT NULL DEFAULT 0,
+    ownerID VARCHAR
 );
 
 -- Table for 'category'
@@ -26,6 +35,7 @@ CREATE TABLE IF NOT EXISTS "post" (
     description TEXT,
     authorID VARCHAR,
     createDate TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    hidden BOOLEAN NOT NULL DEFAULT 0,
     FOREIGN KEY (authorID) REFERENCES "user"(id)
 );
 
@@ -45,6 +55,7 @@ CREATE TABLE IF NOT EXISTS "comment" (
     authorID VARCHAR,
     postID VARCHAR,
     createDate TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    hidden BOOLEAN NOT NULL DEFAULT 0,
     FOREIGN KEY (authorID) REFERENCES "user"(id),
     FOREIGN KEY (postID) REFERENCES "post"(id)
 );
@@ -56,6 +67,166 @@ CREATE TABLE IF NOT EXISTS "message" (
     receiverID VARCHAR,
     content TEXT,
     createDate TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    hidden BOOLEAN NOT NULL DEFAULT 0,
     FOREIGN KEY (senderID) REFERENCES "user"(id),
     FOREIGN KEY (receiverID) REFERENCES "user"(id)
 );
+
+-- Table for 'block'
+CREATE TABLE IF NOT EXISTS "block" (
+    blockerID VARCHAR,
+    blockedID VARCHAR,
+    hideContent BOOLEAN DEFAULT 0,
+    createDate TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (blockerID, blockedID),
+    FOREIGN KEY (blockerID) REFERENCES "user"(id),
+    FOREIGN KEY (blockedID) REFERENCES "user"(id)
+);
+
+-- Table for 'mute'
+CREATE TABLE IF NOT EXISTS "mute" (
+    userID VARCHAR,
+    talkerID VARCHAR,
+    createDate TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (userID, talkerID),
+    FOREIGN KEY (userID) REFERENCES "user"(id),
+    FOREIGN KEY (talkerID) REFERENCES "user"(id)
+);
+
+-- Table for 'report'
+CREATE TABLE IF NOT EXISTS "report" (
+    id VARCHAR PRIMARY KEY,
+    reporterID VARCHAR,
+    contentType VARCHAR,
+    contentID VARCHAR,
+    authorID VARCHAR,
+    excerpt TEXT,
+    reason VARCHAR,
+    details TEXT,
+    status VARCHAR NOT NULL DEFAULT 'open',
+    assigneeID VARCHAR,
+    resolution VARCHAR,
+    note TEXT,
+    resolverID VARCHAR,
+    createDate TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    resolveDate TIMESTAMP,
+    FOREIGN KEY (reporterID) REFERENCES "user"(id),
+    FOREIGN KEY (authorID) REFERENCES "user"(id),
+    FOREIGN KEY (assigneeID) REFERENCES "user"(id),
+    FOREIGN KEY (resolverID) REFERENCES "user"(id)
+);
+
+-- Table for 'filter_rule'
+CREATE TABLE IF NOT EXISTS "filter_rule" (
+    id VARCHAR PRIMARY KEY,
+    pattern TEXT,
+    regex BOOLEAN NOT NULL DEFAULT 0,
+    action VARCHAR,
+    authorID VARCHAR,
+    createDate TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (authorID) REFERENCES "user"(id)
+);
+
+-- Table for 'login_failure', the failed sign-in attempts by account and IP
+CREATE TABLE IF NOT EXISTS "login_failure" (
+    account VARCHAR NOT NULL,
+    ip VARCHAR NOT NULL,
+    createDate TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS login_failure_account ON "login_failure" (account, createDate);
+CREATE INDEX IF NOT EXISTS login_failure_ip ON "login_failure" (ip, createDate);
+
+-- Table for 'password_reset', the single-use tokens resetting a password
+CREATE TABLE IF NOT EXISTS "password_reset" (
+    tokenHash VARCHAR PRIMARY KEY,
+    userID VARCHAR NOT NULL,
+    expireAt TIMESTAMP NOT NULL,
+    usedAt TIMESTAMP,
+    createDate TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (userID) REFERENCES "user"(id)
+);
+
+-- Table for 'email_verification', the single-use tokens verifying an email
+CREATE TABLE IF NOT EXISTS "email_verification" (
+    tokenHash VARCHAR PRIMARY KEY,
+    userID VARCHAR NOT NULL,
+    email VARCHAR NOT NULL,
+    expireAt TIMESTAMP NOT NULL,
+    usedAt TIMESTAMP,
+    createDate TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (userID) REFERENCES "user"(id)
+);
+
+-- Table for 'two_factor', the TOTP secret of a user, enabled once a code
+-- confirmed it. lastStep is the time step of the last code used, so that a
+-- code can't be used twice.
+CREATE TABLE IF NOT EXISTS "two_factor" (
+    userID VARCHAR PRIMARY KEY,
+    secret VARCHAR NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT 0,
+    lastStep INTEGER NOT NULL DEFAULT 0,
+    createDate TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (userID) REFERENCES "user"(id)
+);
+
+-- Table for 'recovery_code', the single-use codes signing in without the
+-- authenticator app
+CREATE TABLE IF NOT EXISTS "recovery_code" (
+    codeHash VARCHAR PRIMARY KEY,
+    userID VARCHAR NOT NULL,
+    usedAt TIMESTAMP,
+    FOREIGN KEY (userID) REFERENCES "user"(id)
+);
+
+-- Table for 'oidc_identity', the accounts of the OpenID Connect providers
+-- linked to the users
+CREATE TABLE IF NOT EXISTS "oidc_identity" (
+    provider VARCHAR NOT NULL,
+    subject VARCHAR NOT NULL,
+    userID VARCHAR NOT NULL,
+    createDate TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (provider, subject),
+    UNIQUE (provider, userID),
+    FOREIGN KEY (userID) REFERENCES "user"(id)
+);
+
+-- Table for 'api_token', the personal tokens of the scripts and the bots.
+-- Only the hash of a token is stored.
+CREATE TABLE IF NOT EXISTS "api_token" (
+    id VARCHAR PRIMARY KEY,
+    userID VARCHAR NOT NULL,
+    name VARCHAR NOT NULL,
+    tokenHash VARCHAR NOT NULL UNIQUE,
+    scopes VARCHAR NOT NULL,
+    expireAt TIMESTAMP,
+    lastUsedAt TIMESTAMP,
+    lastUsedIP VARCHAR,
+    createDate TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (userID) REFERENCES "user"(id)
+);
+CREATE INDEX IF NOT EXISTS idx_api_token_user ON api_token(userID);
+
+-- Table for 'audit', append-only
+CREATE TABLE IF NOT EXISTS "audit" (
+    id VARCHAR PRIMARY KEY,
+    actorID VARCHAR,
+    action VARCHAR,
+    targetType VARCHAR,
+    targetID VARCHAR,
+    before TEXT,
+    after TEXT,
+    ip VARCHAR,
+    createDate TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (actorID) REFERENCES "user"(id)
+);
+
+CREATE TRIGGER IF NOT EXISTS audit_no_update BEFORE UPDATE ON "audit"
+BEGIN
+    SELECT RAISE(ABORT, 'the audit log is append-only');
+END;
+
+CREATE TRIGGER IF NOT EXISTS audit_no_delete BEFORE DELETE ON "audit"
+BEGIN
+    SELECT RAISE(ABORT, 'the audit log is append-only');
+END;
@@ -10,4 +10,7 @@ require (
 	golang.org/x/crypto v0.16.0
 )
 
-require golang.org/x/net v0.17.0 // indirect
+require (
+	golang.org/x/net v0.17.0 // indirect
+	golang.org/x/sys v0.15.0 // indirect
+)
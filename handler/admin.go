@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"real-time-forum/data/models"
+	"real-time-forum/lib"
+	"strings"
+)
+
+// GetStaff lists the moderators and admins
+func GetStaff(res http.ResponseWriter, req *http.Request) {
+	if lib.ValidateRequest(req, res, "/admin/staff", http.MethodGet) {
+		if _, ok := authorize(res, req, models.PermissionManageRoles); !ok {
+			return
+		}
+		staff, err := models.UserRepo.GetStaff()
+		if err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error getting staff : "+err.Error())
+			return
+		}
+		lib.SendJSONResponse(res, http.StatusOK, map[string]any{"staff": staff})
+	}
+}
+
+// SetRole grants a role to the user of the URL
+func SetRole(res http.ResponseWriter, req *http.Request) {
+	if lib.ValidateRequest(req, res, "/admin/role/*", http.MethodPost) {
+		admin, ok := authorize(res, req, models.PermissionManageRoles)
+		if !ok {
+			return
+		}
+		userID, ok := targetUser(res, req, admin.ID)
+		if !ok {
+			return
+		}
+		var grant struct {
+			Role string `json:"role"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&grant); err != nil {
+			lib.HandleError(res, http.StatusBadRequest, "Invalid JSON format")
+			return
+		}
+		grant.Role = strings.ToLower(strings.TrimSpace(grant.Role))
+		if !models.IsValidRole(grant.Role) {
+			lib.HandleError(res, http.StatusBadRequest, "Unknown role")
+			return
+		}
+		user, err := models.UserRepo.GetUserByID(userID)
+		if err != nil || user == nil {
+			lib.HandleError(res, http.StatusNotFound, "User not found")
+			return
+		}
+		if err := models.UserRepo.SetRole(userID, grant.Role); err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error granting role : "+err.Error())
+			return
+		}
+		audit(req, admin, models.AuditRoleGrant, models.AuditTargetUser, userID,
+			map[string]string{"role": user.Role}, map[string]string{"role": grant.Role})
+		lib.SendJSONResponse(res, http.StatusOK, map[string]any{"message": "role granted successfully"})
+	}
+}
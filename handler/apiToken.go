@@ -0,0 +1,292 @@
+package handler
+
+import (
+	"encoding/json"
+	"html"
+	"net/http"
+	"real-time-forum/data/models"
+	"real-time-forum/lib"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+const (
+	// maxTokensPerUser caps the API tokens of an account, bot or not
+	maxTokensPerUser = 10
+	// maxBotsPerUser caps the bot accounts a user runs
+	maxBotsPerUser = 5
+	// maxTokenNameLength caps the names telling the tokens apart
+	maxTokenNameLength = 50
+	// maxTokenDays is the longest lifetime of an expiring token
+	maxTokenDays = 365
+	// tokenOwnerTTL is how long RequestUserID remembers the owner of a token
+	tokenOwnerTTL = time.Minute
+	// maxTokenOwners bounds the tokens remembered, the unknown ones included
+	maxTokenOwners = 10000
+)
+
+// tokenOwner is the user of a token as last looked up, empty for an unknown
+// token.
+type tokenOwner struct {
+	userID   string
+	expireAt time.Time
+}
+
+// tokenOwners caches the owners of the tokens by hash, sparing the rate
+// limiter a query per request.
+var tokenOwners = struct {
+	sync.Mutex
+	owners map[string]tokenOwner
+}{owners: make(map[string]tokenOwner)}
+
+type tokenInput struct {
+	Name          string              `json:"name"`
+	Scopes        []models.Permission `json:"scopes"`
+	ExpiresInDays int                 `json:"expiresInDays"` // 0 never expires
+	Bot           string              `json:"bot"`           // the ID of a bot of the user, empty for themselves
+}
+
+// bearerToken returns the API token of the Authorization header, if any
+func bearerToken(req *http.Request) (string, bool) {
+	header := req.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return "", false
+	}
+	token := strings.TrimSpace(header[7:])
+	return token, token != ""
+}
+
+// RequestUserID returns the ID of the user of the session or the API token
+// of a request, or an empty string. It neither checks nor records the use of
+// the token, and suits the rate limiter: the owners of the tokens are cached
+// for tokenOwnerTTL, so a revoked token may still count against its owner for
+// that long while authorize refuses it.
+func RequestUserID(req *http.Request) string {
+	if token, ok := bearerToken(req); ok {
+		return tokenUserID(token)
+	}
+	return models.GetSessionUserID(req)
+}
+
+// tokenUserID looks up the owner of a token, from the cache if still fresh.
+func tokenUserID(token string) string {
+	hash := lib.HashToken(token)
+	now := time.Now()
+	tokenOwners.Lock()
+	owner, ok := tokenOwners.owners[hash]
+	tokenOwners.Unlock()
+	if ok && now.Before(owner.expireAt) {
+		return owner.userID
+	}
+
+	userID := models.TokenRepo.GetTokenUserID(token)
+	tokenOwners.Lock()
+	defer tokenOwners.Unlock()
+	if len(tokenOwners.owners) >= maxTokenOwners {
+		for key, owner := range tokenOwners.owners {
+			if !now.Before(owner.expireAt) {
+				delete(tokenOwners.owners, key)
+			}
+		}
+	}
+	if len(tokenOwners.owners) < maxTokenOwners {
+		tokenOwners.owners[hash] = tokenOwner{userID, now.Add(tokenOwnerTTL)}
+	}
+	return userID
+}
+
+// GetTokens lists the API tokens of the user in session, or of one of their
+// bots with ?bot={id}
+func GetTokens(res http.ResponseWriter, req *http.Request) {
+	if lib.ValidateRequest(req, res, "/tokens", http.MethodGet) {
+		user, ok := authorize(res, req, models.PermissionAccount)
+		if !ok {
+			return
+		}
+		accountID, ok := tokenAccount(res, user, req.URL.Query().Get("bot"))
+		if !ok {
+			return
+		}
+		tokens, err := models.TokenRepo.GetTokens(accountID)
+		if err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error getting API tokens : "+err.Error())
+			return
+		}
+		lib.SendJSONResponse(res, http.StatusOK, map[string]any{"tokens": tokens, "scopes": models.TokenScopes})
+	}
+}
+
+// CreateToken hands out an API token to the user in session or one of their
+// bots. The secret is only ever shown in this answer.
+func CreateToken(res http.ResponseWriter, req *http.Request) {
+	if lib.ValidateRequest(req, res, "/token", http.MethodPost) {
+		user, ok := authorize(res, req, models.PermissionAccount)
+		if !ok {
+			return
+		}
+		var input tokenInput
+		if err := json.NewDecoder(req.Body).Decode(&input); err != nil {
+			lib.HandleError(res, http.StatusBadRequest, "Invalid JSON format")
+			return
+		}
+		input.Name = strings.TrimSpace(input.Name)
+		if input.Name == "" || len(input.Scopes) == 0 {
+			lib.HandleError(res, http.StatusBadRequest, ErrMissingRequiredFields.Error())
+			return
+		}
+		if len(input.Name) > maxTokenNameLength {
+			lib.HandleError(res, http.StatusBadRequest, "The name of a token is too long")
+			return
+		}
+		for _, scope := range input.Scopes {
+			if !models.IsValidScope(scope) {
+				lib.HandleError(res, http.StatusBadRequest, "Unknown scope "+string(scope))
+				return
+			}
+		}
+		if input.ExpiresInDays < 0 || input.ExpiresInDays > maxTokenDays {
+			lib.HandleError(res, http.StatusBadRequest, "A token expires within a year, or never")
+			return
+		}
+		accountID, ok := tokenAccount(res, user, input.Bot)
+		if !ok {
+			return
+		}
+		count, err := models.TokenRepo.CountTokens(accountID)
+		if err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error counting API tokens")
+			return
+		}
+		if count >= maxTokensPerUser {
+			lib.HandleError(res, http.StatusConflict, "Too many API tokens, revoke one first")
+			return
+		}
+
+		ttl := time.Duration(input.ExpiresInDays) * 24 * time.Hour
+		token, apiToken, err := models.TokenRepo.CreateToken(accountID, html.EscapeString(input.Name), input.Scopes, ttl)
+		if err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error creating API token : "+err.Error())
+			return
+		}
+		if input.Bot == "" {
+			sendMail(user.Email, "A new API token was created",
+				"Hello "+user.Nickname+",\n\nThe API token \""+input.Name+"\" was created for your account.\n"+
+					"If it wasn't you, revoke it and reset your password at once: "+PublicURL+"/#/password/forgot\n")
+		}
+		lib.SendJSONResponse(res, http.StatusCreated, map[string]any{"message": "API token created, copy it now as it won't be shown again", "token": token, "apiToken": apiToken})
+	}
+}
+
+// DeleteToken revokes an API token of the user in session, or of one of
+// their bots with ?bot={id}
+func DeleteToken(res http.ResponseWriter, req *http.Request) {
+	if lib.ValidateRequest(req, res, "/token/*", http.MethodDelete) {
+		user, ok := authorize(res, req, models.PermissionAccount)
+		if !ok {
+			return
+		}
+		pathPart := strings.Split(req.URL.Path, "/")
+		tokenID := pathPart[len(pathPart)-1]
+		accountID, ok := tokenAccount(res, user, req.URL.Query().Get("bot"))
+		if !ok {
+			return
+		}
+		deleted, err := models.TokenRepo.DeleteToken(tokenID, accountID)
+		if err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error revoking API token : "+err.Error())
+			return
+		}
+		if !deleted {
+			lib.HandleError(res, http.StatusNotFound, "API token not found")
+			return
+		}
+		lib.SendJSONResponse(res, http.StatusOK, map[string]string{"message": "API token revoked"})
+	}
+}
+
+// GetBots lists the bot accounts of the user in session
+func GetBots(res http.ResponseWriter, req *http.Request) {
+	if lib.ValidateRequest(req, res, "/bots", http.MethodGet) {
+		user, ok := authorize(res, req, models.PermissionAccount)
+		if !ok {
+			return
+		}
+		bots, err := models.UserRepo.GetBots(user.ID)
+		if err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error getting bots : "+err.Error())
+			return
+		}
+		lib.SendJSONResponse(res, http.StatusOK, map[string]any{"bots": bots})
+	}
+}
+
+// CreateBot creates a bot account run by the user in session. A bot can't
+// sign in: it acts through the API tokens its owner creates for it.
+func CreateBot(res http.ResponseWriter, req *http.Request) {
+	if lib.ValidateRequest(req, res, "/bot", http.MethodPost) {
+		user, ok := authorize(res, req, models.PermissionAccount)
+		if !ok {
+			return
+		}
+		if user.Bot {
+			lib.HandleError(res, http.StatusForbidden, "A bot can't run bots")
+			return
+		}
+		var bot models.User
+		if err := json.NewDecoder(req.Body).Decode(&bot); err != nil {
+			lib.HandleError(res, http.StatusBadRequest, "Invalid JSON format")
+			return
+		}
+		bot.Nickname = strings.TrimSpace(bot.Nickname)
+		if bot.Nickname == "" {
+			lib.HandleError(res, http.StatusBadRequest, ErrMissingRequiredFields.Error())
+			return
+		}
+		if err := screenNickname(bot.Nickname); err != nil {
+			lib.HandleError(res, http.StatusBadRequest, err.Error())
+			return
+		}
+		bot.Nickname = html.EscapeString(bot.Nickname)
+		bots, err := models.UserRepo.GetBots(user.ID)
+		if err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error getting bots")
+			return
+		}
+		if len(bots) >= maxBotsPerUser {
+			lib.HandleError(res, http.StatusConflict, "Too many bots")
+			return
+		}
+
+		bot = models.User{Nickname: bot.Nickname, AvatarURL: models.DEFAULT_AVATAR}
+		if err := models.UserRepo.CreateBot(&bot, user.ID); err != nil {
+			if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.Code == sqlite3.ErrConstraint {
+				lib.HandleError(res, http.StatusConflict, "Nickname already exists")
+				return
+			}
+			lib.HandleError(res, http.StatusInternalServerError, "Error creating bot : "+err.Error())
+			return
+		}
+		lib.SendJSONResponse(res, http.StatusCreated, map[string]any{"message": "Bot created, create an API token for it", "bot": bot})
+	}
+}
+
+// tokenAccount returns the account whose tokens the user manages: their own,
+// or one of their bots. Other bots answer 404, as if they didn't exist.
+func tokenAccount(res http.ResponseWriter, user *models.User, botID string) (string, bool) {
+	if botID == "" {
+		return user.ID, true
+	}
+	bot, err := models.UserRepo.GetUserByID(botID)
+	if err != nil {
+		lib.HandleError(res, http.StatusInternalServerError, "Error retrieving bot")
+		return "", false
+	}
+	if bot == nil || !bot.Bot || bot.OwnerID != user.ID {
+		lib.HandleError(res, http.StatusNotFound, "Bot not found")
+		return "", false
+	}
+	return bot.ID, true
+}
@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"real-time-forum/data/models"
+	"real-time-forum/lib"
+	"strconv"
+	"time"
+)
+
+// GetAuditLog lists the entries of the audit log, filtered by the actor,
+// action, targetType, target, from and to query parameters
+func GetAuditLog(res http.ResponseWriter, req *http.Request) {
+	if lib.ValidateRequest(req, res, "/admin/audit", http.MethodGet) {
+		if _, ok := authorize(res, req, models.PermissionAudit); !ok {
+			return
+		}
+		filter := auditFilter(req)
+		page, err := strconv.Atoi(req.URL.Query().Get("page"))
+		if err != nil || page < 1 {
+			page = 1
+		}
+		limit, err := strconv.Atoi(req.URL.Query().Get("limit"))
+		if err != nil || limit < 1 || limit > 100 {
+			limit = 50
+		}
+		filter.Offset = (page - 1) * limit
+		filter.Limit = limit
+
+		entries, err := models.AuditRepo.GetEntries(filter)
+		if err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error getting audit log : "+err.Error())
+			return
+		}
+		lib.SendJSONResponse(res, http.StatusOK, map[string]any{"entries": entries})
+	}
+}
+
+// ExportAuditLog downloads every entry of the audit log matching the same
+// filters as GetAuditLog as a JSON file
+func ExportAuditLog(res http.ResponseWriter, req *http.Request) {
+	if lib.ValidateRequest(req, res, "/admin/audit/export", http.MethodGet) {
+		if _, ok := authorize(res, req, models.PermissionAudit); !ok {
+			return
+		}
+		entries, err := models.AuditRepo.GetEntries(auditFilter(req))
+		if err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error exporting audit log : "+err.Error())
+			return
+		}
+		res.Header().Set("Content-Disposition", `attachment; filename="audit-`+time.Now().Format("20060102-150405")+`.json"`)
+		lib.SendJSONResponse(res, http.StatusOK, entries)
+	}
+}
+
+func auditFilter(req *http.Request) models.AuditFilter {
+	query := req.URL.Query()
+	return models.AuditFilter{
+		ActorID:    query.Get("actor"),
+		Action:     query.Get("action"),
+		TargetType: query.Get("targetType"),
+		TargetID:   query.Get("target"),
+		From:       query.Get("from"),
+		To:         query.Get("to"),
+	}
+}
+
+// audit records an action of a moderator or an admin. The action is already
+// done, so a failure is only logged.
+func audit(req *http.Request, actor *models.User, action, targetType, targetID string, before, after interface{}) {
+	entry := models.AuditEntry{
+		ActorID:    actor.ID,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		IP:         lib.ClientIP(req),
+	}
+	var err error
+	if entry.Before, err = json.Marshal(before); err != nil {
+		log.Println("❌ Failed to snapshot the audited target:", err)
+	}
+	if entry.After, err = json.Marshal(after); err != nil {
+		log.Println("❌ Failed to snapshot the audited target:", err)
+	}
+	if err := models.AuditRepo.Record(&entry); err != nil {
+		log.Println("❌ Failed to record an audit entry:", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"html"
+	"log"
 	"net/http"
 	"real-time-forum/data/models"
 	"real-time-forum/lib"
@@ -44,17 +45,21 @@ func SignUp(res http.ResponseWriter, req *http.Request) {
 			return
 		}
 
-		// Respond with success
+		// Respond with success, the account staying unverified until the link
+		// mailed to its email is followed
+		mailVerificationLink(&user)
 		models.NewSessionToken(res, user.ID, user.Nickname)
 		authUser := models.AuthUser{
-			ID:        user.ID,
-			Nickname:  user.Nickname,
-			Firstname: user.Firstname,
-			Lastname:  user.Lastname,
-			Age:       user.Age,
-			Gender:    user.Gender,
-			Email:     user.Email,
-			AvatarURL: user.AvatarURL,
+			ID:            user.ID,
+			Nickname:      user.Nickname,
+			Firstname:     user.Firstname,
+			Lastname:      user.Lastname,
+			Age:           user.Age,
+			Gender:        user.Gender,
+			Email:         user.Email,
+			AvatarURL:     user.AvatarURL,
+			Role:          user.Role,
+			EmailVerified: user.EmailVerified,
 		}
 		lib.SendJSONResponse(res, http.StatusOK, map[string]any{"message": "User created successfully", "user": authUser})
 	}
@@ -75,34 +80,94 @@ func SignIn(res http.ResponseWriter, req *http.Request) {
 			return
 		}
 
-		// Retrieve user from the database using email or nickname
+		// Retrieve user from the database using email or nickname. An unknown
+		// account answers like a wrong password, checked as long.
 		user, exists := models.UserRepo.IsExistedByIdentifiant(loginInfo.Identifiant)
-		if !exists {
+
+		// Make the client wait after failed attempts on the account or from its IP
+		account, ip := loginAccount(loginInfo.Identifiant, user), lib.ClientIP(req)
+		if wait := signInWait(account, ip); wait > 0 {
+			lib.HandleTooManyRequests(res, "Too many failed sign-in attempts, retry in "+retryIn(wait), wait)
+			return
+		}
+
+		hashedPassword := dummyHash()
+		if exists {
+			hashedPassword = user.Password
+		}
+		matches, legacy := passwordMatches(loginInfo.Password, hashedPassword)
+		if !matches || !exists {
+			failSignIn(account, ip, user)
+			lib.HandleError(res, http.StatusUnauthorized, "Invalid credentials")
+			return
+		}
+		if legacy || lib.NeedsRehash(hashedPassword) {
+			rehashPassword(user.ID, loginInfo.Password)
+		}
+
+		// Banned users can't log in anymore
+		sanction, err := models.UserRepo.GetSanction(user.ID)
+		if err != nil {
 			lib.HandleError(res, http.StatusInternalServerError, "Error retrieving user")
 			return
 		}
+		if sanction != nil {
+			sendSanction(res, sanction)
+			return
+		}
 
-		// Verify password
-		if user != nil && lib.CheckPasswordHash(loginInfo.Password, user.Password) {
-			// Create a new session for the authenticated user
-			models.NewSessionToken(res, user.ID, user.Nickname)
-			authUser := models.AuthUser{
-				ID:         user.ID,
-				Nickname:   user.Nickname,
-				Firstname:  user.Firstname,
-				Lastname:   user.Lastname,
-				Age:        user.Age,
-				IsLoggedIn: true,
-				Gender:     user.Gender,
-				Email:      user.Email,
-				AvatarURL:  user.AvatarURL,
+		// With two-factor authentication, the password only earns a short-lived
+		// token, exchanged for a session with a code at /sign-in/two-factor
+		twoFactor, err := models.TwoFactorRepo.IsEnabled(user.ID)
+		if err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error retrieving user")
+			return
+		}
+		if twoFactor {
+			token, err := models.NewPreAuthToken(user.ID, account)
+			if err != nil {
+				lib.HandleError(res, http.StatusInternalServerError, "Error signing in")
+				return
 			}
-			lib.SendJSONResponse(res, http.StatusOK, map[string]any{"message": "Login successful", "user": authUser})
+			lib.SendJSONResponse(res, http.StatusOK, map[string]any{"message": "Enter the code of your authenticator app", "twoFactorRequired": true, "preAuthToken": token})
 			return
 		}
 
-		lib.HandleError(res, http.StatusUnauthorized, "Invalid credentials")
+		completeSignIn(res, user, account)
+	}
+}
+
+// completeSignIn opens a session for a user who proved who they are
+func completeSignIn(res http.ResponseWriter, user *models.User, account string) {
+	failedAttempts := openSession(res, user, account)
+	authUser := models.AuthUser{
+		ID:            user.ID,
+		Nickname:      user.Nickname,
+		Firstname:     user.Firstname,
+		Lastname:      user.Lastname,
+		Age:           user.Age,
+		IsLoggedIn:    true,
+		Gender:        user.Gender,
+		Email:         user.Email,
+		AvatarURL:     user.AvatarURL,
+		Role:          user.Role,
+		EmailVerified: user.EmailVerified,
+	}
+	lib.SendJSONResponse(res, http.StatusOK, map[string]any{"message": "Login successful", "user": authUser, "failedAttempts": failedAttempts})
+}
+
+// openSession creates a new session for a user, clearing the failed sign-in
+// attempts on their account. It returns their number, to tell the user.
+func openSession(res http.ResponseWriter, user *models.User, account string) int {
+	failures, err := models.LoginRepo.AccountFailures(account, loginWindow)
+	if err != nil {
+		log.Println("❌ Failed to count the failed sign-in attempts:", err)
+	}
+	if err := models.LoginRepo.ClearFailures(account); err != nil {
+		log.Println("❌ Failed to clear the failed sign-in attempts:", err)
 	}
+	models.NewSessionToken(res, user.ID, user.Nickname)
+	return failures.Count
 }
 
 // Logout handles user logout.
@@ -122,38 +187,70 @@ func Logout(res http.ResponseWriter, req *http.Request) {
 // Get me
 func Me(res http.ResponseWriter, req *http.Request) {
 	if lib.ValidateRequest(req, res, "/me", http.MethodGet) {
-		// Check if there is an active session
-		if models.ValidSession(req) {
-			user := models.GetUserFromSession(req)
-			authUser := models.AuthUser{
-				ID:         user.ID,
-				Nickname:   user.Nickname,
-				Firstname:  user.Firstname,
-				Lastname:   user.Lastname,
-				Age:        user.Age,
-				IsLoggedIn: true,
-				Gender:     user.Gender,
-				Email:      user.Email,
-				AvatarURL:  user.AvatarURL,
-			}
-			lib.SendJSONResponse(res, http.StatusOK, map[string]any{"message": "Get me successful", "user": authUser})
-		} else {
-			lib.HandleError(res, http.StatusUnauthorized, "No active session")
+		user, ok := authorize(res, req, models.PermissionRead)
+		if !ok {
+			return
+		}
+		authUser := models.AuthUser{
+			ID:            user.ID,
+			Nickname:      user.Nickname,
+			Firstname:     user.Firstname,
+			Lastname:      user.Lastname,
+			Age:           user.Age,
+			IsLoggedIn:    true,
+			Gender:        user.Gender,
+			Email:         user.Email,
+			AvatarURL:     user.AvatarURL,
+			Role:          user.Role,
+			EmailVerified: user.EmailVerified,
+			Bot:           user.Bot,
 		}
+		lib.SendJSONResponse(res, http.StatusOK, map[string]any{"message": "Get me successful", "user": authUser})
 	}
 }
 
 var ErrMissingRequiredFields = errors.New("missing required fields")
 
+// passwordMatches checks a password against its hash, telling if the hash is
+// of the HTML-escaped password, as the passwords used to be hashed.
+func passwordMatches(password, hashedPassword string) (bool, bool) {
+	if lib.CheckPasswordHash(password, hashedPassword) {
+		return true, false
+	}
+	escaped := html.EscapeString(password)
+	if escaped != password && lib.CheckPasswordHash(escaped, hashedPassword) {
+		return true, true
+	}
+	return false, false
+}
+
+// rehashPassword replaces the hash of a password made with outdated settings,
+// which only the sign-in can do as it knows the password.
+func rehashPassword(userID, password string) {
+	hashedPassword, err := lib.HashPassword(password)
+	if err != nil {
+		log.Println("❌ Failed to rehash a password:", err)
+		return
+	}
+	if err := models.UserRepo.SetPassword(userID, hashedPassword); err != nil {
+		log.Println("❌ Failed to rehash a password:", err)
+	}
+}
+
 // validateSignUpInput validates the input data for user registration.
 func validateSignUpInput(user *models.User) error {
 	// Add any validation rules as needed
 	if user.Nickname == "" || user.Email == "" || user.Password == "" {
 		return ErrMissingRequiredFields
 	}
+	if err := screenNickname(user.Nickname); err != nil {
+		return err
+	}
+	if err := lib.CheckPassword(user.Password); err != nil {
+		return err
+	}
 	user.Nickname = html.EscapeString(user.Nickname)
 	user.Email = html.EscapeString(user.Email)
-	user.Password = html.EscapeString(user.Password)
 	return nil
 }
 
@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"real-time-forum/data/models"
+	"real-time-forum/lib"
+	"strings"
+)
+
+// BlockUser blocks the user of the URL, optionally hiding their posts and comments
+func BlockUser(res http.ResponseWriter, req *http.Request) {
+	if lib.ValidateRequest(req, res, "/block/*", http.MethodPost) {
+		user, ok := authorize(res, req, models.PermissionRead)
+		if !ok {
+			return
+		}
+		blockedID, ok := targetUser(res, req, user.ID)
+		if !ok {
+			return
+		}
+		var options struct {
+			HideContent bool `json:"hideContent"`
+		}
+		if req.ContentLength != 0 {
+			if err := json.NewDecoder(req.Body).Decode(&options); err != nil {
+				lib.HandleError(res, http.StatusBadRequest, "Invalid JSON format")
+				return
+			}
+		}
+		if err := models.BlockRepo.Block(user.ID, blockedID, options.HideContent); err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error blocking user : "+err.Error())
+			return
+		}
+		lib.SendJSONResponse(res, http.StatusOK, map[string]any{"message": "user blocked successfully"})
+	}
+}
+
+// UnblockUser removes the user of the URL from the block list
+func UnblockUser(res http.ResponseWriter, req *http.Request) {
+	if lib.ValidateRequest(req, res, "/unblock/*", http.MethodPost) {
+		user, ok := authorize(res, req, models.PermissionRead)
+		if !ok {
+			return
+		}
+		blockedID, ok := targetUser(res, req, user.ID)
+		if !ok {
+			return
+		}
+		if err := models.BlockRepo.Unblock(user.ID, blockedID); err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error unblocking user : "+err.Error())
+			return
+		}
+		lib.SendJSONResponse(res, http.StatusOK, map[string]any{"message": "user unblocked successfully"})
+	}
+}
+
+// GetBlocks lists the users blocked by the user in session
+func GetBlocks(res http.ResponseWriter, req *http.Request) {
+	if lib.ValidateRequest(req, res, "/blocks", http.MethodGet) {
+		user, ok := authorize(res, req, models.PermissionRead)
+		if !ok {
+			return
+		}
+		blocks, err := models.BlockRepo.GetBlocks(user.ID)
+		if err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error getting blocks : "+err.Error())
+			return
+		}
+		lib.SendJSONResponse(res, http.StatusOK, map[string]any{"blocks": blocks})
+	}
+}
+
+// MuteUser mutes the notifications of the conversation with the user of the URL
+func MuteUser(res http.ResponseWriter, req *http.Request) {
+	if lib.ValidateRequest(req, res, "/mute/*", http.MethodPost) {
+		user, ok := authorize(res, req, models.PermissionRead)
+		if !ok {
+			return
+		}
+		talkerID, ok := targetUser(res, req, user.ID)
+		if !ok {
+			return
+		}
+		if err := models.MuteRepo.Mute(user.ID, talkerID); err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error muting conversation : "+err.Error())
+			return
+		}
+		lib.SendJSONResponse(res, http.StatusOK, map[string]any{"message": "conversation muted successfully"})
+	}
+}
+
+// UnmuteUser restores the notifications of the conversation with the user of the URL
+func UnmuteUser(res http.ResponseWriter, req *http.Request) {
+	if lib.ValidateRequest(req, res, "/unmute/*", http.MethodPost) {
+		user, ok := authorize(res, req, models.PermissionRead)
+		if !ok {
+			return
+		}
+		talkerID, ok := targetUser(res, req, user.ID)
+		if !ok {
+			return
+		}
+		if err := models.MuteRepo.Unmute(user.ID, talkerID); err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error unmuting conversation : "+err.Error())
+			return
+		}
+		lib.SendJSONResponse(res, http.StatusOK, map[string]any{"message": "conversation unmuted successfully"})
+	}
+}
+
+// targetUser reads the ID of the user of the URL, which must exist and be
+// someone else than the user in session
+func targetUser(res http.ResponseWriter, req *http.Request, userID string) (string, bool) {
+	pathPart := strings.Split(req.URL.Path, "/")
+	targetID := pathPart[len(pathPart)-1]
+	if targetID == "" || targetID == userID {
+		lib.HandleError(res, http.StatusBadRequest, "Invalid user")
+		return "", false
+	}
+	if _, exists := models.UserRepo.IsExistedByID(targetID); !exists {
+		lib.HandleError(res, http.StatusNotFound, "User not found")
+		return "", false
+	}
+	return targetID, true
+}
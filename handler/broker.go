@@ -0,0 +1,168 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"real-time-forum/lib"
+	"sync"
+)
+
+// Broker carries the events between the instances of the server. It is in
+// process until UseBroker is called with a networked one.
+var Broker lib.Broker
+
+// sequence makes this instance record its events in the order of their
+// sequence numbers, so that a client resuming from an event finds every
+// earlier one in the log.
+var sequence sync.Mutex
+
+func init() {
+	if err := UseBroker(lib.NewMemoryBroker()); err != nil {
+		log.Fatal("❌ Couldn't set up the broker: ", err)
+	}
+}
+
+// UseBroker routes the events of every instance through the broker.
+func UseBroker(broker lib.Broker) error {
+	if err := broker.Subscribe(deliver, resyncAll); err != nil {
+		return err
+	}
+	if Broker != nil {
+		Broker.Close()
+	}
+	Broker = broker
+	return nil
+}
+
+// brokerEvent is an event as it travels between the instances.
+type brokerEvent struct {
+	Seq     uint64          `json:"seq"`               // 0 for the events not kept for replay
+	Topics  []string        `json:"topics,omitempty"`  // topics of a public event
+	Exclude []string        `json:"exclude,omitempty"` // users not receiving a public event
+	UserIDs []string        `json:"userIDs,omitempty"` // recipients of a private event
+	Payload json.RawMessage `json:"payload"`
+	// Disconnect closes the connections of the recipients after the event
+	Disconnect bool `json:"disconnect,omitempty"`
+}
+
+// nextSeq hands out the sequence number of a new event, 0 if the broker is
+// unreachable in which case the event cannot be replayed.
+func nextSeq() uint64 {
+	seq, err := Broker.NextSeq()
+	if err != nil {
+		log.Println("❌ Failed to get an event sequence number:", err)
+	}
+	return seq
+}
+
+// sequenced numbers the events built by build and records them for replay,
+// one at a time so that an event is recorded before a later one is numbered,
+// then publishes them. The delivery happens outside the lock, a slow broker
+// or client holding back only its own events. The number is 0 when the
+// broker is unreachable.
+func sequenced(build func(seq uint64) []brokerEvent) {
+	sequence.Lock()
+	events := build(nextSeq())
+	for _, event := range events {
+		record(event)
+	}
+	sequence.Unlock()
+	for _, event := range events {
+		send(event)
+	}
+}
+
+// publicEvent is an event for the subscribers of the topics, except for the
+// excluded users, on every instance.
+func publicEvent(seq uint64, topics []string, exclude []string, output []byte) brokerEvent {
+	return brokerEvent{Seq: seq, Topics: topics, Exclude: exclude, Payload: output}
+}
+
+// privateEvent is an event for the given users on every instance.
+func privateEvent(seq uint64, userIDs []string, output []byte) brokerEvent {
+	return brokerEvent{Seq: seq, UserIDs: userIDs, Payload: output}
+}
+
+// publishPrivate sends an event not kept for replay to the given users on
+// every instance.
+func publishPrivate(userIDs []string, output []byte) {
+	send(privateEvent(0, userIDs, output))
+}
+
+// disconnectUsers sends a last event to the given users then closes their
+// connections, on every instance.
+func disconnectUsers(userIDs []string, output []byte) {
+	send(brokerEvent{UserIDs: userIDs, Payload: output, Disconnect: true})
+}
+
+func send(event brokerEvent) {
+	message, err := json.Marshal(event)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if err := Broker.Publish(message); err != nil {
+		log.Println("❌ Failed to publish an event:", err)
+	}
+}
+
+// resyncAll makes the clients reload their state after the broker lost some
+// events, which can no longer be replayed.
+func resyncAll() {
+	sequence.Lock()
+	seq := nextSeq()
+	Events.MarkLost(seq)
+	sequence.Unlock()
+	for _, client := range Connections.Clients() {
+		sendSync(client, "resync-required", seq)
+	}
+}
+
+// record keeps an event for replay. The events published by this instance are
+// recorded before they go out, so they are already kept when the broker
+// delivers them back.
+func record(event brokerEvent) {
+	if len(event.UserIDs) > 0 {
+		Events.RecordPrivate(event.Seq, event.UserIDs, event.Payload)
+	} else {
+		Events.RecordPublic(event.Seq, event.Topics, exclusion(event.Exclude), event.Payload)
+	}
+}
+
+// exclusion is the filter accepting the users not excluded, nil when no one is.
+func exclusion(exclude []string) func(userID string) bool {
+	if len(exclude) == 0 {
+		return nil
+	}
+	excluded := make(map[string]bool)
+	for _, userID := range exclude {
+		excluded[userID] = true
+	}
+	return func(userID string) bool { return !excluded[userID] }
+}
+
+// deliver records an event coming from the broker for replay and sends it to
+// the clients of this instance.
+func deliver(message []byte) {
+	var event brokerEvent
+	if err := json.Unmarshal(message, &event); err != nil {
+		log.Println("❌ Invalid event from the broker:", err)
+		return
+	}
+
+	record(event)
+	if len(event.UserIDs) > 0 {
+		topics := make([]string, len(event.UserIDs))
+		for i, userID := range event.UserIDs {
+			topics[i] = UserTopic(userID)
+		}
+		Connections.Publish(topics, nil, event.Payload)
+		if event.Disconnect {
+			for _, userID := range event.UserIDs {
+				Connections.Disconnect(userID)
+			}
+		}
+		return
+	}
+	Connections.Publish(event.Topics, exclusion(event.Exclude), event.Payload)
+}
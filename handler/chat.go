@@ -12,119 +12,147 @@ import (
 
 func GetUsers(res http.ResponseWriter, req *http.Request) {
 	if lib.ValidateRequest(req, res, "/chat/users", http.MethodGet) {
-		isLogin := models.ValidSession(req)
-		if isLogin {
-			user := models.GetUserFromSession(req)
-			users, err := models.UserRepo.SelectAllUsers(user.ID)
-			if err != nil {
-				lib.HandleError(res, http.StatusInternalServerError, "Error getting users : "+err.Error())
-				return
-			}
-			for i := 0; i < len(users); i++ {
-				if models.CheckIfSessionExist(users[i].Nickname) {
-					users[i].IsConnected = true
-				}
-			}
-			lib.SendJSONResponse(res, http.StatusOK, map[string]any{"users": users})
-		} else {
-			lib.HandleError(res, http.StatusUnauthorized, "No active session")
+		user, ok := authorize(res, req, models.PermissionRead)
+		if !ok {
+			return
 		}
+		users, err := models.UserRepo.SelectAllUsers(user.ID)
+		if err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error getting users : "+err.Error())
+			return
+		}
+		userIDs := make([]string, len(users))
+		for i := range users {
+			userIDs[i] = users[i].ID
+		}
+		presences := Presences(userIDs)
+		for i := 0; i < len(users); i++ {
+			users[i].Presence = presences[users[i].ID]
+			users[i].IsConnected = users[i].Presence != PresenceOffline
+		}
+		lib.SendJSONResponse(res, http.StatusOK, map[string]any{"users": users})
 	}
 }
 
 func GetMessages(res http.ResponseWriter, req *http.Request) {
 	if lib.ValidateRequest(req, res, "/chat/messages/*", http.MethodGet) {
-		isLogin := models.ValidSession(req)
-		if isLogin {
-			user := models.GetUserFromSession(req)
-			path := req.URL.Path
-			pathPart := strings.Split(path, "/")
-			idReceiver := pathPart[3]
-
-			// Parse query parameters for pagination
-			pageStr := req.URL.Query().Get("page")
-			limitStr := req.URL.Query().Get("limit")
+		user, ok := authorize(res, req, models.PermissionRead)
+		if !ok {
+			return
+		}
+		path := req.URL.Path
+		pathPart := strings.Split(path, "/")
+		idReceiver := pathPart[3]
 
-			page, err := strconv.Atoi(pageStr)
-			if err != nil || page < 1 {
-				page = 1
-			}
+		// Parse query parameters for pagination
+		pageStr := req.URL.Query().Get("page")
+		limitStr := req.URL.Query().Get("limit")
 
-			limit, err := strconv.Atoi(limitStr)
-			if err != nil || limit < 1 {
-				limit = 10 // Default limit
-			}
+		page, err := strconv.Atoi(pageStr)
+		if err != nil || page < 1 {
+			page = 1
+		}
 
-			// Calculate offset based on page and limit
-			offset := (page - 1) * limit
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 {
+			limit = 10 // Default limit
+		}
 
-			messages, err := models.MessageRepo.GetDiscussionsBetweenUsersWithPagination(user.ID, idReceiver, offset, limit)
-			if err != nil {
-				lib.HandleError(res, http.StatusInternalServerError, "Error getting messages: "+err.Error())
-				return
-			}
+		// Calculate offset based on page and limit
+		offset := (page - 1) * limit
 
-			talker, err := models.UserRepo.GetUserByID(idReceiver)
-			if err != nil {
-				lib.HandleError(res, http.StatusInternalServerError, "Error getting talker: "+err.Error())
-				return
-			}
+		messages, err := models.MessageRepo.GetDiscussionsBetweenUsersWithPagination(user.ID, idReceiver, offset, limit)
+		if err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error getting messages: "+err.Error())
+			return
+		}
 
-			lib.SendJSONResponse(res, http.StatusOK, map[string]interface{}{"messages": messages, "talker": talker})
-		} else {
-			lib.HandleError(res, http.StatusUnauthorized, "No active session")
+		talker, err := models.UserRepo.GetUserByID(idReceiver)
+		if err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error getting talker: "+err.Error())
+			return
 		}
+
+		lib.SendJSONResponse(res, http.StatusOK, map[string]interface{}{"messages": messages, "talker": talker})
 	}
 }
 
 func GetTalker(res http.ResponseWriter, req *http.Request) {
 	if lib.ValidateRequest(req, res, "/chat/user/*", http.MethodGet) {
-		isLogin := models.ValidSession(req)
-		if isLogin {
-			path := req.URL.Path
-			pathPart := strings.Split(path, "/")
-			idReceiver := pathPart[3]
-			talker, err := models.UserRepo.GetUserByID(idReceiver)
-			if err != nil {
-				lib.HandleError(res, http.StatusInternalServerError, "Error getting talker : "+err.Error())
-				return
-			}
-
-			lib.SendJSONResponse(res, http.StatusOK, map[string]any{"talker": talker})
-		} else {
-			lib.HandleError(res, http.StatusUnauthorized, "No active session")
+		if _, ok := authorize(res, req, models.PermissionRead); !ok {
+			return
+		}
+		path := req.URL.Path
+		pathPart := strings.Split(path, "/")
+		idReceiver := pathPart[3]
+		talker, err := models.UserRepo.GetUserByID(idReceiver)
+		if err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error getting talker : "+err.Error())
+			return
 		}
+
+		lib.SendJSONResponse(res, http.StatusOK, map[string]any{"talker": talker})
 	}
 }
 
 func NewMessage(res http.ResponseWriter, req *http.Request) {
 	if lib.ValidateRequest(req, res, "/chat/new", http.MethodPost) {
-		isLogin := models.ValidSession(req)
-		if isLogin {
-			_ = models.GetUserFromSession(req)
-			var _message models.Message
-			if err := json.NewDecoder(req.Body).Decode(&_message); err != nil {
-				lib.HandleError(res, http.StatusBadRequest, "Invalid JSON format")
-				return
-			}
-			if err := validateMessageInput(&_message); err != nil {
-				lib.HandleError(res, http.StatusBadRequest, err.Error())
-				return
-			}
-			err := models.MessageRepo.CreateMessage(&_message)
-			if err != nil {
-				lib.HandleError(res, http.StatusInternalServerError, "Error creating message : "+err.Error())
-			}
-			message, err := models.MessageRepo.GetMessageByID(_message.ID)
-			if err != nil {
-				lib.HandleError(res, http.StatusInternalServerError, "Error creating message : "+err.Error())
-			}
-			// message.CreateDate = lib.FormatDateDB(message.CreateDate)
-			lib.SendJSONResponse(res, http.StatusOK, map[string]any{"message": message})
-			SendMessage(*message)
-		} else {
-			lib.HandleError(res, http.StatusUnauthorized, "No active session")
+		user, ok := authorize(res, req, models.PermissionMessage)
+		if !ok {
+			return
 		}
+		var _message models.Message
+		if err := json.NewDecoder(req.Body).Decode(&_message); err != nil {
+			lib.HandleError(res, http.StatusBadRequest, "Invalid JSON format")
+			return
+		}
+		if err := validateMessageInput(&_message); err != nil {
+			lib.HandleError(res, http.StatusBadRequest, err.Error())
+			return
+		}
+		_message.SenderID = user.ID
+		if _, exists := models.UserRepo.IsExistedByID(_message.ReceiverID); !exists {
+			lib.HandleError(res, http.StatusNotFound, "Receiver not found")
+			return
+		}
+		blocked, err := models.BlockRepo.IsBlockedBetween(user.ID, _message.ReceiverID)
+		if err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error checking blocks : "+err.Error())
+			return
+		}
+		if blocked {
+			lib.HandleError(res, http.StatusForbidden, "You cannot message this user")
+			return
+		}
+		known, err := models.ActivityRepo.HasConversation(user.ID, _message.ReceiverID)
+		if err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error checking conversation : "+err.Error())
+			return
+		}
+		if !known && !throttle(res, user, models.ActivityConversation) {
+			return
+		}
+		if !checkDuplicate(res, user, models.ContentMessage, _message.Content) {
+			return
+		}
+		err = models.MessageRepo.CreateMessage(&_message)
+		if err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error creating message : "+err.Error())
+			return
+		}
+		message, err := models.MessageRepo.GetMessageByID(_message.ID)
+		if err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error creating message : "+err.Error())
+			return
+		}
+		// message.CreateDate = lib.FormatDateDB(message.CreateDate)
+		if _message.Held {
+			holdForReview(models.ContentMessage, message.ID, user.ID, message.Content)
+			lib.SendJSONResponse(res, http.StatusOK, map[string]any{"message": message, "held": true})
+			return
+		}
+		lib.SendJSONResponse(res, http.StatusOK, map[string]any{"message": message})
+		SendMessage(*message)
 	}
 }
 
@@ -134,6 +162,11 @@ func validateMessageInput(message *models.Message) error {
 	if message.Content == "" {
 		return ErrMissingRequiredFields
 	}
+	held, err := screenText(&message.Content)
+	if err != nil {
+		return err
+	}
+	message.Held = held
 	message.Content = html.EscapeString(message.Content)
 	return nil
 }
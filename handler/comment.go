@@ -13,68 +13,83 @@ import (
 
 func CreateComment(res http.ResponseWriter, req *http.Request) {
 	if lib.ValidateRequest(req, res, "/comment/*", http.MethodPost) {
-		userInSession := models.GetUserFromSession(req)
-		isLogin := models.ValidSession(req)
+		userInSession, ok := authorize(res, req, models.PermissionPost)
+		if !ok {
+			return
+		}
 		path := req.URL.Path
 		pathPart := strings.Split(path, "/")
 		postID := pathPart[2]
-		_, err := models.PostRepo.GetPostByID(postID)
-		if err != nil {
+		post, err := models.PostRepo.GetPostByID(postID)
+		if err != nil || post.Hidden {
 			lib.HandleError(res, http.StatusNotFound, "post not found")
 			return
 		}
-		if isLogin {
-			var commentInfo models.Comment
-			if err := json.NewDecoder(req.Body).Decode(&commentInfo); err != nil {
-				lib.HandleError(res, http.StatusBadRequest, "Invalid JSON format")
-				return
-			}
-			if err := validateCommentInput(&commentInfo); err != nil {
-				lib.HandleError(res, http.StatusBadRequest, err.Error())
-				return
-			}
+		var commentInfo models.Comment
+		if err := json.NewDecoder(req.Body).Decode(&commentInfo); err != nil {
+			lib.HandleError(res, http.StatusBadRequest, "Invalid JSON format")
+			return
+		}
+		if err := validateCommentInput(&commentInfo); err != nil {
+			lib.HandleError(res, http.StatusBadRequest, err.Error())
+			return
+		}
+		if !throttle(res, userInSession, models.ActivityComment) || !checkDuplicate(res, userInSession, models.ContentComment, commentInfo.Text) {
+			return
+		}
 
-			commentInfo.AuthorID = userInSession.ID
-			commentInfo.PostID = postID
-			err = models.CommentRepo.CreateComment(&commentInfo)
-			if err != nil {
-				lib.HandleError(res, http.StatusInternalServerError, "Error creating comment : "+err.Error())
-				return
-			}
-			comment, err := models.CommentRepo.GetCommentByID(commentInfo.ID)
-			if err != nil {
-				lib.HandleError(res, http.StatusInternalServerError, "Error getting comment : "+err.Error())
-				return
-			}
+		commentInfo.AuthorID = userInSession.ID
+		commentInfo.PostID = postID
+		err = models.CommentRepo.CreateComment(&commentInfo)
+		if err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error creating comment : "+err.Error())
+			return
+		}
+		comment, err := models.CommentRepo.GetCommentByID(commentInfo.ID)
+		if err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error getting comment : "+err.Error())
+			return
+		}
+		if commentInfo.Held {
+			holdForReview(models.ContentComment, commentInfo.ID, userInSession.ID, commentInfo.Text)
 			lib.SendJSONResponse(res, http.StatusOK, map[string]any{
-				"message": "comment created successfully",
+				"message": "comment held for review",
 				"comment": comment,
+				"held":    true,
 			})
-			SendComment(postID, comment)
-		} else {
-			lib.HandleError(res, http.StatusUnauthorized, "not connected")
+			return
 		}
+		lib.SendJSONResponse(res, http.StatusOK, map[string]any{
+			"message": "comment created successfully",
+			"comment": comment,
+		})
+		SendComment(postID, comment)
 	}
 }
 
 func GetComments(res http.ResponseWriter, req *http.Request) {
 	if lib.ValidateRequest(req, res, "/comments/*", http.MethodGet) {
-		if models.ValidSession(req) {
-			path := req.URL.Path
-			pathPart := strings.Split(path, "/")
-			postID := pathPart[2]
-			comments, err := models.CommentRepo.GetCommentsOfPost(postID)
-			if err != nil {
-				lib.HandleError(res, http.StatusNotFound, err.Error())
-				return
-			}
-			lib.SendJSONResponse(res, http.StatusOK, map[string]any{
-				"message":  "comment list got successfully",
-				"comments": comments,
-			})
-		} else {
-			lib.HandleError(res, http.StatusUnauthorized, "No active session")
+		viewer, ok := authorize(res, req, models.PermissionRead)
+		if !ok {
+			return
+		}
+		path := req.URL.Path
+		pathPart := strings.Split(path, "/")
+		postID := pathPart[2]
+		post, err := models.PostRepo.GetPostByID(postID)
+		if err != nil || post == nil || !canSeePost(post, viewer) {
+			lib.HandleError(res, http.StatusNotFound, "post not found")
+			return
 		}
+		comments, err := models.CommentRepo.GetCommentsOfPost(postID, viewer.ID)
+		if err != nil {
+			lib.HandleError(res, http.StatusNotFound, err.Error())
+			return
+		}
+		lib.SendJSONResponse(res, http.StatusOK, map[string]any{
+			"message":  "comment list got successfully",
+			"comments": comments,
+		})
 	}
 }
 
@@ -84,6 +99,11 @@ func validateCommentInput(comment *models.Comment) error {
 	if comment.Text == "" {
 		return ErrMissingRequiredFields
 	}
+	held, err := screenText(&comment.Text)
+	if err != nil {
+		return err
+	}
+	comment.Held = held
 	comment.Text = html.EscapeString(comment.Text)
 	return nil
 }
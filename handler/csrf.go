@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+	"real-time-forum/data/models"
+	"real-time-forum/lib"
+	"strings"
+)
+
+// CSRF refuses the state-changing requests coming from other sites. Those
+// riding on the session cookie must send back the CSRF token of the session
+// in the X-CSRF-Token header, which other sites can't read. The requests
+// authenticated by an API token carry no ambient credential and need none.
+func CSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(res, req)
+			return
+		}
+		if origin := req.Header.Get("Origin"); origin != "" && !sameOrigin(req) {
+			lib.HandleError(res, http.StatusForbidden, "Cross-site request refused")
+			return
+		}
+		if _, ok := bearerToken(req); !ok {
+			if expected, ok := models.GetSessionCSRFToken(req); ok {
+				token := req.Header.Get(models.CSRFHeader)
+				if expected == "" || subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+					lib.HandleError(res, http.StatusForbidden, "Invalid CSRF token, reload the page")
+					return
+				}
+			}
+		}
+		next.ServeHTTP(res, req)
+	})
+}
+
+// sameOrigin checks that the Origin of a request is the forum itself, as
+// served on the host of the request or on its public URL. Requests without
+// Origin, sent by other clients than browsers, pass.
+func sameOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if strings.EqualFold(origin, PublicURL) {
+		return true
+	}
+	parsed, err := url.Parse(origin)
+	return err == nil && parsed.Host != "" && strings.EqualFold(parsed.Host, req.Host)
+}
@@ -0,0 +1,224 @@
+package handler
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// eventLogSize bounds the number of events kept per log for replay.
+	eventLogSize = 256
+	// eventLogRetention is how long an event stays replayable.
+	eventLogRetention = 10 * time.Minute
+)
+
+// loggedEvent is an event kept for replay after a reconnection.
+type loggedEvent struct {
+	Seq     uint64
+	At      time.Time
+	Topics  []string
+	To      func(userID string) bool // users of a public event, nil for all
+	Payload []byte
+}
+
+// matches reports whether the event was sent to the user on one of the topics
+// accepted by subscribed.
+func (e loggedEvent) matches(userID string, subscribed func(topic string) bool) bool {
+	if e.To != nil && !e.To(userID) {
+		return false
+	}
+	for _, topic := range e.Topics {
+		if subscribed(topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// eventLog is a bounded list of events ordered by sequence number.
+type eventLog struct {
+	events  []loggedEvent
+	dropped uint64 // highest sequence number evicted from the log
+}
+
+// append inserts an event in order, as the events of the other instances may
+// come in after later ones. An event already kept, or older than the evicted
+// ones, is ignored: this instance records its events both when publishing
+// them and when the broker delivers them back.
+func (l *eventLog) append(event loggedEvent, size int) {
+	if event.Seq <= l.dropped {
+		return
+	}
+	i := len(l.events)
+	for i > 0 && l.events[i-1].Seq > event.Seq {
+		i--
+	}
+	if i > 0 && l.events[i-1].Seq == event.Seq {
+		return
+	}
+	l.events = append(l.events, loggedEvent{})
+	copy(l.events[i+1:], l.events[i:])
+	l.events[i] = event
+	if len(l.events) > size {
+		if l.events[0].Seq > l.dropped {
+			l.dropped = l.events[0].Seq
+		}
+		l.events = l.events[1:]
+	}
+}
+
+// prune evicts the events older than the given time and returns the highest
+// sequence number evicted.
+func (l *eventLog) prune(before time.Time) uint64 {
+	i := 0
+	for i < len(l.events) && l.events[i].At.Before(before) {
+		l.dropped = l.events[i].Seq
+		i++
+	}
+	l.events = l.events[i:]
+	return l.dropped
+}
+
+// EventStore keeps recent events so a client coming back from a disconnection
+// can receive what it missed. The sequence numbers come from the broker.
+type EventStore struct {
+	mutex     sync.Mutex
+	seq       uint64
+	size      int
+	retention time.Duration
+	public    *eventLog
+	private   map[string]*eventLog
+	expired   uint64 // highest sequence number evicted for being too old
+	lastSweep time.Time
+}
+
+func NewEventStore(size int, retention time.Duration) *EventStore {
+	return &EventStore{
+		size:      size,
+		retention: retention,
+		public:    &eventLog{},
+		private:   make(map[string]*eventLog),
+		lastSweep: time.Now(),
+	}
+}
+
+// Seq returns the highest sequence number recorded.
+func (s *EventStore) Seq() uint64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.seq
+}
+
+// RecordPublic keeps an event sent to the subscribers of the topics whose user
+// is accepted by the filter.
+func (s *EventStore) RecordPublic(seq uint64, topics []string, to func(userID string) bool, payload []byte) {
+	if seq == 0 {
+		return // not numbered, the broker being unreachable
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.public.append(loggedEvent{seq, time.Now(), topics, to, payload}, s.size)
+	s.observe(seq)
+	s.sweep()
+}
+
+// RecordPrivate keeps an event sent to the given users only.
+func (s *EventStore) RecordPrivate(seq uint64, userIDs []string, payload []byte) {
+	if seq == 0 {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now()
+	for _, userID := range userIDs {
+		log, ok := s.private[userID]
+		if !ok {
+			log = &eventLog{}
+			s.private[userID] = log
+		}
+		log.append(loggedEvent{seq, now, []string{UserTopic(userID)}, nil, payload}, s.size)
+	}
+	s.observe(seq)
+	s.sweep()
+}
+
+// MarkLost tells that events up to seq may be missing from the log, so that
+// the clients which last saw an earlier one must resync.
+func (s *EventStore) MarkLost(seq uint64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if seq == 0 {
+		seq = s.seq // the broker is unreachable, at least the known events
+	}
+	if seq > s.expired {
+		s.expired = seq
+	}
+	s.observe(seq)
+}
+
+func (s *EventStore) observe(seq uint64) {
+	if seq > s.seq {
+		s.seq = seq
+	}
+}
+
+// sweep prunes old events and forgets the users without any recent one.
+func (s *EventStore) sweep() {
+	now := time.Now()
+	if now.Sub(s.lastSweep) < s.retention/10 {
+		return
+	}
+	s.lastSweep = now
+	before := now.Add(-s.retention)
+	if seq := s.public.prune(before); seq > s.expired {
+		s.expired = seq
+	}
+	for userID, log := range s.private {
+		if seq := log.prune(before); seq > s.expired {
+			s.expired = seq
+		}
+		if len(log.events) == 0 {
+			delete(s.private, userID)
+		}
+	}
+}
+
+// Since returns the payloads of the events the user missed after lastSeq on
+// the topics accepted by subscribed, in order. It reports false when some of
+// them are no longer available and the client has to reload its state instead.
+func (s *EventStore) Since(userID string, lastSeq uint64, subscribed func(topic string) bool) ([][]byte, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if lastSeq > s.seq {
+		// The client saw events from a previous run of the server
+		return nil, false
+	}
+	if lastSeq < s.public.dropped || lastSeq < s.expired {
+		return nil, false
+	}
+
+	var missed []loggedEvent
+	for _, event := range s.public.events {
+		if event.Seq > lastSeq && event.matches(userID, subscribed) {
+			missed = append(missed, event)
+		}
+	}
+	if log, ok := s.private[userID]; ok && userID != "" && subscribed(UserTopic(userID)) {
+		if lastSeq < log.dropped {
+			return nil, false
+		}
+		for _, event := range log.events {
+			if event.Seq > lastSeq {
+				missed = append(missed, event)
+			}
+		}
+	}
+	sort.Slice(missed, func(i, j int) bool { return missed[i].Seq < missed[j].Seq })
+
+	payloads := make([][]byte, len(missed))
+	for i, event := range missed {
+		payloads[i] = event.Payload
+	}
+	return payloads, true
+}
@@ -0,0 +1,204 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"html"
+	"log"
+	"net/http"
+	"real-time-forum/data/models"
+	"real-time-forum/lib"
+	"strings"
+	"sync"
+	"time"
+)
+
+// filterRefresh is how long the rules are cached, so that the changes made on
+// another instance apply too.
+const filterRefresh = 30 * time.Second
+
+var (
+	ErrContentRejected  = errors.New("content contains forbidden terms")
+	ErrNicknameRejected = errors.New("nickname contains forbidden terms")
+	ErrCategoryRejected = errors.New("category contains forbidden terms")
+)
+
+// wordFilter caches the compiled rules of the word filter.
+var wordFilter struct {
+	mutex    sync.Mutex
+	filter   *lib.WordFilter
+	loadedAt time.Time
+}
+
+// currentFilter returns the word filter, reloading its rules when stale.
+func currentFilter() *lib.WordFilter {
+	wordFilter.mutex.Lock()
+	defer wordFilter.mutex.Unlock()
+	if wordFilter.filter != nil && time.Since(wordFilter.loadedAt) < filterRefresh {
+		return wordFilter.filter
+	}
+	rules, err := models.FilterRuleRepo.GetRules()
+	if err != nil {
+		log.Println("❌ Failed to load the word filter:", err)
+		if wordFilter.filter != nil {
+			return wordFilter.filter
+		}
+	}
+	filterRules := make([]lib.FilterRule, len(rules))
+	for i, rule := range rules {
+		filterRules[i] = lib.FilterRule{Pattern: rule.Pattern, Regex: rule.Regex, Action: rule.Action}
+	}
+	filter, invalid := lib.NewWordFilter(filterRules)
+	for _, rule := range invalid {
+		log.Println("🚨 Skipping an invalid word filter rule:", rule.Pattern)
+	}
+	wordFilter.filter = filter
+	wordFilter.loadedAt = time.Now()
+	return filter
+}
+
+// reloadFilter makes the next texts go through the latest rules.
+func reloadFilter() {
+	wordFilter.mutex.Lock()
+	defer wordFilter.mutex.Unlock()
+	wordFilter.filter = nil
+}
+
+// screenText runs the word filter on the texts of a content, masking them in
+// place. It tells whether the content must be held for review, or fails when
+// it is rejected.
+func screenText(texts ...*string) (bool, error) {
+	filter := currentFilter()
+	held := false
+	for _, text := range texts {
+		result := filter.Apply(*text)
+		if result.Rejected {
+			return false, ErrContentRejected
+		}
+		held = held || result.Held
+		*text = result.Text
+	}
+	return held, nil
+}
+
+// screenNickname rejects the nicknames matching any rule, as they cannot wait
+// for a review.
+func screenNickname(nickname string) error {
+	if len(currentFilter().Apply(nickname).Matches) > 0 {
+		return ErrNicknameRejected
+	}
+	return nil
+}
+
+// screenCategory rejects the category names matching any rule, as a new
+// category is public at once.
+func screenCategory(name string) error {
+	if len(currentFilter().Apply(name).Matches) > 0 {
+		return ErrCategoryRejected
+	}
+	return nil
+}
+
+// holdForReview opens a report on a content held by the word filter, so that
+// it surfaces in the moderation queue.
+func holdForReview(contentType, contentID, authorID, text string) {
+	text = html.UnescapeString(text)
+	report := models.Report{
+		ContentType: contentType,
+		ContentID:   contentID,
+		AuthorID:    authorID,
+		Excerpt:     text,
+		Reason:      models.ReasonHeld,
+		Details:     "Matched: " + strings.Join(currentFilter().Apply(text).Matches, ", "),
+	}
+	if len(report.Excerpt) > excerptLength {
+		report.Excerpt = report.Excerpt[:excerptLength]
+	}
+	if err := models.ReportRepo.CreateReport(&report); err != nil {
+		log.Println("❌ Failed to hold a content for review:", err)
+		return
+	}
+	if held, err := models.ReportRepo.GetReportByID(report.ID); err == nil && held != nil {
+		SendReport("report", *held)
+	}
+}
+
+// GetFilterRules lists the rules of the word filter
+func GetFilterRules(res http.ResponseWriter, req *http.Request) {
+	if lib.ValidateRequest(req, res, "/admin/filters", http.MethodGet) {
+		if _, ok := authorize(res, req, models.PermissionManageFilters); !ok {
+			return
+		}
+		rules, err := models.FilterRuleRepo.GetRules()
+		if err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error getting filter rules : "+err.Error())
+			return
+		}
+		lib.SendJSONResponse(res, http.StatusOK, map[string]any{"rules": rules})
+	}
+}
+
+// CreateFilterRule adds a term or a regular expression to the word filter
+func CreateFilterRule(res http.ResponseWriter, req *http.Request) {
+	if lib.ValidateRequest(req, res, "/admin/filter", http.MethodPost) {
+		admin, ok := authorize(res, req, models.PermissionManageFilters)
+		if !ok {
+			return
+		}
+		var rule models.FilterRule
+		if err := json.NewDecoder(req.Body).Decode(&rule); err != nil {
+			lib.HandleError(res, http.StatusBadRequest, "Invalid JSON format")
+			return
+		}
+		if err := validateFilterRuleInput(&rule); err != nil {
+			lib.HandleError(res, http.StatusBadRequest, err.Error())
+			return
+		}
+		rule.AuthorID = admin.ID
+		if err := models.FilterRuleRepo.CreateRule(&rule); err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error creating filter rule : "+err.Error())
+			return
+		}
+		reloadFilter()
+		audit(req, admin, models.AuditFilterCreate, models.AuditTargetFilter, rule.ID, nil, rule)
+		lib.SendJSONResponse(res, http.StatusOK, map[string]any{"message": "filter rule created successfully", "rule": rule})
+	}
+}
+
+// DeleteFilterRule removes the rule of the URL from the word filter
+func DeleteFilterRule(res http.ResponseWriter, req *http.Request) {
+	if lib.ValidateRequest(req, res, "/admin/filter/*", http.MethodDelete) {
+		admin, ok := authorize(res, req, models.PermissionManageFilters)
+		if !ok {
+			return
+		}
+		pathPart := strings.Split(req.URL.Path, "/")
+		rule, err := models.FilterRuleRepo.GetRuleByID(pathPart[len(pathPart)-1])
+		if err != nil || rule == nil {
+			lib.HandleError(res, http.StatusNotFound, "Filter rule not found")
+			return
+		}
+		if err := models.FilterRuleRepo.DeleteRule(rule.ID); err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error deleting filter rule : "+err.Error())
+			return
+		}
+		reloadFilter()
+		audit(req, admin, models.AuditFilterDelete, models.AuditTargetFilter, rule.ID, rule, nil)
+		lib.SendJSONResponse(res, http.StatusOK, map[string]any{"message": "filter rule deleted successfully"})
+	}
+}
+
+func validateFilterRuleInput(rule *models.FilterRule) error {
+	rule.Pattern = strings.TrimSpace(rule.Pattern)
+	rule.Action = strings.ToLower(strings.TrimSpace(rule.Action))
+	if rule.Pattern == "" || rule.Action == "" {
+		return ErrMissingRequiredFields
+	}
+	if rule.Action != lib.FilterReject && rule.Action != lib.FilterMask && rule.Action != lib.FilterHold {
+		return errors.New("unknown action, expected reject, mask or hold")
+	}
+	if _, err := lib.CompileFilterRule(lib.FilterRule{Pattern: rule.Pattern, Regex: rule.Regex}); err != nil {
+		return errors.New("invalid regular expression: " + err.Error())
+	}
+	return nil
+}
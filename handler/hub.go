@@ -0,0 +1,229 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"real-time-forum/data/models"
+	"real-time-forum/lib"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Transport delivers the frames of a client, whether over a WebSocket, an
+// event stream or long polling.
+type Transport interface {
+	Send(output []byte) error
+	// Close ends the connection once the frames already sent are delivered.
+	Close() error
+}
+
+// Client is a connection bound to the user of the session that opened it.
+// The user is empty for visitors.
+type Client struct {
+	transport Transport
+	userID    string
+	userMutex sync.RWMutex
+	idle      bool // whether the user stopped interacting, read loop only
+}
+
+// NewClient binds a transport to the user of the request session, or of its
+// API token with the read scope, if any.
+func NewClient(transport Transport, req *http.Request) *Client {
+	client := &Client{transport: transport}
+	if models.ValidSession(req) {
+		client.userID = models.GetUserFromSession(req).ID
+	} else if token, ok := bearerToken(req); ok {
+		if apiToken, err := models.TokenRepo.Authenticate(token, lib.ClientIP(req)); err == nil && apiToken != nil && apiToken.HasScope(models.PermissionRead) {
+			if sanction, err := models.UserRepo.GetSanction(apiToken.UserID); err == nil && sanction == nil {
+				client.userID = apiToken.UserID
+			}
+		}
+	}
+	return client
+}
+
+// Send delivers a frame to the client.
+func (c *Client) Send(output []byte) error {
+	return c.transport.Send(output)
+}
+
+// User returns the ID of the user bound to the connection.
+func (c *Client) User() string {
+	c.userMutex.RLock()
+	defer c.userMutex.RUnlock()
+	return c.userID
+}
+
+func (c *Client) setUser(userID string) {
+	c.userMutex.Lock()
+	defer c.userMutex.Unlock()
+	c.userID = userID
+}
+
+// Hub keeps track of the connected clients and of the topics they are
+// subscribed to, so that an event only reaches the clients interested in it.
+type Hub struct {
+	mutex   sync.RWMutex
+	clients map[*Client]bool
+	topics  map[string]map[*Client]bool
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		clients: make(map[*Client]bool),
+		topics:  make(map[string]map[*Client]bool),
+	}
+}
+
+// Register adds a client to the hub.
+func (h *Hub) Register(client *Client) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.clients[client] = true
+}
+
+// Unregister removes a client and all its subscriptions.
+func (h *Hub) Unregister(client *Client) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	delete(h.clients, client)
+	for topic, subscribers := range h.topics {
+		delete(subscribers, client)
+		if len(subscribers) == 0 {
+			delete(h.topics, topic)
+		}
+	}
+}
+
+// Subscribe adds the client to the subscribers of the topic.
+func (h *Hub) Subscribe(client *Client, topic string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	subscribers, ok := h.topics[topic]
+	if !ok {
+		subscribers = make(map[*Client]bool)
+		h.topics[topic] = subscribers
+	}
+	subscribers[client] = true
+}
+
+// Unsubscribe removes the client from the subscribers of the topic.
+func (h *Hub) Unsubscribe(client *Client, topic string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if subscribers, ok := h.topics[topic]; ok {
+		delete(subscribers, client)
+		if len(subscribers) == 0 {
+			delete(h.topics, topic)
+		}
+	}
+}
+
+// IsSubscribed reports whether the client is subscribed to the topic.
+func (h *Hub) IsSubscribed(client *Client, topic string) bool {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return h.topics[topic][client]
+}
+
+// Clients returns the connected clients.
+func (h *Hub) Clients() []*Client {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	clients := make([]*Client, 0, len(h.clients))
+	for client := range h.clients {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// Publish writes the output once to every subscriber of any of the topics
+// whose user is accepted by the filter, nil accepting everyone.
+func (h *Hub) Publish(topics []string, filter func(userID string) bool, output []byte) {
+	h.mutex.RLock()
+	recipients := make(map[*Client]bool)
+	for _, topic := range topics {
+		for client := range h.topics[topic] {
+			recipients[client] = true
+		}
+	}
+	h.mutex.RUnlock()
+
+	for client := range recipients {
+		if filter == nil || filter(client.User()) {
+			client.Send(output)
+		}
+	}
+}
+
+// Disconnect closes the connections of a user.
+func (h *Hub) Disconnect(userID string) {
+	for _, client := range h.Clients() {
+		if client.User() == userID {
+			client.transport.Close()
+		}
+	}
+}
+
+// CloseAll sends a last frame to every client and closes its connection,
+// returning the number of clients. The clients are written to in parallel,
+// so that a slow one doesn't hold back the others.
+func (h *Hub) CloseAll(output []byte) int {
+	clients := h.Clients()
+	var wg sync.WaitGroup
+	for _, client := range clients {
+		wg.Add(1)
+		go func(client *Client) {
+			defer wg.Done()
+			client.Send(output)
+			client.transport.Close()
+		}(client)
+	}
+	wg.Wait()
+	return len(clients)
+}
+
+// Drain waits until every client is unregistered, once their connections
+// are closed, or until the context is done.
+func (h *Hub) Drain(ctx context.Context) error {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		h.mutex.RLock()
+		left := len(h.clients)
+		h.mutex.RUnlock()
+		if left == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// UserTopic is the private topic of the events addressed to a user.
+func UserTopic(userID string) string {
+	return "user:" + userID
+}
+
+// PostTopic is the topic of the comments of a post.
+func PostTopic(postID string) string {
+	return "post:" + postID
+}
+
+// CategoryTopic is the topic of the new posts of a category.
+func CategoryTopic(name string) string {
+	return "category:" + strings.ToLower(name)
+}
+
+const (
+	// FeedTopic is the topic of every new post.
+	FeedTopic = "feed"
+	// PresenceTopic is the topic of the online status of the users.
+	PresenceTopic = "presence"
+	// ModerationTopic is the topic of the reports, for the moderators.
+	ModerationTopic = "moderation"
+)
@@ -15,7 +15,8 @@ func Index(res http.ResponseWriter, req *http.Request) {
 			res.WriteHeader(http.StatusInternalServerError)
 			log.Println("🚨 " + err.Error())
 		} else {
-			tpl.Execute(res, nil)
+			// The inline script runs with the nonce of the Content-Security-Policy
+			tpl.Execute(res, map[string]string{"Nonce": lib.CSPNonce(req)})
 		}
 		log.Println("✅ Home page get with success")
 	}
@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"log"
+	"real-time-forum/data/models"
+	"real-time-forum/lib"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// loginWindow is how long a failed sign-in attempt counts
+	loginWindow = time.Hour
+	// accountFreeAttempts and ipFreeAttempts are the number of failures
+	// allowed before the delays start, more for an IP shared by many users
+	accountFreeAttempts = 3
+	ipFreeAttempts      = 10
+	// maxLoginDelay caps the delay between two attempts, doubling from 1s
+	maxLoginDelay = time.Minute
+	// accountLockout and ipLockout are the number of failures locking an
+	// account or an IP out for lockoutDuration after the last one
+	accountLockout  = 10
+	ipLockout       = 30
+	lockoutDuration = 15 * time.Minute
+)
+
+var dummy struct {
+	once sync.Once
+	hash string
+}
+
+// dummyHash returns the hash checked against the passwords of unknown
+// accounts, so that signing in to them takes as long as to an existing one.
+// It is made on first use, with the configured hashing settings.
+func dummyHash() string {
+	dummy.once.Do(func() {
+		dummy.hash, _ = lib.HashPassword("not the password of anybody")
+	})
+	return dummy.hash
+}
+
+// loginAccount is the key of the failed attempts on an account: its user ID,
+// so that the attempts by nickname and by email add up, or the identifiant
+// for an unknown account, so that it is locked out alike. The prefixes keep
+// an identifiant from passing for the key of an account.
+func loginAccount(identifiant string, user *models.User) string {
+	if user != nil {
+		return "user:" + user.ID
+	}
+	return "unknown:" + strings.ToLower(strings.TrimSpace(identifiant))
+}
+
+// signInWait returns how long the client must wait before trying to sign in
+// to an account again, zero or less when it may try now.
+func signInWait(account, ip string) time.Duration {
+	accountFailures, err := models.LoginRepo.AccountFailures(account, loginWindow)
+	if err != nil {
+		log.Println("❌ Failed to count the failed sign-in attempts:", err)
+	}
+	ipFailures, err := models.LoginRepo.IPFailures(ip, loginWindow)
+	if err != nil {
+		log.Println("❌ Failed to count the failed sign-in attempts:", err)
+	}
+	wait := loginDelay(accountFailures, accountFreeAttempts, accountLockout)
+	if ipWait := loginDelay(ipFailures, ipFreeAttempts, ipLockout); ipWait > wait {
+		wait = ipWait
+	}
+	return wait
+}
+
+// loginDelay is the time left before the next attempt after some failures
+func loginDelay(failures models.LoginFailures, freeAttempts, lockout int) time.Duration {
+	if failures.Count >= lockout {
+		return time.Until(failures.Last.Add(lockoutDuration))
+	}
+	if failures.Count < freeAttempts {
+		return 0
+	}
+	delay := maxLoginDelay
+	if shift := failures.Count - freeAttempts; shift < 6 {
+		delay = time.Second << shift
+	}
+	return time.Until(failures.Last.Add(delay))
+}
+
+// failSignIn records a failed attempt, telling the user when it locks their
+// account out.
+func failSignIn(account, ip string, user *models.User) {
+	if err := models.LoginRepo.RecordFailure(account, ip); err != nil {
+		log.Println("❌ Failed to record a failed sign-in attempt:", err)
+		return
+	}
+	failures, err := models.LoginRepo.AccountFailures(account, loginWindow)
+	if err != nil || failures.Count != accountLockout {
+		return
+	}
+	log.Println("🚨 Sign-in locked after too many failed attempts on", account, "from", ip)
+	if user != nil {
+		notice := "Someone failed to sign in to your account too many times, sign-in is locked for " + strconv.Itoa(int(lockoutDuration.Minutes())) + " minutes"
+		SendSecurityNotice(user.ID, notice)
+		sendMail(user.Email, "Sign-in locked", "Hello "+user.Nickname+",\n\n"+notice+".\n"+
+			"If it wasn't you, you may reset your password: "+PublicURL+"/#/password/forgot\n")
+	}
+}
@@ -0,0 +1,25 @@
+package handler
+
+import (
+	"log"
+	"real-time-forum/lib"
+)
+
+// Mailer sends the emails of the forum, logging them unless set otherwise.
+var Mailer lib.Mailer = &lib.LogMailer{}
+
+// PublicURL is the address of the forum in the links of the emails.
+var PublicURL = "http://localhost:8085"
+
+// sendMail sends a mail in the background, so that the response neither
+// waits for the mail server nor tells by its timing whether a mail was sent.
+func sendMail(to, subject, body string) {
+	if to == "" { // bots have no email
+		return
+	}
+	go func() {
+		if err := Mailer.Send(lib.Mail{To: to, Subject: subject, Body: body}); err != nil {
+			log.Println("❌ Failed to send a mail:", err)
+		}
+	}()
+}
@@ -0,0 +1,350 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"real-time-forum/data/models"
+	"real-time-forum/lib"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+const (
+	// oidcLoginTTL is how long a user has to come back from their provider
+	oidcLoginTTL = 10 * time.Minute
+	// oidcStateCookie binds a sign-in to the browser that started it
+	oidcStateCookie = "oidc_state"
+	// maxNicknameLength caps the nicknames made from the provider accounts
+	maxNicknameLength = 20
+)
+
+// oidcProviders are the configured providers, by name, in the order of
+// oidcProviderNames
+var (
+	oidcProviders     = map[string]*lib.OIDCProvider{}
+	oidcProviderNames []string
+)
+
+// oidcLogin is a sign-in waiting for the user to come back from their
+// provider, stored by its state
+type oidcLogin struct {
+	provider     string
+	nonce        string
+	codeVerifier string
+	linkUserID   string // the user in session, linking their account
+	expireAt     time.Time
+}
+
+var oidcLogins = struct {
+	sync.Mutex
+	states map[string]oidcLogin
+}{states: make(map[string]oidcLogin)}
+
+// UseOIDCProviders sets the providers the users may sign in with
+func UseOIDCProviders(providers []*lib.OIDCProvider) {
+	oidcProviders = map[string]*lib.OIDCProvider{}
+	oidcProviderNames = nil
+	for _, provider := range providers {
+		oidcProviders[provider.Name] = provider
+		oidcProviderNames = append(oidcProviderNames, provider.Name)
+	}
+}
+
+// GetOIDCProviders lists the providers for the login page, and those linked
+// to the account of the user in session, if any
+func GetOIDCProviders(res http.ResponseWriter, req *http.Request) {
+	if lib.ValidateRequest(req, res, "/oidc/providers", http.MethodGet) {
+		providers := []map[string]string{}
+		for _, name := range oidcProviderNames {
+			providers = append(providers, map[string]string{"name": name, "label": oidcProviders[name].Label})
+		}
+		linked := []string{}
+		if userID := models.GetSessionUserID(req); userID != "" {
+			var err error
+			if linked, err = models.IdentityRepo.GetProviders(userID); err != nil {
+				lib.HandleError(res, http.StatusInternalServerError, "Error retrieving linked accounts")
+				return
+			}
+		}
+		lib.SendJSONResponse(res, http.StatusOK, map[string]any{"providers": providers, "linked": linked})
+	}
+}
+
+// OIDCLogin sends the user to their provider. Signed in, it links the account
+// they sign in with to theirs.
+func OIDCLogin(res http.ResponseWriter, req *http.Request) {
+	if lib.ValidateRequest(req, res, "/oidc/login/*", http.MethodGet) {
+		provider, ok := oidcProviders[strings.TrimPrefix(req.URL.Path, "/oidc/login/")]
+		if !ok {
+			lib.HandleError(res, http.StatusNotFound, "Unknown provider")
+			return
+		}
+		state, _, err := lib.NewToken()
+		if err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error signing in")
+			return
+		}
+		nonce, _, err := lib.NewToken()
+		if err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error signing in")
+			return
+		}
+		codeVerifier, codeChallenge, err := lib.NewPKCE()
+		if err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error signing in")
+			return
+		}
+		authURL, err := provider.AuthCodeURL(state, nonce, codeChallenge)
+		if err != nil {
+			log.Println("❌ Failed to reach the OIDC provider:", err)
+			lib.HandleError(res, http.StatusBadGateway, "Couldn't reach "+provider.Label)
+			return
+		}
+
+		storeOIDCLogin(state, oidcLogin{
+			provider:     provider.Name,
+			nonce:        nonce,
+			codeVerifier: codeVerifier,
+			linkUserID:   models.GetSessionUserID(req),
+			expireAt:     time.Now().Add(oidcLoginTTL),
+		})
+		http.SetCookie(res, &http.Cookie{
+			Name:     oidcStateCookie,
+			Value:    state,
+			Path:     "/oidc/",
+			MaxAge:   int(oidcLoginTTL.Seconds()),
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteLaxMode, // sent back on the redirect of the provider
+		})
+		http.Redirect(res, req, authURL, http.StatusFound)
+	}
+}
+
+// OIDCCallback completes a sign-in when the provider sends the user back. It
+// signs in the user linked to their account, links it to the user with the
+// same verified email, or creates a user.
+func OIDCCallback(res http.ResponseWriter, req *http.Request) {
+	if lib.ValidateRequest(req, res, "/oidc/callback/*", http.MethodGet) {
+		provider, ok := oidcProviders[strings.TrimPrefix(req.URL.Path, "/oidc/callback/")]
+		if !ok {
+			lib.HandleError(res, http.StatusNotFound, "Unknown provider")
+			return
+		}
+		query := req.URL.Query()
+		state := query.Get("state")
+		http.SetCookie(res, &http.Cookie{Name: oidcStateCookie, Path: "/oidc/", MaxAge: -1})
+		cookie, err := req.Cookie(oidcStateCookie)
+		login, ok := takeOIDCLogin(state)
+		if err != nil || cookie.Value != state || !ok || login.provider != provider.Name {
+			lib.HandleError(res, http.StatusBadRequest, "Sign-in expired, try again")
+			return
+		}
+		if errorCode := query.Get("error"); errorCode != "" {
+			lib.HandleError(res, http.StatusUnauthorized, provider.Label+" refused the sign-in: "+errorCode)
+			return
+		}
+		claims, err := provider.Exchange(query.Get("code"), login.codeVerifier, login.nonce)
+		if err != nil {
+			log.Println("❌ Failed to sign in with", provider.Name+":", err)
+			lib.HandleError(res, http.StatusBadGateway, "Couldn't sign in with "+provider.Label)
+			return
+		}
+
+		if login.linkUserID != "" {
+			linkOIDCAccount(res, req, provider, claims, login.linkUserID)
+			return
+		}
+		user, ok := oidcUser(res, provider, claims)
+		if !ok {
+			return
+		}
+		sanction, err := models.UserRepo.GetSanction(user.ID)
+		if err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error retrieving user")
+			return
+		}
+		if sanction != nil {
+			sendSanction(res, sanction)
+			return
+		}
+
+		// The provider doesn't replace the second factor of the forum
+		account := loginAccount(user.Nickname, user)
+		twoFactor, err := models.TwoFactorRepo.IsEnabled(user.ID)
+		if err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error retrieving user")
+			return
+		}
+		if twoFactor {
+			token, err := models.NewPreAuthToken(user.ID, account)
+			if err != nil {
+				lib.HandleError(res, http.StatusInternalServerError, "Error signing in")
+				return
+			}
+			http.Redirect(res, req, PublicURL+"/#/login/two-factor/"+token, http.StatusFound)
+			return
+		}
+		openSession(res, user, account)
+		http.Redirect(res, req, PublicURL+"/#/login/oidc", http.StatusFound)
+	}
+}
+
+// linkOIDCAccount links the account of a provider to the user in session
+func linkOIDCAccount(res http.ResponseWriter, req *http.Request, provider *lib.OIDCProvider, claims *lib.IDTokenClaims, userID string) {
+	user, ok := authorize(res, req, models.PermissionAccount)
+	if !ok {
+		return
+	}
+	if user.ID != userID {
+		lib.HandleError(res, http.StatusBadRequest, "Sign-in expired, try again")
+		return
+	}
+	if err := models.IdentityRepo.Link(provider.Name, claims.Subject, user.ID); err != nil {
+		if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.Code == sqlite3.ErrConstraint {
+			lib.HandleError(res, http.StatusConflict, "This "+provider.Label+" account, or another one, is already linked")
+			return
+		}
+		lib.HandleError(res, http.StatusInternalServerError, "Error linking account : "+err.Error())
+		return
+	}
+	sendMail(user.Email, provider.Label+" account linked",
+		"Hello "+user.Nickname+",\n\nYou can now sign in to your account with "+provider.Label+".\n"+
+			"If it wasn't you, reset your password at once: "+PublicURL+"/#/password/forgot\n")
+	http.Redirect(res, req, PublicURL+"/#/", http.StatusFound)
+}
+
+// oidcUser finds or creates the user of an account of a provider, answering
+// the request when it can't. It only links an existing user by email when
+// both the provider and the forum verified it, so that a provider can't take
+// over the account of somebody else.
+func oidcUser(res http.ResponseWriter, provider *lib.OIDCProvider, claims *lib.IDTokenClaims) (*models.User, bool) {
+	userID, err := models.IdentityRepo.GetUserID(provider.Name, claims.Subject)
+	if err != nil {
+		lib.HandleError(res, http.StatusInternalServerError, "Error retrieving user")
+		return nil, false
+	}
+	if userID != "" {
+		user, err := models.UserRepo.GetUserByID(userID)
+		if err != nil || user == nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error retrieving user")
+			return nil, false
+		}
+		return user, true
+	}
+
+	if claims.Email == "" {
+		lib.HandleError(res, http.StatusBadRequest, provider.Label+" didn't share your email")
+		return nil, false
+	}
+	user, err := models.UserRepo.GetUserByEmail(claims.Email)
+	if err != nil {
+		lib.HandleError(res, http.StatusInternalServerError, "Error retrieving user")
+		return nil, false
+	}
+	if user != nil && (!bool(claims.EmailVerified) || !user.EmailVerified) {
+		lib.HandleError(res, http.StatusConflict, "An account already uses this email, sign in with its password and link "+provider.Label+" from the Password page")
+		return nil, false
+	}
+	if user == nil {
+		if user, err = createOIDCUser(claims); err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error while creating user")
+			return nil, false
+		}
+	}
+	if err := models.IdentityRepo.Link(provider.Name, claims.Subject, user.ID); err != nil {
+		lib.HandleError(res, http.StatusConflict, "Another "+provider.Label+" account is already linked to yours")
+		return nil, false
+	}
+	return user, true
+}
+
+// createOIDCUser creates a user from the claims of their provider, with a
+// free nickname derived from theirs. They have no password until they reset
+// one.
+func createOIDCUser(claims *lib.IDTokenClaims) (*models.User, error) {
+	base := oidcNickname(claims)
+	user := models.User{
+		Firstname: claims.GivenName,
+		Lastname:  claims.FamilyName,
+		Email:     claims.Email,
+	}
+	for i := 1; ; i++ {
+		user.Nickname = base
+		if i > 1 {
+			suffix := strconv.Itoa(i)
+			if len(base)+len(suffix) > maxNicknameLength {
+				user.Nickname = base[:maxNicknameLength-len(suffix)]
+			}
+			user.Nickname += suffix
+		}
+		existing, err := models.UserRepo.GetUserByNickname(user.Nickname)
+		if err != nil {
+			return nil, err
+		}
+		if existing == nil {
+			break
+		}
+	}
+	if err := models.UserRepo.CreateUser(&user); err != nil {
+		return nil, err
+	}
+	if claims.EmailVerified {
+		if _, err := models.UserRepo.VerifyEmail(user.ID, user.Email); err != nil {
+			return nil, err
+		}
+		user.EmailVerified = true
+	} else {
+		mailVerificationLink(&user)
+	}
+	return &user, nil
+}
+
+// oidcNickname derives a nickname from the username, the email or the name
+// of a provider account, keeping the characters safe in a nickname
+func oidcNickname(claims *lib.IDTokenClaims) string {
+	for _, candidate := range []string{claims.PreferredUsername, strings.Split(claims.Email, "@")[0], claims.Name} {
+		nickname := strings.Map(func(r rune) rune {
+			switch {
+			case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_', r == '-', r == '.':
+				return r
+			case r == ' ':
+				return '_'
+			}
+			return -1
+		}, strings.ToLower(candidate))
+		if len(nickname) > maxNicknameLength {
+			nickname = nickname[:maxNicknameLength]
+		}
+		if nickname != "" && screenNickname(nickname) == nil {
+			return nickname
+		}
+	}
+	return "member"
+}
+
+func storeOIDCLogin(state string, login oidcLogin) {
+	oidcLogins.Lock()
+	defer oidcLogins.Unlock()
+	now := time.Now()
+	for other, pending := range oidcLogins.states {
+		if pending.expireAt.Before(now) {
+			delete(oidcLogins.states, other)
+		}
+	}
+	oidcLogins.states[state] = login
+}
+
+// takeOIDCLogin returns the unexpired sign-in of a state, which can only be
+// used once
+func takeOIDCLogin(state string) (oidcLogin, bool) {
+	oidcLogins.Lock()
+	defer oidcLogins.Unlock()
+	login, ok := oidcLogins.states[state]
+	delete(oidcLogins.states, state)
+	return login, ok && login.expireAt.After(time.Now())
+}
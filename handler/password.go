@@ -0,0 +1,191 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"real-time-forum/data/models"
+	"real-time-forum/lib"
+	"strings"
+	"time"
+)
+
+const (
+	// resetTokenTTL is how long a password reset link works
+	resetTokenTTL = time.Hour
+	// resetCooldown is how long to wait before mailing another reset link
+	resetCooldown = time.Minute
+)
+
+type passwordChange struct {
+	CurrentPassword string `json:"currentPassword"`
+	NewPassword     string `json:"newPassword"`
+}
+
+type passwordReset struct {
+	Email       string `json:"email"`
+	Token       string `json:"token"`
+	NewPassword string `json:"newPassword"`
+}
+
+// ChangePassword replaces the password of the user in session, signing out
+// their other sessions
+func ChangePassword(res http.ResponseWriter, req *http.Request) {
+	if lib.ValidateRequest(req, res, "/password/change", http.MethodPost) {
+		user, ok := authorize(res, req, models.PermissionAccount)
+		if !ok {
+			return
+		}
+		var change passwordChange
+		if err := json.NewDecoder(req.Body).Decode(&change); err != nil {
+			lib.HandleError(res, http.StatusBadRequest, "Invalid JSON format")
+			return
+		}
+		if change.CurrentPassword == "" || change.NewPassword == "" {
+			lib.HandleError(res, http.StatusBadRequest, ErrMissingRequiredFields.Error())
+			return
+		}
+		if err := lib.CheckPassword(change.NewPassword); err != nil {
+			lib.HandleError(res, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if !confirmPassword(res, req, user, change.CurrentPassword) {
+			return
+		}
+
+		if err := setPassword(user.ID, change.NewPassword); err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error changing password : "+err.Error())
+			return
+		}
+		models.DeleteOtherSessions(req, user.ID)
+		sendMail(user.Email, "Your password was changed",
+			"Hello "+user.Nickname+",\n\nThe password of your account was changed and your other sessions were signed out.\n"+
+				"If it wasn't you, reset your password at once: "+PublicURL+"/#/password/forgot\n")
+		lib.SendJSONResponse(res, http.StatusOK, map[string]string{"message": "Password changed successfully"})
+	}
+}
+
+// ForgotPassword mails a link resetting the password to the owner of an
+// email. It answers the same whether the email is known or not.
+func ForgotPassword(res http.ResponseWriter, req *http.Request) {
+	if lib.ValidateRequest(req, res, "/password/forgot", http.MethodPost) {
+		var reset passwordReset
+		if err := json.NewDecoder(req.Body).Decode(&reset); err != nil {
+			lib.HandleError(res, http.StatusBadRequest, "Invalid JSON format")
+			return
+		}
+		reset.Email = strings.TrimSpace(reset.Email)
+		if reset.Email == "" {
+			lib.HandleError(res, http.StatusBadRequest, ErrMissingRequiredFields.Error())
+			return
+		}
+
+		user, err := models.UserRepo.GetUserByEmail(reset.Email)
+		if err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error retrieving user")
+			return
+		}
+		if user != nil {
+			mailResetLink(user)
+		}
+		lib.SendJSONResponse(res, http.StatusOK, map[string]string{"message": "If an account uses this email, a reset link was sent to it"})
+	}
+}
+
+// ResetPassword sets a new password with the token of a reset link, signing
+// out every session of the user
+func ResetPassword(res http.ResponseWriter, req *http.Request) {
+	if lib.ValidateRequest(req, res, "/password/reset", http.MethodPost) {
+		var reset passwordReset
+		if err := json.NewDecoder(req.Body).Decode(&reset); err != nil {
+			lib.HandleError(res, http.StatusBadRequest, "Invalid JSON format")
+			return
+		}
+		if reset.Token == "" || reset.NewPassword == "" {
+			lib.HandleError(res, http.StatusBadRequest, ErrMissingRequiredFields.Error())
+			return
+		}
+		// Check the password first, so that a refused one doesn't spend the token
+		if err := lib.CheckPassword(reset.NewPassword); err != nil {
+			lib.HandleError(res, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		userID, err := models.ResetRepo.UseToken(reset.Token)
+		if err == models.ErrInvalidResetToken {
+			lib.HandleError(res, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error checking reset token : "+err.Error())
+			return
+		}
+		user, err := models.UserRepo.GetUserByID(userID)
+		if err != nil || user == nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error retrieving user")
+			return
+		}
+		if err := setPassword(user.ID, reset.NewPassword); err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error resetting password : "+err.Error())
+			return
+		}
+		models.DeleteUserSessions(user.ID)
+		// The owner proved who they are, lift the sign-in delays
+		if err := models.LoginRepo.ClearFailures(loginAccount(user.Nickname, user)); err != nil {
+			log.Println("❌ Failed to clear the failed sign-in attempts:", err)
+		}
+		sendMail(user.Email, "Your password was reset",
+			"Hello "+user.Nickname+",\n\nThe password of your account was reset with a link sent to this email.\n")
+		lib.SendJSONResponse(res, http.StatusOK, map[string]string{"message": "Password reset successfully, you can sign in"})
+	}
+}
+
+// confirmPassword checks the current password of the user in session before
+// a sensitive change, answering the request when it doesn't match. Guessing
+// it is throttled like signing in.
+func confirmPassword(res http.ResponseWriter, req *http.Request, user *models.User, password string) bool {
+	account, ip := loginAccount(user.Nickname, user), lib.ClientIP(req)
+	if wait := signInWait(account, ip); wait > 0 {
+		lib.HandleTooManyRequests(res, "Too many failed attempts, retry in "+retryIn(wait), wait)
+		return false
+	}
+	hashedPassword, err := models.UserRepo.GetPasswordHash(user.ID)
+	if err != nil {
+		lib.HandleError(res, http.StatusInternalServerError, "Error retrieving user")
+		return false
+	}
+	if matches, _ := passwordMatches(password, hashedPassword); !matches {
+		failSignIn(account, ip, user)
+		lib.HandleError(res, http.StatusForbidden, "Current password is incorrect")
+		return false
+	}
+	return true
+}
+
+// mailResetLink mails a reset link to a user, unless one was just sent
+func mailResetLink(user *models.User) {
+	recent, err := models.ResetRepo.RecentlyCreated(user.ID, resetCooldown)
+	if err != nil || recent {
+		return
+	}
+	token, err := models.ResetRepo.CreateToken(user.ID, resetTokenTTL)
+	if err != nil {
+		log.Println("❌ Failed to create a reset token:", err)
+		return
+	}
+	sendMail(user.Email, "Reset your password",
+		"Hello "+user.Nickname+",\n\nSomeone asked to reset the password of your account. "+
+			"Follow this link within an hour to choose a new one:\n"+PublicURL+"/#/password/reset/"+token+"\n\n"+
+			"If it wasn't you, ignore this email: your password stays the same.\n")
+}
+
+// setPassword stores the hash of a new password, already checked against the
+// policy
+func setPassword(userID, password string) error {
+	hashedPassword, err := lib.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	return models.UserRepo.SetPassword(userID, hashedPassword)
+}
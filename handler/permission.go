@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"net/http"
+	"real-time-forum/data/models"
+	"real-time-forum/lib"
+)
+
+// authorize returns the user in session, or of the API token of the request,
+// if their role grants the permission. It answers 401 without a session or a
+// valid token, 403 with the sanction of a suspended or banned user, and 403
+// without the permission, the scope or a verified email otherwise.
+func authorize(res http.ResponseWriter, req *http.Request, permission models.Permission) (*models.User, bool) {
+	if token, ok := bearerToken(req); ok {
+		return authorizeToken(res, req, token, permission)
+	}
+	if sanction := models.GetSessionSanction(req); sanction != nil {
+		models.DeleteSession(req)
+		sendSanction(res, sanction)
+		return nil, false
+	}
+	if !models.ValidSession(req) {
+		lib.HandleError(res, http.StatusUnauthorized, "No active session")
+		return nil, false
+	}
+	user := models.GetUserFromSession(req)
+	return user, checkPermission(res, user, permission)
+}
+
+// authorizeToken is authorize for the requests of the scripts and the bots,
+// carrying an API token instead of a session
+func authorizeToken(res http.ResponseWriter, req *http.Request, token string, permission models.Permission) (*models.User, bool) {
+	apiToken, err := models.TokenRepo.Authenticate(token, lib.ClientIP(req))
+	if err != nil {
+		lib.HandleError(res, http.StatusInternalServerError, "Error checking API token")
+		return nil, false
+	}
+	var user *models.User
+	if apiToken != nil {
+		user, err = models.UserRepo.GetUserByID(apiToken.UserID)
+	}
+	if err != nil || user == nil {
+		res.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		lib.HandleError(res, http.StatusUnauthorized, "Invalid or expired API token")
+		return nil, false
+	}
+	sanction, err := models.UserRepo.GetSanction(user.ID)
+	if err != nil {
+		lib.HandleError(res, http.StatusInternalServerError, "Error retrieving user")
+		return nil, false
+	}
+	if sanction != nil {
+		sendSanction(res, sanction)
+		return nil, false
+	}
+	if !apiToken.HasScope(permission) {
+		res.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope", scope="`+string(permission)+`"`)
+		lib.HandleError(res, http.StatusForbidden, "This API token doesn't allow this, it lacks the "+string(permission)+" scope")
+		return nil, false
+	}
+	return user, checkPermission(res, user, permission)
+}
+
+// checkPermission checks that the role of a user grants a permission, and
+// that they verified their email if it needs one
+func checkPermission(res http.ResponseWriter, user *models.User, permission models.Permission) bool {
+	if user.NeedsVerification(permission) {
+		lib.HandleError(res, http.StatusForbidden, "Verify your email to do this")
+		return false
+	}
+	if !user.Can(permission) {
+		lib.HandleError(res, http.StatusForbidden, "You are not allowed to do this")
+		return false
+	}
+	return true
+}
+
+// sendSanction tells a suspended or banned user why they are kept out.
+func sendSanction(res http.ResponseWriter, sanction *models.Sanction) {
+	lib.SendJSONResponse(res, http.StatusForbidden, map[string]any{
+		"errors":   sanction.Message(),
+		"sanction": sanction,
+	})
+}
@@ -14,94 +14,117 @@ import (
 
 func CreatePost(res http.ResponseWriter, req *http.Request) {
 	if lib.ValidateRequest(req, res, "/post", http.MethodPost) {
-		isLogin := models.ValidSession(req)
-		if isLogin {
-			userInSession := models.GetUserFromSession(req)
-			var postInfo models.PostCreation
-			if err := json.NewDecoder(req.Body).Decode(&postInfo); err != nil {
-				lib.HandleError(res, http.StatusBadRequest, "Invalid JSON format "+err.Error())
-				return
-			}
-			if err := validatePostInput(&postInfo); err != nil {
-				lib.HandleError(res, http.StatusBadRequest, err.Error())
-				return
-			}
-			postInfo.Slug = lib.Slugify(postInfo.Title)
-			listOfCategories := postInfo.Categories
+		userInSession, ok := authorize(res, req, models.PermissionPost)
+		if !ok {
+			return
+		}
+		var postInfo models.PostCreation
+		if err := json.NewDecoder(req.Body).Decode(&postInfo); err != nil {
+			lib.HandleError(res, http.StatusBadRequest, "Invalid JSON format "+err.Error())
+			return
+		}
+		if err := validatePostInput(&postInfo); err != nil {
+			lib.HandleError(res, http.StatusBadRequest, err.Error())
+			return
+		}
+		if !throttle(res, userInSession, models.ActivityPost) || !checkDuplicate(res, userInSession, models.ContentPost, postInfo.Description) {
+			return
+		}
+		postInfo.Slug = lib.Slugify(postInfo.Title)
+		listOfCategories := postInfo.Categories
 
-			postInfo.AuthorID = userInSession.ID
-			if err := models.PostRepo.CreatePost(&postInfo); err != nil {
-				lib.HandleError(res, http.StatusInternalServerError, "Error creating post : "+err.Error())
-				return
-			}
-			for i := 0; i < len(listOfCategories); i++ {
-				name := strings.TrimSpace(listOfCategories[i])
-				if name != "" {
-					category, _ := models.CategoryRepo.GetCategoryByName(name)
-					if category == nil {
-						category = &models.Category{
-							Name: name,
-						}
-						models.CategoryRepo.CreateCategory(category)
+		postInfo.AuthorID = userInSession.ID
+		if err := models.PostRepo.CreatePost(&postInfo); err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error creating post : "+err.Error())
+			return
+		}
+		for i := 0; i < len(listOfCategories); i++ {
+			name := strings.TrimSpace(listOfCategories[i])
+			if name != "" {
+				category, _ := models.CategoryRepo.GetCategoryByName(name)
+				if category == nil && postInfo.Held {
+					continue // a held post doesn't make public a new category
+				}
+				if category == nil {
+					category = &models.Category{
+						Name: name,
 					}
-					models.PostCategoryRepo.CreatePostCategory(category.ID, postInfo.ID)
+					models.CategoryRepo.CreateCategory(category)
 				}
+				models.PostCategoryRepo.CreatePostCategory(category.ID, postInfo.ID)
 			}
-			post, err := models.PostRepo.GetPostItemByID(postInfo.ID)
-			if err != nil {
-				lib.HandleError(res, http.StatusInternalServerError, "Error getting post : "+err.Error())
-				return
-			}
+		}
+		post, err := models.PostRepo.GetPostItemByID(postInfo.ID)
+		if err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error getting post : "+err.Error())
+			return
+		}
+		if postInfo.Held {
+			holdForReview(models.ContentPost, postInfo.ID, userInSession.ID, postInfo.Title+"\n"+postInfo.Description)
 			lib.SendJSONResponse(res, http.StatusOK, map[string]any{
-				"message": "post created successfully",
+				"message": "post held for review",
 				"post":    post,
+				"held":    true,
 			})
-			SendPost(post)
-		} else {
-			lib.HandleError(res, http.StatusUnauthorized, "not connected")
+			return
 		}
+		lib.SendJSONResponse(res, http.StatusOK, map[string]any{
+			"message": "post created successfully",
+			"post":    post,
+		})
+		SendPost(userInSession.ID, post)
 	}
 }
 
+// canSeePost tells if a post is visible to a user: a hidden post only is to
+// its author and the moderators.
+func canSeePost(post *models.CompletePost, viewer *models.User) bool {
+	return !post.Hidden || viewer != nil && (post.AuthorID == viewer.ID || viewer.Can(models.PermissionModerate))
+}
+
 func GetPost(res http.ResponseWriter, req *http.Request) {
 	if lib.ValidateRequest(req, res, "/post/*", http.MethodGet) {
-		if models.ValidSession(req) {
-			path := req.URL.Path
-			pathPart := strings.Split(path, "/")
-			slug := pathPart[2]
-			post, err := models.PostRepo.GetPostBySlug(slug)
-			if err != nil {
-				lib.HandleError(res, http.StatusInternalServerError, err.Error())
-				return
-			}
+		viewer, ok := authorize(res, req, models.PermissionRead)
+		if !ok {
+			return
+		}
+		path := req.URL.Path
+		pathPart := strings.Split(path, "/")
+		slug := pathPart[2]
+		post, err := models.PostRepo.GetPostBySlug(slug)
+		if err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if !canSeePost(post, viewer) {
+			lib.HandleError(res, http.StatusNotFound, "post not found")
+			return
+		}
 
-			comments, err := models.CommentRepo.GetCommentsOfPost(post.ID)
-			if err != nil {
-				lib.HandleError(res, http.StatusInternalServerError, err.Error())
-				return
-			}
+		comments, err := models.CommentRepo.GetCommentsOfPost(post.ID, viewer.ID)
+		if err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, err.Error())
+			return
+		}
 
-			post.Comments = comments
+		post.Comments = comments
 
-			lib.SendJSONResponse(res, http.StatusOK, map[string]any{"message": "post retrieved successfully", "post": post})
-		} else {
-			lib.HandleError(res, http.StatusUnauthorized, "No active session")
-		}
+		lib.SendJSONResponse(res, http.StatusOK, map[string]any{"message": "post retrieved successfully", "post": post})
 	}
 }
 
 func GetAllPosts(res http.ResponseWriter, req *http.Request) {
 	if lib.ValidateRequest(req, res, "/posts", http.MethodGet) {
-		if models.ValidSession(req) {
-			posts, err := models.PostRepo.GetAllPosts()
-			if err != nil {
-				lib.HandleError(res, http.StatusInternalServerError, err.Error())
-			}
-
-			lib.SendJSONResponse(res, http.StatusOK, map[string]any{"message": "posts retrieved successfully", "posts": posts})
-		} else {
-			lib.HandleError(res, http.StatusUnauthorized, "No active session")
+		viewer, ok := authorize(res, req, models.PermissionRead)
+		if !ok {
+			return
 		}
+		posts, err := models.PostRepo.GetAllPosts(viewer.ID)
+		if err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, err.Error())
+		}
+
+		lib.SendJSONResponse(res, http.StatusOK, map[string]any{"message": "posts retrieved successfully", "posts": posts})
 	}
 }
 
@@ -111,6 +134,16 @@ func validatePostInput(post *models.PostCreation) error {
 	if post.Title == "" || post.Description == "" || len(post.Categories) == 0 {
 		return ErrMissingRequiredFields
 	}
+	for _, name := range post.Categories {
+		if err := screenCategory(name); err != nil {
+			return err
+		}
+	}
+	held, err := screenText(&post.Title, &post.Description)
+	if err != nil {
+		return err
+	}
+	post.Held = held
 	post.Title = html.EscapeString(post.Title)
 	post.Description = html.EscapeString(post.Description)
 	return nil
@@ -0,0 +1,188 @@
+package handler
+
+import (
+	"log"
+	"real-time-forum/data/models"
+	"sync"
+	"time"
+)
+
+// The presence of a user: online while one of their connections is active,
+// away while all of them are idle and offline without any.
+const (
+	PresenceOnline  = "online"
+	PresenceAway    = "away"
+	PresenceOffline = "offline"
+)
+
+// idleKey is the broker key counting the idle connections of a user, next to
+// the key of all their connections which is the user ID itself.
+func idleKey(userID string) string {
+	return "idle:" + userID
+}
+
+func presenceState(connections, idle int) string {
+	switch {
+	case connections <= 0:
+		return PresenceOffline
+	case idle >= connections:
+		return PresenceAway
+	}
+	return PresenceOnline
+}
+
+// Presence returns the presence of a user over all the instances.
+func Presence(userID string) string {
+	connections, err := Broker.Connections(userID)
+	if err != nil {
+		log.Println("❌ Failed to get the presence of", userID, err)
+		return PresenceOffline
+	}
+	idle, _ := Broker.Connections(idleKey(userID))
+	return presenceState(connections, idle)
+}
+
+// Presences returns the presence of each of the users, looked up at once.
+func Presences(userIDs []string) map[string]string {
+	keys := make([]string, 0, 2*len(userIDs))
+	for _, userID := range userIDs {
+		keys = append(keys, userID, idleKey(userID))
+	}
+	counts, err := Broker.ConnectionsOf(keys)
+	if err != nil {
+		log.Println("❌ Failed to get the presence of the users", err)
+	}
+	presences := make(map[string]string, len(userIDs))
+	for _, userID := range userIDs {
+		presences[userID] = presenceState(counts[userID], counts[idleKey(userID)])
+	}
+	return presences
+}
+
+// userConnected counts a new connection of a user and announces them when it
+// is their first one.
+func userConnected(userID string) {
+	connections, err := Broker.Connect(userID)
+	if err != nil {
+		log.Println("❌ Failed to count the connection of", userID, err)
+		return
+	}
+	idle, _ := Broker.Connections(idleKey(userID))
+	presenceChanged(userID, presenceState(connections-1, idle), presenceState(connections, idle))
+}
+
+// userDisconnected counts a closed connection of a user, idle or not, and
+// announces them offline when it was their last one.
+func userDisconnected(userID string, wasIdle bool) {
+	var idle int
+	if wasIdle {
+		idle, _ = Broker.Disconnect(idleKey(userID))
+	} else {
+		idle, _ = Broker.Connections(idleKey(userID))
+	}
+	connections, err := Broker.Disconnect(userID)
+	if err != nil {
+		log.Println("❌ Failed to count the disconnection of", userID, err)
+		return
+	}
+	before := idle
+	if wasIdle {
+		before++
+	}
+	presenceChanged(userID, presenceState(connections+1, before), presenceState(connections, idle))
+}
+
+// userActivity counts a connection of a user becoming idle or active again.
+func userActivity(userID string, idle bool) {
+	var idleCount int
+	var err error
+	if idle {
+		idleCount, err = Broker.Connect(idleKey(userID))
+	} else {
+		idleCount, err = Broker.Disconnect(idleKey(userID))
+	}
+	if err != nil {
+		log.Println("❌ Failed to count the activity of", userID, err)
+		return
+	}
+	connections, _ := Broker.Connections(userID)
+	before := idleCount + 1
+	if idle {
+		before = idleCount - 1
+	}
+	presenceChanged(userID, presenceState(connections, before), presenceState(connections, idleCount))
+}
+
+// presenceChanged records when the user was last seen and sends their status
+// on real transitions only.
+func presenceChanged(userID, before, after string) {
+	if err := models.UserRepo.UpdateLastSeen(userID); err != nil {
+		log.Println("❌ Failed to update the last seen date of", userID, err)
+	}
+	if before != after {
+		SendStatus(userID, after)
+	}
+}
+
+// PollPresence counts the long polls of the users as connections. As a client
+// polls again right after each answer, a user stays connected for a grace
+// period after their last poll ended, instead of going offline in between.
+type PollPresence struct {
+	mutex  sync.Mutex
+	linger time.Duration
+	users  map[string]*pollingUser
+}
+
+type pollingUser struct {
+	polls int         // polls waiting for an answer
+	timer *time.Timer // disconnects the user once the polls stopped
+}
+
+func NewPollPresence(linger time.Duration) *PollPresence {
+	return &PollPresence{linger: linger, users: make(map[string]*pollingUser)}
+}
+
+// Begin counts a poll of the user, connecting them on their first one.
+func (p *PollPresence) Begin(userID string) {
+	p.mutex.Lock()
+	user, ok := p.users[userID]
+	if !ok {
+		user = &pollingUser{}
+		p.users[userID] = user
+	}
+	user.polls++
+	if user.timer != nil {
+		user.timer.Stop()
+		user.timer = nil
+	}
+	p.mutex.Unlock()
+	if !ok {
+		userConnected(userID)
+	}
+}
+
+// End counts an answered poll, disconnecting the user unless they poll again
+// before the grace period is over.
+func (p *PollPresence) End(userID string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	user, ok := p.users[userID]
+	if !ok {
+		return
+	}
+	if user.polls--; user.polls > 0 {
+		return
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(p.linger, func() {
+		p.mutex.Lock()
+		if user.timer != timer {
+			p.mutex.Unlock() // polled again meanwhile
+			return
+		}
+		delete(p.users, userID)
+		p.mutex.Unlock()
+		userDisconnected(userID, false)
+	})
+	user.timer = timer
+}
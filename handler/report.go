@@ -0,0 +1,350 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"html"
+	"net/http"
+	"real-time-forum/data/models"
+	"real-time-forum/lib"
+	"strconv"
+	"strings"
+)
+
+// excerptLength bounds the copy of the reported content kept with a report
+const excerptLength = 500
+
+var (
+	ErrContentNotFound = errors.New("content not found")
+	ErrUnknownAction   = errors.New("unknown action")
+)
+
+// CreateReport flags a post, a comment or a message for the moderators
+func CreateReport(res http.ResponseWriter, req *http.Request) {
+	if lib.ValidateRequest(req, res, "/report", http.MethodPost) {
+		user, ok := authorize(res, req, models.PermissionRead)
+		if !ok {
+			return
+		}
+		var report models.Report
+		if err := json.NewDecoder(req.Body).Decode(&report); err != nil {
+			lib.HandleError(res, http.StatusBadRequest, "Invalid JSON format")
+			return
+		}
+		if err := validateReportInput(&report); err != nil {
+			lib.HandleError(res, http.StatusBadRequest, err.Error())
+			return
+		}
+		authorID, excerpt, err := reportedContent(report.ContentType, report.ContentID, user.ID)
+		if err != nil {
+			lib.HandleError(res, http.StatusNotFound, err.Error())
+			return
+		}
+		if authorID == user.ID {
+			lib.HandleError(res, http.StatusBadRequest, "You cannot report your own content")
+			return
+		}
+		report.ReporterID = user.ID
+		report.AuthorID = authorID
+		report.Excerpt = excerpt
+		if err := models.ReportRepo.CreateReport(&report); err != nil {
+			if err == models.ErrAlreadyReported {
+				lib.HandleError(res, http.StatusConflict, err.Error())
+				return
+			}
+			lib.HandleError(res, http.StatusInternalServerError, "Error creating report : "+err.Error())
+			return
+		}
+		lib.SendJSONResponse(res, http.StatusOK, map[string]any{"message": "content reported successfully"})
+		if created, err := models.ReportRepo.GetReportByID(report.ID); err == nil && created != nil {
+			SendReport("report", *created)
+		}
+	}
+}
+
+// GetReports lists the reports of the moderation queue, filtered by the
+// status, type, reason and assignee ("me", "none" or a user ID) query
+// parameters
+func GetReports(res http.ResponseWriter, req *http.Request) {
+	if lib.ValidateRequest(req, res, "/moderation/reports", http.MethodGet) {
+		moderator, ok := authorize(res, req, models.PermissionModerate)
+		if !ok {
+			return
+		}
+		query := req.URL.Query()
+		filter := models.ReportFilter{
+			Status:      query.Get("status"),
+			ContentType: query.Get("type"),
+			Reason:      query.Get("reason"),
+		}
+		if filter.Status == "" {
+			filter.Status = models.ReportOpen
+		} else if filter.Status == "all" {
+			filter.Status = ""
+		}
+		switch assignee := query.Get("assignee"); assignee {
+		case "me":
+			filter.AssigneeID = moderator.ID
+		case "none":
+			filter.Unassigned = true
+		default:
+			filter.AssigneeID = assignee
+		}
+
+		page, err := strconv.Atoi(query.Get("page"))
+		if err != nil || page < 1 {
+			page = 1
+		}
+		limit, err := strconv.Atoi(query.Get("limit"))
+		if err != nil || limit < 1 || limit > 100 {
+			limit = 20
+		}
+		filter.Offset = (page - 1) * limit
+		filter.Limit = limit
+
+		reports, err := models.ReportRepo.GetReports(filter)
+		if err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error getting reports : "+err.Error())
+			return
+		}
+		lib.SendJSONResponse(res, http.StatusOK, map[string]any{"reports": reports})
+	}
+}
+
+// AssignReport assigns the report of the URL to a moderator, the one in
+// session by default, or to nobody with an empty assignee
+func AssignReport(res http.ResponseWriter, req *http.Request) {
+	if lib.ValidateRequest(req, res, "/moderation/assign/*", http.MethodPost) {
+		moderator, ok := authorize(res, req, models.PermissionModerate)
+		if !ok {
+			return
+		}
+		report, ok := requestedReport(res, req)
+		if !ok {
+			return
+		}
+		var assignment struct {
+			AssigneeID *string `json:"assigneeID"`
+		}
+		if req.ContentLength != 0 {
+			if err := json.NewDecoder(req.Body).Decode(&assignment); err != nil {
+				lib.HandleError(res, http.StatusBadRequest, "Invalid JSON format")
+				return
+			}
+		}
+		assigneeID := moderator.ID
+		if assignment.AssigneeID != nil {
+			assigneeID = *assignment.AssigneeID
+		}
+		if assigneeID != "" && assigneeID != moderator.ID {
+			assignee, err := models.UserRepo.GetUserByID(assigneeID)
+			if err != nil || assignee == nil || !assignee.Can(models.PermissionModerate) {
+				lib.HandleError(res, http.StatusBadRequest, "The assignee must be a moderator")
+				return
+			}
+		}
+		if err := models.ReportRepo.Assign(report.ID, assigneeID); err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error assigning report : "+err.Error())
+			return
+		}
+		audit(req, moderator, models.AuditReportAssign, models.AuditTargetReport, report.ID,
+			map[string]string{"assigneeID": report.AssigneeID}, map[string]string{"assigneeID": assigneeID})
+		report, _ = models.ReportRepo.GetReportByID(report.ID)
+		lib.SendJSONResponse(res, http.StatusOK, map[string]any{"message": "report assigned successfully", "report": report})
+		SendReport("report-updated", *report)
+	}
+}
+
+// ResolveReport applies a moderation action to the content of the report of
+// the URL and closes the open reports on it
+func ResolveReport(res http.ResponseWriter, req *http.Request) {
+	if lib.ValidateRequest(req, res, "/moderation/resolve/*", http.MethodPost) {
+		moderator, ok := authorize(res, req, models.PermissionModerate)
+		if !ok {
+			return
+		}
+		report, ok := requestedReport(res, req)
+		if !ok {
+			return
+		}
+		if report.Status != models.ReportOpen {
+			lib.HandleError(res, http.StatusConflict, "Report already resolved")
+			return
+		}
+		var resolution struct {
+			Action string `json:"action"`
+			Note   string `json:"note"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&resolution); err != nil {
+			lib.HandleError(res, http.StatusBadRequest, "Invalid JSON format")
+			return
+		}
+		resolution.Note = html.EscapeString(strings.TrimSpace(resolution.Note))
+
+		if resolution.Action == models.ResolutionBan && !canSanction(res, moderator, report.AuthorID) {
+			return
+		}
+		previousSanction, _ := models.UserRepo.GetSanction(report.AuthorID)
+		if err := applyResolution(report, resolution.Action, resolution.Note); err != nil {
+			if err == ErrUnknownAction {
+				lib.HandleError(res, http.StatusBadRequest, err.Error())
+				return
+			}
+			lib.HandleError(res, http.StatusInternalServerError, "Error resolving report : "+err.Error())
+			return
+		}
+		if err := models.ReportRepo.Resolve(report, moderator.ID, resolution.Action, resolution.Note); err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error resolving report : "+err.Error())
+			return
+		}
+		resolved, _ := models.ReportRepo.GetReportByID(report.ID)
+		audit(req, moderator, models.AuditReportResolve, models.AuditTargetReport, report.ID, report, resolved)
+		if resolution.Action == models.ResolutionBan {
+			sanction, _ := models.UserRepo.GetSanction(report.AuthorID)
+			audit(req, moderator, models.AuditUserSanction, models.AuditTargetUser, report.AuthorID, previousSanction, sanction)
+		}
+		report = resolved
+		lib.SendJSONResponse(res, http.StatusOK, map[string]any{"message": "report resolved successfully", "report": report})
+		SendReport("report-updated", *report)
+	}
+}
+
+// applyResolution does the moderation action on the reported content or its author
+func applyResolution(report *models.Report, action, note string) error {
+	switch action {
+	case models.ResolutionDismiss:
+		// Dismissing a content held by the word filter publishes it
+		held, err := models.ReportRepo.IsHeld(report.ContentType, report.ContentID)
+		if err != nil || !held {
+			return err
+		}
+		return releaseContent(report.ContentType, report.ContentID, report.AuthorID)
+	case models.ResolutionHide:
+		switch report.ContentType {
+		case models.ContentPost:
+			return models.PostRepo.SetHidden(report.ContentID, true)
+		case models.ContentComment:
+			return models.CommentRepo.SetHidden(report.ContentID, true)
+		case models.ContentMessage:
+			return models.MessageRepo.SetHidden(report.ContentID, true)
+		}
+	case models.ResolutionDelete:
+		switch report.ContentType {
+		case models.ContentPost:
+			return models.PostRepo.DeletePost(report.ContentID)
+		case models.ContentComment:
+			return models.CommentRepo.DeleteComment(report.ContentID)
+		case models.ContentMessage:
+			return models.MessageRepo.DeleteMessage(report.ContentID)
+		}
+	case models.ResolutionWarn:
+		SendWarning(report.AuthorID, report.Reason, note)
+		return nil
+	case models.ResolutionBan:
+		reason := note
+		if reason == "" {
+			reason = report.Reason
+		}
+		return sanctionUser(report.AuthorID, &models.Sanction{Banned: true, Reason: reason})
+	}
+	return ErrUnknownAction
+}
+
+// requestedReport reads the report of the URL
+func requestedReport(res http.ResponseWriter, req *http.Request) (*models.Report, bool) {
+	pathPart := strings.Split(req.URL.Path, "/")
+	report, err := models.ReportRepo.GetReportByID(pathPart[len(pathPart)-1])
+	if err != nil {
+		lib.HandleError(res, http.StatusInternalServerError, "Error getting report : "+err.Error())
+		return nil, false
+	}
+	if report == nil {
+		lib.HandleError(res, http.StatusNotFound, "Report not found")
+		return nil, false
+	}
+	return report, true
+}
+
+// releaseContent shows a content held for review and sends it to the users
+// as if it was just published.
+func releaseContent(contentType, contentID, authorID string) error {
+	switch contentType {
+	case models.ContentPost:
+		if err := models.PostRepo.SetHidden(contentID, false); err != nil {
+			return err
+		}
+		post, err := models.PostRepo.GetPostItemByID(contentID)
+		if err != nil {
+			return err
+		}
+		SendPost(authorID, post)
+	case models.ContentComment:
+		if err := models.CommentRepo.SetHidden(contentID, false); err != nil {
+			return err
+		}
+		postID, err := models.CommentRepo.GetPostIDOfComment(contentID)
+		if err != nil {
+			return err
+		}
+		comment, err := models.CommentRepo.GetCommentByID(contentID)
+		if err != nil {
+			return err
+		}
+		SendComment(postID, comment)
+	case models.ContentMessage:
+		if err := models.MessageRepo.SetHidden(contentID, false); err != nil {
+			return err
+		}
+		message, err := models.MessageRepo.GetMessageByID(contentID)
+		if err != nil || message == nil {
+			return err
+		}
+		SendMessage(*message)
+	}
+	return nil
+}
+
+// reportedContent returns the author and an excerpt of the reported content.
+// Only the participants of a conversation can report its messages.
+func reportedContent(contentType, contentID, reporterID string) (string, string, error) {
+	var authorID, text string
+	switch contentType {
+	case models.ContentPost:
+		post, err := models.PostRepo.GetPostByID(contentID)
+		if err != nil || post == nil {
+			return "", "", ErrContentNotFound
+		}
+		authorID, text = post.AuthorID, post.Title+"\n"+post.Description
+	case models.ContentComment:
+		comment, err := models.CommentRepo.GetCommentByID(contentID)
+		if err != nil {
+			return "", "", ErrContentNotFound
+		}
+		authorID, text = comment.AuthorID, comment.Text
+	case models.ContentMessage:
+		message, err := models.MessageRepo.GetMessageByID(contentID)
+		if err != nil || message == nil || (message.SenderID != reporterID && message.ReceiverID != reporterID) {
+			return "", "", ErrContentNotFound
+		}
+		authorID, text = message.SenderID, message.Content
+	default:
+		return "", "", ErrContentNotFound
+	}
+	if len(text) > excerptLength {
+		text = text[:excerptLength]
+	}
+	return authorID, text, nil
+}
+
+func validateReportInput(report *models.Report) error {
+	report.ContentType = strings.ToLower(strings.TrimSpace(report.ContentType))
+	report.Reason = strings.ToLower(strings.TrimSpace(report.Reason))
+	if report.ContentType == "" || report.ContentID == "" || report.Reason == "" {
+		return ErrMissingRequiredFields
+	}
+	if !models.IsValidReason(report.Reason) {
+		return errors.New("unknown reason, expected one of " + strings.Join(models.ReportReasons, ", "))
+	}
+	report.Details = html.EscapeString(strings.TrimSpace(report.Details))
+	return nil
+}
@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"encoding/json"
+	"html"
+	"net/http"
+	"real-time-forum/data/models"
+	"real-time-forum/lib"
+	"strings"
+	"time"
+)
+
+// maxSuspension bounds a suspension, longer ones are bans
+const maxSuspension = 365 * 24 * time.Hour
+
+// SanctionUser suspends the user of the URL for a number of hours, or bans
+// them for good
+func SanctionUser(res http.ResponseWriter, req *http.Request) {
+	if lib.ValidateRequest(req, res, "/moderation/sanction/*", http.MethodPost) {
+		moderator, ok := authorize(res, req, models.PermissionModerate)
+		if !ok {
+			return
+		}
+		userID, ok := targetUser(res, req, moderator.ID)
+		if !ok {
+			return
+		}
+		var input struct {
+			Type   string `json:"type"` // "suspend" or "ban"
+			Hours  int    `json:"hours"`
+			Reason string `json:"reason"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&input); err != nil {
+			lib.HandleError(res, http.StatusBadRequest, "Invalid JSON format")
+			return
+		}
+		sanction := models.Sanction{Reason: html.EscapeString(strings.TrimSpace(input.Reason))}
+		switch input.Type {
+		case "ban":
+			sanction.Banned = true
+		case "suspend":
+			duration := time.Duration(input.Hours) * time.Hour
+			if duration <= 0 || duration > maxSuspension {
+				lib.HandleError(res, http.StatusBadRequest, "Invalid suspension duration")
+				return
+			}
+			until := time.Now().Add(duration)
+			sanction.Until = &until
+		default:
+			lib.HandleError(res, http.StatusBadRequest, "Unknown sanction, expected suspend or ban")
+			return
+		}
+		if !canSanction(res, moderator, userID) {
+			return
+		}
+		previous, err := models.UserRepo.GetSanction(userID)
+		if err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error getting sanction : "+err.Error())
+			return
+		}
+		if err := sanctionUser(userID, &sanction); err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error sanctioning user : "+err.Error())
+			return
+		}
+		audit(req, moderator, models.AuditUserSanction, models.AuditTargetUser, userID, previous, sanction)
+		lib.SendJSONResponse(res, http.StatusOK, map[string]any{"message": "user sanctioned successfully", "sanction": sanction})
+	}
+}
+
+// LiftSanction ends the suspension or the ban of the user of the URL
+func LiftSanction(res http.ResponseWriter, req *http.Request) {
+	if lib.ValidateRequest(req, res, "/moderation/lift/*", http.MethodPost) {
+		moderator, ok := authorize(res, req, models.PermissionModerate)
+		if !ok {
+			return
+		}
+		userID, ok := targetUser(res, req, moderator.ID)
+		if !ok {
+			return
+		}
+		if !canSanction(res, moderator, userID) {
+			return
+		}
+		previous, err := models.UserRepo.GetSanction(userID)
+		if err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error getting sanction : "+err.Error())
+			return
+		}
+		if err := models.UserRepo.LiftSanction(userID); err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error lifting sanction : "+err.Error())
+			return
+		}
+		audit(req, moderator, models.AuditUserLift, models.AuditTargetUser, userID, previous, nil)
+		lib.SendJSONResponse(res, http.StatusOK, map[string]any{"message": "sanction lifted successfully"})
+	}
+}
+
+// canSanction checks that the moderator may sanction the user, or lift their
+// sanction: only admins sanction the staff
+func canSanction(res http.ResponseWriter, moderator *models.User, userID string) bool {
+	user, err := models.UserRepo.GetUserByID(userID)
+	if err != nil || user == nil {
+		lib.HandleError(res, http.StatusNotFound, "User not found")
+		return false
+	}
+	if user.Can(models.PermissionModerate) && !moderator.Can(models.PermissionManageRoles) {
+		lib.HandleError(res, http.StatusForbidden, "Only an admin can sanction a moderator")
+		return false
+	}
+	return true
+}
+
+// sanctionUser stores the sanction then ends the sessions and the connections
+// of the user right away
+func sanctionUser(userID string, sanction *models.Sanction) error {
+	var err error
+	if sanction.Banned {
+		err = models.UserRepo.Ban(userID, sanction.Reason)
+	} else {
+		err = models.UserRepo.Suspend(userID, *sanction.Until, sanction.Reason)
+	}
+	if err != nil {
+		return err
+	}
+	models.DeleteUserSessions(userID)
+	SendTokenExpired(userID, sanction)
+	return nil
+}
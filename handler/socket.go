@@ -2,19 +2,78 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"real-time-forum/data/models"
-	"sync"
+	"strings"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+// socketWriteWait bounds the time to write a frame to a WebSocket.
+const socketWriteWait = 10 * time.Second
+
 var (
-	upgrader        = websocket.Upgrader{}
-	UserConnections = &sync.Map{}
+	upgrader    = websocket.Upgrader{CheckOrigin: sameOrigin} // no other site may connect with the cookie of a user
+	Connections = NewHub()
+	Events      = NewEventStore(eventLogSize, eventLogRetention)
+	Typing      = NewTypingTracker()
 )
 
+// socketTransport queues the frames of a client for a writer of its own, so
+// that a client not reading holds back neither the publishers nor the other
+// clients: it is disconnected once its queue is full.
+type socketTransport struct {
+	*queueTransport
+	conn *websocket.Conn
+}
+
+func newSocketTransport(conn *websocket.Conn) *socketTransport {
+	t := &socketTransport{newQueueTransport(), conn}
+	go t.write()
+	return t
+}
+
+// write sends the queued frames until the transport is closed, then the ones
+// left and closes the WebSocket, which ends the read loop of the client. A
+// client not reading gets its connection broken after socketWriteWait.
+func (t *socketTransport) write() {
+	defer t.conn.Close()
+	for {
+		select {
+		case output := <-t.frames:
+			if err := t.writeFrame(output); err != nil {
+				t.Close()
+				return
+			}
+		case <-t.closed:
+			for _, output := range t.pending() {
+				if err := t.writeFrame(output); err != nil {
+					return
+				}
+			}
+			return
+		}
+	}
+}
+
+// Send queues a frame. A client whose queue is full has its WebSocket closed
+// at once, the frames left being of no use to it.
+func (t *socketTransport) Send(output []byte) error {
+	err := t.queueTransport.Send(output)
+	if err == ErrSlowClient {
+		t.conn.Close()
+	}
+	return err
+}
+
+func (t *socketTransport) writeFrame(output []byte) error {
+	t.conn.SetWriteDeadline(time.Now().Add(socketWriteWait))
+	return t.conn.WriteMessage(websocket.TextMessage, output)
+}
+
 type wsInput struct {
 	Type string                 `json:"type"`
 	Data map[string]interface{} `json:"data"`
@@ -22,36 +81,90 @@ type wsInput struct {
 
 type NewPostEvent struct {
 	Type string          `json:"type"`
+	Seq  uint64          `json:"seq"`
 	Data models.PostItem `json:"post"`
 }
 
 type NewCommentEvent struct {
 	Type   string             `json:"type"`
+	Seq    uint64             `json:"seq"`
 	PostID string             `json:"postID"`
 	Data   models.CommentItem `json:"comment"`
 }
 
 type NewStatusEvent struct {
-	Type   string `json:"type"`
-	UserID string `json:"userID"`
-	Online bool   `json:"online"`
+	Type     string `json:"type"`
+	Seq      uint64 `json:"seq"`
+	UserID   string `json:"userID"`
+	Online   bool   `json:"online"`
+	Presence string `json:"presence"`
+	LastSeen string `json:"lastSeen"`
 }
 
 type TypingEvent struct {
-	Type     string `json:"type"`
-	From     string `json:"from"`
-	To       string `json:"to"`
-	IsTyping bool   `json:"isTyping"`
+	Type         string   `json:"type"`
+	From         string   `json:"from"`
+	To           string   `json:"to"`
+	IsTyping     bool     `json:"isTyping"`
+	Participants []string `json:"participants,omitempty"`
 }
 
+// TokenExpiredEvent tells a user that their session ended, with the
+// sanction that ended it if any.
 type TokenExpiredEvent struct {
-	Type   string `json:"type"`
-	UserID string `json:"userID"`
+	Type     string           `json:"type"`
+	UserID   string           `json:"userID"`
+	Sanction *models.Sanction `json:"sanction,omitempty"`
 }
 
 type NewMessageEvent struct {
 	Type    string         `json:"type"`
+	Seq     uint64         `json:"seq"`
 	Message models.Message `json:"message"`
+	Muted   bool           `json:"muted"` // whether the recipient muted the conversation
+}
+
+// ReportEvent tells the moderators about a new ("report") or an assigned or
+// resolved ("report-updated") report.
+type ReportEvent struct {
+	Type   string        `json:"type"`
+	Seq    uint64        `json:"seq"`
+	Report models.Report `json:"report"`
+}
+
+// WarningEvent tells a user that a moderator warned them about their content.
+type WarningEvent struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+	Note   string `json:"note"`
+}
+
+// SecurityEvent tells a user about an event on their account, like a lockout.
+type SecurityEvent struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// TopicEvent answers a subscribe or unsubscribe frame.
+type TopicEvent struct {
+	Type  string `json:"type"`
+	Topic string `json:"topic"`
+	Error string `json:"error,omitempty"`
+}
+
+// SyncEvent tells a client the sequence number it is up to date with, after
+// connecting, after a replay ("resumed") or when the replay is impossible
+// ("resync-required").
+type SyncEvent struct {
+	Type string `json:"type"`
+	Seq  uint64 `json:"seq"`
+}
+
+// ShutdownEvent tells a client that the server is stopping, so that it
+// reconnects, to another instance, within RetryAfter milliseconds.
+type ShutdownEvent struct {
+	Type       string `json:"type"`
+	RetryAfter int64  `json:"retryAfter"`
 }
 
 func HandleWebSocket(res http.ResponseWriter, req *http.Request) {
@@ -61,8 +174,23 @@ func HandleWebSocket(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	UserConnections.Store(conn, "")
-	defer UserConnections.Delete(conn)
+	transport := newSocketTransport(conn)
+	defer transport.Close()
+	client := NewClient(transport, req)
+	Connections.Register(client)
+	defer Connections.Unregister(client)
+	defer Typing.StopAll(client)
+	sendSync(client, "hello", Events.Seq())
+
+	if userID := client.User(); userID != "" {
+		Connections.Subscribe(client, UserTopic(userID))
+		userConnected(userID)
+		defer func() {
+			if client.User() != "" {
+				userDisconnected(userID, client.idle)
+			}
+		}()
+	}
 	for {
 		_, incoming, err := conn.ReadMessage()
 		if err != nil {
@@ -75,103 +203,309 @@ func HandleWebSocket(res http.ResponseWriter, req *http.Request) {
 			return
 		}
 		switch data.Type {
-		case "login":
-			UserConnections.Store(conn, data.Data["userID"])
-			SendStatus(data.Data["userID"].(string), true)
-			defer SendStatus(data.Data["userID"].(string), false)
 		case "logout":
-			UserConnections.Store(conn, "")
-			SendStatus(data.Data["userID"].(string), false)
+			if userID := client.User(); userID != "" {
+				Typing.StopAll(client)
+				Connections.Unsubscribe(client, UserTopic(userID))
+				Connections.Unsubscribe(client, PresenceTopic)
+				client.setUser("")
+				userDisconnected(userID, client.idle)
+			}
+		case "activity":
+			// The client reports whether its user stopped interacting with it
+			idle := data.Data["state"] == "idle"
+			if userID := client.User(); userID != "" && idle != client.idle {
+				client.idle = idle
+				userActivity(userID, idle)
+			}
+		case "subscribe":
+			topic, _ := data.Data["topic"].(string)
+			subscribe(client, topic)
+		case "unsubscribe":
+			topic, _ := data.Data["topic"].(string)
+			topic = normalizeTopic(topic)
+			Connections.Unsubscribe(client, topic)
+			sendTopic(client, "unsubscribed", topic, "")
+		case "resume":
+			lastSeq, _ := data.Data["lastSeq"].(float64)
+			resume(client, uint64(lastSeq))
 		case "typing":
-			SendTyping(data.Data["from"].(string), data.Data["to"].(string), data.Data["isTyping"].(bool))
+			// The sender is the user of the connection, "to" is a user ID or
+			// the participants of a group conversation
+			isTyping, _ := data.Data["isTyping"].(bool)
+			if userID := client.User(); userID != "" {
+				if err := Typing.Typing(client, userID, typingTargets(data.Data["to"]), isTyping); err != nil {
+					log.Println("❌ Typing frame rejected:", err)
+				}
+			}
 		}
 	}
 }
 
-func SendTyping(from string, to string, isTyping bool) {
-	data := TypingEvent{"typing", from, to, isTyping}
-	output, err := json.Marshal(data)
+// typingTargets reads the recipients of a typing frame.
+func typingTargets(to interface{}) []string {
+	switch to := to.(type) {
+	case string:
+		return []string{to}
+	case []interface{}:
+		recipients := make([]string, 0, len(to))
+		for _, recipient := range to {
+			if recipient, ok := recipient.(string); ok {
+				recipients = append(recipients, recipient)
+			}
+		}
+		return recipients
+	}
+	return nil
+}
+
+// subscribe adds the client to a topic after checking it may read it.
+func subscribe(client *Client, topic string) {
+	topic = normalizeTopic(topic)
+	if err := canSubscribe(client.User(), topic); err != nil {
+		sendTopic(client, "subscribe-denied", topic, err.Error())
+		return
+	}
+	Connections.Subscribe(client, topic)
+	sendTopic(client, "subscribed", topic, "")
+}
+
+// normalizeTopic lowercases the category names the way CategoryTopic does.
+func normalizeTopic(topic string) string {
+	topic = strings.TrimSpace(topic)
+	if strings.HasPrefix(topic, "category:") {
+		return CategoryTopic(strings.TrimPrefix(topic, "category:"))
+	}
+	return topic
+}
+
+var (
+	ErrUnknownTopic   = errors.New("unknown topic")
+	ErrForbiddenTopic = errors.New("forbidden topic")
+)
+
+// canSubscribe checks that the user may receive the events of the topic:
+// the feed, categories and posts are public, the presence is for members
+// and a user topic is for its owner only.
+func canSubscribe(userID, topic string) error {
+	switch {
+	case topic == FeedTopic:
+		return nil
+	case topic == PresenceTopic:
+		if userID == "" {
+			return ErrForbiddenTopic
+		}
+		return nil
+	case topic == ModerationTopic:
+		if userID == "" {
+			return ErrForbiddenTopic
+		}
+		if user, err := models.UserRepo.GetUserByID(userID); err != nil || user == nil || !user.Can(models.PermissionModerate) {
+			return ErrForbiddenTopic
+		}
+		return nil
+	case strings.HasPrefix(topic, "category:") && len(topic) > len("category:"):
+		return nil
+	case strings.HasPrefix(topic, "post:"):
+		post, err := models.PostRepo.GetPostByID(strings.TrimPrefix(topic, "post:"))
+		if err != nil || post == nil {
+			return ErrUnknownTopic
+		}
+		var viewer *models.User
+		if userID != "" {
+			viewer, _ = models.UserRepo.GetUserByID(userID)
+		}
+		if !canSeePost(post, viewer) {
+			return ErrUnknownTopic // as if it didn't exist, like GetPost
+		}
+		return nil
+	case strings.HasPrefix(topic, "user:"):
+		if userID == "" || topic != UserTopic(userID) {
+			return ErrForbiddenTopic
+		}
+		return nil
+	}
+	return ErrUnknownTopic
+}
+
+// resume replays the events the client missed since lastSeq, or asks it to
+// reload its state when they are no longer available.
+func resume(client *Client, lastSeq uint64) {
+	current := Events.Seq()
+	subscribed := func(topic string) bool { return Connections.IsSubscribed(client, topic) }
+	missed, ok := Events.Since(client.User(), lastSeq, subscribed)
+	if !ok {
+		log.Printf("🔄 Resync required for %q from %d to %d\n", client.User(), lastSeq, current)
+		sendSync(client, "resync-required", current)
+		return
+	}
+	for _, output := range missed {
+		if err := client.Send(output); err != nil {
+			log.Println("Error replaying event", err)
+			return
+		}
+	}
+	sendSync(client, "resumed", current)
+}
+
+// NotifyShutdown sends the server-shutdown frame to every client and closes
+// its connection, the WebSockets being out of reach of http.Server.Shutdown
+// and the event streams and polls holding it until its deadline otherwise.
+func NotifyShutdown() {
+	output, err := json.Marshal(ShutdownEvent{"server-shutdown", shutdownReconnectWindow.Milliseconds()})
 	if err != nil {
 		log.Println(err)
+		return
 	}
-	UserConnections.Range(func(key, value interface{}) bool {
-		if value.(string) != "" && value.(string) != from && value.(string) == to {
-			key.(*websocket.Conn).WriteMessage(websocket.TextMessage, output)
-		}
-		return true
-	})
+	clients := Connections.CloseAll(output)
+	log.Println("✅ Notified", clients, "clients of the shutdown")
 }
 
-func SendPost(post models.PostItem) {
-	data := NewPostEvent{"post", post}
-	output, err := json.Marshal(data)
+func sendSync(client *Client, kind string, seq uint64) {
+	output, err := json.Marshal(SyncEvent{kind, seq})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	client.Send(output)
+}
+
+func sendTopic(client *Client, kind, topic, reason string) {
+	output, err := json.Marshal(TopicEvent{kind, topic, reason})
 	if err != nil {
 		log.Println(err)
+		return
 	}
-	UserConnections.Range(func(key, value interface{}) bool {
-		if c, ok := key.(*websocket.Conn); ok {
-			c.WriteMessage(websocket.TextMessage, output)
+	client.Send(output)
+}
+
+func SendPost(authorID string, post models.PostItem) {
+	topics := []string{FeedTopic}
+	for _, name := range post.ListOfCategories {
+		if name != "" {
+			topics = append(topics, CategoryTopic(name))
 		}
-		return true
+	}
+	exclude := hidingContentOf(authorID)
+	sequenced(func(seq uint64) []brokerEvent {
+		output, err := json.Marshal(NewPostEvent{"post", seq, post})
+		if err != nil {
+			log.Println(err)
+			return nil
+		}
+		return []brokerEvent{publicEvent(seq, topics, exclude, output)}
 	})
 }
 
 func SendComment(postID string, comment models.CommentItem) {
-	data := NewCommentEvent{"comment", postID, comment}
-	output, err := json.Marshal(data)
+	exclude := hidingContentOf(comment.AuthorID)
+	sequenced(func(seq uint64) []brokerEvent {
+		output, err := json.Marshal(NewCommentEvent{"comment", seq, postID, comment})
+		if err != nil {
+			log.Println(err)
+			return nil
+		}
+		return []brokerEvent{publicEvent(seq, []string{PostTopic(postID)}, exclude, output)}
+	})
+}
+
+// SendStatus tells the presence of a user to the others, except to the users
+// they blocked.
+func SendStatus(userID string, presence string) {
+	lastSeen := time.Now().UTC().Format(models.LastSeenLayout)
+	blocked, err := models.BlockRepo.GetBlockedIDs(userID)
 	if err != nil {
-		log.Println(err)
+		log.Println("❌ Failed to get the users blocked by", userID, err)
 	}
-	UserConnections.Range(func(key, value interface{}) bool {
-		if c, ok := key.(*websocket.Conn); ok {
-			c.WriteMessage(websocket.TextMessage, output)
+	sequenced(func(seq uint64) []brokerEvent {
+		output, err := json.Marshal(NewStatusEvent{"status", seq, userID, presence != PresenceOffline, presence, lastSeen})
+		if err != nil {
+			log.Println(err)
+			return nil
 		}
-		return true
+		return []brokerEvent{publicEvent(seq, []string{PresenceTopic}, append(blocked, userID), output)}
 	})
 }
 
-func SendStatus(userID string, online bool) {
-	data := NewStatusEvent{"status", userID, online}
+// hidingContentOf lists the users who asked not to see the author's content.
+func hidingContentOf(authorID string) []string {
+	userIDs, err := models.BlockRepo.GetUsersHidingContentOf(authorID)
+	if err != nil {
+		log.Println("❌ Failed to get the users hiding", authorID, err)
+	}
+	return userIDs
+}
+
+// SendTokenExpired tells a user their session ended and closes their
+// connections, which come back as visitors.
+func SendTokenExpired(userID string, sanction *models.Sanction) {
+	data := TokenExpiredEvent{"token-expired", userID, sanction}
 	output, err := json.Marshal(data)
 	if err != nil {
 		log.Println(err)
+		return
 	}
-	UserConnections.Range(func(key, value interface{}) bool {
-		if value.(string) != "" && value.(string) != userID {
-			key.(*websocket.Conn).WriteMessage(websocket.TextMessage, output)
+	disconnectUsers([]string{userID}, output)
+}
+
+// SendReport tells the online moderators about a report.
+func SendReport(kind string, report models.Report) {
+	sequenced(func(seq uint64) []brokerEvent {
+		output, err := json.Marshal(ReportEvent{kind, seq, report})
+		if err != nil {
+			log.Println(err)
+			return nil
 		}
-		return true
+		return []brokerEvent{publicEvent(seq, []string{ModerationTopic}, nil, output)}
 	})
 }
 
-func SendTokenExpired(userID string) {
-	data := TokenExpiredEvent{"token-expired", userID}
-	output, err := json.Marshal(data)
+// SendWarning tells a user that a moderator warned them.
+func SendWarning(userID, reason, note string) {
+	output, err := json.Marshal(WarningEvent{"warning", reason, note})
 	if err != nil {
 		log.Println(err)
+		return
 	}
-	UserConnections.Range(func(key, value interface{}) bool {
-		if value.(string) != "" && value.(string) != userID {
-			key.(*websocket.Conn).WriteMessage(websocket.TextMessage, output)
-		}
-		return true
-	})
+	publishPrivate([]string{userID}, output)
 }
 
-func SendMessage(message models.Message) {
-	data := NewMessageEvent{"message", message}
-	output, err := json.Marshal(data)
+// SendSecurityNotice tells a user about an event on their account.
+func SendSecurityNotice(userID, message string) {
+	output, err := json.Marshal(SecurityEvent{"security", message})
 	if err != nil {
 		log.Println(err)
+		return
 	}
-	UserConnections.Range(func(key, value interface{}) bool {
-		if value.(string) == message.SenderID || value.(string) == message.ReceiverID {
-			key.(*websocket.Conn).WriteMessage(websocket.TextMessage, output)
-			if message.SenderID == message.ReceiverID {
-				log.Println("🚨 Sender and receiver are the same")
-				return false
+	publishPrivate([]string{userID}, output)
+}
+
+// SendMessage sends a message to its sender and its receiver, telling the
+// receiver whether they muted the conversation.
+func SendMessage(message models.Message) {
+	if message.SenderID == message.ReceiverID {
+		log.Println("🚨 Sender and receiver are the same")
+	}
+	muted, err := models.MuteRepo.IsMuted(message.ReceiverID, message.SenderID)
+	if err != nil {
+		log.Println("❌ Failed to check if the conversation is muted", err)
+	}
+	sequenced(func(seq uint64) []brokerEvent {
+		output, err := json.Marshal(NewMessageEvent{"message", seq, message, false})
+		if err != nil {
+			log.Println(err)
+			return nil
+		}
+		events := []brokerEvent{privateEvent(seq, []string{message.SenderID}, output)}
+		if message.ReceiverID == message.SenderID {
+			return events
+		}
+		if muted {
+			if output, err = json.Marshal(NewMessageEvent{"message", seq, message, true}); err != nil {
+				log.Println(err)
+				return events
 			}
 		}
-		return true
+		return append(events, privateEvent(seq, []string{message.ReceiverID}, output))
 	})
 }
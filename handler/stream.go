@@ -0,0 +1,253 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"real-time-forum/lib"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// queueSize bounds the frames waiting for a socket, a stream or a poll,
+	// enough to hold a full replay.
+	queueSize = 2*eventLogSize + 16
+	// pollGrace is how long a user stays connected after their last poll
+	// ended, the time for the client to poll again.
+	pollGrace = 10 * time.Second
+	// streamHeartbeat keeps idle event streams open through proxies.
+	streamHeartbeat = 20 * time.Second
+	// shutdownReconnectWindow spreads over a while the reconnections of the
+	// clients of a stopping server.
+	shutdownReconnectWindow = 5 * time.Second
+)
+
+// Polls counts the users polling for events as connected.
+var Polls = NewPollPresence(pollGrace)
+
+// PollTimeout is how long a poll waits for an event before answering.
+var PollTimeout = 25 * time.Second
+
+var (
+	ErrSlowClient   = errors.New("client is not reading fast enough")
+	ErrClosedClient = errors.New("client connection is closed")
+)
+
+// queueTransport buffers the frames of a client, for its socket writer or for
+// the requests serving it over plain HTTP. It gives up on the client instead
+// of blocking the publishers when full.
+type queueTransport struct {
+	frames chan []byte
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newQueueTransport() *queueTransport {
+	return &queueTransport{
+		frames: make(chan []byte, queueSize),
+		closed: make(chan struct{}),
+	}
+}
+
+func (q *queueTransport) Send(output []byte) error {
+	select {
+	case <-q.closed:
+		return ErrClosedClient
+	default:
+	}
+	select {
+	case q.frames <- output:
+		return nil
+	default:
+		log.Println("🚨 Closing a slow client")
+		q.Close()
+		return ErrSlowClient
+	}
+}
+
+// Close stops accepting frames, the ones already queued are still delivered.
+func (q *queueTransport) Close() error {
+	q.once.Do(func() { close(q.closed) })
+	return nil
+}
+
+// pending takes the frames waiting in the queue.
+func (q *queueTransport) pending() [][]byte {
+	var frames [][]byte
+	for len(q.frames) > 0 {
+		frames = append(frames, <-q.frames)
+	}
+	return frames
+}
+
+// EventStream serves the real-time events as Server-Sent Events, for the
+// clients that cannot keep a WebSocket open. The topics are given by the
+// "topics" query parameter and a reconnection resumes from the Last-Event-ID
+// header or the "lastSeq" parameter.
+func EventStream(res http.ResponseWriter, req *http.Request) {
+	if lib.ValidateRequest(req, res, "/events", http.MethodGet) {
+		flusher, ok := res.(http.Flusher)
+		if !ok {
+			lib.HandleError(res, http.StatusInternalServerError, "Streaming unsupported")
+			return
+		}
+
+		queue := newQueueTransport()
+		client := NewClient(queue, req)
+		Connections.Register(client)
+		defer Connections.Unregister(client)
+		if err := subscribeAll(client, req); err != nil {
+			lib.HandleError(res, http.StatusForbidden, err.Error())
+			return
+		}
+
+		res.Header().Set("Content-Type", "text/event-stream")
+		res.Header().Set("Cache-Control", "no-cache")
+		res.Header().Set("Connection", "keep-alive")
+		res.WriteHeader(http.StatusOK)
+
+		if lastSeq, ok := requestedSeq(req); ok {
+			resume(client, lastSeq)
+		} else {
+			sendSync(client, "hello", Events.Seq())
+		}
+
+		if userID := client.User(); userID != "" {
+			userConnected(userID)
+			defer userDisconnected(userID, false)
+		}
+
+		write := func(output []byte) {
+			if seq := frameSeq(output); seq > 0 {
+				fmt.Fprintf(res, "id: %d\n", seq)
+			}
+			fmt.Fprintf(res, "data: %s\n\n", output)
+		}
+		heartbeat := time.NewTicker(streamHeartbeat)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case output := <-queue.frames:
+				write(output)
+				flusher.Flush()
+			case <-heartbeat.C:
+				fmt.Fprint(res, ": ping\n\n")
+				flusher.Flush()
+			case <-queue.closed:
+				for _, output := range queue.pending() {
+					write(output)
+				}
+				flusher.Flush()
+				return
+			case <-req.Context().Done():
+				return
+			}
+		}
+	}
+}
+
+// Poll serves the real-time events by long polling. It answers with the
+// events after "lastSeq" on the "topics" as soon as there is one, or with
+// none after a while, along with the sequence number to poll from next.
+func Poll(res http.ResponseWriter, req *http.Request) {
+	if lib.ValidateRequest(req, res, "/poll", http.MethodGet) {
+		queue := newQueueTransport()
+		client := NewClient(queue, req)
+		Connections.Register(client)
+		defer Connections.Unregister(client)
+		if err := subscribeAll(client, req); err != nil {
+			lib.HandleError(res, http.StatusForbidden, err.Error())
+			return
+		}
+
+		if userID := client.User(); userID != "" {
+			Polls.Begin(userID)
+			defer Polls.End(userID)
+		}
+
+		current := Events.Seq()
+		lastSeq, ok := requestedSeq(req)
+		if !ok {
+			lastSeq = current
+		}
+		subscribed := func(topic string) bool { return Connections.IsSubscribed(client, topic) }
+		missed, ok := Events.Since(client.User(), lastSeq, subscribed)
+		if !ok {
+			output, _ := json.Marshal(SyncEvent{"resync-required", current})
+			sendPoll(res, [][]byte{output}, current)
+			return
+		}
+		if len(missed) > 0 {
+			sendPoll(res, missed, current)
+			return
+		}
+
+		timeout := time.NewTimer(PollTimeout)
+		defer timeout.Stop()
+		select {
+		case output := <-queue.frames:
+			sendPoll(res, append([][]byte{output}, queue.pending()...), current)
+		case <-queue.closed:
+			sendPoll(res, queue.pending(), current)
+		case <-timeout.C:
+			sendPoll(res, nil, current)
+		case <-req.Context().Done():
+		}
+	}
+}
+
+func sendPoll(res http.ResponseWriter, frames [][]byte, seq uint64) {
+	events := make([]json.RawMessage, len(frames))
+	for i, output := range frames {
+		events[i] = output
+		if frameSeq := frameSeq(output); frameSeq > seq {
+			seq = frameSeq
+		}
+	}
+	lib.SendJSONResponse(res, http.StatusOK, map[string]any{"events": events, "seq": seq})
+}
+
+// subscribeAll subscribes the client to its own topic and to the topics of the
+// request.
+func subscribeAll(client *Client, req *http.Request) error {
+	if userID := client.User(); userID != "" {
+		Connections.Subscribe(client, UserTopic(userID))
+	}
+	for _, topic := range strings.Split(req.URL.Query().Get("topics"), ",") {
+		if topic = normalizeTopic(topic); topic == "" {
+			continue
+		}
+		if err := canSubscribe(client.User(), topic); err != nil {
+			return fmt.Errorf("%w: %s", err, topic)
+		}
+		Connections.Subscribe(client, topic)
+	}
+	return nil
+}
+
+// requestedSeq reads the sequence number the client is up to date with.
+func requestedSeq(req *http.Request) (uint64, bool) {
+	value := req.Header.Get("Last-Event-ID")
+	if value == "" {
+		value = req.URL.Query().Get("lastSeq")
+	}
+	seq, err := strconv.ParseUint(value, 10, 64)
+	return seq, err == nil
+}
+
+// frameSeq reads the sequence number of a frame, 0 for the frames without one
+// such as typing events.
+func frameSeq(output []byte) uint64 {
+	var frame struct {
+		Seq uint64 `json:"seq"`
+	}
+	if err := json.Unmarshal(output, &frame); err != nil {
+		return 0
+	}
+	return frame.Seq
+}
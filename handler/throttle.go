@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"real-time-forum/data/models"
+	"real-time-forum/lib"
+	"time"
+	"unicode/utf8"
+)
+
+// quota limits how many contents a user may create for an action within a
+// window. Accounts younger than newAccountAge get the stricter newLimit.
+type quota struct {
+	limit    int
+	newLimit int
+	window   time.Duration
+}
+
+var quotas = map[string]quota{
+	models.ActivityPost:         {limit: 10, newLimit: 3, window: time.Hour},
+	models.ActivityComment:      {limit: 10, newLimit: 3, window: time.Minute},
+	models.ActivityConversation: {limit: 20, newLimit: 5, window: 24 * time.Hour},
+}
+
+const (
+	newAccountAge = 24 * time.Hour
+	// duplicateWindow is how long a user must wait before posting the same
+	// text again. Texts shorter than duplicateMinLength may be repeated.
+	duplicateWindow    = 10 * time.Minute
+	duplicateMinLength = 20
+)
+
+// throttle checks that the user has not spent their quota for an action,
+// answering 429 otherwise. Moderators are not throttled.
+func throttle(res http.ResponseWriter, user *models.User, action string) bool {
+	if user.Can(models.PermissionModerate) {
+		return true
+	}
+	q := quotas[action]
+	limit := q.limit
+	if isNew, err := models.UserRepo.IsNewAccount(user.ID, newAccountAge); err != nil {
+		log.Println("❌ Failed to get the account age:", err)
+	} else if isNew {
+		limit = q.newLimit
+	}
+	activity, err := models.ActivityRepo.Recent(action, user.ID, q.window)
+	if err != nil {
+		log.Println("❌ Failed to count the recent activity:", err)
+		return true
+	}
+	if activity.Count < limit {
+		return true
+	}
+	retryAfter := time.Until(activity.Oldest.Add(q.window))
+	lib.HandleTooManyRequests(res, "Too many "+action+"s, retry in "+retryIn(retryAfter), retryAfter)
+	return false
+}
+
+// checkDuplicate answers 429 when the user recently posted the same text, as
+// spammers repeat their content.
+func checkDuplicate(res http.ResponseWriter, user *models.User, contentType, text string) bool {
+	if utf8.RuneCountInString(text) < duplicateMinLength || user.Can(models.PermissionModerate) {
+		return true
+	}
+	duplicates, err := models.ActivityRepo.Duplicates(contentType, user.ID, text, duplicateWindow)
+	if err != nil {
+		log.Println("❌ Failed to look for duplicates:", err)
+		return true
+	}
+	if duplicates.Count == 0 {
+		return true
+	}
+	retryAfter := time.Until(duplicates.Oldest.Add(duplicateWindow))
+	lib.HandleTooManyRequests(res, "You already posted the same "+contentType+", retry in "+retryIn(retryAfter), retryAfter)
+	return false
+}
+
+// retryIn formats a delay for the users, rounded up to the second
+func retryIn(delay time.Duration) string {
+	return (time.Duration(math.Ceil(delay.Seconds())) * time.Second).String()
+}
@@ -0,0 +1,284 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"real-time-forum/data/models"
+	"real-time-forum/lib"
+	"strconv"
+	"time"
+)
+
+const (
+	// totpIssuer names the forum in the authenticator apps
+	totpIssuer = "ThunderForum"
+	// recoveryCodeCount is the number of recovery codes handed out at once
+	recoveryCodeCount = 10
+)
+
+type twoFactorInput struct {
+	PreAuthToken string `json:"preAuthToken"`
+	Code         string `json:"code"`
+	Password     string `json:"password"`
+}
+
+// SignInTwoFactor completes a sign-in with a code of the authenticator app, or
+// a recovery code, and the pre-auth token handed out by SignIn.
+func SignInTwoFactor(res http.ResponseWriter, req *http.Request) {
+	if lib.ValidateRequest(req, res, "/sign-in/two-factor", http.MethodPost) {
+		var input twoFactorInput
+		if err := json.NewDecoder(req.Body).Decode(&input); err != nil {
+			lib.HandleError(res, http.StatusBadRequest, "Invalid JSON format")
+			return
+		}
+		if input.PreAuthToken == "" || input.Code == "" {
+			lib.HandleError(res, http.StatusBadRequest, ErrMissingRequiredFields.Error())
+			return
+		}
+
+		preAuth, ok := models.TryPreAuthToken(input.PreAuthToken)
+		if !ok {
+			lib.HandleError(res, http.StatusUnauthorized, "Sign-in expired, enter your password again")
+			return
+		}
+		// Wrong codes count as failed sign-in attempts on the account
+		ip := lib.ClientIP(req)
+		if wait := signInWait(preAuth.Account, ip); wait > 0 {
+			lib.HandleTooManyRequests(res, "Too many failed sign-in attempts, retry in "+retryIn(wait), wait)
+			return
+		}
+		user, err := models.UserRepo.GetUserByID(preAuth.UserID)
+		if err != nil || user == nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error retrieving user")
+			return
+		}
+		sanction, err := models.UserRepo.GetSanction(user.ID)
+		if err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error retrieving user")
+			return
+		}
+		if sanction != nil {
+			models.DeletePreAuthToken(input.PreAuthToken)
+			sendSanction(res, sanction)
+			return
+		}
+
+		valid, recovery, err := checkSecondFactor(user.ID, input.Code)
+		if err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error checking code : "+err.Error())
+			return
+		}
+		if !valid {
+			failSignIn(preAuth.Account, ip, user)
+			lib.HandleError(res, http.StatusUnauthorized, "Invalid code")
+			return
+		}
+		models.DeletePreAuthToken(input.PreAuthToken)
+		if recovery {
+			left, _ := models.TwoFactorRepo.RecoveryCodesLeft(user.ID)
+			sendMail(user.Email, "A recovery code was used",
+				"Hello "+user.Nickname+",\n\nA recovery code was used to sign in to your account, "+strconv.Itoa(left)+" are left.\n"+
+					"If it wasn't you, reset your password at once: "+PublicURL+"/#/password/forgot\n")
+		}
+		completeSignIn(res, user, preAuth.Account)
+	}
+}
+
+// GetTwoFactor tells the user in session whether two-factor authentication
+// is enabled, and how many recovery codes they have left
+func GetTwoFactor(res http.ResponseWriter, req *http.Request) {
+	if lib.ValidateRequest(req, res, "/two-factor", http.MethodGet) {
+		user, ok := authorize(res, req, models.PermissionAccount)
+		if !ok {
+			return
+		}
+		enabled, err := models.TwoFactorRepo.IsEnabled(user.ID)
+		if err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error retrieving two-factor authentication")
+			return
+		}
+		left := 0
+		if enabled {
+			if left, err = models.TwoFactorRepo.RecoveryCodesLeft(user.ID); err != nil {
+				lib.HandleError(res, http.StatusInternalServerError, "Error retrieving two-factor authentication")
+				return
+			}
+		}
+		lib.SendJSONResponse(res, http.StatusOK, map[string]any{"enabled": enabled, "recoveryCodesLeft": left})
+	}
+}
+
+// SetupTwoFactor generates the secret to enroll in an authenticator app. It
+// only takes effect once EnableTwoFactor confirms it with a code.
+func SetupTwoFactor(res http.ResponseWriter, req *http.Request) {
+	if lib.ValidateRequest(req, res, "/two-factor/setup", http.MethodPost) {
+		user, ok := authorize(res, req, models.PermissionAccount)
+		if !ok {
+			return
+		}
+		secret, err := lib.NewTOTPSecret()
+		if err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error generating secret")
+			return
+		}
+		stored, err := models.TwoFactorRepo.SetPending(user.ID, secret)
+		if err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error storing secret : "+err.Error())
+			return
+		}
+		if !stored {
+			lib.HandleError(res, http.StatusConflict, "Two-factor authentication is already enabled")
+			return
+		}
+		lib.SendJSONResponse(res, http.StatusOK, map[string]string{"secret": secret, "uri": lib.TOTPURI(totpIssuer, user.Nickname, secret)})
+	}
+}
+
+// EnableTwoFactor turns two-factor authentication on once a code confirms
+// that the app holds the secret, handing out the recovery codes once.
+func EnableTwoFactor(res http.ResponseWriter, req *http.Request) {
+	if lib.ValidateRequest(req, res, "/two-factor/enable", http.MethodPost) {
+		user, ok := authorize(res, req, models.PermissionAccount)
+		if !ok {
+			return
+		}
+		var input twoFactorInput
+		if err := json.NewDecoder(req.Body).Decode(&input); err != nil {
+			lib.HandleError(res, http.StatusBadRequest, "Invalid JSON format")
+			return
+		}
+		if input.Code == "" {
+			lib.HandleError(res, http.StatusBadRequest, ErrMissingRequiredFields.Error())
+			return
+		}
+
+		twoFactor, err := models.TwoFactorRepo.Get(user.ID)
+		if err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error retrieving two-factor authentication")
+			return
+		}
+		if twoFactor == nil || twoFactor.Enabled {
+			lib.HandleError(res, http.StatusConflict, "No two-factor authentication to enable, set it up first")
+			return
+		}
+		step, valid := lib.ValidateTOTP(twoFactor.Secret, input.Code, time.Now())
+		if !valid {
+			lib.HandleError(res, http.StatusBadRequest, "Invalid code, check the time of your device")
+			return
+		}
+
+		recoveryCodes, err := newRecoveryCodes()
+		if err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error generating recovery codes")
+			return
+		}
+		enabled, err := models.TwoFactorRepo.Enable(user.ID, step, recoveryCodes)
+		if err != nil || !enabled {
+			lib.HandleError(res, http.StatusInternalServerError, "Error enabling two-factor authentication")
+			return
+		}
+		sendMail(user.Email, "Two-factor authentication enabled",
+			"Hello "+user.Nickname+",\n\nSigning in to your account now asks for a code of your authenticator app.\n")
+		lib.SendJSONResponse(res, http.StatusOK, map[string]any{"message": "Two-factor authentication enabled, keep your recovery codes somewhere safe", "recoveryCodes": recoveryCodes})
+	}
+}
+
+// DisableTwoFactor turns two-factor authentication off, once the user entered
+// their password again
+func DisableTwoFactor(res http.ResponseWriter, req *http.Request) {
+	if lib.ValidateRequest(req, res, "/two-factor/disable", http.MethodPost) {
+		user, ok := authorize(res, req, models.PermissionAccount)
+		if !ok {
+			return
+		}
+		var input twoFactorInput
+		if err := json.NewDecoder(req.Body).Decode(&input); err != nil {
+			lib.HandleError(res, http.StatusBadRequest, "Invalid JSON format")
+			return
+		}
+		if input.Password == "" {
+			lib.HandleError(res, http.StatusBadRequest, ErrMissingRequiredFields.Error())
+			return
+		}
+		if !confirmPassword(res, req, user, input.Password) {
+			return
+		}
+		if err := models.TwoFactorRepo.Disable(user.ID); err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error disabling two-factor authentication : "+err.Error())
+			return
+		}
+		sendMail(user.Email, "Two-factor authentication disabled",
+			"Hello "+user.Nickname+",\n\nSigning in to your account no longer asks for a code of your authenticator app.\n"+
+				"If it wasn't you, reset your password at once: "+PublicURL+"/#/password/forgot\n")
+		lib.SendJSONResponse(res, http.StatusOK, map[string]string{"message": "Two-factor authentication disabled"})
+	}
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the user in session,
+// once they entered their password again
+func RegenerateRecoveryCodes(res http.ResponseWriter, req *http.Request) {
+	if lib.ValidateRequest(req, res, "/two-factor/recovery-codes", http.MethodPost) {
+		user, ok := authorize(res, req, models.PermissionAccount)
+		if !ok {
+			return
+		}
+		var input twoFactorInput
+		if err := json.NewDecoder(req.Body).Decode(&input); err != nil {
+			lib.HandleError(res, http.StatusBadRequest, "Invalid JSON format")
+			return
+		}
+		if input.Password == "" {
+			lib.HandleError(res, http.StatusBadRequest, ErrMissingRequiredFields.Error())
+			return
+		}
+		if !confirmPassword(res, req, user, input.Password) {
+			return
+		}
+		enabled, err := models.TwoFactorRepo.IsEnabled(user.ID)
+		if err != nil || !enabled {
+			lib.HandleError(res, http.StatusConflict, "Two-factor authentication is not enabled")
+			return
+		}
+		recoveryCodes, err := newRecoveryCodes()
+		if err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error generating recovery codes")
+			return
+		}
+		if err := models.TwoFactorRepo.ReplaceRecoveryCodes(user.ID, recoveryCodes); err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error storing recovery codes : "+err.Error())
+			return
+		}
+		lib.SendJSONResponse(res, http.StatusOK, map[string]any{"message": "New recovery codes generated, the previous ones no longer work", "recoveryCodes": recoveryCodes})
+	}
+}
+
+// checkSecondFactor checks a code of the authenticator app, each usable once,
+// or else a recovery code. It tells if a recovery code was spent.
+func checkSecondFactor(userID, code string) (bool, bool, error) {
+	twoFactor, err := models.TwoFactorRepo.Get(userID)
+	if err != nil || twoFactor == nil || !twoFactor.Enabled {
+		return false, false, err
+	}
+	if step, valid := lib.ValidateTOTP(twoFactor.Secret, code, time.Now()); valid {
+		used, err := models.TwoFactorRepo.UseStep(userID, step)
+		return used, false, err
+	}
+	used, err := models.TwoFactorRepo.UseRecoveryCode(userID, code)
+	if used {
+		log.Println("🚨 Recovery code used to sign in by", userID)
+	}
+	return used, used, err
+}
+
+func newRecoveryCodes() ([]string, error) {
+	recoveryCodes := make([]string, recoveryCodeCount)
+	for i := range recoveryCodes {
+		code, err := lib.NewRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		recoveryCodes[i] = code
+	}
+	return recoveryCodes, nil
+}
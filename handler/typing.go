@@ -0,0 +1,211 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"real-time-forum/data/models"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// typingTimeout is how long a typing indicator lasts without being renewed.
+	typingTimeout = 6 * time.Second
+	// typingThrottle is the minimum delay between two "isTyping" events
+	// relayed for the same user, whatever the conversation.
+	typingThrottle = 2 * time.Second
+	// maxTypingRecipients bounds the participants of a group conversation.
+	maxTypingRecipients = 20
+)
+
+var ErrInvalidRecipients = errors.New("invalid typing recipients")
+
+type typingKey struct {
+	client       *Client
+	conversation string
+}
+
+type typingState struct {
+	from       string
+	recipients []string
+	timer      *time.Timer
+}
+
+// typingSender throttles the typing events of a user over all their
+// conversations, so that changing the recipients doesn't bypass it.
+type typingSender struct {
+	states   int // typing indicators shown on behalf of the user
+	lastSent time.Time
+}
+
+// TypingTracker holds the typing indicators shown on behalf of each
+// connection, so that they stop on their own when the client stops renewing
+// them or disconnects. The events are sent once the tracker is unlocked.
+type TypingTracker struct {
+	mutex   sync.Mutex
+	states  map[typingKey]*typingState
+	senders map[string]*typingSender
+}
+
+func NewTypingTracker() *TypingTracker {
+	return &TypingTracker{
+		states:  make(map[typingKey]*typingState),
+		senders: make(map[string]*typingSender),
+	}
+}
+
+// Typing starts, renews or stops the typing indicator of the user of a
+// connection in the conversation with the recipients, one user or the
+// participants of a group conversation.
+func (t *TypingTracker) Typing(client *Client, from string, recipients []string, isTyping bool) error {
+	recipients = typingRecipients(from, recipients)
+	key := typingKey{client, strings.Join(recipients, ",")}
+
+	t.mutex.Lock()
+	state, exists := t.states[key]
+	if !isTyping {
+		if exists {
+			t.remove(key, state)
+		}
+		t.mutex.Unlock()
+		if exists {
+			SendTyping(from, recipients, false)
+		}
+		return nil
+	}
+	t.mutex.Unlock()
+
+	// The recipients of a new indicator are checked without holding the
+	// tracker, another connection may have started it meanwhile
+	if !exists {
+		if err := validateTypingRecipients(from, recipients); err != nil {
+			return err
+		}
+	}
+
+	t.mutex.Lock()
+	if state, exists = t.states[key]; exists {
+		state.timer.Reset(typingTimeout)
+	} else {
+		state = &typingState{from: from, recipients: recipients}
+		state.timer = time.AfterFunc(typingTimeout, func() { t.expire(key, state) })
+		t.states[key] = state
+		if t.senders[from] == nil {
+			t.senders[from] = &typingSender{}
+		}
+		t.senders[from].states++
+	}
+	sender := t.senders[from]
+	send := time.Since(sender.lastSent) >= typingThrottle
+	if send {
+		sender.lastSent = time.Now()
+	}
+	t.mutex.Unlock()
+
+	if send {
+		SendTyping(from, recipients, true)
+	}
+	return nil
+}
+
+// StopAll stops the typing indicators of a closing connection.
+func (t *TypingTracker) StopAll(client *Client) {
+	var stopped []*typingState
+	t.mutex.Lock()
+	for key, state := range t.states {
+		if key.client == client {
+			t.remove(key, state)
+			stopped = append(stopped, state)
+		}
+	}
+	t.mutex.Unlock()
+	for _, state := range stopped {
+		SendTyping(state.from, state.recipients, false)
+	}
+}
+
+// expire stops an indicator that wasn't renewed in time.
+func (t *TypingTracker) expire(key typingKey, state *typingState) {
+	t.mutex.Lock()
+	current := t.states[key] == state
+	if current {
+		t.remove(key, state)
+	}
+	t.mutex.Unlock()
+	if current {
+		SendTyping(state.from, state.recipients, false)
+	}
+}
+
+// remove forgets an indicator, the tracker being locked. The throttle of the
+// sender outlives their last indicator, so that stopping and starting again
+// doesn't bypass it.
+func (t *TypingTracker) remove(key typingKey, state *typingState) {
+	state.timer.Stop()
+	delete(t.states, key)
+	sender := t.senders[state.from]
+	if sender.states--; sender.states > 0 {
+		return
+	}
+	time.AfterFunc(typingThrottle-time.Since(sender.lastSent), func() {
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+		if sender.states == 0 && t.senders[state.from] == sender {
+			delete(t.senders, state.from)
+		}
+	})
+}
+
+// validateTypingRecipients checks that the recipients exist and that none of
+// them blocked the sender or was blocked by them.
+func validateTypingRecipients(from string, recipients []string) error {
+	if len(recipients) == 0 || len(recipients) > maxTypingRecipients {
+		return ErrInvalidRecipients
+	}
+	for _, recipient := range recipients {
+		if _, ok := models.UserRepo.IsExistedByID(recipient); !ok {
+			return ErrInvalidRecipients
+		}
+		if blocked, err := models.BlockRepo.IsBlockedBetween(from, recipient); err != nil || blocked {
+			return ErrInvalidRecipients
+		}
+	}
+	return nil
+}
+
+// typingRecipients sorts and deduplicates the recipients, without the sender.
+func typingRecipients(from string, recipients []string) []string {
+	unique := make(map[string]bool)
+	for _, recipient := range recipients {
+		if recipient != "" && recipient != from {
+			unique[recipient] = true
+		}
+	}
+	sorted := make([]string, 0, len(unique))
+	for recipient := range unique {
+		sorted = append(sorted, recipient)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// SendTyping tells each recipient that the user is typing or stopped typing.
+// The participants are only set for group conversations.
+func SendTyping(from string, recipients []string, isTyping bool) {
+	var participants []string
+	if len(recipients) > 1 {
+		participants = append([]string{from}, recipients...)
+	}
+	for _, to := range recipients {
+		data := TypingEvent{"typing", from, to, isTyping, participants}
+		output, err := json.Marshal(data)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		publishPrivate([]string{to}, output)
+	}
+}
@@ -8,11 +8,10 @@ import (
 
 func GetUser(res http.ResponseWriter, req *http.Request) {
 	if lib.ValidateRequest(req, res, "/profile", http.MethodGet) {
-		if models.ValidSession(req) {
-			user := models.GetUserFromSession(req)
-			lib.SendJSONResponse(res, http.StatusOK, map[string]any{"message": "user retrieved successfully", "user": user})
-		} else {
-			lib.HandleError(res, http.StatusUnauthorized, "No active session")
+		user, ok := authorize(res, req, models.PermissionRead)
+		if !ok {
+			return
 		}
+		lib.SendJSONResponse(res, http.StatusOK, map[string]any{"message": "user retrieved successfully", "user": user})
 	}
 }
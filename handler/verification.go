@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"real-time-forum/data/models"
+	"real-time-forum/lib"
+	"time"
+)
+
+const (
+	// verificationTTL is how long an email verification link works
+	verificationTTL = 48 * time.Hour
+	// verificationCooldown is how long to wait before resending a link
+	verificationCooldown = 5 * time.Minute
+)
+
+// VerifyEmail verifies the email of the token of a verification link
+func VerifyEmail(res http.ResponseWriter, req *http.Request) {
+	if lib.ValidateRequest(req, res, "/verify-email", http.MethodPost) {
+		var verification struct {
+			Token string `json:"token"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&verification); err != nil {
+			lib.HandleError(res, http.StatusBadRequest, "Invalid JSON format")
+			return
+		}
+		if verification.Token == "" {
+			lib.HandleError(res, http.StatusBadRequest, ErrMissingRequiredFields.Error())
+			return
+		}
+		userID, email, err := models.VerificationRepo.UseToken(verification.Token)
+		if err == models.ErrInvalidVerificationToken {
+			lib.HandleError(res, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error checking verification token : "+err.Error())
+			return
+		}
+		verified, err := models.UserRepo.VerifyEmail(userID, email)
+		if err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error verifying email : "+err.Error())
+			return
+		}
+		if !verified {
+			lib.HandleError(res, http.StatusBadRequest, "This email is no longer the one of the account")
+			return
+		}
+		lib.SendJSONResponse(res, http.StatusOK, map[string]string{"message": "Email verified successfully"})
+	}
+}
+
+// ResendVerification mails a new verification link to the user in session
+func ResendVerification(res http.ResponseWriter, req *http.Request) {
+	if lib.ValidateRequest(req, res, "/verify-email/resend", http.MethodPost) {
+		user, ok := authorize(res, req, models.PermissionRead)
+		if !ok {
+			return
+		}
+		if user.EmailVerified {
+			lib.HandleError(res, http.StatusBadRequest, "Email already verified")
+			return
+		}
+		last, err := models.VerificationRepo.LastCreated(user.ID)
+		if err != nil {
+			lib.HandleError(res, http.StatusInternalServerError, "Error checking verification : "+err.Error())
+			return
+		}
+		if wait := time.Until(last.Add(verificationCooldown)); wait > 0 {
+			lib.HandleTooManyRequests(res, "A verification link was just sent, retry in "+retryIn(wait), wait)
+			return
+		}
+		mailVerificationLink(user)
+		lib.SendJSONResponse(res, http.StatusOK, map[string]string{"message": "Verification link sent to " + user.Email})
+	}
+}
+
+// mailVerificationLink mails a link verifying the email of a user
+func mailVerificationLink(user *models.User) {
+	token, err := models.VerificationRepo.CreateToken(user.ID, user.Email, verificationTTL)
+	if err != nil {
+		log.Println("❌ Failed to create a verification token:", err)
+		return
+	}
+	sendMail(user.Email, "Verify your email",
+		"Hello "+user.Nickname+",\n\nWelcome to ThunderForum! Follow this link within 48 hours to verify your email:\n"+
+			PublicURL+"/#/verify-email/"+token+"\n\n"+
+			"If you didn't sign up, ignore this email.\n")
+}
@@ -0,0 +1,128 @@
+package lib
+
+import (
+	"fmt"
+	"net/url"
+	"sync"
+)
+
+// Broker carries the real-time events and the presence of the users between
+// the instances of the server, so that an event published on one instance
+// reaches the clients connected to any other.
+type Broker interface {
+	// NextSeq hands out the next event sequence number, unique to all instances.
+	NextSeq() (uint64, error)
+	// Publish sends a message to every instance, this one included.
+	Publish(message []byte) error
+	// Subscribe registers the function receiving the published messages, and
+	// the function called when some of them may have been lost, once the
+	// broker is reachable again.
+	Subscribe(handler func(message []byte), lost func()) error
+	// Connect counts a new connection of a user and returns how many the user
+	// has on all instances.
+	Connect(userID string) (int, error)
+	// Disconnect counts a closed connection of a user and returns how many the
+	// user still has on all instances.
+	Disconnect(userID string) (int, error)
+	// Connections returns how many connections a user has on all instances.
+	Connections(userID string) (int, error)
+	// ConnectionsOf returns how many connections each of the users has on all
+	// instances, at once. The users without any are left out.
+	ConnectionsOf(userIDs []string) (map[string]int, error)
+	Close() error
+}
+
+// NewBroker connects to the broker of the given URL, "redis://[:password@]host:port"
+// for Redis or an empty URL for a single instance.
+func NewBroker(brokerURL string) (Broker, error) {
+	if brokerURL == "" {
+		return NewMemoryBroker(), nil
+	}
+	parsed, err := url.Parse(brokerURL)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Scheme != "redis" {
+		return nil, fmt.Errorf("unsupported broker %q", parsed.Scheme)
+	}
+	password, _ := parsed.User.Password()
+	return NewRedisBroker(parsed.Host, password)
+}
+
+// MemoryBroker is the broker of a single instance. It delivers the messages
+// synchronously.
+type MemoryBroker struct {
+	mutex       sync.Mutex
+	seq         uint64
+	handlers    []func(message []byte)
+	connections map[string]int
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{connections: make(map[string]int)}
+}
+
+func (b *MemoryBroker) NextSeq() (uint64, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.seq++
+	return b.seq, nil
+}
+
+func (b *MemoryBroker) Publish(message []byte) error {
+	b.mutex.Lock()
+	handlers := b.handlers
+	b.mutex.Unlock()
+	for _, handler := range handlers {
+		handler(message)
+	}
+	return nil
+}
+
+// Subscribe registers the handler, no message being ever lost in process.
+func (b *MemoryBroker) Subscribe(handler func(message []byte), lost func()) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.handlers = append(b.handlers, handler)
+	return nil
+}
+
+func (b *MemoryBroker) Connect(userID string) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.connections[userID]++
+	return b.connections[userID], nil
+}
+
+func (b *MemoryBroker) Disconnect(userID string) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.connections[userID] <= 1 {
+		delete(b.connections, userID)
+		return 0, nil
+	}
+	b.connections[userID]--
+	return b.connections[userID], nil
+}
+
+func (b *MemoryBroker) Connections(userID string) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.connections[userID], nil
+}
+
+func (b *MemoryBroker) ConnectionsOf(userIDs []string) (map[string]int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	counts := make(map[string]int)
+	for _, userID := range userIDs {
+		if count := b.connections[userID]; count > 0 {
+			counts[userID] = count
+		}
+	}
+	return counts, nil
+}
+
+func (b *MemoryBroker) Close() error {
+	return nil
+}
@@ -1,14 +1,131 @@
 package lib
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
 
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Algorithms hashing the passwords
+const (
+	HashBcrypt   = "bcrypt"
+	HashArgon2id = "argon2id"
+)
+
+// HashConfig sets how the new passwords are hashed. The hashes made with
+// other settings still verify, and are replaced at the next sign-in.
+type HashConfig struct {
+	Algorithm     string
+	BcryptCost    int
+	Argon2Time    uint32
+	Argon2Memory  uint32 // in KiB
+	Argon2Threads uint8
+}
+
+// DefaultHashConfig follows the OWASP recommendations for the argon2id
+// parameters.
+var DefaultHashConfig = HashConfig{
+	Algorithm:     HashBcrypt,
+	BcryptCost:    bcrypt.DefaultCost,
+	Argon2Time:    2,
+	Argon2Memory:  19 * 1024,
+	Argon2Threads: 1,
+}
+
+var hashConfig = DefaultHashConfig
+
+const argon2KeyLength, argon2SaltLength = 32, 16
+
+var ErrInvalidHash = errors.New("invalid password hash")
+
+// SetHashConfig checks and applies the hashing settings.
+func SetHashConfig(config HashConfig) error {
+	switch config.Algorithm {
+	case HashBcrypt:
+		if config.BcryptCost < bcrypt.MinCost || config.BcryptCost > bcrypt.MaxCost {
+			return fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	case HashArgon2id:
+		if config.Argon2Time < 1 || config.Argon2Memory < 8*uint32(config.Argon2Threads) || config.Argon2Threads < 1 {
+			return errors.New("invalid argon2id parameters")
+		}
+	default:
+		return errors.New("unknown password hashing algorithm: " + config.Algorithm)
+	}
+	hashConfig = config
+	return nil
+}
+
+// HashPassword hashes a password with the configured algorithm.
 func HashPassword(pwd string) (string, error) {
-	var pwdBytes = []byte(pwd)
-	hashedPwd, err := bcrypt.GenerateFromPassword(pwdBytes, bcrypt.MinCost)
+	if hashConfig.Algorithm == HashArgon2id {
+		salt := make([]byte, argon2SaltLength)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(pwd), salt, hashConfig.Argon2Time, hashConfig.Argon2Memory, hashConfig.Argon2Threads, argon2KeyLength)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version,
+			hashConfig.Argon2Memory, hashConfig.Argon2Time, hashConfig.Argon2Threads,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+	}
+	hashedPwd, err := bcrypt.GenerateFromPassword([]byte(pwd), hashConfig.BcryptCost)
 	return string(hashedPwd), err
 }
 
-func IsPasswordsMatch(hashedPwd, currentPwd string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hashedPwd), []byte(currentPwd))
+// CheckPasswordHash checks if the given plain text password matches the hashed password.
+func CheckPasswordHash(password, hashedPassword string) bool {
+	if strings.HasPrefix(hashedPassword, "$argon2id$") {
+		params, salt, key, err := decodeArgon2id(hashedPassword)
+		if err != nil {
+			return false
+		}
+		other := argon2.IDKey([]byte(password), salt, params.Argon2Time, params.Argon2Memory, params.Argon2Threads, uint32(len(key)))
+		return subtle.ConstantTimeCompare(key, other) == 1
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
 	return err == nil
 }
+
+// NeedsRehash checks if a hash was made with another algorithm or weaker
+// settings than the configured ones.
+func NeedsRehash(hashedPassword string) bool {
+	if hashConfig.Algorithm == HashArgon2id {
+		params, _, _, err := decodeArgon2id(hashedPassword)
+		return err != nil || params.Argon2Time != hashConfig.Argon2Time ||
+			params.Argon2Memory != hashConfig.Argon2Memory || params.Argon2Threads != hashConfig.Argon2Threads
+	}
+	cost, err := bcrypt.Cost([]byte(hashedPassword))
+	return err != nil || cost != hashConfig.BcryptCost
+}
+
+// decodeArgon2id reads a hash in the PHC string format.
+func decodeArgon2id(hashedPassword string) (HashConfig, []byte, []byte, error) {
+	var params HashConfig
+	var version int
+	parts := strings.Split(hashedPassword, "$")
+	if len(parts) != 6 || parts[1] != HashArgon2id {
+		return params, nil, nil, ErrInvalidHash
+	}
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, ErrInvalidHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Argon2Memory, &params.Argon2Time, &params.Argon2Threads); err != nil {
+		return params, nil, nil, ErrInvalidHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrInvalidHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, ErrInvalidHash
+	}
+	params.Algorithm = HashArgon2id
+	return params, salt, key, nil
+}
@@ -0,0 +1,116 @@
+package lib
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"mime"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Mail is a plain text email.
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends the emails of the forum.
+type Mailer interface {
+	Send(mail Mail) error
+}
+
+// NewMailer creates the mailer of the given URL, sending from the given
+// address, with or without a display name: "smtp://[user:password@]host:port"
+// for an SMTP server, "file:///path/to/dir" to write each mail to a file, or
+// an empty URL to log them.
+func NewMailer(mailerURL, from string) (Mailer, error) {
+	sender, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender %q: %w", from, err)
+	}
+	from = sender.String()
+	if mailerURL == "" {
+		return &LogMailer{From: from}, nil
+	}
+	parsed, err := url.Parse(mailerURL)
+	if err != nil {
+		return nil, err
+	}
+	switch parsed.Scheme {
+	case "smtp":
+		mailer := &SMTPMailer{Address: parsed.Host, From: from, Envelope: sender.Address}
+		if parsed.User != nil {
+			password, _ := parsed.User.Password()
+			mailer.Auth = smtp.PlainAuth("", parsed.User.Username(), password, parsed.Hostname())
+		}
+		return mailer, nil
+	case "file":
+		if err := os.MkdirAll(parsed.Path, 0o750); err != nil {
+			return nil, err
+		}
+		return &FileMailer{Dir: parsed.Path, From: from}, nil
+	}
+	return nil, fmt.Errorf("unsupported mailer %q", parsed.Scheme)
+}
+
+// headerBreaks are removed from the header values, which may come from the users
+var headerBreaks = strings.NewReplacer("\r", "", "\n", "")
+
+// Message formats a mail as an RFC 5322 message.
+func (m Mail) Message(from string) []byte {
+	var message strings.Builder
+	message.WriteString("From: " + headerBreaks.Replace(from) + "\r\n")
+	message.WriteString("To: " + headerBreaks.Replace(m.To) + "\r\n")
+	message.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", m.Subject) + "\r\n")
+	message.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	message.WriteString("MIME-Version: 1.0\r\n")
+	message.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	message.WriteString(strings.ReplaceAll(m.Body, "\n", "\r\n"))
+	return []byte(message.String())
+}
+
+// SMTPMailer sends the mails through an SMTP server, with STARTTLS when the
+// server offers it.
+type SMTPMailer struct {
+	Address  string
+	Auth     smtp.Auth
+	From     string // the From header
+	Envelope string // the bare address of From, the sender of the envelope
+}
+
+func (m *SMTPMailer) Send(mail Mail) error {
+	return smtp.SendMail(m.Address, m.Auth, m.Envelope, []string{mail.To}, mail.Message(m.From))
+}
+
+// FileMailer writes each mail to an .eml file of a directory.
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+func (m *FileMailer) Send(mail Mail) error {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	name := time.Now().Format("20060102-150405") + "-" + hex.EncodeToString(suffix) + ".eml"
+	return os.WriteFile(filepath.Join(m.Dir, name), mail.Message(m.From), 0o640)
+}
+
+// LogMailer logs the mails instead of sending them, for the development.
+type LogMailer struct {
+	From string
+}
+
+func (m *LogMailer) Send(mail Mail) error {
+	log.Printf("✉️ Mail to %s: %s\n%s", mail.To, mail.Subject, mail.Body)
+	return nil
+}
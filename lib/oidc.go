@@ -0,0 +1,339 @@
+package lib
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// oidcLeeway tolerates the clock drift between the forum and a provider
+	oidcLeeway = time.Minute
+	// oidcKeysRefresh is the least time between two fetches of the keys, as
+	// an unknown key ID triggers one
+	oidcKeysRefresh = time.Minute
+)
+
+var ErrInvalidIDToken = errors.New("invalid ID token")
+
+// OIDCConfig describes an OpenID Connect provider the users may sign in with
+type OIDCConfig struct {
+	Name         string // in the routes, e.g. /oidc/login/{name}
+	Label        string // shown on the login page
+	Issuer       string
+	ClientID     string
+	ClientSecret string // empty for a public client
+	RedirectURL  string
+	Scopes       []string
+}
+
+// IDTokenClaims are the claims of an ID token the forum uses
+type IDTokenClaims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          audience `json:"aud"`
+	AuthorizedParty   string   `json:"azp"`
+	Expiry            int64    `json:"exp"`
+	IssuedAt          int64    `json:"iat"`
+	Nonce             string   `json:"nonce"`
+	Email             string   `json:"email"`
+	EmailVerified     oidcBool `json:"email_verified"`
+	PreferredUsername string   `json:"preferred_username"`
+	Name              string   `json:"name"`
+	GivenName         string   `json:"given_name"`
+	FamilyName        string   `json:"family_name"`
+	Picture           string   `json:"picture"`
+}
+
+// audience is a single audience or a list of them
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if json.Unmarshal(data, &single) == nil {
+		*a = audience{single}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(a))
+}
+
+// oidcBool is a boolean some providers send as a string
+type oidcBool bool
+
+func (b *oidcBool) UnmarshalJSON(data []byte) error {
+	*b = oidcBool(strings.Trim(string(data), `"`) == "true")
+	return nil
+}
+
+// oidcMetadata is the part of the discovery document the forum uses
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// OIDCProvider signs users in with the authorization code flow and PKCE. It
+// discovers the endpoints of the provider on first use, and caches its keys.
+type OIDCProvider struct {
+	OIDCConfig
+	client *http.Client
+
+	mutex       sync.Mutex
+	metadata    *oidcMetadata
+	keys        map[string]crypto.PublicKey
+	keysFetched time.Time
+}
+
+func NewOIDCProvider(config OIDCConfig) (*OIDCProvider, error) {
+	if config.Name == "" || config.Issuer == "" || config.ClientID == "" || config.RedirectURL == "" {
+		return nil, errors.New("an OIDC provider needs a name, an issuer, a client ID and a redirect URL")
+	}
+	if config.Label == "" {
+		config.Label = config.Name
+	}
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email", "profile"}
+	}
+	config.Issuer = strings.TrimSuffix(config.Issuer, "/")
+	return &OIDCProvider{
+		OIDCConfig: config,
+		client:     &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// NewPKCE returns a PKCE code verifier and its S256 challenge
+func NewPKCE() (string, string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", "", err
+	}
+	verifier := base64.RawURLEncoding.EncodeToString(bytes)
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// AuthCodeURL returns the address of the provider to send the user to
+func (p *OIDCProvider) AuthCodeURL(state, nonce, codeChallenge string) (string, error) {
+	metadata, err := p.discover()
+	if err != nil {
+		return "", err
+	}
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", p.ClientID)
+	query.Set("redirect_uri", p.RedirectURL)
+	query.Set("scope", strings.Join(p.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", codeChallenge)
+	query.Set("code_challenge_method", "S256")
+	separator := "?"
+	if strings.Contains(metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return metadata.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange trades an authorization code for the verified claims of the ID
+// token it returns
+func (p *OIDCProvider) Exchange(code, codeVerifier, nonce string) (*IDTokenClaims, error) {
+	metadata, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("code_verifier", codeVerifier)
+	if p.ClientSecret == "" {
+		form.Set("client_id", p.ClientID)
+	}
+	req, err := http.NewRequest(http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	var token struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := p.getJSON(req, &token); err != nil && token.Error == "" {
+		return nil, err
+	}
+	if token.Error != "" {
+		return nil, fmt.Errorf("token endpoint: %s %s", token.Error, token.ErrorDescription)
+	}
+	if token.IDToken == "" {
+		return nil, errors.New("token endpoint returned no ID token")
+	}
+	return p.VerifyIDToken(token.IDToken, nonce)
+}
+
+// VerifyIDToken checks the signature, the issuer, the audience, the expiry
+// and the nonce of an ID token, returning its claims
+func (p *OIDCProvider) VerifyIDToken(raw, nonce string) (*IDTokenClaims, error) {
+	metadata, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+	header, payload, signed, signature, err := splitJWT(raw)
+	if err != nil {
+		return nil, err
+	}
+	key, err := p.publicKey(header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWTSignature(header.Algorithm, key, signed, signature); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	var claims IDTokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+	now := time.Now()
+	switch {
+	case claims.Issuer != metadata.Issuer: // verbatim, trailing slash included
+		return nil, fmt.Errorf("%w: unexpected issuer %s", ErrInvalidIDToken, claims.Issuer)
+	case !claims.Audience.contains(p.ClientID):
+		return nil, fmt.Errorf("%w: not issued for this client", ErrInvalidIDToken)
+	case len(claims.Audience) > 1 && claims.AuthorizedParty != p.ClientID:
+		return nil, fmt.Errorf("%w: not authorized for this client", ErrInvalidIDToken)
+	case claims.Expiry == 0 || now.After(time.Unix(claims.Expiry, 0).Add(oidcLeeway)):
+		return nil, fmt.Errorf("%w: expired", ErrInvalidIDToken)
+	case claims.IssuedAt != 0 && now.Add(oidcLeeway).Before(time.Unix(claims.IssuedAt, 0)):
+		return nil, fmt.Errorf("%w: issued in the future", ErrInvalidIDToken)
+	case claims.Nonce != nonce:
+		return nil, fmt.Errorf("%w: unexpected nonce", ErrInvalidIDToken)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidIDToken)
+	}
+	return &claims, nil
+}
+
+func (a audience) contains(clientID string) bool {
+	for _, aud := range a {
+		if aud == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// discover fetches the discovery document once, trying again on the next use
+// when it fails
+func (p *OIDCProvider) discover() (*oidcMetadata, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+	req, err := http.NewRequest(http.MethodGet, p.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	var metadata oidcMetadata
+	if err := p.getJSON(req, &metadata); err != nil {
+		return nil, fmt.Errorf("discovery of %s: %w", p.Issuer, err)
+	}
+	if strings.TrimSuffix(metadata.Issuer, "/") != p.Issuer {
+		return nil, fmt.Errorf("discovery of %s: the document is for %s", p.Issuer, metadata.Issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, fmt.Errorf("discovery of %s: missing endpoints", p.Issuer)
+	}
+	p.metadata = &metadata
+	return p.metadata, nil
+}
+
+// publicKey returns a signing key of the provider, fetching the keys again
+// when the ID is unknown, as the provider may have rotated them
+func (p *OIDCProvider) publicKey(keyID string) (crypto.PublicKey, error) {
+	metadata, err := p.discover()
+	if err != nil {
+		return nil, err
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if key, ok := p.lookupKey(keyID); ok {
+		return key, nil
+	}
+	if time.Since(p.keysFetched) < oidcKeysRefresh {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidIDToken, keyID)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, metadata.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := p.getJSON(req, &set); err != nil {
+		return nil, fmt.Errorf("keys of %s: %w", p.Issuer, err)
+	}
+	p.keys = make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if key, err := k.publicKey(); err == nil {
+			p.keys[k.KeyID] = key
+		}
+	}
+	p.keysFetched = time.Now()
+	if key, ok := p.lookupKey(keyID); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidIDToken, keyID)
+}
+
+// lookupKey finds a cached key. A token without key ID is only accepted
+// when the provider has a single key.
+func (p *OIDCProvider) lookupKey(keyID string) (crypto.PublicKey, bool) {
+	if keyID == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, ok := p.keys[keyID]
+	return key, ok
+}
+
+// getJSON sends a request to the provider and decodes its JSON answer, also
+// on error as the token endpoint explains its errors in the body
+func (p *OIDCProvider) getJSON(req *http.Request, v interface{}) error {
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	decodeErr := json.Unmarshal(body, v)
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %s", req.URL.Host, res.Status)
+	}
+	return decodeErr
+}
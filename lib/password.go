@@ -0,0 +1,77 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Character classes a password policy may require
+const (
+	PasswordLower  = "lower"
+	PasswordUpper  = "upper"
+	PasswordDigit  = "digit"
+	PasswordSymbol = "symbol"
+)
+
+// maxPasswordBytes is the length bcrypt is limited to, kept whatever the
+// algorithm so that it can be switched back.
+const maxPasswordBytes = 72
+
+// PasswordPolicy sets the rules the new passwords must follow.
+type PasswordPolicy struct {
+	MinLength int
+	Require   []string // character classes that must appear
+}
+
+// DefaultPasswordPolicy only asks for a length
+var DefaultPasswordPolicy = PasswordPolicy{MinLength: 8}
+
+var passwordPolicy = DefaultPasswordPolicy
+
+var passwordClasses = map[string]func(rune) bool{
+	PasswordLower:  unicode.IsLower,
+	PasswordUpper:  unicode.IsUpper,
+	PasswordDigit:  unicode.IsDigit,
+	PasswordSymbol: func(r rune) bool { return unicode.IsPunct(r) || unicode.IsSymbol(r) },
+}
+
+// SetPasswordPolicy checks and applies the password rules.
+func SetPasswordPolicy(policy PasswordPolicy) error {
+	if policy.MinLength < 1 || policy.MinLength > maxPasswordBytes {
+		return fmt.Errorf("the minimum password length must be between 1 and %d", maxPasswordBytes)
+	}
+	for i, class := range policy.Require {
+		class = strings.ToLower(strings.TrimSpace(class))
+		policy.Require[i] = class
+		if _, ok := passwordClasses[class]; !ok {
+			return errors.New("unknown character class: " + class)
+		}
+	}
+	passwordPolicy = policy
+	return nil
+}
+
+// CheckPassword tells why a password breaks the policy, nil if it doesn't.
+func CheckPassword(password string) error {
+	var problems []string
+	if len([]rune(password)) < passwordPolicy.MinLength {
+		problems = append(problems, fmt.Sprintf("be at least %d characters long", passwordPolicy.MinLength))
+	}
+	if len(password) > maxPasswordBytes {
+		problems = append(problems, fmt.Sprintf("be at most %d bytes long", maxPasswordBytes))
+	}
+	if strings.TrimSpace(password) == "" {
+		problems = append(problems, "not be only spaces")
+	}
+	for _, class := range passwordPolicy.Require {
+		if strings.IndexFunc(password, passwordClasses[class]) < 0 {
+			problems = append(problems, "contain a "+class+" character")
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New("password must " + strings.Join(problems, ", "))
+	}
+	return nil
+}
@@ -1,54 +1,122 @@
 package lib
 
 import (
+	"log"
+	"math"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 )
 
+// Limit is the budget of a class of routes: a client may send Requests
+// requests at once, and the budget refills evenly over Per.
+type Limit struct {
+	Requests int
+	Per      time.Duration
+	// ByUser counts the requests of a signed in user together, whatever
+	// their IP, instead of by IP
+	ByUser bool
+}
+
+// bucket holds the tokens left to a client for a class of routes
+type bucket struct {
+	tokens float64
+	last   time.Time
+	per    time.Duration
+}
+
+// RateLimiter limits the requests of each client with a token bucket per
+// class of routes, so that the classes don't share their budget.
 type RateLimiter struct {
-	WindowDuration time.Duration
-	Limits         map[string]int
-	Requests       map[string][]time.Time
-	Mutex          sync.Mutex
+	limits  map[string]Limit
+	buckets map[string]*bucket
+	mutex   sync.Mutex
+	// UserID identifies the signed in user of a request for the ByUser
+	// limits, an empty string meaning an anonymous request
+	UserID func(*http.Request) string
 }
 
-func NewRateLimiter(window time.Duration) *RateLimiter {
+func NewRateLimiter(limits map[string]Limit) *RateLimiter {
 	return &RateLimiter{
-		WindowDuration: window,
-		Limits: map[string]int{
-			"api":  3000, // 300 requests per minute for API endpoints
-			"auth": 100,  // 100 requests per minute for authentication endpoints
-		},
-		Requests: make(map[string][]time.Time),
+		limits:  limits,
+		buckets: make(map[string]*bucket),
 	}
 }
 
-func (rl *RateLimiter) Wrap(limitType string, next http.HandlerFunc) http.HandlerFunc {
+// Wrap limits the requests of a handler with the budget of a class of routes
+func (rl *RateLimiter) Wrap(class string, next http.HandlerFunc) http.HandlerFunc {
+	limit, ok := rl.limits[class]
+	if !ok {
+		log.Fatal("❌ Unknown rate limit class: ", class)
+	}
 	return func(w http.ResponseWriter, r *http.Request) {
-		remoteIP := r.RemoteAddr
-
-		rl.Mutex.Lock()
-		defer rl.Mutex.Unlock()
-
-		// Clear expired request records
-		now := time.Now()
-		validRequests := rl.Requests[remoteIP][:0]
-		for _, t := range rl.Requests[remoteIP] {
-			if now.Sub(t) <= rl.WindowDuration {
-				validRequests = append(validRequests, t)
+		key := class + "|ip:" + ClientIP(r)
+		if limit.ByUser && rl.UserID != nil {
+			if userID := rl.UserID(r); userID != "" {
+				key = class + "|user:" + userID
 			}
 		}
-		rl.Requests[remoteIP] = validRequests
 
-		limit := rl.Limits[limitType]
-		if len(rl.Requests[remoteIP]) >= limit {
-			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+		allowed, remaining, reset := rl.take(key, limit)
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(limit.Requests))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(reset.Seconds()))))
+		if !allowed {
+			HandleTooManyRequests(w, "Rate limit exceeded", reset)
 			return
 		}
+		next(w, r)
+	}
+}
 
-		rl.Requests[remoteIP] = append(rl.Requests[remoteIP], time.Now())
+// take spends a token of the bucket of a client. It returns whether the
+// request is allowed, the number of tokens left, and the time until the next
+// token when none is left or until the bucket is full otherwise.
+func (rl *RateLimiter) take(key string, limit Limit) (bool, int, time.Duration) {
+	now := time.Now()
+	capacity := float64(limit.Requests)
+	interval := limit.Per / time.Duration(limit.Requests) // time to refill a token
 
-		next(w, r)
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	b, ok := rl.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, last: now, per: limit.Per}
+		rl.buckets[key] = b
+	}
+	b.tokens = math.Min(capacity, b.tokens+float64(now.Sub(b.last))/float64(interval))
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, 0, time.Duration((1 - b.tokens) * float64(interval))
+	}
+	b.tokens--
+	return true, int(b.tokens), time.Duration((capacity - b.tokens) * float64(interval))
+}
+
+// EvictIdle regularly forgets the clients whose bucket refilled, as they are
+// back to a fresh bucket anyway, until stop is closed.
+func (rl *RateLimiter) EvictIdle(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			rl.evict(time.Now())
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (rl *RateLimiter) evict(now time.Time) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+	for key, b := range rl.buckets {
+		if now.Sub(b.last) >= b.per {
+			delete(rl.buckets, key)
+		}
 	}
 }
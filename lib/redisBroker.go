@@ -0,0 +1,395 @@
+package lib
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	redisChannel      = "real-time-forum:events"
+	redisSeqKey       = "real-time-forum:seq"
+	redisInstancesKey = "real-time-forum:instances"
+	redisPresenceKey  = "real-time-forum:presence:" // followed by the instance ID
+	// presenceTTL is how long the connections counted by an instance outlive
+	// its last heartbeat, when it stopped without releasing them.
+	presenceTTL       = 30 * time.Second
+	presenceHeartbeat = presenceTTL / 3
+	// redisTimeout bounds a dial and the round trip of a command by default
+	redisTimeout = 5 * time.Second
+)
+
+var ErrRedisClosed = errors.New("redis broker closed")
+
+// RedisBroker shares the events and the presence between the instances
+// through a Redis server (or anything speaking its protocol): the events go
+// through a pub/sub channel, the sequence numbers through a counter and the
+// connections of the users through a hash per instance. The hash of an
+// instance expires unless renewed by its heartbeat, and the instances are
+// listed with the deadline of their heartbeat, so that the users of a crashed
+// instance don't stay online.
+type RedisBroker struct {
+	address  string
+	password string
+	id       string // the instance
+	// Timeout bounds each command, a server not answering in time being
+	// dropped like a lost connection. It is redisTimeout by default.
+	Timeout time.Duration
+
+	mutex  sync.Mutex // guards the command connection
+	conn   *redisConn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func NewRedisBroker(address, password string) (*RedisBroker, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	b := &RedisBroker{
+		address:  address,
+		password: password,
+		id:       hex.EncodeToString(id),
+		Timeout:  redisTimeout,
+		closed:   make(chan struct{}),
+	}
+	conn, err := b.dial()
+	if err != nil {
+		return nil, err
+	}
+	b.conn = conn
+	if err := b.heartbeat(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go b.beat()
+	return b, nil
+}
+
+// beat renews the presence of the instance until the broker is closed.
+func (b *RedisBroker) beat() {
+	ticker := time.NewTicker(presenceHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.closed:
+			return
+		case <-ticker.C:
+			if err := b.heartbeat(); err != nil {
+				log.Println("❌ Failed to renew the presence of the instance:", err)
+			}
+		}
+	}
+}
+
+// heartbeat renews the deadline of the instance and of its connections,
+// forgetting the instances past their deadline.
+func (b *RedisBroker) heartbeat() error {
+	now := time.Now()
+	deadline := strconv.FormatInt(now.Add(presenceTTL).Unix(), 10)
+	if _, err := b.do("ZADD", redisInstancesKey, deadline, b.id); err != nil {
+		return err
+	}
+	if _, err := b.do("EXPIRE", redisPresenceKey+b.id, strconv.Itoa(int(presenceTTL.Seconds()))); err != nil {
+		return err
+	}
+	_, err := b.do("ZREMRANGEBYSCORE", redisInstancesKey, "-inf", "("+strconv.FormatInt(now.Unix(), 10))
+	return err
+}
+
+func (b *RedisBroker) dial() (*redisConn, error) {
+	netConn, err := net.DialTimeout("tcp", b.address, b.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{netConn, bufio.NewReader(netConn)}
+	conn.SetDeadline(time.Now().Add(b.Timeout)) // for the commands setting it up
+	if b.password != "" {
+		if _, err := conn.do("AUTH", b.password); err != nil {
+			netConn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// do runs a command, reconnecting once if the connection was lost. A command
+// that timed out drops the connection without being retried, as it may have
+// run: the next command reconnects.
+func (b *RedisBroker) do(args ...string) (interface{}, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	select {
+	case <-b.closed:
+		return nil, ErrRedisClosed
+	default:
+	}
+	if b.conn != nil {
+		b.conn.SetDeadline(time.Now().Add(b.Timeout))
+		reply, err := b.conn.do(args...)
+		if _, ok := err.(redisError); ok || err == nil {
+			return reply, err
+		}
+		b.conn.Close()
+		b.conn = nil
+		if isTimeout(err) {
+			return nil, err
+		}
+	}
+	conn, err := b.dial()
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(b.Timeout))
+	reply, err := conn.do(args...)
+	if _, ok := err.(redisError); ok || err == nil {
+		b.conn = conn
+	} else {
+		conn.Close()
+	}
+	return reply, err
+}
+
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func (b *RedisBroker) NextSeq() (uint64, error) {
+	reply, err := b.do("INCR", redisSeqKey)
+	if err != nil {
+		return 0, err
+	}
+	seq, _ := reply.(int64)
+	return uint64(seq), nil
+}
+
+func (b *RedisBroker) Publish(message []byte) error {
+	_, err := b.do("PUBLISH", redisChannel, string(message))
+	return err
+}
+
+// Subscribe listens to the channel on a dedicated connection, reconnecting
+// until the broker is closed. The messages published while disconnected are
+// lost, which lost tells once subscribed again.
+func (b *RedisBroker) Subscribe(handler func(message []byte), lost func()) error {
+	conn, err := b.subscribe()
+	if err != nil {
+		return err
+	}
+	go func() {
+		delay := time.Second
+		for {
+			err := b.listen(conn, handler)
+			select {
+			case <-b.closed:
+				return
+			default:
+			}
+			log.Println("❌ Lost the Redis subscription:", err)
+			for {
+				time.Sleep(delay)
+				if conn, err = b.subscribe(); err == nil {
+					delay = time.Second
+					log.Println("✅ Subscribed to Redis again")
+					lost()
+					break
+				}
+				if delay < 30*time.Second {
+					delay *= 2
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func (b *RedisBroker) subscribe() (*redisConn, error) {
+	conn, err := b.dial()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.do("SUBSCRIBE", redisChannel); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go func() {
+		<-b.closed
+		conn.Close()
+	}()
+	return conn, nil
+}
+
+func (b *RedisBroker) listen(conn *redisConn, handler func(message []byte)) error {
+	defer conn.Close()
+	conn.SetDeadline(time.Time{}) // the channel may stay quiet for long
+	for {
+		reply, err := conn.read()
+		if err != nil {
+			return err
+		}
+		push, ok := reply.([]interface{})
+		if !ok || len(push) != 3 || push[0] != "message" {
+			continue
+		}
+		if message, ok := push[2].(string); ok {
+			handler([]byte(message))
+		}
+	}
+}
+
+// Connect counts a connection in the hash of the instance, renewing its
+// deadline, and returns the connections of the user on all the live instances.
+func (b *RedisBroker) Connect(userID string) (int, error) {
+	if _, err := b.do("HINCRBY", redisPresenceKey+b.id, userID, "1"); err != nil {
+		return 0, err
+	}
+	if err := b.heartbeat(); err != nil {
+		return 0, err
+	}
+	return b.Connections(userID)
+}
+
+func (b *RedisBroker) Disconnect(userID string) (int, error) {
+	reply, err := b.do("HINCRBY", redisPresenceKey+b.id, userID, "-1")
+	if err != nil {
+		return 0, err
+	}
+	if count, _ := reply.(int64); count <= 0 {
+		if _, err := b.do("HDEL", redisPresenceKey+b.id, userID); err != nil {
+			return 0, err
+		}
+	}
+	return b.Connections(userID)
+}
+
+// Connections sums the connections of the user on the instances whose
+// heartbeat is not past its deadline.
+func (b *RedisBroker) Connections(userID string) (int, error) {
+	counts, err := b.ConnectionsOf([]string{userID})
+	return counts[userID], err
+}
+
+// ConnectionsOf sums the connections of the users on the live instances with
+// one HMGET per instance.
+func (b *RedisBroker) ConnectionsOf(userIDs []string) (map[string]int, error) {
+	counts := make(map[string]int)
+	if len(userIDs) == 0 {
+		return counts, nil
+	}
+	reply, err := b.do("ZRANGEBYSCORE", redisInstancesKey, strconv.FormatInt(time.Now().Unix(), 10), "+inf")
+	if err != nil {
+		return nil, err
+	}
+	instances, _ := reply.([]interface{})
+	for _, instance := range instances {
+		id, _ := instance.(string)
+		reply, err := b.do(append([]string{"HMGET", redisPresenceKey + id}, userIDs...)...)
+		if err != nil {
+			return nil, err
+		}
+		values, _ := reply.([]interface{})
+		for i, value := range values {
+			if value, ok := value.(string); ok && i < len(userIDs) {
+				if count, _ := strconv.Atoi(value); count > 0 {
+					counts[userIDs[i]] += count
+				}
+			}
+		}
+	}
+	return counts, nil
+}
+
+// Close releases the connections this instance counted, so that its users do
+// not stay online, and stops the subscription.
+func (b *RedisBroker) Close() error {
+	if _, err := b.do("DEL", redisPresenceKey+b.id); err != nil {
+		log.Println("❌ Failed to release the presence of the instance:", err)
+	}
+	if _, err := b.do("ZREM", redisInstancesKey, b.id); err != nil {
+		log.Println("❌ Failed to release the presence of the instance:", err)
+	}
+
+	b.once.Do(func() { close(b.closed) })
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.conn != nil {
+		return b.conn.Close()
+	}
+	return nil
+}
+
+// redisError is an error answered by the server.
+type redisError string
+
+func (e redisError) Error() string { return string(e) }
+
+// redisConn speaks the Redis serialization protocol (RESP).
+type redisConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *redisConn) do(args ...string) (interface{}, error) {
+	command := fmt.Sprintf("*%d\r\n", len(args))
+	for _, arg := range args {
+		command += fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(c, command); err != nil {
+		return nil, err
+	}
+	return c.read()
+}
+
+// read parses a reply: simple strings and bulk strings become strings, nil
+// bulk strings nil, integers int64 and arrays []interface{}.
+func (c *redisConn) read() (interface{}, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 {
+		return nil, fmt.Errorf("malformed reply %q", line)
+	}
+	kind, value := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return value, nil
+	case '-':
+		return nil, redisError(value)
+	case ':':
+		return strconv.ParseInt(value, 10, 64)
+	case '$':
+		size, err := strconv.Atoi(value)
+		if err != nil || size < 0 {
+			return nil, err
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(c.reader, data); err != nil {
+			return nil, err
+		}
+		return string(data[:size]), nil
+	case '*':
+		size, err := strconv.Atoi(value)
+		if err != nil || size < 0 {
+			return nil, err
+		}
+		array := make([]interface{}, size)
+		for i := range array {
+			if array[i], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+		return array, nil
+	}
+	return nil, fmt.Errorf("unknown reply %q", line)
+}
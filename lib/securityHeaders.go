@@ -0,0 +1,142 @@
+package lib
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NoncePlaceholder stands in a Content-Security-Policy for the nonce drawn
+// for each response, e.g. "script-src 'self' 'nonce-{nonce}'"
+const NoncePlaceholder = "{nonce}"
+
+// HeaderPolicy is the set of security headers of a class of routes. An empty
+// field leaves its header out.
+type HeaderPolicy struct {
+	ContentSecurityPolicy string
+	FrameOptions          string
+	ReferrerPolicy        string
+	PermissionsPolicy     string
+}
+
+// SecurityHeaders adds the security headers to the responses, with the
+// policy of a class of routes, like the RateLimiter. Every response is also
+// sent with X-Content-Type-Options.
+type SecurityHeaders struct {
+	policies map[string]HeaderPolicy
+	// HSTSMaxAge is how long browsers keep to HTTPS once they reached the
+	// forum over it, zero sending no Strict-Transport-Security
+	HSTSMaxAge time.Duration
+}
+
+type nonceKey struct{}
+
+// DefaultHeaderClass is the class of the routes without a class of their own
+const DefaultHeaderClass = "default"
+
+func NewSecurityHeaders(policies map[string]HeaderPolicy) *SecurityHeaders {
+	if _, ok := policies[DefaultHeaderClass]; !ok {
+		log.Fatal("❌ Missing the default security headers")
+	}
+	return &SecurityHeaders{policies: policies}
+}
+
+// Handler adds the headers of the default class to all the responses of a
+// handler, the routes wrapped with another class replacing them
+func (sh *SecurityHeaders) Handler(next http.Handler) http.Handler {
+	return sh.Wrap(DefaultHeaderClass, next)
+}
+
+// Wrap adds the headers of a class of routes to the responses of a handler.
+// When the policy needs a nonce, it is passed on in the request, see CSPNonce.
+func (sh *SecurityHeaders) Wrap(class string, next http.Handler) http.Handler {
+	policy, ok := sh.policies[class]
+	if !ok {
+		log.Fatal("❌ Unknown security headers class: ", class)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := w.Header()
+		header.Set("X-Content-Type-Options", "nosniff")
+		header.Del("Content-Security-Policy") // set by the default class
+		if csp := policy.ContentSecurityPolicy; csp != "" {
+			if strings.Contains(csp, NoncePlaceholder) {
+				nonce, err := newNonce()
+				if err != nil {
+					HandleError(w, http.StatusInternalServerError, "Error drawing a nonce")
+					return
+				}
+				csp = strings.ReplaceAll(csp, NoncePlaceholder, nonce)
+				r = r.WithContext(context.WithValue(r.Context(), nonceKey{}, nonce))
+			}
+			header.Set("Content-Security-Policy", csp)
+		}
+		setOrDelete(header, "X-Frame-Options", policy.FrameOptions)
+		setOrDelete(header, "Referrer-Policy", policy.ReferrerPolicy)
+		setOrDelete(header, "Permissions-Policy", policy.PermissionsPolicy)
+		if sh.HSTSMaxAge > 0 && IsSecureRequest(r) {
+			header.Set("Strict-Transport-Security", "max-age="+strconv.Itoa(int(sh.HSTSMaxAge.Seconds()))+"; includeSubDomains")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func setOrDelete(header http.Header, key, value string) {
+	if value == "" {
+		header.Del(key)
+	} else {
+		header.Set(key, value)
+	}
+}
+
+// CSPNonce returns the nonce of the Content-Security-Policy of the response
+// to a request, for the inline scripts of a page
+func CSPNonce(r *http.Request) string {
+	nonce, _ := r.Context().Value(nonceKey{}).(string)
+	return nonce
+}
+
+// WebSocketOrigin returns the WebSocket origin of a public URL, e.g.
+// "wss://forum.example" for "https://forum.example", for the connect-src of
+// the browsers which 'self' doesn't cover. It is empty for a URL without an
+// HTTP scheme or a host.
+func WebSocketOrigin(publicURL string) string {
+	parsed, err := url.Parse(publicURL)
+	if err != nil || parsed.Host == "" {
+		return ""
+	}
+	switch parsed.Scheme {
+	case "https":
+		return "wss://" + parsed.Host
+	case "http":
+		return "ws://" + parsed.Host
+	}
+	return ""
+}
+
+// IsSecureRequest tells if a request came over HTTPS, directly or through a
+// trusted proxy terminating TLS
+func IsSecureRequest(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return isTrustedProxy(ip) && strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+func newNonce() (string, error) {
+	bytes := make([]byte, 16)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(bytes), nil
+}
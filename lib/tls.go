@@ -0,0 +1,205 @@
+package lib
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// selfSignedValidity is how long a certificate of the dev mode is valid
+const selfSignedValidity = 365 * 24 * time.Hour
+
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// NewTLSConfig builds the TLS settings of the server from a minimum version,
+// "1.2" or "1.3" and "1.2" by default, and the names of the cipher suites of
+// TLS 1.2, Go choosing them when none is given. Only the suites Go deems
+// secure are accepted. The cipher suites of TLS 1.3 aren't configurable.
+func NewTLSConfig(minVersion string, ciphers []string, getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: getCertificate,
+	}
+	if minVersion != "" {
+		version, ok := tlsVersions[minVersion]
+		if !ok {
+			return nil, errors.New("unsupported TLS version " + minVersion + ", use 1.2 or 1.3")
+		}
+		config.MinVersion = version
+	}
+	secure := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		secure[suite.Name] = suite.ID
+	}
+	for _, name := range ciphers {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		id, ok := secure[name]
+		if !ok {
+			return nil, errors.New("unknown or insecure cipher suite " + name)
+		}
+		config.CipherSuites = append(config.CipherSuites, id)
+	}
+	return config, nil
+}
+
+// CertReloader serves a certificate and its key read from files, reading
+// them again when they change, so that a renewed certificate is used without
+// restarting the server.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	mutex   sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	cr := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if err := cr.reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// GetCertificate returns the current certificate, for tls.Config
+func (cr *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mutex.RLock()
+	defer cr.mutex.RUnlock()
+	return cr.cert, nil
+}
+
+// Watch checks the files at every interval, reloading them once either
+// changed. A certificate that fails to load is logged and the previous one
+// kept, as the certificate and the key may be replaced one after the other.
+// It returns once stop is closed.
+func (cr *CertReloader) Watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			cr.check()
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (cr *CertReloader) check() {
+	modTime, err := cr.lastModified()
+	if err != nil {
+		log.Println("❌ Couldn't check the TLS certificate:", err)
+		return
+	}
+	cr.mutex.RLock()
+	changed := modTime.After(cr.modTime)
+	cr.mutex.RUnlock()
+	if !changed {
+		return
+	}
+	if err := cr.reload(); err != nil {
+		log.Println("❌ Couldn't reload the TLS certificate:", err)
+		return
+	}
+	log.Println("✅ TLS certificate reloaded")
+}
+
+func (cr *CertReloader) reload() error {
+	modTime, err := cr.lastModified()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return err
+	}
+	if cert.Leaf == nil && len(cert.Certificate) > 0 {
+		if leaf, err := x509.ParseCertificate(cert.Certificate[0]); err == nil {
+			cert.Leaf = leaf
+		}
+	}
+	if cert.Leaf != nil && time.Now().After(cert.Leaf.NotAfter) {
+		log.Println("🚨 The TLS certificate", cr.certFile, "expired on", cert.Leaf.NotAfter.Format("2006-01-02"))
+	}
+	cr.mutex.Lock()
+	cr.cert, cr.modTime = &cert, modTime
+	cr.mutex.Unlock()
+	return nil
+}
+
+// lastModified returns the latest modification time of the two files
+func (cr *CertReloader) lastModified() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{cr.certFile, cr.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return latest, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// NewSelfSignedCertificate generates a certificate for development, valid
+// for localhost and the given host names or IPs. Browsers warn about it, as
+// no authority signed it.
+func NewSelfSignedCertificate(hosts []string) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"ThunderForum development"}, CommonName: "localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	for _, host := range hosts {
+		if host = strings.TrimSpace(host); host == "" || host == "localhost" {
+			continue
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("self-signed certificate: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, nil
+}
@@ -4,11 +4,12 @@ import (
 	"bufio"
 	"encoding/json"
 	"fmt"
-	"unicode"
 
 	//"real-time-forum/data/models"
 	"io"
 	"log"
+	"math"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -18,7 +19,6 @@ import (
 	"time"
 
 	"github.com/gofrs/uuid"
-	"golang.org/x/crypto/bcrypt"
 )
 
 var maxSize int64 = 20 * 1024 * 1024 // 20 MB
@@ -67,16 +67,93 @@ func LoadEnv(path string) error {
 	return scanner.Err()
 }
 
-func RedirectToHTTPS(next http.Handler) http.Handler {
+// RedirectToHTTPS sends the requests over plain HTTP to the same address on
+// the HTTPS port, e.g. ":443". The requests a trusted proxy received over
+// HTTPS are passed on to next.
+func RedirectToHTTPS(httpsPort string, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Header.Get("X-Forwarded-Proto") != "https" {
-			http.Redirect(w, r, "https://"+r.Host+r.RequestURI, http.StatusPermanentRedirect)
+		if IsSecureRequest(r) {
+			next.ServeHTTP(w, r)
 			return
 		}
-		next.ServeHTTP(w, r)
+		host := r.Host
+		if hostname, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = hostname
+		}
+		if strings.Contains(host, ":") && !strings.HasPrefix(host, "[") { // an IPv6 address
+			host = "[" + host + "]"
+		}
+		if httpsPort != ":443" {
+			host += httpsPort
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
 	})
 }
 
+// trustedProxies are the networks of the reverse proxies whose
+// X-Forwarded-For header is trusted.
+var trustedProxies []*net.IPNet
+
+// SetTrustedProxies sets the reverse proxies, as IPs or CIDR ranges, whose
+// X-Forwarded-For header tells the client IP.
+func SetTrustedProxies(proxies []string) error {
+	var networks []*net.IPNet
+	for _, proxy := range proxies {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return err
+		}
+		networks = append(networks, network)
+	}
+	trustedProxies = networks
+	return nil
+}
+
+func isTrustedProxy(address string) bool {
+	ip := net.ParseIP(address)
+	for _, network := range trustedProxies {
+		if ip != nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the IP address the request comes from. Behind trusted
+// proxies, it is the last address of X-Forwarded-For not added by one of them.
+func ClientIP(req *http.Request) string {
+	ip, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		ip = req.RemoteAddr
+	}
+	if !isTrustedProxy(ip) {
+		return ip
+	}
+	hops := strings.Split(req.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		ip = hop
+		if !isTrustedProxy(hop) {
+			break
+		}
+	}
+	return ip
+}
+
 func ValidateRequest(req *http.Request, res http.ResponseWriter, url, method string) bool {
 	if strings.Contains(url, "*") {
 		_urlSplit := strings.Split(req.URL.Path, "/")
@@ -106,6 +183,18 @@ func HandleError(res http.ResponseWriter, statusCode int, message string) {
 	SendJSONResponse(res, statusCode, errorResponse)
 }
 
+// HandleTooManyRequests answers 429 with the delay after which the client may
+// retry, in the Retry-After header and the retryAfter field in seconds.
+func HandleTooManyRequests(res http.ResponseWriter, message string, retryAfter time.Duration) {
+	log.Println("🚨 " + message)
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	res.Header().Set("Retry-After", strconv.Itoa(seconds))
+	SendJSONResponse(res, http.StatusTooManyRequests, map[string]any{"errors": message, "retryAfter": seconds})
+}
+
 // SendJSONResponse writes a JSON response with the given status code and data.
 func SendJSONResponse(res http.ResponseWriter, statusCode int, data interface{}) {
 	res.Header().Set("Content-Type", "application/json")
@@ -248,19 +337,3 @@ func FormatDate(DateAndTime string) string {
 	TheDate := fmt.Sprintf("%s, %sth, %s, at %s time", month, day, year, TimeFormatted)
 	return TheDate
 }
-
-func VerifyPassword(password string) bool {
-	var num int
-	for _, val := range password {
-		if !unicode.IsSpace(val) {
-			num++
-		}
-	}
-	return num >= 8
-}
-
-// CheckPasswordHash checks if the given plain text password matches the hashed password.
-func CheckPasswordHash(password, hashedPassword string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
-	return err == nil
-}
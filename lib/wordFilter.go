@@ -0,0 +1,124 @@
+package lib
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Actions of the word filter rules, from the strongest to the weakest
+const (
+	FilterReject = "reject"
+	FilterHold   = "hold"
+	FilterMask   = "mask"
+)
+
+// FilterRule matches a plain term, as a whole word whatever the case, or a
+// regular expression.
+type FilterRule struct {
+	Pattern string
+	Regex   bool
+	Action  string
+}
+
+// FilterResult tells what the filter did to a text.
+type FilterResult struct {
+	Text     string   // the text with the masked terms replaced by stars
+	Rejected bool     // a "reject" rule matched
+	Held     bool     // a "hold" rule matched
+	Matches  []string // the matched terms
+}
+
+type compiledRule struct {
+	pattern *regexp.Regexp
+	action  string
+}
+
+// WordFilter applies a set of rules to the texts written by the users.
+type WordFilter struct {
+	rules []compiledRule
+}
+
+// CompileFilterRule checks a rule and builds its regular expression. A plain
+// term is bounded as a word on the sides ending with a word character only,
+// as \b can't match next to the others, such as the pluses of "c++".
+func CompileFilterRule(rule FilterRule) (*regexp.Regexp, error) {
+	if rule.Regex {
+		return regexp.Compile("(?i)" + rule.Pattern)
+	}
+	term := strings.TrimSpace(rule.Pattern)
+	if term == "" {
+		return nil, errors.New("empty term")
+	}
+	pattern := regexp.QuoteMeta(term)
+	if isWordByte(term[0]) {
+		pattern = `\b` + pattern
+	}
+	if isWordByte(term[len(term)-1]) {
+		pattern += `\b`
+	}
+	return regexp.Compile("(?i)" + pattern)
+}
+
+// isWordByte tells the ASCII word characters, the ones \b knows of.
+func isWordByte(b byte) bool {
+	return b == '_' || '0' <= b && b <= '9' || 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z'
+}
+
+// NewWordFilter compiles the rules, skipping the invalid ones which are
+// returned.
+func NewWordFilter(rules []FilterRule) (*WordFilter, []FilterRule) {
+	filter := &WordFilter{}
+	var invalid []FilterRule
+	for _, rule := range rules {
+		pattern, err := CompileFilterRule(rule)
+		if err != nil {
+			invalid = append(invalid, rule)
+			continue
+		}
+		filter.rules = append(filter.rules, compiledRule{pattern, rule.Action})
+	}
+	return filter, invalid
+}
+
+// Apply runs the rules on a text. Every rule matches the original text, so
+// that a mask can't hide a term from a later rule.
+func (f *WordFilter) Apply(text string) FilterResult {
+	result := FilterResult{Text: text}
+	masked := make([]bool, len(text))
+	for _, rule := range f.rules {
+		spans := rule.pattern.FindAllStringIndex(text, -1)
+		for _, span := range spans {
+			result.Matches = append(result.Matches, text[span[0]:span[1]])
+		}
+		if len(spans) == 0 {
+			continue
+		}
+		switch rule.action {
+		case FilterReject:
+			result.Rejected = true
+		case FilterHold:
+			result.Held = true
+		case FilterMask:
+			for _, span := range spans {
+				for i := span[0]; i < span[1]; i++ {
+					masked[i] = true
+				}
+			}
+		}
+	}
+
+	var output strings.Builder
+	for i := 0; i < len(text); {
+		_, size := utf8.DecodeRuneInString(text[i:])
+		if masked[i] {
+			output.WriteByte('*')
+		} else {
+			output.WriteString(text[i : i+size])
+		}
+		i += size
+	}
+	result.Text = output.String()
+	return result
+}
@@ -1,9 +1,16 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"real-time-forum/data/models"
@@ -15,25 +22,179 @@ func main() {
 	PORT := ":" + os.Getenv("PORT")
 	ADDRESS := os.Getenv("ADDRESS")
 
-	rateLimiter := lib.NewRateLimiter(time.Minute)
+	// Trust the X-Forwarded-For header of the reverse proxies, if any
+	if err := lib.SetTrustedProxies(strings.Split(os.Getenv("TRUSTED_PROXIES"), ",")); err != nil {
+		log.Fatal("❌ Invalid TRUSTED_PROXIES: ", err)
+	}
+	// Hash the passwords and check the new ones as configured
+	hashConfig := lib.DefaultHashConfig
+	if algorithm := os.Getenv("PASSWORD_HASH"); algorithm != "" {
+		hashConfig.Algorithm = algorithm
+	}
+	if cost := os.Getenv("BCRYPT_COST"); cost != "" {
+		var err error
+		if hashConfig.BcryptCost, err = strconv.Atoi(cost); err != nil {
+			log.Fatal("❌ Invalid BCRYPT_COST: ", cost)
+		}
+	}
+	if err := lib.SetHashConfig(hashConfig); err != nil {
+		log.Fatal("❌ Invalid password hashing settings: ", err)
+	}
+	passwordPolicy := lib.DefaultPasswordPolicy
+	if length := os.Getenv("PASSWORD_MIN_LENGTH"); length != "" {
+		var err error
+		if passwordPolicy.MinLength, err = strconv.Atoi(length); err != nil {
+			log.Fatal("❌ Invalid PASSWORD_MIN_LENGTH: ", length)
+		}
+	}
+	if require := os.Getenv("PASSWORD_REQUIRE"); require != "" {
+		passwordPolicy.Require = strings.Split(require, ",")
+	}
+	if err := lib.SetPasswordPolicy(passwordPolicy); err != nil {
+		log.Fatal("❌ Invalid password policy: ", err)
+	}
+
+	// Withhold permissions from the users until they verify their email
+	if deny, ok := os.LookupEnv("UNVERIFIED_DENY"); ok {
+		var permissions []models.Permission
+		for _, permission := range strings.Split(deny, ",") {
+			if permission = strings.TrimSpace(permission); permission != "" && permission != "none" {
+				permissions = append(permissions, models.Permission(permission))
+			}
+		}
+		if err := models.SetUnverifiedRestrictions(permissions); err != nil {
+			log.Fatal("❌ Invalid UNVERIFIED_DENY: ", err)
+		}
+	}
+
+	rateLimiter := lib.NewRateLimiter(map[string]lib.Limit{
+		"api":  {Requests: 3000, Per: time.Minute, ByUser: true}, // 3000 requests per minute for API endpoints
+		"auth": {Requests: 100, Per: time.Minute},                // 100 requests per minute for authentication endpoints
+	})
+	rateLimiter.UserID = handler.RequestUserID
+	// done stops the background work once the server is shut down
+	done := make(chan struct{})
+	go rateLimiter.EvictIdle(time.Minute, done)
+
+	// Share the real-time events with the other instances, if any
+	broker, err := lib.NewBroker(os.Getenv("BROKER_URL"))
+	if err != nil {
+		log.Fatal("❌ Couldn't connect to the broker: ", err)
+	}
+	if err := handler.UseBroker(broker); err != nil {
+		log.Fatal("❌ Couldn't subscribe to the broker: ", err)
+	}
+
+	// Send the emails through MAILER_URL, logging them without it
+	mailFrom := os.Getenv("MAIL_FROM")
+	if mailFrom == "" {
+		mailFrom = "ThunderForum <no-reply@localhost>"
+	}
+	mailer, err := lib.NewMailer(os.Getenv("MAILER_URL"), mailFrom)
+	if err != nil {
+		log.Fatal("❌ Couldn't set up the mailer: ", err)
+	}
+	handler.Mailer = mailer
+	handler.PublicURL = ADDRESS + PORT
+	if publicURL := os.Getenv("PUBLIC_URL"); publicURL != "" {
+		handler.PublicURL = strings.TrimSuffix(publicURL, "/")
+	}
+
+	// Let the users sign in with the OpenID Connect providers of OIDC_PROVIDERS
+	var providers []*lib.OIDCProvider
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name == "" {
+			continue
+		}
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		provider, err := lib.NewOIDCProvider(lib.OIDCConfig{
+			Name:         name,
+			Label:        os.Getenv(prefix + "LABEL"),
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  handler.PublicURL + "/oidc/callback/" + name,
+			Scopes:       strings.Fields(os.Getenv(prefix + "SCOPES")),
+		})
+		if err != nil {
+			log.Fatal("❌ Invalid OIDC provider "+name+": ", err)
+		}
+		providers = append(providers, provider)
+	}
+	handler.UseOIDCProviders(providers)
+
+	// Security headers: the JSON API renders nothing, and the page only runs
+	// the scripts of the forum and only connects to it
+	connectSrc := "'self'"
+	if origin := lib.WebSocketOrigin(handler.PublicURL); origin != "" {
+		connectSrc += " " + origin
+	}
+	securityHeaders := lib.NewSecurityHeaders(map[string]lib.HeaderPolicy{
+		lib.DefaultHeaderClass: {
+			ContentSecurityPolicy: "default-src 'none'; frame-ancestors 'none'",
+			FrameOptions:          "DENY",
+			ReferrerPolicy:        "no-referrer",
+		},
+		"page": {
+			ContentSecurityPolicy: "default-src 'self'; script-src 'self' 'nonce-" + lib.NoncePlaceholder + "'; style-src 'self'; " +
+				"img-src 'self' data: blob: https://ui-avatars.com; connect-src " + connectSrc + "; object-src 'none'; base-uri 'none'; form-action 'self'; frame-ancestors 'none'",
+			FrameOptions:      "DENY",
+			ReferrerPolicy:    "strict-origin-when-cross-origin",
+			PermissionsPolicy: "camera=(), microphone=(), geolocation=(), payment=(), usb=()",
+		},
+		"static": {
+			ContentSecurityPolicy: "default-src 'none'; style-src 'self'; img-src 'self'; frame-ancestors 'none'; sandbox",
+			FrameOptions:          "DENY",
+			ReferrerPolicy:        "no-referrer",
+		},
+	})
+	securityHeaders.HSTSMaxAge = 180 * 24 * time.Hour
+	if maxAge := os.Getenv("HSTS_MAX_AGE"); maxAge != "" {
+		seconds, err := strconv.Atoi(maxAge)
+		if err != nil || seconds < 0 {
+			log.Fatal("❌ Invalid HSTS_MAX_AGE: ", maxAge)
+		}
+		securityHeaders.HSTSMaxAge = time.Duration(seconds) * time.Second
+	}
 
 	// Static file serving
-	http.Handle("/js/", http.StripPrefix("/js/", http.FileServer(http.Dir("./public/js/"))))
-	http.Handle("/css/", http.StripPrefix("/css/", http.FileServer(http.Dir("./public/css/"))))
-	http.Handle("/img/", http.StripPrefix("/img/", http.FileServer(http.Dir("./public/img/"))))
-	http.Handle("/uploads/", http.StripPrefix("/uploads/", http.FileServer(http.Dir("./uploads/"))))
+	http.Handle("/js/", securityHeaders.Wrap("static", http.StripPrefix("/js/", http.FileServer(http.Dir("./public/js/")))))
+	http.Handle("/css/", securityHeaders.Wrap("static", http.StripPrefix("/css/", http.FileServer(http.Dir("./public/css/")))))
+	http.Handle("/img/", securityHeaders.Wrap("static", http.StripPrefix("/img/", http.FileServer(http.Dir("./public/img/")))))
+	http.Handle("/uploads/", securityHeaders.Wrap("static", http.StripPrefix("/uploads/", http.FileServer(http.Dir("./uploads/")))))
 
 	// Single Page
-	http.Handle("/", rateLimiter.Wrap("auth", http.HandlerFunc(handler.Index)))
+	http.Handle("/", securityHeaders.Wrap("page", rateLimiter.Wrap("auth", http.HandlerFunc(handler.Index))))
 
-	// WebSocket
+	// WebSocket and its fallbacks
 	http.HandleFunc("/ws", handler.HandleWebSocket)
+	http.HandleFunc("/events", handler.EventStream)
+	http.Handle("/poll", rateLimiter.Wrap("api", http.HandlerFunc(handler.Poll)))
 
 	// Authentication
 	http.Handle("/me", rateLimiter.Wrap("auth", http.HandlerFunc(handler.Me)))
 	http.Handle("/sign-up", rateLimiter.Wrap("auth", http.HandlerFunc(handler.SignUp)))
 	http.Handle("/sign-in", rateLimiter.Wrap("auth", http.HandlerFunc(handler.SignIn)))
+	http.Handle("/sign-in/two-factor", rateLimiter.Wrap("auth", http.HandlerFunc(handler.SignInTwoFactor)))
 	http.Handle("/logout", rateLimiter.Wrap("auth", http.HandlerFunc(handler.Logout)))
+	http.Handle("/password/change", rateLimiter.Wrap("auth", http.HandlerFunc(handler.ChangePassword)))
+	http.Handle("/password/forgot", rateLimiter.Wrap("auth", http.HandlerFunc(handler.ForgotPassword)))
+	http.Handle("/password/reset", rateLimiter.Wrap("auth", http.HandlerFunc(handler.ResetPassword)))
+	http.Handle("/verify-email", rateLimiter.Wrap("auth", http.HandlerFunc(handler.VerifyEmail)))
+	http.Handle("/verify-email/resend", rateLimiter.Wrap("auth", http.HandlerFunc(handler.ResendVerification)))
+	http.Handle("/oidc/providers", rateLimiter.Wrap("auth", http.HandlerFunc(handler.GetOIDCProviders)))
+	http.Handle("/oidc/login/", rateLimiter.Wrap("auth", http.HandlerFunc(handler.OIDCLogin)))
+	http.Handle("/oidc/callback/", rateLimiter.Wrap("auth", http.HandlerFunc(handler.OIDCCallback)))
+	http.Handle("/tokens", rateLimiter.Wrap("auth", http.HandlerFunc(handler.GetTokens)))
+	http.Handle("/token", rateLimiter.Wrap("auth", http.HandlerFunc(handler.CreateToken)))
+	http.Handle("/token/", rateLimiter.Wrap("auth", http.HandlerFunc(handler.DeleteToken)))
+	http.Handle("/bots", rateLimiter.Wrap("auth", http.HandlerFunc(handler.GetBots)))
+	http.Handle("/bot", rateLimiter.Wrap("auth", http.HandlerFunc(handler.CreateBot)))
+	http.Handle("/two-factor", rateLimiter.Wrap("auth", http.HandlerFunc(handler.GetTwoFactor)))
+	http.Handle("/two-factor/setup", rateLimiter.Wrap("auth", http.HandlerFunc(handler.SetupTwoFactor)))
+	http.Handle("/two-factor/enable", rateLimiter.Wrap("auth", http.HandlerFunc(handler.EnableTwoFactor)))
+	http.Handle("/two-factor/disable", rateLimiter.Wrap("auth", http.HandlerFunc(handler.DisableTwoFactor)))
+	http.Handle("/two-factor/recovery-codes", rateLimiter.Wrap("auth", http.HandlerFunc(handler.RegenerateRecoveryCodes)))
 
 	// Post Handlers
 	http.Handle("/post", rateLimiter.Wrap("api", http.HandlerFunc(handler.CreatePost)))
@@ -50,12 +211,150 @@ func main() {
 	http.HandleFunc("/chat/messages/", rateLimiter.Wrap("api", http.HandlerFunc(handler.GetMessages)))
 	http.HandleFunc("/chat/new", rateLimiter.Wrap("api", http.HandlerFunc(handler.NewMessage)))
 
+	// Block and Mute Handlers
+	http.Handle("/block/", rateLimiter.Wrap("api", http.HandlerFunc(handler.BlockUser)))
+	http.Handle("/unblock/", rateLimiter.Wrap("api", http.HandlerFunc(handler.UnblockUser)))
+	http.Handle("/blocks", rateLimiter.Wrap("api", http.HandlerFunc(handler.GetBlocks)))
+	http.Handle("/mute/", rateLimiter.Wrap("api", http.HandlerFunc(handler.MuteUser)))
+	http.Handle("/unmute/", rateLimiter.Wrap("api", http.HandlerFunc(handler.UnmuteUser)))
+
+	// Moderation Handlers
+	http.Handle("/report", rateLimiter.Wrap("api", http.HandlerFunc(handler.CreateReport)))
+	http.Handle("/moderation/reports", rateLimiter.Wrap("api", http.HandlerFunc(handler.GetReports)))
+	http.Handle("/moderation/assign/", rateLimiter.Wrap("api", http.HandlerFunc(handler.AssignReport)))
+	http.Handle("/moderation/resolve/", rateLimiter.Wrap("api", http.HandlerFunc(handler.ResolveReport)))
+	http.Handle("/moderation/sanction/", rateLimiter.Wrap("api", http.HandlerFunc(handler.SanctionUser)))
+	http.Handle("/moderation/lift/", rateLimiter.Wrap("api", http.HandlerFunc(handler.LiftSanction)))
+
+	// Admin Handlers
+	http.Handle("/admin/staff", rateLimiter.Wrap("api", http.HandlerFunc(handler.GetStaff)))
+	http.Handle("/admin/role/", rateLimiter.Wrap("api", http.HandlerFunc(handler.SetRole)))
+	http.Handle("/admin/filters", rateLimiter.Wrap("api", http.HandlerFunc(handler.GetFilterRules)))
+	http.Handle("/admin/filter", rateLimiter.Wrap("api", http.HandlerFunc(handler.CreateFilterRule)))
+	http.Handle("/admin/filter/", rateLimiter.Wrap("api", http.HandlerFunc(handler.DeleteFilterRule)))
+	http.Handle("/admin/audit", rateLimiter.Wrap("api", http.HandlerFunc(handler.GetAuditLog)))
+	http.Handle("/admin/audit/export", rateLimiter.Wrap("api", http.HandlerFunc(handler.ExportAuditLog)))
+
+	// Grant the admin role to the users listed in ADMINS
+	if admins := os.Getenv("ADMINS"); admins != "" {
+		if err := models.UserRepo.GrantAdmins(strings.Split(admins, ",")); err != nil {
+			log.Println("❌ Couldn't grant the admin role: ", err)
+		}
+	}
+
 	go models.DeleteExpiredSessions()
 
-	// Start the server with the Gorilla Mux router
+	// No ReadTimeout nor WriteTimeout, which would cut the event streams and
+	// the long polls: the handlers bound how long they hold a request.
+	server := &http.Server{
+		Addr:              PORT,
+		Handler:           securityHeaders.Handler(handler.CSRF(http.DefaultServeMux)),
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
+	// The real-time connections are closed as the shutdown starts, telling
+	// the clients to reconnect
+	server.RegisterOnShutdown(handler.NotifyShutdown)
+	servers := []*http.Server{server}
+	serve := server.ListenAndServe
+
+	// Serve over HTTPS with TLS=on, or TLS=dev for a self-signed certificate
+	switch mode := os.Getenv("TLS"); mode {
+	case "", "off":
+	case "on", "dev":
+		var getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+		if mode == "dev" {
+			hosts := []string{}
+			if publicURL, err := url.Parse(handler.PublicURL); err == nil && publicURL.Hostname() != "" {
+				hosts = append(hosts, publicURL.Hostname())
+			}
+			cert, err := lib.NewSelfSignedCertificate(hosts)
+			if err != nil {
+				log.Fatal("❌ Couldn't generate a certificate: ", err)
+			}
+			getCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return cert, nil }
+			log.Println("🚨 Serving a self-signed certificate, for development only")
+		} else {
+			certFile, keyFile := os.Getenv("TLS_CERT"), os.Getenv("TLS_KEY")
+			if certFile == "" {
+				certFile = "keys/server.crt"
+			}
+			if keyFile == "" {
+				keyFile = "keys/server.key"
+			}
+			certReloader, err := lib.NewCertReloader(certFile, keyFile)
+			if err != nil {
+				log.Fatal("❌ Couldn't load the TLS certificate: ", err)
+			}
+			go certReloader.Watch(time.Minute, done)
+			getCertificate = certReloader.GetCertificate
+		}
+		tlsConfig, err := lib.NewTLSConfig(os.Getenv("TLS_MIN_VERSION"), strings.Split(os.Getenv("TLS_CIPHERS"), ","), getCertificate)
+		if err != nil {
+			log.Fatal("❌ Invalid TLS settings: ", err)
+		}
+		server.TLSConfig = tlsConfig
+		serve = func() error { return server.ListenAndServeTLS("", "") }
+
+		// Redirect the plain HTTP requests of HTTP_PORT, if set
+		if httpPort := os.Getenv("HTTP_PORT"); httpPort != "" {
+			redirect := &http.Server{
+				Addr:              ":" + httpPort,
+				Handler:           lib.RedirectToHTTPS(PORT, server.Handler),
+				ReadHeaderTimeout: 10 * time.Second,
+				IdleTimeout:       2 * time.Minute,
+			}
+			servers = append(servers, redirect)
+			go func() {
+				if err := redirect.ListenAndServe(); err != http.ErrServerClosed {
+					log.Fatal(err)
+				}
+			}()
+		}
+	default:
+		log.Fatal("❌ Invalid TLS: ", mode, ", use on, dev or off")
+	}
+
+	go func() {
+		if err := serve(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
 	log.Print("Server started and running on ")
-	log.Println(ADDRESS + PORT)
-	if err := http.ListenAndServe(PORT, nil); err != nil {
-		log.Fatal(err)
+	log.Println(handler.PublicURL)
+
+	// Stop on SIGINT or SIGTERM, letting the requests in flight finish
+	// within SHUTDOWN_TIMEOUT seconds
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	log.Println("🚨 Received", <-stop, "shutting down")
+	signal.Stop(stop) // a second signal kills the server at once
+	close(done)
+
+	shutdownTimeout := 15 * time.Second
+	if timeout := os.Getenv("SHUTDOWN_TIMEOUT"); timeout != "" {
+		if seconds, err := strconv.Atoi(timeout); err == nil && seconds >= 0 {
+			shutdownTimeout = time.Duration(seconds) * time.Second
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			log.Println("❌ Requests still in flight after the shutdown timeout:", err)
+			server.Close()
+		}
+	}
+
+	// The handlers of the closed WebSockets still record the users leaving
+	if err := handler.Connections.Drain(ctx); err != nil {
+		log.Println("❌ Connections still open after the shutdown timeout:", err)
+	}
+	if err := handler.Broker.Close(); err != nil {
+		log.Println("❌ Couldn't close the broker:", err)
+	}
+	if err := models.Close(); err != nil {
+		log.Println("❌ Couldn't close the database:", err)
 	}
+	log.Println("✅ Server stopped")
 }
@@ -3,21 +3,98 @@ import { Environment } from '../lib/environment.js'
 export default class SocketHandler extends HTMLElement {
   constructor() {
     super();
-    this.socket = new WebSocket('ws://localhost:8085/ws');
+    // sequence number of the last event received, sent back on reconnection
+    this.lastSeq = 0
+    this.retryDelay = 1000
+    // topics the page listens to, subscribed again on reconnection
+    this.topics = new Set(['feed', 'presence'])
+    // failed WebSocket attempts before falling back to Server-Sent Events
+    this.failures = 0
 
-    this.socket.onopen = () => {
-      if (Environment.auth) {
-        this.login()
+    this.connect = () => {
+      if (this.failures >= 3) return this.connectStream()
+      this.socket = new WebSocket(`${location.protocol === 'https:' ? 'wss' : 'ws'}://${location.host}/ws`);
+      this.resuming = this.lastSeq > 0
+      let opened = false
+
+      this.socket.onopen = () => {
+        opened = true
+        this.failures = 0
+        this.retryDelay = 1000
+        if (this.idle) this.send('activity', { state: 'idle' })
+        if (['moderator', 'admin'].includes(Environment.auth?.role)) this.topics.add('moderation')
+        this.topics.forEach(topic => this.send('subscribe', { topic }))
+        if (this.resuming) {
+          this.socket.send(JSON.stringify({ type: 'resume', data: { lastSeq: this.lastSeq } }));
+        }
+      }
+
+      this.socket.onclose = () => {
+        if (this.closing) {
+          this.closing = false
+          return
+        }
+        if (!opened) this.failures++
+        setTimeout(this.connect, this.retryDelay)
+        this.retryDelay = Math.min(this.retryDelay * 2, 30000)
+      }
+
+      this.socket.onmessage = this.onmessage
+    }
+
+    // the event stream only goes one way: the topics are given in the URL
+    // and the browser resumes from the last event id by itself
+    this.connectStream = () => {
+      if (this.socket) this.socket.close()
+      const topics = encodeURIComponent([...this.topics].join(','))
+      this.socket = new EventSource(`${Environment.fetchBaseUrl}/events?topics=${topics}&lastSeq=${this.lastSeq}`, { withCredentials: true })
+      this.socket.onmessage = this.onmessage
+    }
+
+    this.send = (type, data) => {
+      if (this.socket instanceof WebSocket && this.socket.readyState === WebSocket.OPEN) {
+        this.socket.send(JSON.stringify({ type, data }))
       }
     }
 
-    this.socket.onclose = () => {
-      this.socket.send(JSON.stringify({ type: 'logout', data: { userID: Environment.auth.id } }));
+    this.subscribe = (e) => {
+      this.topics.add(e.detail.topic)
+      if (this.socket instanceof EventSource) return this.connectStream()
+      this.send('subscribe', { topic: e.detail.topic })
+    }
+
+    this.unsubscribe = (e) => {
+      this.topics.delete(e.detail.topic)
+      if (this.socket instanceof EventSource) return this.connectStream()
+      this.send('unsubscribe', { topic: e.detail.topic })
+    }
+
+    // reopen the socket so that it is bound to the new session
+    this.reconnect = () => {
+      if (this.socket instanceof EventSource) return this.connectStream()
+      this.closing = true
+      this.socket.close()
+      this.connect()
     }
 
-    this.socket.onmessage = (event) => {
+    this.onmessage = (event) => {
       const data = JSON.parse(event.data);
+      if (data.type === 'hello') {
+        // a reconnection catches up through the 'resume' frame instead
+        if (!this.resuming) this.lastSeq = data.seq
+      } else if (data.seq > this.lastSeq || data.type === 'resync-required') {
+        this.lastSeq = data.seq
+      }
       switch (data.type) {
+        case 'server-shutdown':
+          // the server is stopping: reconnect, to another instance, after a
+          // random delay so that the clients don't all come back at once
+          this.retryDelay = 500 + Math.random() * (data.retryAfter || 5000)
+          break;
+        case 'resync-required':
+          // the missed events are gone, reload the state from the API
+          self.location.reload()
+          break;
         case 'post':
           const postEventName = `new-post`
           this.dispatchEvent(new CustomEvent(postEventName, {
@@ -39,7 +116,7 @@ export default class SocketHandler extends HTMLElement {
         case 'status':
           const statusEventName = `status-${data.userID}`
           this.dispatchEvent(new CustomEvent(statusEventName, {
-            detail: data.online,
+            detail: { online: data.online, presence: data.presence, lastSeen: data.lastSeen },
             bubbles: true,
             cancelable: true,
             composed: true
@@ -48,7 +125,7 @@ export default class SocketHandler extends HTMLElement {
         case 'message':
           const chatID = Environment.auth.id === data.message.authorID ? data.message.receiverID : data.message.authorID
           const messageEventName = `message-${chatID}-${Environment.auth.id}`
-          if (Environment.auth.id !== data.message.authorID) {
+          if (Environment.auth.id !== data.message.authorID && !data.muted) {
             Environment.toastWidget.showToast(data.message.authorName + '\n' + data.message.text, 'infos')
           }
           this.dispatchEvent(new CustomEvent(messageEventName, {
@@ -58,6 +135,31 @@ export default class SocketHandler extends HTMLElement {
             composed: true
           }))
           break;
+        case 'report':
+          Environment.toastWidget.showToast(`New report on a ${data.report.contentType}: ${data.report.reason}`, 'infos')
+        case 'report-updated':
+          this.dispatchEvent(new CustomEvent(data.type, {
+            detail: data.report,
+            bubbles: true,
+            cancelable: true,
+            composed: true
+          }))
+          break;
+        case 'token-expired':
+          // the session ended on the server, the connection comes back as a visitor
+          if (data.sanction) {
+            const until = data.sanction.banned ? '' : ` until ${new Date(data.sanction.until).toLocaleString()}`
+            Environment.toastWidget.showToast(`Your account is ${data.sanction.banned ? 'banned' : 'suspended'}${until}${data.sanction.reason ? ': ' + data.sanction.reason : ''}`, 'error')
+          }
+          Environment.auth = null
+          self.location.hash = '#/login'
+          break;
+        case 'warning':
+          Environment.toastWidget.showToast(`A moderator warned you (${data.reason})${data.note ? ': ' + data.note : ''}`, 'error')
+          break;
+        case 'security':
+          Environment.toastWidget.showToast(data.message, 'error')
+          break;
         case 'typing':
           const typingEventName = `typing-${data.to}-${data.from}`
           this.dispatchEvent(new CustomEvent(typingEventName, {
@@ -70,37 +172,61 @@ export default class SocketHandler extends HTMLElement {
       }
     };
 
+    // report the user as away after a while without interaction or when the
+    // page is hidden, and as active again on the next interaction
+    this.idle = false
+    this.activity = () => {
+      clearTimeout(this.idleTimer)
+      const idle = document.visibilityState === 'hidden'
+      if (!idle) this.idleTimer = setTimeout(() => this.setIdle(true), 5 * 60 * 1000)
+      this.setIdle(idle)
+    }
+    this.setIdle = (idle) => {
+      if (this.idle === idle) return
+      this.idle = idle
+      this.send('activity', { state: idle ? 'idle' : 'active' })
+    }
+
     this.login = () => {
-      this.socket.send(JSON.stringify({ type: 'login', data: { userID: Environment.auth.id } }));
+      this.reconnect()
     }
 
     this.typing = (e) => {
-      this.socket.send(JSON.stringify({
-        type: 'typing',
-        data: {
-          isTyping: e.detail.isTyping,
-          to: e.detail.to,
-          from: Environment.auth.id
-        }
-      }));
+      this.send('typing', {
+        isTyping: e.detail.isTyping,
+        to: e.detail.to
+      })
     }
 
     this.logout = () => {
-      this.socket.send(JSON.stringify({ type: 'logout', data: { userID: Environment.auth.id } }));
+      this.send('logout', {})
       Environment.auth = null
       self.location.hash = '#/login'
     }
+
+    this.connect()
   }
 
 
   connectedCallback() {
+    this.activity()
+    document.addEventListener('visibilitychange', this.activity)
+    document.addEventListener('pointerdown', this.activity)
+    document.addEventListener('keydown', this.activity)
     this.addEventListener('typing', this.typing)
+    this.addEventListener('subscribe', this.subscribe)
+    this.addEventListener('unsubscribe', this.unsubscribe)
     this.addEventListener('ok-login', this.login)
     this.addEventListener('ok-logout', this.logout)
   }
 
   disconnectedCallback() {
+    document.removeEventListener('visibilitychange', this.activity)
+    document.removeEventListener('pointerdown', this.activity)
+    document.removeEventListener('keydown', this.activity)
     this.removeEventListener('typing', this.typing)
+    this.removeEventListener('subscribe', this.subscribe)
+    this.removeEventListener('unsubscribe', this.unsubscribe)
     this.removeEventListener('ok-login', this.login)
     this.removeEventListener('ok-logout', this.logout)
   }
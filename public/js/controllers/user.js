@@ -24,6 +24,7 @@ import { dispatchCustomEvent } from '../lib/utils.js'
 /**
  * As a controller, this component becomes a store and organizes events
  * dispatches: 'user' on 'login'
+ * dispatches: 'two-factor' on 'login' when the account asks for a code
  * dispatches: 'user' on 'sign-up'
  * dispatches: 'user' on 'updateUser'
  * dispatches: 'user' on 'get-user'
@@ -78,14 +79,26 @@ export default class User extends HTMLElement {
         }
 
         this.loginUserListener = event => {
-            if (!event.detail.user) return
+            if (!event.detail.user && !event.detail.twoFactor && !event.detail.session) return
 
             if (this.abortController) this.abortController.abort()
             this.abortController = new AbortController()
 
-            const url = `${Environment.fetchBaseUrl}/sign-in`
+            // the second step sends the code with the token of the first one,
+            // and a sign-in through a provider already opened the session
+            const url = `${Environment.fetchBaseUrl}${event.detail.session ? '/me' : event.detail.twoFactor ? '/sign-in/two-factor' : '/sign-in'}`
             let finishCallback = data => {
                 if (data.errors) throw data.errors
+                if (data.twoFactorRequired) {
+                    this.dispatchEvent(new CustomEvent('two-factor', {
+                        detail: { preAuthToken: data.preAuthToken },
+                        bubbles: true,
+                        cancelable: true,
+                        composed: true
+                    }))
+                    return null
+                }
+                if (data.failedAttempts) Environment.toastWidget.showToast(`${data.failedAttempts} failed sign-in attempt(s) on your account since your last sign-in`, 'error')
                 if (data.user) {
                     this.user = data.user
                     Environment.auth = data.user;
@@ -103,9 +116,9 @@ export default class User extends HTMLElement {
             // answer with event
             dispatchCustomEvent(this, 'user', url,
                 {
-                    method: 'POST',
+                    method: event.detail.session ? 'GET' : 'POST',
                     ...Environment.fetchHeaders,
-                    body: JSON.stringify(event.detail.user),
+                    body: event.detail.session ? undefined : JSON.stringify(event.detail.twoFactor || event.detail.user),
                     signal: this.abortController.signal
                 }, finishCallback)
         }
@@ -46,6 +46,17 @@ export default class Header extends HTMLElement {
                 composed: true
             }))
         }
+
+        /**
+         * Mails a new link verifying the email of the user
+         */
+        this.resendVerificationListener = () => {
+            fetch(`${Environment.fetchBaseUrl}/verify-email/resend`, { method: 'POST', ...Environment.fetchHeaders })
+                .then(async response => {
+                    const data = await response.json()
+                    Environment.toastWidget.showToast(data.errors || data.message, data.errors ? 'error' : 'infos')
+                })
+        }
     }
 
     connectedCallback() {
@@ -97,8 +108,12 @@ export default class Header extends HTMLElement {
             </a>
             <div>
                 ${user ? /* html */`
-                <a href="#/">Welcome, ${user.nickname}</a>
+                <a href="#/">Welcome, ${user.nickname}${user.role && user.role !== 'member' ? ` (${user.role})` : ''}</a>
                 <a href="#/add-post" class="btn small primary not mr--8">New Post</a>
+                ${user.email_verified === false ? /* html */`<button id="resend-verification" class="primary small mr--8">Verify email</button>` : ''}
+                <a href="#/password/change" class="btn small primary not mr--8">Password</a>
+                <a href="#/two-factor" class="btn small primary not mr--8">2FA</a>
+                <a href="#/tokens" class="btn small primary not mr--8">Tokens</a>
                 <button id="logout" class="primary small mr--8">Logout</button>
                 `
                 : /* html */`
@@ -110,5 +125,6 @@ export default class Header extends HTMLElement {
         if (this.button) {
             this.button.addEventListener('click', this.logoutUserListener);
         }
+        this.querySelector('button#resend-verification')?.addEventListener('click', this.resendVerificationListener)
     }
 }
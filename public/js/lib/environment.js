@@ -10,7 +10,7 @@
  */
 class EnvironmentClass {
   constructor() {
-    this._fetchBaseUrl = 'http://localhost:8085'
+    this._fetchBaseUrl = location.origin // served by the API, over HTTP or HTTPS
     document.addEventListener('DOMContentLoaded', () => {
       // Retrieve the ToastList element
     });
@@ -46,11 +46,22 @@ class EnvironmentClass {
   get fetchHeaders() {
     return {
       headers: {
-        'Content-Type': 'application/json;charset=utf-8'
+        'Content-Type': 'application/json;charset=utf-8',
+        'X-CSRF-Token': this.csrfToken
       }
     }
   }
 
+  /**
+   * get the CSRF token of the session, sent back on the requests changing anything
+   *
+   * @return {string}
+   */
+  get csrfToken() {
+    const cookie = document.cookie.split('; ').find(cookie => cookie.startsWith('csrf_token='))
+    return cookie ? decodeURIComponent(cookie.slice('csrf_token='.length)) : ''
+  }
+
 
   /**
    * get JWT token
@@ -39,7 +39,7 @@ export default class Router extends HTMLElement {
                 name: 'p-login',
                 path: '../pages/login.js',
                 title: "Login | ThunderForum 💜",
-                regExp: new RegExp(/^#\/login$/),
+                regExp: new RegExp(/^#\/login(\/oidc|\/two-factor\/[\w-]+)?$/),
                 authPage: true,
             },
             {
@@ -49,6 +49,36 @@ export default class Router extends HTMLElement {
                 regExp: new RegExp(/^#\/register$/),
                 authPage: true,
             },
+            // Password pages (URL: /#/password/forgot, /#/password/reset/token, /#/password/change )
+            {
+                name: 'p-password',
+                title: "Password | ThunderForum 💜",
+                path: '../pages/password.js',
+                regExp: new RegExp(/^#\/password\/(forgot|reset\/[\w-]+|change)$/),
+                authPage: true,
+            },
+            // Two-factor authentication page (URL: /#/two-factor )
+            {
+                name: 'p-two-factor',
+                title: "Two-factor authentication | ThunderForum 💜",
+                path: '../pages/two-factor.js',
+                regExp: new RegExp(/^#\/two-factor$/),
+            },
+            // API tokens and bots page (URL: /#/tokens )
+            {
+                name: 'p-tokens',
+                title: "API tokens | ThunderForum 💜",
+                path: '../pages/tokens.js',
+                regExp: new RegExp(/^#\/tokens$/),
+            },
+            // Email verification page (URL: /#/verify-email/token )
+            {
+                name: 'p-verify-email',
+                title: "Verify email | ThunderForum 💜",
+                path: '../pages/verify-email.js',
+                regExp: new RegExp(/^#\/verify-email\/[\w-]+$/),
+                authPage: true,
+            },
             // Post pages (URL: /#/post/post-slug-here )
             {
                 name: 'p-post',
@@ -32,7 +32,10 @@
       gender: string,
       is_logged_in: bool,
       email: string,
-      avatar_url: string
+      avatar_url: string,
+      role: 'admin' | 'moderator' | 'member',
+      email_verified: bool,
+      bot: bool
     }} AuthUser
 */
 
@@ -58,8 +61,11 @@
       id: string
       nickname: string
       is_connected: bool
+      presence: string
+      last_seen: string
       last_message: string
       last_message_time: string
+      is_bot: bool
  }} ChatItem
  */
 
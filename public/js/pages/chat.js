@@ -90,10 +90,12 @@ export default class Chat extends HTMLElement {
             }
         };
 
-        // Event listener for typing start
+        // Event listener for typing start, renewed before the server lets the
+        // indicator expire
         this.inputListener = () => {
-            if (!this.typing) {
+            if (!this.typing || Date.now() - this.typingSentAt > 3000) {
                 this.typing = true;
+                this.typingSentAt = Date.now();
                 this.dispatchEvent(new CustomEvent('typing', {
                     detail: {
                         isTyping: this.typing,
@@ -18,6 +18,21 @@ export default class Login extends HTMLElement {
       if (this.loginForm?.checkValidity()) {
         e.preventDefault();
 
+        if (this.preAuthToken) {
+          this.dispatchEvent(new CustomEvent('login', {
+            detail: {
+              twoFactor: {
+                preAuthToken: this.preAuthToken,
+                code: this.codeField ? this.codeField.value : ""
+              }
+            },
+            bubbles: true,
+            cancelable: true,
+            composed: true
+          }))
+          return
+        }
+
         this.dispatchEvent(new CustomEvent('login', {
           detail: {
             /** @type {import("../lib/typing.js").Login} */
@@ -46,6 +61,15 @@ export default class Login extends HTMLElement {
         })
         .catch(error => (this.errorMessages = error))
     }
+    /**
+    * Listens to the event name/typeArg: 'two-factor', the password being right
+    *
+    * @param {CustomEvent & {detail: {preAuthToken: string}}} event
+    */
+    this.twoFactorListener = event => {
+      this.preAuthToken = event.detail.preAuthToken
+      this.renderTwoFactor()
+    }
   }
 
   connectedCallback() {
@@ -54,8 +78,32 @@ export default class Login extends HTMLElement {
     }
     if (this.shouldComponentRender()) this.render()
     this.loginForm?.addEventListener('submit', this.submitListener)
+    this.renderProviders()
+    // back from a provider, the session is open or waits for the second factor
+    const twoFactor = location.hash.match(/^#\/login\/two-factor\/([\w-]+)$/)
+    if (twoFactor) {
+      this.preAuthToken = twoFactor[1]
+      this.renderTwoFactor()
+    } else if (location.hash === '#/login/oidc') {
+      this.dispatchEvent(new CustomEvent('login', {
+        detail: { session: true },
+        bubbles: true,
+        cancelable: true,
+        composed: true
+      }))
+    }
     // @ts-ignore
     document.body.addEventListener('user', this.userListener)
+    // @ts-ignore
+    document.body.addEventListener('two-factor', this.twoFactorListener)
+  }
+
+  disconnectedCallback() {
+    this.loginForm?.removeEventListener('submit', this.submitListener)
+    // @ts-ignore
+    document.body.removeEventListener('user', this.userListener)
+    // @ts-ignore
+    document.body.removeEventListener('two-factor', this.twoFactorListener)
   }
 
   /**
@@ -89,12 +137,53 @@ export default class Login extends HTMLElement {
                         <button class="primary my--16" type="submit">Login</button>
                     </form>
 
+                    <p class="ml--16 oidc-providers"></p>
                     <p class="ml--16">Don't have an account? <a href="#/register">Sign up here</a></p>
+                    <p class="ml--16"><a href="#/password/forgot">Forgot your password?</a></p>
                 </div>
             </div>
         </div>
       `
   }
+  /**
+   * renders a link to sign in with each identity provider
+   *
+   * @return {void}
+   */
+  renderProviders() {
+    fetch(`${Environment.fetchBaseUrl}/oidc/providers`, { ...Environment.fetchHeaders })
+      .then(response => response.json())
+      .then(data => {
+        const paragraph = this.querySelector('.oidc-providers')
+        if (!paragraph || !data.providers) return
+        paragraph.innerHTML = ''
+        data.providers.forEach(provider => {
+          const link = document.createElement('a')
+          link.className = 'btn small primary mr--8'
+          link.href = `${Environment.fetchBaseUrl}/oidc/login/${encodeURIComponent(provider.name)}`
+          link.textContent = `Sign in with ${provider.label}`
+          paragraph.appendChild(link)
+        })
+      })
+      .catch(error => console.log(`Error@Providers: ${error}`))
+  }
+
+  /**
+   * replaces the password with the code of the authenticator app
+   *
+   * @return {void}
+   */
+  renderTwoFactor() {
+    const form = this.loginForm
+    if (!form) return
+    form.innerHTML = /* html */`
+                                <label for="code">Code:</label>
+                                <input placeholder="Enter the code of your authenticator app, or a recovery code" type="text" id="code" name="code" autocomplete="one-time-code" required>
+                        <button class="primary my--16" type="submit">Verify</button>
+    `
+    this.codeField?.focus()
+  }
+
   /**
    * @return {HTMLFormElement | null}
    */
@@ -114,6 +203,12 @@ export default class Login extends HTMLElement {
   get passwordField() {
     return document.querySelector('input[name="password"]')
   }
+  /**
+  * @return {HTMLInputElement | null}
+  */
+  get codeField() {
+    return this.querySelector('input[name="code"]')
+  }
   get errorMessages() {
     return this.querySelector('.error-messages')
   }
@@ -0,0 +1,143 @@
+// @ts-check
+/* global HTMLElement */
+/* global location */
+/* global self */
+
+import { Environment } from "../lib/environment.js";
+
+/**
+ * As a page, this component becomes a domain dependent container and shall hold organisms, molecules and/or atoms
+ * It asks for a reset link (#/password/forgot), resets the password with it (#/password/reset/token)
+ * or changes the password of the user in session (#/password/change)
+ *
+ * @export
+ * @class Password
+ */
+export default class Password extends HTMLElement {
+  constructor() {
+    super()
+
+    this.submitListener = (e) => {
+      if (!this.form?.checkValidity()) return
+      e.preventDefault()
+
+      const fields = Object.fromEntries(new FormData(this.form))
+      const [url, body, next] = {
+        forgot: ['/password/forgot', { email: fields.email }, null],
+        reset: ['/password/reset', { token: this.token, newPassword: fields.newPassword }, '#/login'],
+        change: ['/password/change', { currentPassword: fields.currentPassword, newPassword: fields.newPassword }, '#/'],
+      }[this.mode]
+
+      fetch(`${Environment.fetchBaseUrl}${url}`, {
+        method: 'POST',
+        ...Environment.fetchHeaders,
+        body: JSON.stringify(body),
+      })
+        .then(async response => {
+          const data = await response.json()
+          if (data.errors) throw data.errors
+          Environment.toastWidget.showToast(data.message, 'infos')
+          if (next) self.location.hash = next
+        })
+        .catch(error => Environment.toastWidget.showToast(String(error), 'error'))
+    }
+  }
+
+  connectedCallback() {
+    this.render()
+    this.form?.addEventListener('submit', this.submitListener)
+    if (this.mode === 'change') this.renderProviders()
+  }
+
+  disconnectedCallback() {
+    this.form?.removeEventListener('submit', this.submitListener)
+  }
+
+  /**
+   * @return {'forgot' | 'reset' | 'change'}
+   */
+  get mode() {
+    if (/^#\/password\/reset\//.test(location.hash)) return 'reset'
+    if (/^#\/password\/change$/.test(location.hash)) return 'change'
+    return 'forgot'
+  }
+
+  get token() {
+    return location.hash.replace(/^#\/password\/reset\//, '')
+  }
+
+  /**
+   * renders the form of the mode
+   *
+   * @return {void}
+   */
+  render() {
+    const newPassword = /* html */`
+      <label for="newPassword">New password:</label>
+      <input placeholder="Enter your new password" type="password" id="newPassword" name="newPassword" autocomplete="new-password" required>`
+    const [title, fields, button] = {
+      forgot: ['Forgot Your Password?', /* html */`
+        <label for="email">Email:</label>
+        <input placeholder="Enter the email of your account" type="email" id="email" name="email" autocomplete="email" required>`, 'Send me a reset link'],
+      reset: ['Choose a New Password', newPassword, 'Reset password'],
+      change: ['Change Your Password', /* html */`
+        <label for="currentPassword">Current password:</label>
+        <input placeholder="Enter your current password" type="password" id="currentPassword" name="currentPassword" autocomplete="current-password" required>
+        ${newPassword}`, 'Change password'],
+    }[this.mode]
+
+    this.innerHTML = /* html */`
+        <div class="l-grid__item">
+            <div class="card align--center justify--center f-height">
+                <div class="card__header">
+                    <h2>${title}</h2>
+                </div>
+                <div class="card__body px--32">
+                    <form id="password-form">
+                        ${fields}
+                        <button class="primary my--16" type="submit">${button}</button>
+                    </form>
+                    <p class="ml--16 oidc-providers"></p>
+                    <p class="ml--16">${this.mode === 'change' ? '<a href="#/">Back to the forum</a>' : '<a href="#/login">Back to login</a>'}</p>
+                </div>
+            </div>
+        </div>
+      `
+  }
+
+  /**
+   * renders the identity providers linked to the account, and a link to link the others
+   *
+   * @return {void}
+   */
+  renderProviders() {
+    fetch(`${Environment.fetchBaseUrl}/oidc/providers`, { ...Environment.fetchHeaders })
+      .then(response => response.json())
+      .then(data => {
+        const paragraph = this.querySelector('.oidc-providers')
+        if (!paragraph || !data.providers) return
+        data.providers.forEach(provider => {
+          if (data.linked.includes(provider.name)) {
+            const span = document.createElement('span')
+            span.className = 'mr--8'
+            span.textContent = `${provider.label} linked ✓`
+            paragraph.appendChild(span)
+            return
+          }
+          const link = document.createElement('a')
+          link.className = 'btn small primary mr--8'
+          link.href = `${Environment.fetchBaseUrl}/oidc/login/${encodeURIComponent(provider.name)}`
+          link.textContent = `Link ${provider.label}`
+          paragraph.appendChild(link)
+        })
+      })
+      .catch(error => console.log(`Error@Providers: ${error}`))
+  }
+
+  /**
+   * @return {HTMLFormElement | null}
+   */
+  get form() {
+    return this.querySelector('form')
+  }
+}
@@ -0,0 +1,194 @@
+// @ts-check
+/* global HTMLElement */
+
+import { Environment } from "../lib/environment.js";
+
+/**
+ * As a page, this component becomes a domain dependent container and shall hold organisms, molecules and/or atoms
+ * It lists, creates and revokes the API tokens of the user in session and of their bots (#/tokens)
+ *
+ * @export
+ * @class Tokens
+ */
+export default class Tokens extends HTMLElement {
+  constructor() {
+    super()
+
+    /** @type {string} the bot whose tokens are shown, empty for the user */
+    this.bot = ''
+
+    this.changeListener = (e) => {
+      if (!(e.target instanceof HTMLSelectElement) || e.target.name !== 'account') return
+      this.bot = e.target.value
+      this.load()
+    }
+
+    this.clickListener = (e) => {
+      const button = e.target instanceof HTMLElement ? e.target.closest('button[data-action]') : null
+      if (!button) return
+      const form = button.closest('form')
+      if (form && !form.checkValidity()) return
+      e.preventDefault()
+
+      const fields = form ? new FormData(form) : new FormData()
+      const action = button.getAttribute('data-action')
+      const query = this.bot ? `?bot=${encodeURIComponent(this.bot)}` : ''
+      const request = {
+        'create-token': () => this.request('POST', '/token', {
+          name: fields.get('name'),
+          scopes: fields.getAll('scopes'),
+          expiresInDays: Number(fields.get('expiresInDays')),
+          bot: this.bot,
+        }),
+        revoke: () => this.request('DELETE', `/token/${button.getAttribute('data-id')}${query}`),
+        'create-bot': () => this.request('POST', '/bot', { nickname: fields.get('nickname') }),
+      }[action || '']
+      if (!request) return
+
+      request()
+        .then(data => {
+          if (data.message) Environment.toastWidget.showToast(data.message, 'infos')
+          if (data.bot) this.bot = data.bot.id
+          return this.load().then(() => { if (data.token) this.renderSecret(data.token) })
+        })
+        .catch(error => Environment.toastWidget.showToast(String(error), 'error'))
+    }
+  }
+
+  connectedCallback() {
+    this.addEventListener('click', this.clickListener)
+    this.addEventListener('change', this.changeListener)
+    this.load()
+  }
+
+  disconnectedCallback() {
+    this.removeEventListener('click', this.clickListener)
+    this.removeEventListener('change', this.changeListener)
+  }
+
+  /**
+   * fetches the bots of the user and the tokens of the selected account
+   *
+   * @return {Promise<void>}
+   */
+  load() {
+    const query = this.bot ? `?bot=${encodeURIComponent(this.bot)}` : ''
+    return Promise.all([this.request('GET', '/bots'), this.request('GET', `/tokens${query}`)])
+      .then(([bots, tokens]) => this.render(bots.bots, tokens.tokens, tokens.scopes))
+      .catch(error => Environment.toastWidget.showToast(String(error), 'error'))
+  }
+
+  /**
+   * @param {string} method
+   * @param {string} url
+   * @param {any} [body]
+   * @return {Promise<any>}
+   */
+  request(method, url, body) {
+    return fetch(`${Environment.fetchBaseUrl}${url}`, {
+      method,
+      ...Environment.fetchHeaders,
+      body: body ? JSON.stringify(body) : undefined,
+    }).then(async response => {
+      const data = await response.json()
+      if (data.errors) throw data.errors
+      return data
+    })
+  }
+
+  /**
+   * @param {{id: string, nickname: string}[]} bots
+   * @param {{id: string, name: string, scopes: string[], expireAt: string | null, lastUsedAt: string | null, lastUsedIP: string}[]} tokens
+   * @param {string[]} scopes
+   * @return {void}
+   */
+  render(bots, tokens, scopes) {
+    this.innerHTML = /* html */`
+        <div class="l-grid__item">
+            <div class="card align--center justify--center f-height">
+                <div class="card__header">
+                    <h2>API Tokens</h2>
+                </div>
+                <div class="card__body px--32">
+                    <p>Scripts and bots send a token in the <code>Authorization: Bearer</code> header to act as an account.</p>
+                    <label for="account">Account:</label>
+                    <select id="account" name="account">
+                        <option value="">${Environment.auth?.nickname || 'You'}</option>
+                    </select>
+                    <div class="token-secret"></div>
+                    <ul class="tokens"></ul>
+                    <form>
+                        <label for="name">Name:</label>
+                        <input placeholder="What the token is for" type="text" id="name" name="name" maxlength="50" required>
+                        <div class="scopes"></div>
+                        <label for="expiresInDays">Expires:</label>
+                        <select id="expiresInDays" name="expiresInDays">
+                            <option value="30">In 30 days</option>
+                            <option value="90">In 90 days</option>
+                            <option value="365">In a year</option>
+                            <option value="0">Never</option>
+                        </select>
+                        <button class="primary my--16" type="submit" data-action="create-token">Create token</button>
+                    </form>
+                    <h3>Bots</h3>
+                    <p>A bot is an account without password nor email, acting only through its tokens.</p>
+                    <form>
+                        <label for="nickname">Nickname:</label>
+                        <input placeholder="The nickname of the bot" type="text" id="nickname" name="nickname" required>
+                        <button class="primary my--16" type="submit" data-action="create-bot">Create bot</button>
+                    </form>
+                </div>
+            </div>
+        </div>
+      `
+    // user provided values are set as text, never as HTML
+    const account = this.querySelector('select[name=account]')
+    bots.forEach(bot => {
+      const option = document.createElement('option')
+      option.value = bot.id
+      option.textContent = `🤖 ${bot.nickname}`
+      account?.appendChild(option)
+    })
+    if (account instanceof HTMLSelectElement) account.value = this.bot
+
+    const scopeList = this.querySelector('.scopes')
+    scopes.forEach(scope => {
+      const label = document.createElement('label')
+      label.innerHTML = /* html */`<input type="checkbox" name="scopes" ${scope === 'read' ? 'checked' : ''}> `
+      label.querySelector('input')?.setAttribute('value', scope)
+      label.append(scope)
+      scopeList?.appendChild(label)
+    })
+
+    const list = this.querySelector('.tokens')
+    if (!tokens.length) list?.insertAdjacentHTML('beforeend', '<li>No tokens yet.</li>')
+    tokens.forEach(token => {
+      const item = document.createElement('li')
+      const expired = token.expireAt && new Date(token.expireAt) < new Date()
+      item.textContent = `${token.name} (${token.scopes.join(', ')}) · ` +
+        (token.expireAt ? `${expired ? 'expired' : 'expires'} ${new Date(token.expireAt).toLocaleDateString()}` : 'never expires') + ' · ' +
+        (token.lastUsedAt ? `last used ${new Date(token.lastUsedAt).toLocaleString()} from ${token.lastUsedIP}` : 'never used') + ' '
+      const revoke = document.createElement('button')
+      revoke.className = 'primary small'
+      revoke.setAttribute('data-action', 'revoke')
+      revoke.setAttribute('data-id', token.id)
+      revoke.textContent = 'Revoke'
+      item.appendChild(revoke)
+      list?.appendChild(item)
+    })
+  }
+
+  /**
+   * shows a new token, the only time it can be read
+   *
+   * @param {string} token
+   * @return {void}
+   */
+  renderSecret(token) {
+    const secret = this.querySelector('.token-secret')
+    if (!secret) return
+    secret.innerHTML = /* html */`<p>Copy this token now, it won't be shown again:</p><p><code></code></p>`
+    const code = secret.querySelector('code')
+    if (code) code.textContent = token
+  }
+}
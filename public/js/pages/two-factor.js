@@ -0,0 +1,157 @@
+// @ts-check
+/* global HTMLElement */
+
+import { Environment } from "../lib/environment.js";
+
+/**
+ * As a page, this component becomes a domain dependent container and shall hold organisms, molecules and/or atoms
+ * It sets up, or disables, the two-factor authentication of the user in session (#/two-factor)
+ *
+ * @export
+ * @class TwoFactor
+ */
+export default class TwoFactor extends HTMLElement {
+  constructor() {
+    super()
+
+    this.clickListener = (e) => {
+      const button = e.target instanceof HTMLElement ? e.target.closest('button[data-action]') : null
+      if (!button) return
+      const form = this.querySelector('form')
+      if (form && !form.checkValidity()) return
+      e.preventDefault()
+
+      const fields = form ? Object.fromEntries(new FormData(form)) : {}
+      const action = button.getAttribute('data-action')
+      const [url, body] = {
+        setup: ['/two-factor/setup', {}],
+        enable: ['/two-factor/enable', { code: fields.code }],
+        disable: ['/two-factor/disable', { password: fields.password }],
+        'recovery-codes': ['/two-factor/recovery-codes', { password: fields.password }],
+      }[action || 'setup']
+
+      this.post(url, body)
+        .then(data => {
+          if (data.message) Environment.toastWidget.showToast(data.message, 'infos')
+          if (action === 'setup') this.renderSetup(data.secret, data.uri)
+          else if (data.recoveryCodes) this.renderRecoveryCodes(data.recoveryCodes)
+          else this.load()
+        })
+        .catch(error => Environment.toastWidget.showToast(String(error), 'error'))
+    }
+  }
+
+  connectedCallback() {
+    this.addEventListener('click', this.clickListener)
+    this.load()
+  }
+
+  disconnectedCallback() {
+    this.removeEventListener('click', this.clickListener)
+  }
+
+  /**
+   * fetches whether two-factor authentication is enabled
+   *
+   * @return {void}
+   */
+  load() {
+    fetch(`${Environment.fetchBaseUrl}/two-factor`, { ...Environment.fetchHeaders })
+      .then(async response => {
+        const data = await response.json()
+        if (data.errors) throw data.errors
+        this.renderStatus(data.enabled, data.recoveryCodesLeft)
+      })
+      .catch(error => Environment.toastWidget.showToast(String(error), 'error'))
+  }
+
+  /**
+   * @param {string} url
+   * @param {any} body
+   * @return {Promise<any>}
+   */
+  post(url, body) {
+    return fetch(`${Environment.fetchBaseUrl}${url}`, {
+      method: 'POST',
+      ...Environment.fetchHeaders,
+      body: JSON.stringify(body),
+    }).then(async response => {
+      const data = await response.json()
+      if (data.errors) throw data.errors
+      return data
+    })
+  }
+
+  /**
+   * @param {boolean} enabled
+   * @param {number} recoveryCodesLeft
+   * @return {void}
+   */
+  renderStatus(enabled, recoveryCodesLeft) {
+    this.renderCard(enabled ? /* html */`
+      <p>Signing in asks for a code of your authenticator app. You have ${recoveryCodesLeft} recovery code(s) left.</p>
+      <form>
+        <label for="password">Password:</label>
+        <input placeholder="Enter your password to confirm" type="password" id="password" name="password" autocomplete="current-password" required>
+        <button class="primary my--16" type="submit" data-action="recovery-codes">New recovery codes</button>
+        <button class="primary my--16" type="submit" data-action="disable">Disable</button>
+      </form>` : /* html */`
+      <p>Protect your account with a code of an authenticator app on top of your password.</p>
+      <button class="primary my--16" data-action="setup">Set up two-factor authentication</button>`)
+  }
+
+  /**
+   * @param {string} secret
+   * @param {string} uri
+   * @return {void}
+   */
+  renderSetup(secret, uri) {
+    this.renderCard(/* html */`
+      <p>Add this key to your authenticator app, or open <a class="totp-uri">this link</a> on your phone:</p>
+      <p><code class="totp-secret"></code></p>
+      <form>
+        <label for="code">Code:</label>
+        <input placeholder="Enter the code shown by the app" type="text" id="code" name="code" inputmode="numeric" autocomplete="one-time-code" required>
+        <button class="primary my--16" type="submit" data-action="enable">Enable</button>
+      </form>`)
+    this.querySelector('.totp-uri')?.setAttribute('href', uri)
+    const code = this.querySelector('.totp-secret')
+    if (code) code.textContent = secret
+  }
+
+  /**
+   * @param {string[]} recoveryCodes
+   * @return {void}
+   */
+  renderRecoveryCodes(recoveryCodes) {
+    this.renderCard(/* html */`
+      <p>Each of these codes signs you in once without the app. They won't be shown again:</p>
+      <ul class="recovery-codes"></ul>
+      <p><a href="#/">Back to the forum</a></p>`)
+    const list = this.querySelector('.recovery-codes')
+    recoveryCodes.forEach(recoveryCode => {
+      const item = document.createElement('li')
+      item.textContent = recoveryCode
+      list?.appendChild(item)
+    })
+  }
+
+  /**
+   * @param {string} body
+   * @return {void}
+   */
+  renderCard(body) {
+    this.innerHTML = /* html */`
+        <div class="l-grid__item">
+            <div class="card align--center justify--center f-height">
+                <div class="card__header">
+                    <h2>Two-Factor Authentication</h2>
+                </div>
+                <div class="card__body px--32">
+                    ${body}
+                </div>
+            </div>
+        </div>
+      `
+  }
+}
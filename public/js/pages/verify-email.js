@@ -0,0 +1,55 @@
+// @ts-check
+/* global HTMLElement */
+/* global location */
+
+import { Environment } from "../lib/environment.js";
+
+/**
+ * As a page, this component becomes a domain dependent container and shall hold organisms, molecules and/or atoms
+ * It verifies the email with the token of the link (#/verify-email/token)
+ *
+ * @export
+ * @class VerifyEmail
+ */
+export default class VerifyEmail extends HTMLElement {
+  connectedCallback() {
+    this.render('Verifying your email…')
+    fetch(`${Environment.fetchBaseUrl}/verify-email`, {
+      method: 'POST',
+      ...Environment.fetchHeaders,
+      body: JSON.stringify({ token: location.hash.replace(/^#\/verify-email\//, '') }),
+    })
+      .then(async response => {
+        const data = await response.json()
+        if (data.errors) throw data.errors
+        const auth = Environment.auth
+        if (auth) Environment.auth = { ...auth, email_verified: true }
+        this.render(data.message)
+      })
+      .catch(error => this.render(String(error)))
+  }
+
+  /**
+   * renders the result of the verification
+   *
+   * @param {string} message
+   * @return {void}
+   */
+  render(message) {
+    this.innerHTML = /* html */`
+        <div class="l-grid__item">
+            <div class="card align--center justify--center f-height">
+                <div class="card__header">
+                    <h2>Email Verification</h2>
+                </div>
+                <div class="card__body px--32">
+                    <p class="verification-message"></p>
+                    <p class="ml--16"><a href="#/">Go to the forum</a></p>
+                </div>
+            </div>
+        </div>
+      `
+    const paragraph = this.querySelector('.verification-message')
+    if (paragraph) paragraph.textContent = message
+  }
+}
@@ -23,7 +23,9 @@ export default class ChatPreview extends HTMLElement {
     this.chat = chat || JSON.parse((this.getAttribute('chat') || '').replace(/'/g, '"') || '{}')
     this.index = `${index + 1}`
     this.updateStatus = (event) => {
-      this.chat.is_connected = event.detail
+      this.chat.is_connected = event.detail.online
+      this.chat.presence = event.detail.presence
+      this.chat.last_seen = event.detail.lastSeen
       this.render(this.chat)
     }
 
@@ -77,7 +79,7 @@ export default class ChatPreview extends HTMLElement {
       <div class="card item">
           <div class="card__body">
               <div class="display--flex flex--col f-width">
-                  <h4 class="mr--16"><a class="not" href="#/chat/${chat.id}">${chat.nickname} ${chat.is_connected ? '🟢' : '🔴'}</a></h4>
+                  <h4 class="mr--16"><a class="not" href="#/chat/${chat.id}">${chat.nickname}${chat.is_bot ? ' 🤖' : ''} ${chat.presence === 'away' ? '🟠' : chat.is_connected ? '🟢' : '🔴'}</a></h4>
                   <div class="display--flex f-width justify--space-between mb--8">
                       <span class="last-msg text--small text--gray">${chat.last_message ? chat.last_message : 'No messages'}</span>
                       <span class="last-msg-date text--small text--gray">${chat.last_message_time}</span>
@@ -55,6 +55,12 @@ export default class CommentList extends HTMLElement {
 
         // @ts-ignore
         document.body.addEventListener('comment-' + this.postID, this.newComment)
+        this.dispatchEvent(new CustomEvent('subscribe', {
+            detail: { topic: 'post:' + this.postID },
+            bubbles: true,
+            cancelable: true,
+            composed: true
+        }))
         // on every connect it will attempt to get newest comments
         this.dispatchEvent(new CustomEvent('get-comments', {
             detail: {
@@ -69,6 +75,15 @@ export default class CommentList extends HTMLElement {
     disconnectedCallback() {
         // @ts-ignore
         document.body.removeEventListener('list-comments', this.commentsListener)
+        // @ts-ignore
+        document.body.removeEventListener('comment-' + this.postID, this.newComment)
+        // detached from the page, the event is sent to the socket directly
+        document.querySelector('c-socket')?.dispatchEvent(new CustomEvent('unsubscribe', {
+            detail: { topic: 'post:' + this.postID },
+            bubbles: true,
+            cancelable: true,
+            composed: true
+        }))
     }
 
     /**
@@ -0,0 +1,51 @@
+package tests
+
+import (
+	"real-time-forum/data/models"
+	"testing"
+	"time"
+)
+
+func TestActivityRepository(t *testing.T) {
+	sender, receiver := createTestUser(t, "chatty"), createTestUser(t, "quiet")
+	if isNew, err := models.UserRepo.IsNewAccount(sender.ID, time.Hour); err != nil || !isNew {
+		t.Errorf("Expected a new account, got %v (%v)", isNew, err)
+	}
+
+	for _, title := range []string{"first spam", "second spam"} {
+		post := models.PostCreation{Title: title, Slug: uniqueName(title), Description: "buy my stuff", AuthorID: sender.ID}
+		if err := models.PostRepo.CreatePost(&post); err != nil {
+			t.Fatalf("Error creating post: %v", err)
+		}
+	}
+	activity, err := models.ActivityRepo.Recent(models.ActivityPost, sender.ID, time.Hour)
+	if err != nil || activity.Count != 2 {
+		t.Fatalf("Expected 2 recent posts, got %+v (%v)", activity, err)
+	}
+	if time.Since(activity.Oldest) > time.Minute {
+		t.Errorf("Expected the oldest post to be recent, got %v", activity.Oldest)
+	}
+	if duplicates, _ := models.ActivityRepo.Duplicates(models.ContentPost, sender.ID, "buy my stuff", time.Hour); duplicates.Count != 2 {
+		t.Errorf("Expected 2 duplicates, got %+v", duplicates)
+	}
+	if duplicates, _ := models.ActivityRepo.Duplicates(models.ContentPost, sender.ID, "something else", time.Hour); duplicates.Count != 0 {
+		t.Errorf("Expected no duplicate, got %+v", duplicates)
+	}
+
+	if known, _ := models.ActivityRepo.HasConversation(receiver.ID, sender.ID); known {
+		t.Error("Expected no conversation yet")
+	}
+	message := models.Message{SenderID: sender.ID, ReceiverID: receiver.ID, Content: "hello"}
+	if err := models.MessageRepo.CreateMessage(&message); err != nil {
+		t.Fatalf("Error creating message: %v", err)
+	}
+	if known, _ := models.ActivityRepo.HasConversation(receiver.ID, sender.ID); !known {
+		t.Error("Expected the conversation to exist")
+	}
+	if started, _ := models.ActivityRepo.Recent(models.ActivityConversation, sender.ID, time.Hour); started.Count != 1 {
+		t.Errorf("Expected 1 conversation started by the sender, got %+v", started)
+	}
+	if started, _ := models.ActivityRepo.Recent(models.ActivityConversation, receiver.ID, time.Hour); started.Count != 0 {
+		t.Errorf("Expected no conversation started by the receiver, got %+v", started)
+	}
+}
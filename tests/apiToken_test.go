@@ -0,0 +1,136 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"real-time-forum/data/models"
+	"real-time-forum/handler"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPITokenRepository(t *testing.T) {
+	user := createTestUser(t, "scripter")
+
+	token, apiToken, err := models.TokenRepo.CreateToken(user.ID, "backup", []models.Permission{models.PermissionRead}, 0)
+	if err != nil {
+		t.Fatalf("Error creating token: %v", err)
+	}
+	if !strings.HasPrefix(token, models.APITokenPrefix) || apiToken.ExpireAt != nil {
+		t.Errorf("Expected a prefixed token never expiring, got %q expiring at %v", token, apiToken.ExpireAt)
+	}
+
+	authenticated, err := models.TokenRepo.Authenticate(token, "192.0.2.1")
+	if err != nil || authenticated == nil || authenticated.UserID != user.ID {
+		t.Fatalf("Expected the token to authenticate the user (%v)", err)
+	}
+	if !authenticated.HasScope(models.PermissionRead) || authenticated.HasScope(models.PermissionPost) {
+		t.Errorf("Expected only the read scope, got %v", authenticated.Scopes)
+	}
+	if authenticated, _ := models.TokenRepo.Authenticate(token+"x", "192.0.2.1"); authenticated != nil {
+		t.Error("Expected a wrong token not to authenticate")
+	}
+	if models.TokenRepo.GetTokenUserID(token) != user.ID {
+		t.Error("Expected the token to identify the user")
+	}
+
+	tokens, _ := models.TokenRepo.GetTokens(user.ID)
+	if len(tokens) != 1 || tokens[0].LastUsedAt == nil || tokens[0].LastUsedIP != "192.0.2.1" {
+		t.Fatalf("Expected the last use of the token to be recorded, got %+v", tokens)
+	}
+
+	expired, _, _ := models.TokenRepo.CreateToken(user.ID, "old", []models.Permission{models.PermissionRead}, -time.Hour)
+	if authenticated, _ := models.TokenRepo.Authenticate(expired, "192.0.2.1"); authenticated != nil {
+		t.Error("Expected an expired token not to authenticate")
+	}
+	if count, _ := models.TokenRepo.CountTokens(user.ID); count != 2 {
+		t.Errorf("Expected 2 tokens, got %d", count)
+	}
+
+	if deleted, _ := models.TokenRepo.DeleteToken(apiToken.ID, "someone-else"); deleted {
+		t.Error("Expected a token not to be revoked by another user")
+	}
+	if deleted, _ := models.TokenRepo.DeleteToken(apiToken.ID, user.ID); !deleted {
+		t.Error("Expected the token to be revoked")
+	}
+	if authenticated, _ := models.TokenRepo.Authenticate(token, "192.0.2.1"); authenticated != nil {
+		t.Error("Expected a revoked token not to authenticate")
+	}
+}
+
+func TestBotAccounts(t *testing.T) {
+	owner := createTestUser(t, "botmaker")
+	nickname := uniqueName("helper")
+	bot := models.User{Nickname: strings.ToUpper(nickname)}
+	if err := models.UserRepo.CreateBot(&bot, owner.ID); err != nil {
+		t.Fatalf("Error creating bot: %v", err)
+	}
+	if err := models.UserRepo.CreateBot(&models.User{Nickname: nickname}, owner.ID); err == nil {
+		t.Error("Expected a taken nickname to be refused")
+	}
+
+	bots, _ := models.UserRepo.GetBots(owner.ID)
+	if len(bots) != 1 || bots[0].Nickname != nickname {
+		t.Fatalf("Expected the bot of the owner, got %+v", bots)
+	}
+	stored, _ := models.UserRepo.GetUserByID(bot.ID)
+	if stored == nil || !stored.Bot || stored.OwnerID != owner.ID || stored.Email != "" {
+		t.Fatalf("Expected a bot without email run by its owner, got %+v", stored)
+	}
+	if _, exists := models.UserRepo.IsExistedByIdentifiant(nickname); !exists {
+		t.Error("Expected the bot to be found by nickname")
+	}
+}
+
+func TestBearerAuthorization(t *testing.T) {
+	user := createTestUser(t, "bearer")
+	token, _, err := models.TokenRepo.CreateToken(user.ID, "test", []models.Permission{models.PermissionRead}, time.Hour)
+	if err != nil {
+		t.Fatalf("Error creating token: %v", err)
+	}
+
+	request := func(serve http.HandlerFunc, method, path, authorization string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		res := httptest.NewRecorder()
+		serve(res, req)
+		return res
+	}
+
+	if res := request(handler.Me, http.MethodGet, "/me", "Bearer "+token); res.Code != http.StatusOK || !strings.Contains(res.Body.String(), `"`+user.Nickname+`"`) {
+		t.Errorf("Expected the token to authenticate /me, got %d %s", res.Code, res.Body)
+	}
+	if res := request(handler.Me, http.MethodGet, "/me", "Bearer rtf_unknown"); res.Code != http.StatusUnauthorized || res.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("Expected an unknown token to be refused with a challenge, got %d", res.Code)
+	}
+	// Account security is out of reach of the tokens
+	if res := request(handler.GetTwoFactor, http.MethodGet, "/two-factor", "Bearer "+token); res.Code != http.StatusForbidden {
+		t.Errorf("Expected a token not to manage two-factor authentication, got %d", res.Code)
+	}
+	if res := request(handler.GetTokens, http.MethodGet, "/tokens", "Bearer "+token); res.Code != http.StatusForbidden {
+		t.Errorf("Expected a token not to manage the tokens, got %d", res.Code)
+	}
+	if handler.RequestUserID(httptest.NewRequest(http.MethodGet, "/me", nil)) != "" {
+		t.Error("Expected an anonymous request to have no user")
+	}
+
+	// The rate limiter looks the owner of a token up once in a while only
+	req := httptest.NewRequest(http.MethodGet, "/me", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	if userID := handler.RequestUserID(req); userID != user.ID {
+		t.Fatalf("Expected the token to identify its user, got %q", userID)
+	}
+	tokens, _ := models.TokenRepo.GetTokens(user.ID)
+	if deleted, err := models.TokenRepo.DeleteToken(tokens[0].ID, user.ID); !deleted {
+		t.Fatalf("Error deleting token: %v", err)
+	}
+	if userID := handler.RequestUserID(req); userID != user.ID {
+		t.Errorf("Expected the owner of the token to be cached, got %q", userID)
+	}
+	if res := request(handler.Me, http.MethodGet, "/me", "Bearer "+token); res.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a revoked token to be refused at once, got %d", res.Code)
+	}
+}
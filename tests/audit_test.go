@@ -0,0 +1,46 @@
+package tests
+
+import (
+	"database/sql"
+	"encoding/json"
+	"os"
+	"real-time-forum/data/models"
+	"testing"
+)
+
+func TestAuditRepository_AppendOnly(t *testing.T) {
+	actorID := uniqueName("admin")
+	entry := models.AuditEntry{
+		ActorID:    actorID,
+		Action:     models.AuditRoleGrant,
+		TargetType: models.AuditTargetUser,
+		TargetID:   "user-audit",
+		Before:     json.RawMessage(`{"role":"member"}`),
+		After:      json.RawMessage(`{"role":"moderator"}`),
+		IP:         "127.0.0.1",
+	}
+	if err := models.AuditRepo.Record(&entry); err != nil {
+		t.Fatalf("Error recording entry: %v", err)
+	}
+
+	entries, err := models.AuditRepo.GetEntries(models.AuditFilter{ActorID: actorID, Action: models.AuditRoleGrant})
+	if err != nil {
+		t.Fatalf("Error getting entries: %v", err)
+	}
+	if len(entries) != 1 || string(entries[0].After) != `{"role":"moderator"}` || entries[0].IP != "127.0.0.1" {
+		t.Errorf("Expected the recorded entry, got %+v", entries)
+	}
+
+	// The entries can neither be changed nor removed
+	db, err := sql.Open("sqlite3", os.Getenv("DATABASE"))
+	if err != nil {
+		t.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("UPDATE audit SET action = 'forged' WHERE id = ?", entry.ID); err == nil {
+		t.Errorf("Expected updating the audit log to fail")
+	}
+	if _, err := db.Exec("DELETE FROM audit WHERE id = ?", entry.ID); err == nil {
+		t.Errorf("Expected deleting from the audit log to fail")
+	}
+}
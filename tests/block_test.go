@@ -0,0 +1,130 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"real-time-forum/data/models"
+	"real-time-forum/handler"
+	"strings"
+	"testing"
+)
+
+// createVerifiedUser creates a member who may send messages
+func createVerifiedUser(t *testing.T, name string) models.User {
+	t.Helper()
+	user := createTestUser(t, name)
+	if verified, err := models.UserRepo.VerifyEmail(user.ID, user.Email); !verified {
+		t.Fatalf("Error verifying email: %v", err)
+	}
+	return user
+}
+
+func sendMessage(from, to models.User, text string) *httptest.ResponseRecorder {
+	body := `{"receiverID":"` + to.ID + `","text":"` + text + `"}`
+	res := httptest.NewRecorder()
+	handler.NewMessage(res, signedInRequest(from, http.MethodPost, "/chat/new", strings.NewReader(body)))
+	return res
+}
+
+func listsUser(users []models.UserItem, userID string) *models.UserItem {
+	for i := range users {
+		if users[i].ID == userID {
+			return &users[i]
+		}
+	}
+	return nil
+}
+
+func TestBlockUser(t *testing.T) {
+	blocker, blocked := createVerifiedUser(t, "blocker"), createVerifiedUser(t, "blocked")
+	res := httptest.NewRecorder()
+	handler.BlockUser(res, signedInRequest(blocker, http.MethodPost, "/block/"+blocked.ID, nil))
+	if res.Code != http.StatusOK {
+		t.Fatalf("Expected the user to be blocked, got %d %s", res.Code, res.Body)
+	}
+
+	// Neither can message the other
+	if res := sendMessage(blocked, blocker, "hello"); res.Code != http.StatusForbidden {
+		t.Errorf("Expected a blocked user not to send messages, got %d", res.Code)
+	}
+	if res := sendMessage(blocker, blocked, "hello"); res.Code != http.StatusForbidden {
+		t.Errorf("Expected a user not to message the users they blocked, got %d", res.Code)
+	}
+
+	// The blocked user no longer sees the blocker, who sees them as blocked
+	users, _ := models.UserRepo.SelectAllUsers(blocked.ID)
+	if listsUser(users, blocker.ID) != nil {
+		t.Error("Expected the blocker to be hidden from the blocked user")
+	}
+	users, _ = models.UserRepo.SelectAllUsers(blocker.ID)
+	if listed := listsUser(users, blocked.ID); listed == nil || !listed.IsBlocked {
+		t.Errorf("Expected the blocker to see the user as blocked, got %+v", listed)
+	}
+
+	// Nor does the blocked user get the presence of the blocker
+	blockedClient, otherClient := &recordingTransport{onExit: func() {}}, &recordingTransport{onExit: func() {}}
+	for _, watcher := range []struct {
+		transport *recordingTransport
+		user      models.User
+	}{{blockedClient, blocked}, {otherClient, createTestUser(t, "bystander")}} {
+		client := handler.NewClient(watcher.transport, signedInRequest(watcher.user, http.MethodGet, "/ws", nil))
+		handler.Connections.Register(client)
+		defer handler.Connections.Unregister(client)
+		handler.Connections.Subscribe(client, handler.PresenceTopic)
+	}
+	handler.SendStatus(blocker.ID, handler.PresenceOnline)
+	if statuses := blockedClient.received("status"); len(statuses) != 0 {
+		t.Errorf("Expected the blocked user not to see the blocker online, got %v", statuses)
+	}
+	if statuses := otherClient.received("status"); len(statuses) != 1 {
+		t.Errorf("Expected the others to see the blocker online, got %v", statuses)
+	}
+
+	// Nor their typing
+	typing := handler.NewTypingTracker()
+	client := handler.NewClient(&recordingTransport{}, signedInRequest(blocked, http.MethodGet, "/ws", nil))
+	if err := typing.Typing(client, blocked.ID, []string{blocker.ID}, true); err != handler.ErrInvalidRecipients {
+		t.Errorf("Expected a blocked user not to type to the blocker, got %v", err)
+	}
+
+	res = httptest.NewRecorder()
+	handler.UnblockUser(res, signedInRequest(blocker, http.MethodPost, "/unblock/"+blocked.ID, nil))
+	if res.Code != http.StatusOK {
+		t.Fatalf("Expected the user to be unblocked, got %d", res.Code)
+	}
+	if res := sendMessage(blocked, blocker, "hello again"); res.Code != http.StatusOK {
+		t.Errorf("Expected an unblocked user to send messages, got %d %s", res.Code, res.Body)
+	}
+}
+
+func TestMuteUser(t *testing.T) {
+	talker, listener := createVerifiedUser(t, "talker"), createVerifiedUser(t, "listener")
+	listenerClient := connectTestClient(t, listener)
+
+	res := httptest.NewRecorder()
+	handler.MuteUser(res, signedInRequest(listener, http.MethodPost, "/mute/"+talker.ID, nil))
+	if res.Code != http.StatusOK {
+		t.Fatalf("Expected the conversation to be muted, got %d %s", res.Code, res.Body)
+	}
+	users, _ := models.UserRepo.SelectAllUsers(listener.ID)
+	if listed := listsUser(users, talker.ID); listed == nil || !listed.IsMuted {
+		t.Errorf("Expected the conversation to be listed as muted, got %+v", listed)
+	}
+
+	// A muted conversation still delivers the messages, marked as muted
+	if res := sendMessage(talker, listener, "are you there?"); res.Code != http.StatusOK {
+		t.Fatalf("Expected the message to be sent, got %d %s", res.Code, res.Body)
+	}
+	res = httptest.NewRecorder()
+	handler.UnmuteUser(res, signedInRequest(listener, http.MethodPost, "/unmute/"+talker.ID, nil))
+	if res.Code != http.StatusOK {
+		t.Fatalf("Expected the conversation to be unmuted, got %d", res.Code)
+	}
+	if res := sendMessage(talker, listener, "hello?"); res.Code != http.StatusOK {
+		t.Fatalf("Expected the message to be sent, got %d %s", res.Code, res.Body)
+	}
+	messages := listenerClient.received("message")
+	if len(messages) != 2 || messages[0]["muted"] != true || messages[1]["muted"] != false {
+		t.Errorf("Expected a muted then an unmuted message, got %v", messages)
+	}
+}
@@ -0,0 +1,341 @@
+package tests
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"real-time-forum/lib"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is a local stand-in for a Redis server, implementing the few
+// commands the broker uses.
+type fakeRedis struct {
+	listener    net.Listener
+	mutex       sync.Mutex
+	counters    map[string]int64
+	hashes      map[string]map[string]int64
+	sortedSets  map[string]map[string]int64
+	ttls        map[string]int64 // seconds, not enforced
+	subscribers map[string][]net.Conn
+	commands    map[string]int // number of calls per command
+	stalled     bool           // reads the commands without answering
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	server := &fakeRedis{
+		listener:    listener,
+		counters:    make(map[string]int64),
+		hashes:      make(map[string]map[string]int64),
+		sortedSets:  make(map[string]map[string]int64),
+		ttls:        make(map[string]int64),
+		subscribers: make(map[string][]net.Conn),
+		commands:    make(map[string]int),
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+	return server
+}
+
+func (s *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+		s.mutex.Lock()
+		if s.stalled {
+			s.mutex.Unlock()
+			continue
+		}
+		s.commands[strings.ToUpper(args[0])]++
+		switch strings.ToUpper(args[0]) {
+		case "INCR":
+			s.counters[args[1]]++
+			fmt.Fprintf(conn, ":%d\r\n", s.counters[args[1]])
+		case "HINCRBY":
+			if s.hashes[args[1]] == nil {
+				s.hashes[args[1]] = make(map[string]int64)
+			}
+			by, _ := strconv.ParseInt(args[3], 10, 64)
+			s.hashes[args[1]][args[2]] += by
+			fmt.Fprintf(conn, ":%d\r\n", s.hashes[args[1]][args[2]])
+		case "HGET":
+			if value, ok := s.hashes[args[1]][args[2]]; ok {
+				value := strconv.FormatInt(value, 10)
+				fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(value), value)
+			} else {
+				fmt.Fprint(conn, "$-1\r\n")
+			}
+		case "HMGET":
+			fmt.Fprintf(conn, "*%d\r\n", len(args)-2)
+			for _, field := range args[2:] {
+				if value, ok := s.hashes[args[1]][field]; ok {
+					value := strconv.FormatInt(value, 10)
+					fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(value), value)
+				} else {
+					fmt.Fprint(conn, "$-1\r\n")
+				}
+			}
+		case "HDEL":
+			delete(s.hashes[args[1]], args[2])
+			fmt.Fprint(conn, ":1\r\n")
+		case "EXPIRE":
+			s.ttls[args[1]], _ = strconv.ParseInt(args[2], 10, 64)
+			fmt.Fprint(conn, ":1\r\n")
+		case "DEL":
+			delete(s.hashes, args[1])
+			delete(s.ttls, args[1])
+			fmt.Fprint(conn, ":1\r\n")
+		case "ZADD":
+			if s.sortedSets[args[1]] == nil {
+				s.sortedSets[args[1]] = make(map[string]int64)
+			}
+			s.sortedSets[args[1]][args[3]], _ = strconv.ParseInt(args[2], 10, 64)
+			fmt.Fprint(conn, ":1\r\n")
+		case "ZREM":
+			delete(s.sortedSets[args[1]], args[2])
+			fmt.Fprint(conn, ":1\r\n")
+		case "ZRANGEBYSCORE", "ZREMRANGEBYSCORE":
+			var members []string
+			for member, score := range s.sortedSets[args[1]] {
+				if inRange(score, args[2], args[3]) {
+					members = append(members, member)
+				}
+			}
+			if strings.ToUpper(args[0]) == "ZREMRANGEBYSCORE" {
+				for _, member := range members {
+					delete(s.sortedSets[args[1]], member)
+				}
+				fmt.Fprintf(conn, ":%d\r\n", len(members))
+				break
+			}
+			fmt.Fprintf(conn, "*%d\r\n", len(members))
+			for _, member := range members {
+				fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(member), member)
+			}
+		case "SUBSCRIBE":
+			s.subscribers[args[1]] = append(s.subscribers[args[1]], conn)
+			fmt.Fprintf(conn, "*3\r\n$9\r\nsubscribe\r\n$%d\r\n%s\r\n:1\r\n", len(args[1]), args[1])
+		case "PUBLISH":
+			for _, subscriber := range s.subscribers[args[1]] {
+				fmt.Fprintf(subscriber, "*3\r\n$7\r\nmessage\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(args[1]), args[1], len(args[2]), args[2])
+			}
+			fmt.Fprintf(conn, ":%d\r\n", len(s.subscribers[args[1]]))
+		default:
+			fmt.Fprintf(conn, "-ERR unknown command '%s'\r\n", args[0])
+		}
+		s.mutex.Unlock()
+	}
+}
+
+// inRange checks a score against the bounds of ZRANGEBYSCORE, "-inf", "+inf"
+// or a number, exclusive when prefixed with "("
+func inRange(score int64, min, max string) bool {
+	bound := func(value string, infinite int64) (int64, bool) {
+		switch value {
+		case "-inf", "+inf":
+			return infinite, false
+		}
+		exclusive := strings.HasPrefix(value, "(")
+		number, _ := strconv.ParseInt(strings.TrimPrefix(value, "("), 10, 64)
+		return number, exclusive
+	}
+	low, lowExclusive := bound(min, -1<<62)
+	high, highExclusive := bound(max, 1<<62)
+	return (score > low || !lowExclusive && score == low) && (score < high || !highExclusive && score == high)
+}
+
+// dropSubscribers breaks the subscriptions, as when Redis restarts
+func (s *fakeRedis) dropSubscribers() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for channel, subscribers := range s.subscribers {
+		for _, subscriber := range subscribers {
+			subscriber.Close()
+		}
+		delete(s.subscribers, channel)
+	}
+}
+
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	count, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, count)
+	for i := range args {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		args[i] = string(data[:size])
+	}
+	return args, nil
+}
+
+func TestRedisBroker_PublishReachesOtherInstances(t *testing.T) {
+	server := newFakeRedis(t)
+	first, err := lib.NewBroker("redis://" + server.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer first.Close()
+	second, err := lib.NewBroker("redis://" + server.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer second.Close()
+
+	received := make(chan string, 1)
+	if err := second.Subscribe(func(message []byte) { received <- string(message) }, func() {}); err != nil {
+		t.Fatalf("Error subscribing: %v", err)
+	}
+	if err := first.Publish([]byte(`{"type":"post"}`)); err != nil {
+		t.Fatalf("Error publishing: %v", err)
+	}
+
+	select {
+	case message := <-received:
+		if message != `{"type":"post"}` {
+			t.Errorf("Expected the published message, got %q", message)
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("Expected the message to reach the other instance")
+	}
+
+	firstSeq, _ := first.NextSeq()
+	secondSeq, _ := second.NextSeq()
+	if firstSeq == secondSeq {
+		t.Errorf("Expected unique sequence numbers, got %d twice", firstSeq)
+	}
+}
+
+func TestRedisBroker_Presence(t *testing.T) {
+	server := newFakeRedis(t)
+	first, _ := lib.NewBroker("redis://" + server.listener.Addr().String())
+	second, _ := lib.NewBroker("redis://" + server.listener.Addr().String())
+	defer second.Close()
+
+	first.Connect("user123")
+	if count, _ := second.Connect("user123"); count != 2 {
+		t.Errorf("Expected 2 connections on all instances, got %d", count)
+	}
+	server.mutex.Lock()
+	for key := range server.hashes {
+		if server.ttls[key] <= 0 {
+			t.Errorf("Expected the connections of %s to expire without a heartbeat", key)
+		}
+	}
+	// An instance which crashed, its deadline past, no longer counts
+	server.sortedSets["real-time-forum:instances"]["crashed"] = time.Now().Add(-time.Minute).Unix()
+	server.hashes["real-time-forum:presence:crashed"] = map[string]int64{"user123": 5}
+	server.mutex.Unlock()
+	if count, _ := second.Connections("user123"); count != 2 {
+		t.Errorf("Expected the connections of a crashed instance to be ignored, got %d", count)
+	}
+
+	// The connections of several users take one command per instance
+	second.Connect("user456")
+	server.mutex.Lock()
+	server.commands = make(map[string]int)
+	server.mutex.Unlock()
+	counts, err := second.ConnectionsOf([]string{"user123", "user456", "nobody"})
+	if err != nil || counts["user123"] != 2 || counts["user456"] != 1 || len(counts) != 2 {
+		t.Errorf("Expected the connections of the connected users, got %v (%v)", counts, err)
+	}
+	server.mutex.Lock()
+	if server.commands["HMGET"] != 2 || server.commands["ZRANGEBYSCORE"] != 1 || server.commands["HGET"] != 0 {
+		t.Errorf("Expected one HMGET per live instance, got %v", server.commands)
+	}
+	server.mutex.Unlock()
+	second.Disconnect("user456")
+
+	// Closing an instance releases the connections it counted
+	first.Close()
+	if count, _ := second.Connections("user123"); count != 1 {
+		t.Errorf("Expected 1 connection left, got %d", count)
+	}
+	if count, _ := second.Disconnect("user123"); count != 0 {
+		t.Errorf("Expected no connection left, got %d", count)
+	}
+}
+
+func TestRedisBroker_ResyncAfterResubscribe(t *testing.T) {
+	server := newFakeRedis(t)
+	broker, err := lib.NewBroker("redis://" + server.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer broker.Close()
+
+	lost := make(chan struct{}, 1)
+	if err := broker.Subscribe(func(message []byte) {}, func() { lost <- struct{}{} }); err != nil {
+		t.Fatalf("Error subscribing: %v", err)
+	}
+	server.dropSubscribers()
+	select {
+	case <-lost:
+	case <-time.After(3 * time.Second):
+		t.Error("Expected the broker to tell about the lost messages once subscribed again")
+	}
+}
+
+func TestRedisBroker_Timeout(t *testing.T) {
+	server := newFakeRedis(t)
+	broker, err := lib.NewRedisBroker(server.listener.Addr().String(), "")
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer broker.Close()
+	broker.Timeout = 100 * time.Millisecond
+
+	server.mutex.Lock()
+	server.stalled = true
+	server.mutex.Unlock()
+	start := time.Now()
+	if _, err := broker.NextSeq(); err == nil {
+		t.Error("Expected a server not answering to fail the command")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the command to give up after its timeout, took %s", elapsed)
+	}
+	// Nor does it hold the other commands waiting
+	start = time.Now()
+	broker.Connections("user123")
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the next command to give up too, took %s", elapsed)
+	}
+
+	server.mutex.Lock()
+	server.stalled = false
+	server.mutex.Unlock()
+	if seq, err := broker.NextSeq(); err != nil || seq == 0 {
+		t.Errorf("Expected the broker to reconnect once the server answers, got %d (%v)", seq, err)
+	}
+}
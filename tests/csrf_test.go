@@ -0,0 +1,63 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"real-time-forum/data/models"
+	"real-time-forum/handler"
+	"testing"
+)
+
+func TestCSRF(t *testing.T) {
+	signIn := httptest.NewRecorder()
+	models.NewSessionToken(signIn, "csrf-user", "csrfuser")
+	cookies := map[string]*http.Cookie{}
+	for _, cookie := range signIn.Result().Cookies() {
+		cookies[cookie.Name] = cookie
+	}
+	session, csrf := cookies["auth_session"], cookies[models.CSRFCookie]
+	if session == nil || csrf == nil {
+		t.Fatalf("Expected a session and a CSRF cookie, got %v", cookies)
+	}
+	if session.SameSite != http.SameSiteLaxMode || !session.HttpOnly || csrf.HttpOnly {
+		t.Error("Expected a SameSite session cookie, and a CSRF cookie readable by the scripts")
+	}
+
+	protected := handler.CSRF(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusNoContent)
+	}))
+	tests := []struct {
+		name    string
+		method  string
+		session bool
+		token   string
+		header  map[string]string
+		want    int
+	}{
+		{"safe method", http.MethodGet, true, "", nil, http.StatusNoContent},
+		{"no session", http.MethodPost, false, "", nil, http.StatusNoContent},
+		{"missing token", http.MethodPost, true, "", nil, http.StatusForbidden},
+		{"wrong token", http.MethodDelete, true, "forged", nil, http.StatusForbidden},
+		{"valid token", http.MethodPost, true, csrf.Value, nil, http.StatusNoContent},
+		{"API token", http.MethodPost, true, "", map[string]string{"Authorization": "Bearer rtf_x"}, http.StatusNoContent},
+		{"same origin", http.MethodPost, true, csrf.Value, map[string]string{"Origin": "http://forum.test"}, http.StatusNoContent},
+		{"other origin", http.MethodPost, false, "", map[string]string{"Origin": "https://evil.test"}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "http://forum.test/post", nil)
+		if tt.session {
+			req.AddCookie(session)
+		}
+		if tt.token != "" {
+			req.Header.Set(models.CSRFHeader, tt.token)
+		}
+		for key, value := range tt.header {
+			req.Header.Set(key, value)
+		}
+		res := httptest.NewRecorder()
+		protected.ServeHTTP(res, req)
+		if res.Code != tt.want {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.want, res.Code)
+		}
+	}
+}
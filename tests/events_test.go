@@ -0,0 +1,170 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"real-time-forum/data/models"
+	"real-time-forum/handler"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEventStore_Since(t *testing.T) {
+	store := handler.NewEventStore(10, time.Minute)
+
+	store.RecordPublic(1, []string{handler.FeedTopic}, nil, []byte("post"))
+	store.RecordPrivate(2, []string{"user123"}, []byte("message"))
+	store.RecordPublic(3, []string{handler.PresenceTopic}, func(userID string) bool { return userID != "user123" }, []byte("status"))
+
+	everything := func(string) bool { return true }
+	missed, ok := store.Since("user123", 0, everything)
+	if !ok {
+		t.Fatalf("Expected events to be replayable")
+	}
+	if len(missed) != 2 || string(missed[0]) != "post" || string(missed[1]) != "message" {
+		t.Errorf("Expected post and message, got %q", missed)
+	}
+
+	missed, ok = store.Since("user456", 1, everything)
+	if !ok || len(missed) != 1 || string(missed[0]) != "status" {
+		t.Errorf("Expected only the status event, got %q", missed)
+	}
+
+	feedOnly := func(topic string) bool { return topic == handler.FeedTopic }
+	missed, ok = store.Since("user123", 0, feedOnly)
+	if !ok || len(missed) != 1 || string(missed[0]) != "post" {
+		t.Errorf("Expected only the events of the subscribed topics, got %q", missed)
+	}
+}
+
+func TestEventStore_ResyncRequired(t *testing.T) {
+	store := handler.NewEventStore(2, time.Minute)
+	for seq := uint64(1); seq <= 3; seq++ {
+		store.RecordPublic(seq, []string{handler.FeedTopic}, nil, []byte("post"))
+	}
+
+	everything := func(string) bool { return true }
+	if _, ok := store.Since("user123", 0, everything); ok {
+		t.Errorf("Expected a resync when events were evicted")
+	}
+	if _, ok := store.Since("user123", 1, everything); !ok {
+		t.Errorf("Expected the retained events to be replayable")
+	}
+	if _, ok := store.Since("user123", 42, everything); ok {
+		t.Errorf("Expected a resync for a sequence from a previous run")
+	}
+
+	// Events lost by the broker cannot be replayed
+	store.MarkLost(5)
+	if _, ok := store.Since("user123", 3, everything); ok {
+		t.Errorf("Expected a resync after events were lost")
+	}
+	if missed, ok := store.Since("user123", 5, everything); !ok || len(missed) != 0 {
+		t.Errorf("Expected the clients which resynced to be up to date, got %d events (%v)", len(missed), ok)
+	}
+}
+
+func TestEventStore_OutOfOrder(t *testing.T) {
+	store := handler.NewEventStore(10, time.Minute)
+	store.RecordPublic(2, []string{handler.FeedTopic}, nil, []byte("second"))
+	store.RecordPublic(1, []string{handler.FeedTopic}, nil, []byte("first"))
+	store.RecordPublic(0, []string{handler.FeedTopic}, nil, []byte("unnumbered"))
+	store.RecordPublic(2, []string{handler.FeedTopic}, nil, []byte("delivered back"))
+
+	missed, ok := store.Since("user123", 0, func(string) bool { return true })
+	if !ok || len(missed) != 2 || string(missed[0]) != "first" || string(missed[1]) != "second" {
+		t.Errorf("Expected the numbered events in order, once, got %q", missed)
+	}
+}
+
+func TestEventOrder(t *testing.T) {
+	transport := &recordingTransport{onExit: func() {}}
+	client := handler.NewClient(transport, httptest.NewRequest(http.MethodGet, "/ws", nil))
+	handler.Connections.Register(client)
+	defer handler.Connections.Unregister(client)
+	handler.Connections.Subscribe(client, handler.FeedTopic)
+	from := handler.Events.Seq()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handler.SendPost("", models.PostItem{Title: "concurrent"})
+		}()
+	}
+	wg.Wait()
+
+	posts := transport.received("post")
+	if len(posts) != 50 {
+		t.Fatalf("Expected 50 posts, got %d", len(posts))
+	}
+	// The events may go out of order, but each is recorded before a later
+	// one is numbered: a client resuming from any of them misses none.
+	missed, ok := handler.Events.Since("", from, func(topic string) bool { return topic == handler.FeedTopic })
+	if !ok || len(missed) != 50 {
+		t.Fatalf("Expected the 50 posts to be replayable, got %d (%v)", len(missed), ok)
+	}
+	var last float64
+	for _, output := range missed {
+		var event map[string]any
+		json.Unmarshal(output, &event)
+		if event["seq"].(float64) <= last {
+			t.Fatalf("Expected the events replayed in the order of their numbers, got %v after %v", event["seq"], last)
+		}
+		last = event["seq"].(float64)
+	}
+}
+
+func TestStuckClientDoesNotBlockPublishers(t *testing.T) {
+	stuck := &stuckTransport{release: make(chan struct{})}
+	stuckClient := handler.NewClient(stuck, httptest.NewRequest(http.MethodGet, "/ws", nil))
+	handler.Connections.Register(stuckClient)
+	defer handler.Connections.Unregister(stuckClient)
+	handler.Connections.Subscribe(stuckClient, handler.FeedTopic)
+	defer close(stuck.release)
+
+	watcher := &recordingTransport{onExit: func() {}}
+	client := handler.NewClient(watcher, httptest.NewRequest(http.MethodGet, "/ws", nil))
+	handler.Connections.Register(client)
+	defer handler.Connections.Unregister(client)
+	handler.Connections.Subscribe(client, handler.PostTopic("stuck"))
+
+	go handler.SendPost("", models.PostItem{Title: "never read"})
+	time.Sleep(50 * time.Millisecond) // the post is being delivered to the stuck client
+	done := make(chan struct{})
+	go func() {
+		handler.SendComment("stuck", models.CommentItem{})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected a client not reading not to hold back the other events")
+	}
+	if len(watcher.received("comment")) != 1 {
+		t.Error("Expected the comment to be delivered")
+	}
+}
+
+func TestSlowSocketDisconnected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(handler.HandleWebSocket))
+	defer server.Close()
+	user := createTestUser(t, "slow")
+	dialTestSocket(t, server, user) // never read
+	eventually(t, func() bool { return handler.Presence(user.ID) != handler.PresenceOffline }, "Expected the user to be online")
+
+	notice := strings.Repeat("x", 16<<10)
+	start := time.Now()
+	for i := 0; i < 5000 && handler.Presence(user.ID) != handler.PresenceOffline; i++ {
+		handler.SendSecurityNotice(user.ID, notice)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the events not to wait for a client not reading, took %v", elapsed)
+	}
+	eventually(t, func() bool { return handler.Presence(user.ID) == handler.PresenceOffline },
+		"Expected a client not reading to be disconnected once its queue is full")
+}
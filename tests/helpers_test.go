@@ -0,0 +1,97 @@
+package tests
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"real-time-forum/data/models"
+	"real-time-forum/handler"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// uniqueName suffixes a name with a random part, so that the rows a test
+// creates don't collide with those of a previous run on the same database
+func uniqueName(name string) string {
+	ID, _ := uuid.NewV4()
+	return name + "_" + strings.SplitN(ID.String(), "-", 2)[0]
+}
+
+// createTestUser creates a member with a unique nickname and email
+func createTestUser(t *testing.T, name string) models.User {
+	t.Helper()
+	nickname := uniqueName(name)
+	user := models.User{Nickname: nickname, Email: nickname + "@example.com"}
+	if err := models.UserRepo.CreateUser(&user); err != nil {
+		t.Fatalf("Error creating user: %v", err)
+	}
+	return user
+}
+
+// signedInRequest makes a request carrying a new session of the user
+func signedInRequest(user models.User, method, path string, body io.Reader) *http.Request {
+	token := uniqueName("session")
+	models.AllSessions.Store(token, models.Session{UserID: user.ID, Nickname: user.Nickname, ExpireAt: time.Now().Add(time.Hour)})
+	req := httptest.NewRequest(method, path, body)
+	req.AddCookie(&http.Cookie{Name: "auth_session", Value: token})
+	return req
+}
+
+// connectTestClient connects the user like a WebSocket would, keeping the
+// frames of their own topic
+func connectTestClient(t *testing.T, user models.User) *recordingTransport {
+	t.Helper()
+	transport := &recordingTransport{onExit: func() {}}
+	client := handler.NewClient(transport, signedInRequest(user, http.MethodGet, "/ws", nil))
+	if client.User() != user.ID {
+		t.Fatalf("Expected the client to be bound to %s", user.Nickname)
+	}
+	handler.Connections.Register(client)
+	handler.Connections.Subscribe(client, handler.UserTopic(user.ID))
+	t.Cleanup(func() { handler.Connections.Unregister(client) })
+	return transport
+}
+
+// received decodes the frames sent to a client of a type
+func (t *recordingTransport) received(kind string) []map[string]any {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	var events []map[string]any
+	for _, output := range t.frames {
+		var event map[string]any
+		if json.Unmarshal(output, &event) == nil && event["type"] == kind {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// dialTestSocket opens a WebSocket to the server as the user
+func dialTestSocket(t *testing.T, server *httptest.Server, user models.User) *websocket.Conn {
+	t.Helper()
+	header := http.Header{}
+	for _, cookie := range signedInRequest(user, http.MethodGet, "/ws", nil).Cookies() {
+		header.Add("Cookie", cookie.String())
+	}
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), header)
+	if err != nil {
+		t.Fatalf("Error opening WebSocket: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// eventually waits for a condition met asynchronously, for a second at most
+func eventually(t *testing.T, condition func() bool, format string, args ...any) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !condition(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf(format, args...)
+		}
+	}
+}
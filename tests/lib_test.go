@@ -1,11 +1,18 @@
 package tests
 
 import (
+	"encoding/base64"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/textproto"
 	"os"
+	"path/filepath"
 	"real-time-forum/lib"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestLoadEnv(t *testing.T) {
@@ -37,3 +44,355 @@ func TestValidateRequest(t *testing.T) {
 		t.Errorf("Expected request to be valid, but it wasn't")
 	}
 }
+
+func TestWordFilter(t *testing.T) {
+	filter, invalid := lib.NewWordFilter([]lib.FilterRule{
+		{Pattern: "darn", Action: lib.FilterMask},
+		{Pattern: "buy (cheap|now)", Regex: true, Action: lib.FilterHold},
+		{Pattern: "forbidden", Action: lib.FilterReject},
+		{Pattern: "(unclosed", Regex: true, Action: lib.FilterReject},
+	})
+	if len(invalid) != 1 || invalid[0].Pattern != "(unclosed" {
+		t.Fatalf("Expected the invalid regex to be skipped, got %v", invalid)
+	}
+
+	result := filter.Apply("Darn it, darned thing")
+	if result.Text != "**** it, darned thing" || result.Held || result.Rejected {
+		t.Errorf("Expected only the whole word to be masked, got %+v", result)
+	}
+	if result = filter.Apply("BUY NOW please"); !result.Held || result.Rejected {
+		t.Errorf("Expected the text to be held, got %+v", result)
+	}
+	if result = filter.Apply("this is Forbidden"); !result.Rejected {
+		t.Errorf("Expected the text to be rejected, got %+v", result)
+	}
+	if result = filter.Apply("a clean text"); len(result.Matches) != 0 || result.Text != "a clean text" {
+		t.Errorf("Expected the text to pass untouched, got %+v", result)
+	}
+
+	// The terms starting or ending with a symbol still match
+	filter, _ = lib.NewWordFilter([]lib.FilterRule{
+		{Pattern: "c++", Action: lib.FilterMask},
+		{Pattern: "@admin", Action: lib.FilterHold},
+		{Pattern: "$$$", Action: lib.FilterReject},
+		{Pattern: "café", Action: lib.FilterMask},
+	})
+	if result = filter.Apply("I write C++ at the café"); result.Text != "I write *** at the ****" {
+		t.Errorf("Expected the terms ending with a symbol or an accent to be masked, got %+v", result)
+	}
+	if result = filter.Apply("ask @admin for $$$"); !result.Held || !result.Rejected {
+		t.Errorf("Expected the terms starting with a symbol to match, got %+v", result)
+	}
+	if result = filter.Apply("abc++ and xcafé"); len(result.Matches) != 0 {
+		t.Errorf("Expected the word sides to stay bounded, got %+v", result)
+	}
+
+	// A mask doesn't hide a term from a later rule
+	filter, _ = lib.NewWordFilter([]lib.FilterRule{
+		{Pattern: "free", Action: lib.FilterMask},
+		{Pattern: "free money", Action: lib.FilterReject},
+		{Pattern: "money now", Action: lib.FilterMask},
+	})
+	if result = filter.Apply("free money now"); !result.Rejected || result.Text != "**** *********" {
+		t.Errorf("Expected the text to be rejected and the overlapping masks merged, got %+v", result)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := lib.NewRateLimiter(map[string]lib.Limit{
+		"auth": {Requests: 2, Per: time.Minute},
+		"api":  {Requests: 1, Per: time.Minute},
+	})
+	ok := func(res http.ResponseWriter, req *http.Request) { res.WriteHeader(http.StatusOK) }
+	auth, api := limiter.Wrap("auth", ok), limiter.Wrap("api", ok)
+	send := func(handler http.HandlerFunc, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remoteAddr
+		res := httptest.NewRecorder()
+		handler(res, req)
+		return res
+	}
+
+	for i, port := range []string{"1000", "1001"} {
+		res := send(auth, "10.0.0.1:"+port)
+		if res.Code != http.StatusOK {
+			t.Fatalf("Expected request %d to pass, got %d", i+1, res.Code)
+		}
+		if remaining := res.Header().Get("RateLimit-Remaining"); remaining != strconv.Itoa(1-i) {
+			t.Errorf("Expected %d remaining requests, got %s", 1-i, remaining)
+		}
+	}
+	res := send(auth, "10.0.0.1:1002")
+	if res.Code != http.StatusTooManyRequests || res.Header().Get("Retry-After") != "30" {
+		t.Errorf("Expected a 429 with a 30s Retry-After, got %d %q", res.Code, res.Header().Get("Retry-After"))
+	}
+	if res := send(api, "10.0.0.1:1003"); res.Code != http.StatusOK {
+		t.Errorf("Expected the api budget to be independent, got %d", res.Code)
+	}
+	if res := send(auth, "10.0.0.2:1000"); res.Code != http.StatusOK {
+		t.Errorf("Expected another IP to have its own budget, got %d", res.Code)
+	}
+
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		limiter.EvictIdle(time.Millisecond, stop)
+		close(stopped)
+	}()
+	close(stop)
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Error("Expected the eviction to stop with the server")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	if err := lib.SetTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1"}); err != nil {
+		t.Fatalf("Error setting trusted proxies: %v", err)
+	}
+	defer lib.SetTrustedProxies(nil)
+
+	for _, test := range []struct{ remoteAddr, forwardedFor, expected string }{
+		{"203.0.113.5:4000", "198.51.100.1", "203.0.113.5"},
+		{"10.1.2.3:4000", "198.51.100.1", "198.51.100.1"},
+		{"10.1.2.3:4000", "6.6.6.6, 198.51.100.1, 192.168.1.1", "198.51.100.1"},
+		{"10.1.2.3:4000", "", "10.1.2.3"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = test.remoteAddr
+		req.Header.Set("X-Forwarded-For", test.forwardedFor)
+		if ip := lib.ClientIP(req); ip != test.expected {
+			t.Errorf("Expected %s for %s via %q, got %s", test.expected, test.remoteAddr, test.forwardedFor, ip)
+		}
+	}
+}
+
+func TestPasswordHashing(t *testing.T) {
+	defer lib.SetHashConfig(lib.DefaultHashConfig)
+
+	bcryptConfig := lib.DefaultHashConfig
+	bcryptConfig.BcryptCost = 4
+	if err := lib.SetHashConfig(bcryptConfig); err != nil {
+		t.Fatalf("Error setting hash config: %v", err)
+	}
+	bcryptHash, err := lib.HashPassword("correct horse")
+	if err != nil || !lib.CheckPasswordHash("correct horse", bcryptHash) || lib.CheckPasswordHash("wrong horse", bcryptHash) {
+		t.Fatalf("Expected the bcrypt hash to only match its password (%v)", err)
+	}
+	if lib.NeedsRehash(bcryptHash) {
+		t.Error("Expected a hash with the configured cost to be kept")
+	}
+
+	argonConfig := lib.DefaultHashConfig
+	argonConfig.Algorithm = lib.HashArgon2id
+	argonConfig.Argon2Memory = 64
+	if err := lib.SetHashConfig(argonConfig); err != nil {
+		t.Fatalf("Error setting hash config: %v", err)
+	}
+	if !lib.NeedsRehash(bcryptHash) || !lib.CheckPasswordHash("correct horse", bcryptHash) {
+		t.Error("Expected the bcrypt hash to still match but need a rehash")
+	}
+	argonHash, err := lib.HashPassword("correct horse")
+	if err != nil || !strings.HasPrefix(argonHash, "$argon2id$") {
+		t.Fatalf("Expected an argon2id hash, got %q (%v)", argonHash, err)
+	}
+	if !lib.CheckPasswordHash("correct horse", argonHash) || lib.CheckPasswordHash("wrong horse", argonHash) {
+		t.Error("Expected the argon2id hash to only match its password")
+	}
+	if lib.NeedsRehash(argonHash) {
+		t.Error("Expected a hash with the configured parameters to be kept")
+	}
+
+	if err := lib.SetHashConfig(lib.HashConfig{Algorithm: "md5"}); err == nil {
+		t.Error("Expected an unknown algorithm to be refused")
+	}
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	defer lib.SetPasswordPolicy(lib.DefaultPasswordPolicy)
+
+	if err := lib.SetPasswordPolicy(lib.PasswordPolicy{MinLength: 10, Require: []string{"upper", " digit"}}); err != nil {
+		t.Fatalf("Error setting password policy: %v", err)
+	}
+	for password, valid := range map[string]bool{
+		"Short1":                 false,
+		"longenough1":            false,
+		"LongEnoughButNoDigit":   false,
+		"Long Enough 1":          true,
+		strings.Repeat("A1", 40): false,
+		"           ":            false,
+		"Ünïcödé-Pässwörd-2024":  true,
+	} {
+		if err := lib.CheckPassword(password); (err == nil) != valid {
+			t.Errorf("Expected %q to be valid: %v, got %v", password, valid, err)
+		}
+	}
+	if err := lib.SetPasswordPolicy(lib.PasswordPolicy{MinLength: 8, Require: []string{"emoji"}}); err == nil {
+		t.Error("Expected an unknown character class to be refused")
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+	mailer, err := lib.NewMailer("file://"+dir, "Forum <no-reply@example.com>")
+	if err != nil {
+		t.Fatalf("Error creating mailer: %v", err)
+	}
+	if err := mailer.Send(lib.Mail{To: "user@example.com\r\nBcc: victim@example.com", Subject: "Réinitialisation", Body: "line 1\nline 2"}); err != nil {
+		t.Fatalf("Error sending mail: %v", err)
+	}
+	files, _ := os.ReadDir(dir)
+	if len(files) != 1 {
+		t.Fatalf("Expected one mail file, got %d", len(files))
+	}
+	content, _ := os.ReadFile(filepath.Join(dir, files[0].Name()))
+	message := string(content)
+	if !strings.Contains(message, "To: user@example.comBcc: victim@example.com\r\n") || strings.Contains(message, "\r\nBcc:") {
+		t.Errorf("Expected the line breaks of the headers to be removed, got %q", message)
+	}
+	if !strings.Contains(message, "Subject: =?utf-8?q?R=C3=A9initialisation?=") || !strings.HasSuffix(message, "line 1\r\nline 2") {
+		t.Errorf("Expected an encoded subject and CRLF lines, got %q", message)
+	}
+
+	if _, err := lib.NewMailer("ftp://example.com", "no-reply@example.com"); err == nil {
+		t.Error("Expected an unsupported mailer to be refused")
+	}
+	if _, err := lib.NewMailer("", "Forum no-reply@example.com"); err == nil {
+		t.Error("Expected an invalid sender to be refused")
+	}
+}
+
+// smtpSession is what a client told the test SMTP server
+type smtpSession struct {
+	auth    string
+	from    string
+	to      []string
+	message string
+}
+
+// serveSMTP accepts one SMTP session on 127.0.0.1, offering AUTH but not
+// STARTTLS, and sends what it received on the channel
+func serveSMTP(t *testing.T) (string, <-chan smtpSession) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	sessions := make(chan smtpSession, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		text := textproto.NewConn(conn)
+		var session smtpSession
+		text.PrintfLine("220 localhost ESMTP")
+		for {
+			line, err := text.ReadLine()
+			if err != nil {
+				return
+			}
+			command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+			switch command {
+			case "EHLO":
+				text.PrintfLine("250-localhost\r\n250 AUTH PLAIN")
+			case "AUTH":
+				decoded, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(line, "AUTH PLAIN "))
+				session.auth = string(decoded)
+				text.PrintfLine("235 Authenticated")
+			case "MAIL":
+				session.from = strings.TrimPrefix(line, "MAIL FROM:")
+				text.PrintfLine("250 OK")
+			case "RCPT":
+				session.to = append(session.to, strings.TrimPrefix(line, "RCPT TO:"))
+				text.PrintfLine("250 OK")
+			case "DATA":
+				text.PrintfLine("354 Go ahead")
+				data, err := text.ReadDotBytes()
+				if err != nil {
+					return
+				}
+				session.message = string(data)
+				text.PrintfLine("250 Queued")
+			case "QUIT":
+				text.PrintfLine("221 Bye")
+				sessions <- session
+				return
+			default:
+				text.PrintfLine("250 OK")
+			}
+		}
+	}()
+	return listener.Addr().String(), sessions
+}
+
+func TestSMTPMailer(t *testing.T) {
+	address, sessions := serveSMTP(t)
+	mailer, err := lib.NewMailer("smtp://forum:secret@"+address, "ThunderForum <no-reply@example.com>")
+	if err != nil {
+		t.Fatalf("Error creating mailer: %v", err)
+	}
+	if err := mailer.Send(lib.Mail{To: "user@example.com", Subject: "Réinitialisation", Body: "line 1\n.line 2"}); err != nil {
+		t.Fatalf("Error sending mail: %v", err)
+	}
+
+	var session smtpSession
+	select {
+	case session = <-sessions:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the mail to reach the SMTP server")
+	}
+	if session.auth != "\x00forum\x00secret" {
+		t.Errorf("Expected the credentials of the URL, got %q", session.auth)
+	}
+	if session.from != "<no-reply@example.com>" || len(session.to) != 1 || session.to[0] != "<user@example.com>" {
+		t.Errorf("Expected the envelope from the bare address of the sender to the user, got %s %v", session.from, session.to)
+	}
+	// ReadDotBytes turns the CRLF into LF and unstuffs the leading dots
+	message := session.message
+	for _, header := range []string{"From: \"ThunderForum\" <no-reply@example.com>\n", "To: user@example.com\n", "Subject: =?utf-8?q?R=C3=A9initialisation?=\n", "Content-Type: text/plain; charset=utf-8\n"} {
+		if !strings.Contains(message, header) {
+			t.Errorf("Expected the message to have %q, got %q", header, message)
+		}
+	}
+	if !strings.HasSuffix(message, "\n\nline 1\n.line 2\n") {
+		t.Errorf("Expected the body after the headers, got %q", message)
+	}
+}
+
+func TestTOTP(t *testing.T) {
+	// Test vectors of RFC 6238, truncated to 6 digits
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" // "12345678901234567890"
+	for unix, expected := range map[int64]string{59: "287082", 1111111109: "081804", 1234567890: "005924"} {
+		if code, err := lib.TOTPCode(secret, time.Unix(unix, 0)); err != nil || code != expected {
+			t.Errorf("Expected code %s at %d, got %s (%v)", expected, unix, code, err)
+		}
+	}
+
+	now := time.Now()
+	code, _ := lib.TOTPCode(secret, now.Add(-30*time.Second))
+	step, valid := lib.ValidateTOTP(secret, code, now)
+	if !valid || step != now.Unix()/30-1 {
+		t.Errorf("Expected the code of the previous period to be valid, got step %d (%v)", step, valid)
+	}
+	code, _ = lib.TOTPCode(secret, now.Add(-2*time.Minute))
+	if _, valid := lib.ValidateTOTP(secret, code, now); valid {
+		t.Error("Expected an old code to be invalid")
+	}
+	if _, valid := lib.ValidateTOTP(secret, "12345", now); valid {
+		t.Error("Expected a short code to be invalid")
+	}
+
+	uri := lib.TOTPURI("ThunderForum", "some one", secret)
+	if !strings.HasPrefix(uri, "otpauth://totp/ThunderForum:some%20one?") || !strings.Contains(uri, "secret="+secret) {
+		t.Errorf("Unexpected otpauth URI %s", uri)
+	}
+
+	recoveryCode, _ := lib.NewRecoveryCode()
+	if lib.HashRecoveryCode(strings.ToUpper(strings.ReplaceAll(recoveryCode, "-", " "))) != lib.HashRecoveryCode(recoveryCode) {
+		t.Errorf("Expected %s to match whatever the case and separators", recoveryCode)
+	}
+}
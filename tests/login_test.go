@@ -0,0 +1,82 @@
+package tests
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"real-time-forum/data/models"
+	"real-time-forum/handler"
+	"real-time-forum/lib"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+func TestLoginRepository_Failures(t *testing.T) {
+	victim, other := uniqueName("victim"), uniqueName("other")
+	ipFailures, _ := models.LoginRepo.IPFailures("192.0.2.1", time.Hour)
+	for _, ip := range []string{"192.0.2.1", "192.0.2.1", "192.0.2.2"} {
+		if err := models.LoginRepo.RecordFailure(victim, ip); err != nil {
+			t.Fatalf("Error recording failure: %v", err)
+		}
+	}
+	if err := models.LoginRepo.RecordFailure(other, "192.0.2.1"); err != nil {
+		t.Fatalf("Error recording failure: %v", err)
+	}
+
+	failures, err := models.LoginRepo.AccountFailures(victim, time.Hour)
+	if err != nil || failures.Count != 3 {
+		t.Fatalf("Expected 3 failures on the account, got %+v (%v)", failures, err)
+	}
+	if time.Since(failures.Last) > time.Minute {
+		t.Errorf("Expected the last failure to be recent, got %v", failures.Last)
+	}
+	// Other runs of the test may have failed from the same IP
+	if failures, _ := models.LoginRepo.IPFailures("192.0.2.1", time.Hour); failures.Count != ipFailures.Count+3 {
+		t.Errorf("Expected 3 more failures from the IP, got %+v after %+v", failures, ipFailures)
+	}
+
+	if err := models.LoginRepo.ClearFailures(victim); err != nil {
+		t.Fatalf("Error clearing failures: %v", err)
+	}
+	if failures, _ := models.LoginRepo.AccountFailures(victim, time.Hour); failures.Count != 0 {
+		t.Errorf("Expected the failures to be cleared, got %+v", failures)
+	}
+	if failures, _ := models.LoginRepo.AccountFailures(other, time.Hour); failures.Count != 1 {
+		t.Errorf("Expected the other account to keep its failure, got %+v", failures)
+	}
+}
+
+func TestSignInLockout(t *testing.T) {
+	user := createTestUser(t, "lockout")
+	hashedPassword, _ := lib.HashPassword("the right password")
+	if err := models.UserRepo.SetPassword(user.ID, hashedPassword); err != nil {
+		t.Fatalf("Error setting password: %v", err)
+	}
+	ID, _ := uuid.NewV4()
+	ip := net.IPv4(10, ID[0], ID[1], ID[2]).String() // no failures from previous runs
+
+	signIn := func(identifiant, password string) int {
+		body := `{"identifiant":"` + identifiant + `","password":"` + password + `"}`
+		req := httptest.NewRequest(http.MethodPost, "/sign-in", strings.NewReader(body))
+		req.RemoteAddr = ip + ":1234"
+		res := httptest.NewRecorder()
+		handler.SignIn(res, req)
+		return res.Code
+	}
+
+	// The failures by nickname and by email count on the same account
+	for _, identifiant := range []string{user.Nickname, user.Email, strings.ToUpper(user.Nickname)} {
+		if code := signIn(identifiant, "a wrong password"); code != http.StatusUnauthorized {
+			t.Fatalf("Expected a wrong password to be refused, got %d", code)
+		}
+	}
+	if code := signIn(user.Email, "the right password"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected the account to be delayed after 3 failures, got %d", code)
+	}
+	if failures, _ := models.LoginRepo.AccountFailures(user.Nickname, time.Hour); failures.Count != 0 {
+		t.Errorf("Expected no failure keyed by the nickname of an existing account, got %+v", failures)
+	}
+}
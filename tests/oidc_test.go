@@ -0,0 +1,273 @@
+package tests
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"real-time-forum/data/models"
+	"real-time-forum/handler"
+	"real-time-forum/lib"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mockProvider is a local OpenID Connect provider, issuing ID tokens signed
+// with an RSA key for the codes the tests authorize
+type mockProvider struct {
+	*httptest.Server
+	key   *rsa.PrivateKey
+	codes map[string]mockGrant
+}
+
+type mockGrant struct {
+	challenge string
+	claims    map[string]any
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	mock := &mockProvider{key: key, codes: map[string]mockGrant{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 mock.URL,
+			"authorization_endpoint": mock.URL + "/authorize",
+			"token_endpoint":         mock.URL + "/token",
+			"jwks_uri":               mock.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "rsa-1", "use": "sig",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		clientID, secret, _ := r.BasicAuth()
+		grant, ok := mock.codes[r.FormValue("code")]
+		delete(mock.codes, r.FormValue("code"))
+		sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if clientID != "forum" || secret != "s3cret" || !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": mock.sign(t, "RS256", "rsa-1", grant.claims)})
+	})
+	mock.Server = httptest.NewServer(mux)
+	t.Cleanup(mock.Close)
+	return mock
+}
+
+// authorize plays the user signing in at the provider, returning the code
+// for the sign-in started at authURL
+func (m *mockProvider) authorize(t *testing.T, authURL string, claims map[string]any) string {
+	parsed, err := url.Parse(authURL)
+	if err != nil || !strings.HasPrefix(authURL, m.URL+"/authorize?") {
+		t.Fatalf("Unexpected authorization URL %s", authURL)
+	}
+	query := parsed.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("client_id") != "forum" || query.Get("state") == "" {
+		t.Fatalf("Expected a PKCE authorization request, got %s", authURL)
+	}
+	claims["nonce"] = query.Get("nonce")
+	code := "code-" + query.Get("state")
+	m.codes[code] = mockGrant{challenge: query.Get("code_challenge"), claims: claims}
+	return code
+}
+
+func (m *mockProvider) claims(subject string) map[string]any {
+	return map[string]any{
+		"iss": m.URL, "sub": subject, "aud": "forum",
+		"exp": time.Now().Add(time.Hour).Unix(), "iat": time.Now().Unix(),
+	}
+}
+
+func (m *mockProvider) sign(t *testing.T, algorithm, keyID string, claims map[string]any) string {
+	header, _ := json.Marshal(map[string]string{"alg": algorithm, "kid": keyID, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, m.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("Error signing token: %v", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func (m *mockProvider) provider(t *testing.T) *lib.OIDCProvider {
+	provider, err := lib.NewOIDCProvider(lib.OIDCConfig{
+		Name: "mock", Label: "Mock", Issuer: m.URL, ClientID: "forum", ClientSecret: "s3cret",
+		RedirectURL: "http://localhost:8085/oidc/callback/mock",
+	})
+	if err != nil {
+		t.Fatalf("Error creating provider: %v", err)
+	}
+	return provider
+}
+
+func TestOIDCProvider(t *testing.T) {
+	mock := newMockProvider(t)
+	provider := mock.provider(t)
+
+	verifier, challenge, _ := lib.NewPKCE()
+	authURL, err := provider.AuthCodeURL("some-state", "some-nonce", challenge)
+	if err != nil {
+		t.Fatalf("Error discovering the provider: %v", err)
+	}
+	code := mock.authorize(t, authURL, mock.claims("alice"))
+	if _, err := provider.Exchange(code, "not-the-verifier", "some-nonce"); err == nil {
+		t.Error("Expected a wrong code verifier to be refused")
+	}
+	code = mock.authorize(t, authURL, mock.claims("alice"))
+	claims, err := provider.Exchange(code, verifier, "some-nonce")
+	if err != nil || claims.Subject != "alice" {
+		t.Fatalf("Expected the ID token of alice, got %+v (%v)", claims, err)
+	}
+
+	for name, tamper := range map[string]func(map[string]any){
+		"wrong issuer":   func(c map[string]any) { c["iss"] = "https://evil.example.com" },
+		"wrong audience": func(c map[string]any) { c["aud"] = []string{"other"} },
+		"no azp":         func(c map[string]any) { c["aud"] = []string{"forum", "other"} },
+		"expired":        func(c map[string]any) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"future":         func(c map[string]any) { c["iat"] = time.Now().Add(time.Hour).Unix() },
+		"wrong nonce":    func(c map[string]any) { c["nonce"] = "other-nonce" },
+	} {
+		claims := mock.claims("alice")
+		claims["nonce"] = "some-nonce"
+		tamper(claims)
+		if _, err := provider.VerifyIDToken(mock.sign(t, "RS256", "rsa-1", claims), "some-nonce"); err == nil {
+			t.Errorf("Expected a token with %s to be refused", name)
+		}
+	}
+
+	claims2 := mock.claims("alice")
+	claims2["nonce"] = "some-nonce"
+	token := mock.sign(t, "RS256", "rsa-1", claims2)
+	parts := strings.Split(token, ".")
+	if _, err := provider.VerifyIDToken(parts[0]+"."+parts[1]+"."+parts[2][:len(parts[2])-4]+"AAAA", "some-nonce"); err == nil {
+		t.Error("Expected a forged signature to be refused")
+	}
+	none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","kid":"rsa-1"}`))
+	if _, err := provider.VerifyIDToken(none+"."+parts[1]+".", "some-nonce"); err == nil {
+		t.Error("Expected an unsigned token to be refused")
+	}
+	if _, err := provider.VerifyIDToken(mock.sign(t, "RS256", "rsa-2", claims2), "some-nonce"); err == nil {
+		t.Error("Expected a token signed with an unknown key to be refused")
+	}
+}
+
+func TestOIDCProviderEC(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	var issuer string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/jwks" {
+			json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+				"kty": "EC", "crv": "P-256",
+				"x": base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+				"y": base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+			}}})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"issuer": issuer, "authorization_endpoint": issuer + "/authorize",
+			"token_endpoint": issuer + "/token", "jwks_uri": issuer + "/jwks"})
+	}))
+	defer server.Close()
+	issuer = server.URL
+	provider, _ := lib.NewOIDCProvider(lib.OIDCConfig{Name: "ec", Issuer: issuer, ClientID: "forum", RedirectURL: "http://localhost/cb"})
+
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"ES256"}`))
+	payload, _ := json.Marshal(map[string]any{"iss": issuer, "sub": "bob", "aud": "forum", "exp": time.Now().Add(time.Hour).Unix(), "nonce": "n"})
+	signed := header + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	r, s, _ := ecdsa.Sign(rand.Reader, key, digest[:])
+	signature := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	claims, err := provider.VerifyIDToken(signed+"."+base64.RawURLEncoding.EncodeToString(signature), "n")
+	if err != nil || claims.Subject != "bob" {
+		t.Errorf("Expected the ES256 token of bob, got %+v (%v)", claims, err)
+	}
+}
+
+func TestOIDCSignIn(t *testing.T) {
+	mock := newMockProvider(t)
+	handler.UseOIDCProviders([]*lib.OIDCProvider{mock.provider(t)})
+	defer handler.UseOIDCProviders(nil)
+
+	// signIn goes through the forum and the provider, returning the answer of
+	// the callback
+	signIn := func(claims map[string]any) *httptest.ResponseRecorder {
+		login := httptest.NewRecorder()
+		handler.OIDCLogin(login, httptest.NewRequest(http.MethodGet, "/oidc/login/mock", nil))
+		if login.Code != http.StatusFound {
+			t.Fatalf("Expected a redirection to the provider, got %d %s", login.Code, login.Body)
+		}
+		authURL := login.Header().Get("Location")
+		code := mock.authorize(t, authURL, claims)
+		parsed, _ := url.Parse(authURL)
+		req := httptest.NewRequest(http.MethodGet, "/oidc/callback/mock?code="+code+"&state="+parsed.Query().Get("state"), nil)
+		for _, cookie := range login.Result().Cookies() {
+			req.AddCookie(cookie)
+		}
+		callback := httptest.NewRecorder()
+		handler.OIDCCallback(callback, req)
+		return callback
+	}
+
+	claims := mock.claims("carol-subject")
+	claims["email"], claims["email_verified"], claims["preferred_username"] = "Carol@Example.com", true, "Carol Smith"
+	res := signIn(claims)
+	if res.Code != http.StatusFound || !strings.HasSuffix(res.Header().Get("Location"), "/#/login/oidc") {
+		t.Fatalf("Expected a signed in redirection, got %d %s", res.Code, res.Body)
+	}
+	user, _ := models.UserRepo.GetUserByEmail("carol@example.com")
+	if user == nil || user.Nickname != "carol_smith" || !user.EmailVerified {
+		t.Fatalf("Expected a verified user carol_smith, got %+v", user)
+	}
+	if userID, _ := models.IdentityRepo.GetUserID("mock", "carol-subject"); userID != user.ID {
+		t.Errorf("Expected the account to be linked to %s, got %q", user.ID, userID)
+	}
+	if signIn(claims).Code != http.StatusFound {
+		t.Error("Expected the linked account to sign in again")
+	}
+
+	// An unverified email doesn't take over an existing account
+	other := mock.claims("mallory-subject")
+	other["email"], other["email_verified"], other["preferred_username"] = "carol@example.com", false, "carol"
+	if res := signIn(other); res.Code != http.StatusConflict {
+		t.Errorf("Expected an unverified email not to be linked, got %d %s", res.Code, res.Body)
+	}
+
+	// The nickname of a new user is made unique
+	dave := mock.claims("dave-subject")
+	dave["email"], dave["preferred_username"] = "dave@example.com", "carol_smith"
+	if res := signIn(dave); res.Code != http.StatusFound {
+		t.Fatalf("Expected dave to sign in, got %d %s", res.Code, res.Body)
+	}
+	if user, _ := models.UserRepo.GetUserByEmail("dave@example.com"); user == nil || user.Nickname != "carol_smith2" || user.EmailVerified {
+		t.Errorf("Expected an unverified user carol_smith2, got %+v", user)
+	}
+
+	// A state is bound to the browser that started the sign-in
+	login := httptest.NewRecorder()
+	handler.OIDCLogin(login, httptest.NewRequest(http.MethodGet, "/oidc/login/mock", nil))
+	parsed, _ := url.Parse(login.Header().Get("Location"))
+	callback := httptest.NewRecorder()
+	handler.OIDCCallback(callback, httptest.NewRequest(http.MethodGet, "/oidc/callback/mock?code=x&state="+parsed.Query().Get("state"), nil))
+	if callback.Code != http.StatusBadRequest {
+		t.Errorf("Expected a callback without the state cookie to be refused, got %d", callback.Code)
+	}
+}
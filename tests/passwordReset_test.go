@@ -0,0 +1,34 @@
+package tests
+
+import (
+	"real-time-forum/data/models"
+	"testing"
+	"time"
+)
+
+func TestPasswordResetRepository(t *testing.T) {
+	user := createTestUser(t, "forgetful")
+
+	first, err := models.ResetRepo.CreateToken(user.ID, time.Hour)
+	if err != nil {
+		t.Fatalf("Error creating token: %v", err)
+	}
+	if recent, _ := models.ResetRepo.RecentlyCreated(user.ID, time.Minute); !recent {
+		t.Error("Expected the token to be recent")
+	}
+	second, _ := models.ResetRepo.CreateToken(user.ID, time.Hour)
+	if _, err := models.ResetRepo.UseToken(first); err != models.ErrInvalidResetToken {
+		t.Errorf("Expected a replaced token to be invalid, got %v", err)
+	}
+	if userID, err := models.ResetRepo.UseToken(second); err != nil || userID != user.ID {
+		t.Fatalf("Expected the token to reset the password of %s, got %q (%v)", user.ID, userID, err)
+	}
+	if _, err := models.ResetRepo.UseToken(second); err != models.ErrInvalidResetToken {
+		t.Errorf("Expected a used token to be invalid, got %v", err)
+	}
+
+	expired, _ := models.ResetRepo.CreateToken(user.ID, -time.Minute)
+	if _, err := models.ResetRepo.UseToken(expired); err != models.ErrInvalidResetToken {
+		t.Errorf("Expected an expired token to be invalid, got %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"real-time-forum/data/models"
+	"real-time-forum/handler"
+	"testing"
+	"time"
+)
+
+func TestPresenceTransitions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(handler.HandleWebSocket))
+	defer server.Close()
+	user, observer := createTestUser(t, "present"), createTestUser(t, "observer")
+
+	watcher := &recordingTransport{onExit: func() {}}
+	client := handler.NewClient(watcher, httptest.NewRequest(http.MethodGet, "/ws", nil))
+	handler.Connections.Register(client)
+	defer handler.Connections.Unregister(client)
+	handler.Connections.Subscribe(client, handler.PresenceTopic)
+	statuses := func() []string {
+		var presences []string
+		for _, event := range watcher.received("status") {
+			if event["userID"] == user.ID {
+				presences = append(presences, event["presence"].(string))
+			}
+		}
+		return presences
+	}
+	expect := func(presence string, announced ...string) {
+		t.Helper()
+		eventually(t, func() bool { return handler.Presence(user.ID) == presence && len(statuses()) == len(announced) },
+			"Expected the user %s after %v, got %s after %v", presence, announced, handler.Presence(user.ID), statuses())
+		for i, status := range statuses() {
+			if status != announced[i] {
+				t.Fatalf("Expected the statuses %v, got %v", announced, statuses())
+			}
+		}
+	}
+	activity := func(device interface{ WriteJSON(any) error }, state string) {
+		device.WriteJSON(map[string]any{"type": "activity", "data": map[string]string{"state": state}})
+	}
+
+	laptop := dialTestSocket(t, server, user)
+	expect(handler.PresenceOnline, "online")
+	phone := dialTestSocket(t, server, user)
+	if count, _ := handler.Broker.Connections(user.ID); count != 2 {
+		t.Errorf("Expected the two devices to be counted, got %d", count)
+	}
+
+	// Away once every device is idle, online again as soon as one is active
+	activity(laptop, "idle")
+	expect(handler.PresenceOnline, "online")
+	activity(phone, "idle")
+	expect(handler.PresenceAway, "online", "away")
+	activity(laptop, "active")
+	expect(handler.PresenceOnline, "online", "away", "online")
+
+	// Offline with the last device only
+	laptop.Close()
+	eventually(t, func() bool { count, _ := handler.Broker.Connections(user.ID); return count == 1 }, "Expected one device left")
+	expect(handler.PresenceAway, "online", "away", "online", "away")
+	phone.Close()
+	expect(handler.PresenceOffline, "online", "away", "online", "away", "offline")
+
+	users, err := models.UserRepo.SelectAllUsers(observer.ID)
+	if err != nil {
+		t.Fatalf("Error listing users: %v", err)
+	}
+	for _, listed := range users {
+		if listed.ID != user.ID {
+			continue
+		}
+		if lastSeen, err := time.Parse(models.LastSeenLayout, listed.LastSeen); err != nil || time.Since(lastSeen) > time.Minute {
+			t.Errorf("Expected the user to have been seen just now, got %q", listed.LastSeen)
+		}
+		return
+	}
+	t.Error("Expected the user to be listed")
+}
+
+func TestGetUsersPresence(t *testing.T) {
+	viewer, present, absent := createTestUser(t, "lister"), createTestUser(t, "listed_online"), createTestUser(t, "listed_offline")
+	polls := handler.NewPollPresence(time.Millisecond)
+	polls.Begin(present.ID)
+	defer polls.End(present.ID)
+
+	presences := handler.Presences([]string{present.ID, absent.ID})
+	if presences[present.ID] != handler.PresenceOnline || presences[absent.ID] != handler.PresenceOffline {
+		t.Errorf("Expected one user online and the other offline, got %v", presences)
+	}
+
+	res := httptest.NewRecorder()
+	handler.GetUsers(res, signedInRequest(viewer, http.MethodGet, "/chat/users", nil))
+	var body struct {
+		Users []models.UserItem `json:"users"`
+	}
+	json.Unmarshal(res.Body.Bytes(), &body)
+	if listed := listsUser(body.Users, present.ID); listed == nil || listed.Presence != handler.PresenceOnline || !listed.IsConnected {
+		t.Errorf("Expected the user to be listed online, got %+v", listed)
+	}
+	if listed := listsUser(body.Users, absent.ID); listed == nil || listed.Presence != handler.PresenceOffline || listed.IsConnected {
+		t.Errorf("Expected the user to be listed offline, got %+v", listed)
+	}
+}
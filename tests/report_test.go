@@ -0,0 +1,141 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"real-time-forum/data/models"
+	"real-time-forum/handler"
+	"testing"
+	"time"
+)
+
+func TestReportRepository_Queue(t *testing.T) {
+	contentID, moderatorID := uniqueName("post"), uniqueName("moderator")
+	first := models.Report{ReporterID: "reporter1", ContentType: models.ContentPost, ContentID: contentID, AuthorID: "author1", Reason: "spam"}
+	if err := models.ReportRepo.CreateReport(&first); err != nil {
+		t.Fatalf("Error creating report: %v", err)
+	}
+	duplicate := models.Report{ReporterID: "reporter1", ContentType: models.ContentPost, ContentID: contentID, Reason: "hate"}
+	if err := models.ReportRepo.CreateReport(&duplicate); err != models.ErrAlreadyReported {
+		t.Errorf("Expected ErrAlreadyReported, got %v", err)
+	}
+	second := models.Report{ReporterID: "reporter2", ContentType: models.ContentPost, ContentID: contentID, AuthorID: "author1", Reason: "hate"}
+	if err := models.ReportRepo.CreateReport(&second); err != nil {
+		t.Fatalf("Error creating report: %v", err)
+	}
+
+	if err := models.ReportRepo.Assign(first.ID, moderatorID); err != nil {
+		t.Fatalf("Error assigning report: %v", err)
+	}
+	assigned, _ := models.ReportRepo.GetReports(models.ReportFilter{Status: models.ReportOpen, AssigneeID: moderatorID, Limit: 10})
+	if len(assigned) != 1 || assigned[0].ID != first.ID {
+		t.Errorf("Expected the assigned report only, got %+v", assigned)
+	}
+	unassigned, _ := models.ReportRepo.GetReports(models.ReportFilter{Status: models.ReportOpen, Reason: "hate", Unassigned: true, Limit: 1000})
+	if !containsReport(unassigned, second.ID) || containsReport(unassigned, first.ID) {
+		t.Errorf("Expected the unassigned report only, got %+v", unassigned)
+	}
+
+	// Resolving a report closes every open report on the same content
+	if err := models.ReportRepo.Resolve(&first, moderatorID, models.ResolutionDismiss, ""); err != nil {
+		t.Fatalf("Error resolving report: %v", err)
+	}
+	resolved, _ := models.ReportRepo.GetReportByID(second.ID)
+	if resolved.Status != models.ReportResolved || resolved.Resolution != models.ResolutionDismiss {
+		t.Errorf("Expected the other report to be resolved, got %+v", resolved)
+	}
+}
+
+// containsReport tells if a report is in a list, as the queue keeps the
+// reports of the previous runs of the tests
+func containsReport(reports []models.Report, reportID string) bool {
+	for _, report := range reports {
+		if report.ID == reportID {
+			return true
+		}
+	}
+	return false
+}
+
+func TestUserRepository_Sanction(t *testing.T) {
+	user := createTestUser(t, "sanctioned")
+
+	if err := models.UserRepo.Suspend(user.ID, time.Now().Add(-time.Hour), "spam"); err != nil {
+		t.Fatalf("Error suspending user: %v", err)
+	}
+	if sanction, _ := models.UserRepo.GetSanction(user.ID); sanction != nil {
+		t.Errorf("Expected an elapsed suspension to be over, got %+v", sanction)
+	}
+
+	if err := models.UserRepo.Suspend(user.ID, time.Now().Add(time.Hour), "spam"); err != nil {
+		t.Fatalf("Error suspending user: %v", err)
+	}
+	sanction, _ := models.UserRepo.GetSanction(user.ID)
+	if sanction == nil || sanction.Banned || sanction.Until == nil || sanction.Reason != "spam" {
+		t.Errorf("Expected a running suspension, got %+v", sanction)
+	}
+
+	// A suspended user loses their sessions
+	token := uniqueName("sanctioned_token")
+	models.AllSessions.Store(token, models.Session{UserID: user.ID, Nickname: user.Nickname, ExpireAt: time.Now().Add(time.Hour)})
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: "auth_session", Value: token})
+	if models.ValidSession(req) {
+		t.Errorf("Expected the session of a suspended user to be invalid")
+	}
+
+	if err := models.UserRepo.Ban(user.ID, "hate"); err != nil {
+		t.Fatalf("Error banning user: %v", err)
+	}
+	if sanction, _ := models.UserRepo.GetSanction(user.ID); sanction == nil || !sanction.Banned {
+		t.Errorf("Expected a ban, got %+v", sanction)
+	}
+
+	if err := models.UserRepo.LiftSanction(user.ID); err != nil {
+		t.Fatalf("Error lifting sanction: %v", err)
+	}
+	if sanction, _ := models.UserRepo.GetSanction(user.ID); sanction != nil {
+		t.Errorf("Expected no sanction, got %+v", sanction)
+	}
+}
+
+func TestHiddenPostThread(t *testing.T) {
+	author, reader := createTestUser(t, "hidden_author"), createTestUser(t, "hidden_reader")
+	moderator := createUserWithRole(t, "hidden_moderator", models.RoleModerator)
+	post := models.PostCreation{Title: "hidden", Slug: uniqueName("hidden"), Description: "moderated", AuthorID: author.ID}
+	if err := models.PostRepo.CreatePost(&post); err != nil {
+		t.Fatalf("Error creating post: %v", err)
+	}
+	if err := models.PostRepo.SetHidden(post.ID, true); err != nil {
+		t.Fatalf("Error hiding post: %v", err)
+	}
+	timeout := handler.PollTimeout
+	handler.PollTimeout = time.Millisecond
+	t.Cleanup(func() { handler.PollTimeout = timeout })
+
+	for _, viewer := range []struct {
+		user    models.User
+		allowed bool
+	}{{reader, false}, {author, true}, {moderator, true}} {
+		expected := http.StatusNotFound
+		if viewer.allowed {
+			expected = http.StatusOK
+		}
+		res := httptest.NewRecorder()
+		handler.GetComments(res, signedInRequest(viewer.user, http.MethodGet, "/comments/"+post.ID, nil))
+		if res.Code != expected {
+			t.Errorf("Expected the comments of the hidden post to answer %d to %s, got %d", expected, viewer.user.Nickname, res.Code)
+		}
+
+		expected = http.StatusForbidden
+		if viewer.allowed {
+			expected = http.StatusOK
+		}
+		if _, _, code := poll(&viewer.user, handler.PostTopic(post.ID), ""); code != expected {
+			t.Errorf("Expected a subscription to the hidden post to answer %d to %s, got %d", expected, viewer.user.Nickname, code)
+		}
+	}
+	if _, _, code := poll(nil, handler.PostTopic(post.ID), ""); code != http.StatusForbidden {
+		t.Errorf("Expected a visitor not to subscribe to the hidden post, got %d", code)
+	}
+}
@@ -0,0 +1,140 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"real-time-forum/data/models"
+	"real-time-forum/handler"
+	"strings"
+	"testing"
+)
+
+func TestUserCan(t *testing.T) {
+	granted := map[string][]models.Permission{
+		models.RoleMember:    {models.PermissionRead, models.PermissionPost, models.PermissionMessage, models.PermissionAccount},
+		models.RoleModerator: {models.PermissionRead, models.PermissionPost, models.PermissionMessage, models.PermissionAccount, models.PermissionModerate},
+		models.RoleAdmin:     {models.PermissionRead, models.PermissionPost, models.PermissionMessage, models.PermissionAccount, models.PermissionModerate, models.PermissionManageRoles, models.PermissionAudit, models.PermissionManageFilters},
+	}
+	all := granted[models.RoleAdmin]
+	for role, permissions := range granted {
+		user := models.User{Role: role, EmailVerified: true}
+		for _, permission := range all {
+			expected := false
+			for _, p := range permissions {
+				expected = expected || p == permission
+			}
+			if user.Can(permission) != expected {
+				t.Errorf("Expected %s to be allowed %s: %v", role, permission, expected)
+			}
+		}
+	}
+
+	unverified := models.User{Role: models.RoleAdmin}
+	if unverified.Can(models.PermissionMessage) || !unverified.NeedsVerification(models.PermissionMessage) {
+		t.Error("Expected an unverified user not to send messages until they verify their email")
+	}
+	if !unverified.Can(models.PermissionManageRoles) {
+		t.Error("Expected an unverified admin to keep the permissions not needing a verified email")
+	}
+	if (&models.User{Role: "unknown", EmailVerified: true}).Can(models.PermissionRead) {
+		t.Error("Expected an unknown role to be allowed nothing")
+	}
+}
+
+// createUserWithRole creates a user and grants them a role
+func createUserWithRole(t *testing.T, name, role string) models.User {
+	t.Helper()
+	user := createTestUser(t, name)
+	if err := models.UserRepo.SetRole(user.ID, role); err != nil {
+		t.Fatalf("Error granting role: %v", err)
+	}
+	user.Role = role
+	return user
+}
+
+func TestSetRole(t *testing.T) {
+	member := createUserWithRole(t, "member", models.RoleMember)
+	moderator := createUserWithRole(t, "moderator", models.RoleModerator)
+	admin := createUserWithRole(t, "admin", models.RoleAdmin)
+	target := createTestUser(t, "promoted")
+	path := "/admin/role/" + target.ID
+	grant := func(user *models.User, role string) *httptest.ResponseRecorder {
+		var req *http.Request
+		if user == nil {
+			req = httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"role":"`+role+`"}`))
+		} else {
+			req = signedInRequest(*user, http.MethodPost, path, strings.NewReader(`{"role":"`+role+`"}`))
+		}
+		res := httptest.NewRecorder()
+		handler.SetRole(res, req)
+		return res
+	}
+
+	if res := grant(nil, models.RoleAdmin); res.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a visitor to be asked to sign in, got %d", res.Code)
+	}
+	for _, user := range []models.User{member, moderator} {
+		if res := grant(&user, models.RoleAdmin); res.Code != http.StatusForbidden {
+			t.Errorf("Expected a %s not to grant roles, got %d", user.Role, res.Code)
+		}
+	}
+	if res := grant(&admin, "owner"); res.Code != http.StatusBadRequest {
+		t.Errorf("Expected an unknown role to be refused, got %d", res.Code)
+	}
+	if user, _ := models.UserRepo.GetUserByID(target.ID); user.Role != models.RoleMember {
+		t.Fatalf("Expected the refused grants to leave the role unchanged, got %s", user.Role)
+	}
+
+	if res := grant(&admin, " Moderator "); res.Code != http.StatusOK {
+		t.Fatalf("Expected an admin to grant roles, got %d %s", res.Code, res.Body)
+	}
+	if user, _ := models.UserRepo.GetUserByID(target.ID); user.Role != models.RoleModerator {
+		t.Errorf("Expected the user to be a moderator, got %s", user.Role)
+	}
+	entries, _ := models.AuditRepo.GetEntries(models.AuditFilter{ActorID: admin.ID, Action: models.AuditRoleGrant})
+	if len(entries) != 1 || entries[0].TargetID != target.ID {
+		t.Errorf("Expected the grant to be audited, got %+v", entries)
+	}
+
+	res := httptest.NewRecorder()
+	handler.SetRole(res, signedInRequest(admin, http.MethodPost, "/admin/role/"+admin.ID, strings.NewReader(`{"role":"member"}`)))
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("Expected an admin not to change their own role, got %d", res.Code)
+	}
+}
+
+func TestGetStaff(t *testing.T) {
+	member := createUserWithRole(t, "member", models.RoleMember)
+	moderator := createUserWithRole(t, "moderator", models.RoleModerator)
+	admin := createUserWithRole(t, "admin", models.RoleAdmin)
+	getStaff := func(req *http.Request) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		handler.GetStaff(res, req)
+		return res
+	}
+
+	if res := getStaff(httptest.NewRequest(http.MethodGet, "/admin/staff", nil)); res.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a visitor to be asked to sign in, got %d", res.Code)
+	}
+	for _, user := range []models.User{member, moderator} {
+		if res := getStaff(signedInRequest(user, http.MethodGet, "/admin/staff", nil)); res.Code != http.StatusForbidden {
+			t.Errorf("Expected a %s not to list the staff, got %d", user.Role, res.Code)
+		}
+	}
+	res := getStaff(signedInRequest(admin, http.MethodGet, "/admin/staff", nil))
+	if res.Code != http.StatusOK {
+		t.Fatalf("Expected an admin to list the staff, got %d %s", res.Code, res.Body)
+	}
+	body := res.Body.String()
+	if !strings.Contains(body, moderator.ID) || !strings.Contains(body, admin.ID) || strings.Contains(body, member.ID) {
+		t.Errorf("Expected the staff to list the moderators and admins only, got %s", body)
+	}
+
+	// A role granted takes effect on the next request
+	if err := models.UserRepo.SetRole(member.ID, models.RoleAdmin); err != nil {
+		t.Fatalf("Error granting role: %v", err)
+	}
+	if res := getStaff(signedInRequest(member, http.MethodGet, "/admin/staff", nil)); res.Code != http.StatusOK {
+		t.Errorf("Expected a new admin to list the staff, got %d", res.Code)
+	}
+}
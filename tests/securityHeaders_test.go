@@ -0,0 +1,76 @@
+package tests
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"real-time-forum/lib"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	securityHeaders := lib.NewSecurityHeaders(map[string]lib.HeaderPolicy{
+		lib.DefaultHeaderClass: {ContentSecurityPolicy: "default-src 'none'", FrameOptions: "DENY"},
+		"page":                 {ContentSecurityPolicy: "script-src 'nonce-" + lib.NoncePlaceholder + "'", ReferrerPolicy: "same-origin"},
+	})
+	securityHeaders.HSTSMaxAge = time.Hour
+
+	var nonce string
+	page := securityHeaders.Wrap("page", http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		nonce = lib.CSPNonce(req)
+	}))
+	mux := http.NewServeMux()
+	mux.Handle("/page", page)
+	mux.HandleFunc("/api", func(res http.ResponseWriter, req *http.Request) {})
+	server := securityHeaders.Handler(mux)
+
+	res := httptest.NewRecorder()
+	server.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/api", nil))
+	if res.Header().Get("Content-Security-Policy") != "default-src 'none'" || res.Header().Get("X-Frame-Options") != "DENY" ||
+		res.Header().Get("X-Content-Type-Options") != "nosniff" {
+		t.Errorf("Expected the default headers, got %v", res.Header())
+	}
+	if res.Header().Get("Strict-Transport-Security") != "" {
+		t.Error("Expected no HSTS over plain HTTP")
+	}
+
+	res = httptest.NewRecorder()
+	server.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/page", nil))
+	csp := res.Header().Get("Content-Security-Policy")
+	if nonce == "" || csp != "script-src 'nonce-"+nonce+"'" {
+		t.Errorf("Expected the nonce %q of the page in its policy, got %q", nonce, csp)
+	}
+	if res.Header().Get("X-Frame-Options") != "" || res.Header().Get("Referrer-Policy") != "same-origin" {
+		t.Errorf("Expected the page headers to replace the default ones, got %v", res.Header())
+	}
+	first := nonce
+	server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/page", nil))
+	if nonce == first {
+		t.Error("Expected a new nonce for each response")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api", nil)
+	req.TLS = &tls.ConnectionState{}
+	res = httptest.NewRecorder()
+	server.ServeHTTP(res, req)
+	if !strings.HasPrefix(res.Header().Get("Strict-Transport-Security"), "max-age=3600") {
+		t.Errorf("Expected HSTS over HTTPS, got %q", res.Header().Get("Strict-Transport-Security"))
+	}
+}
+
+func TestWebSocketOrigin(t *testing.T) {
+	for publicURL, expected := range map[string]string{
+		"https://forum.example":      "wss://forum.example",
+		"http://localhost:8085":      "ws://localhost:8085",
+		"https://forum.example/path": "wss://forum.example",
+		"localhost:8085":             "",
+		"ftp://forum.example":        "",
+		"":                           "",
+	} {
+		if origin := lib.WebSocketOrigin(publicURL); origin != expected {
+			t.Errorf("Expected %q for %q, got %q", expected, publicURL, origin)
+		}
+	}
+}
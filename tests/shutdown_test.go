@@ -0,0 +1,115 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"real-time-forum/handler"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingTransport keeps the frames sent to a client, unregistering it
+// from its hub once closed like the handlers of the connections do
+type recordingTransport struct {
+	mutex  sync.Mutex
+	frames [][]byte
+	closed bool
+	onExit func()
+}
+
+func (t *recordingTransport) Send(output []byte) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.frames = append(t.frames, output)
+	return nil
+}
+
+func (t *recordingTransport) Close() error {
+	t.mutex.Lock()
+	t.closed = true
+	t.mutex.Unlock()
+	go t.onExit()
+	return nil
+}
+
+func TestNotifyShutdown(t *testing.T) {
+	transports := make([]*recordingTransport, 3)
+	for i := range transports {
+		transport := &recordingTransport{}
+		client := handler.NewClient(transport, httptest.NewRequest(http.MethodGet, "/ws", nil))
+		transport.onExit = func() { handler.Connections.Unregister(client) }
+		handler.Connections.Register(client)
+		transports[i] = transport
+	}
+
+	handler.NotifyShutdown()
+	for _, transport := range transports {
+		transport.mutex.Lock()
+		if !transport.closed || len(transport.frames) != 1 {
+			t.Fatalf("Expected one frame and the connection closed, got %d frames", len(transport.frames))
+		}
+		var event handler.ShutdownEvent
+		if err := json.Unmarshal(transport.frames[0], &event); err != nil || event.Type != "server-shutdown" || event.RetryAfter <= 0 {
+			t.Errorf("Expected a server-shutdown frame, got %s", transport.frames[0])
+		}
+		transport.mutex.Unlock()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := handler.Connections.Drain(ctx); err != nil {
+		t.Errorf("Expected the hub to drain once the connections closed: %v", err)
+	}
+}
+
+func TestHubDrainTimeout(t *testing.T) {
+	hub := handler.NewHub()
+	hub.Register(handler.NewClient(&recordingTransport{onExit: func() {}}, httptest.NewRequest(http.MethodGet, "/ws", nil)))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := hub.Drain(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected the drain to give up at the deadline, got %v", err)
+	}
+}
+
+// stuckTransport is a client that doesn't read, its frames blocking until
+// released
+type stuckTransport struct {
+	release chan struct{}
+}
+
+func (t *stuckTransport) Send(output []byte) error {
+	<-t.release
+	return nil
+}
+
+func (t *stuckTransport) Close() error { return nil }
+
+func TestHubCloseAllParallel(t *testing.T) {
+	hub := handler.NewHub()
+	stuck := &stuckTransport{release: make(chan struct{})}
+	hub.Register(handler.NewClient(stuck, httptest.NewRequest(http.MethodGet, "/ws", nil)))
+	healthy := &recordingTransport{onExit: func() {}}
+	hub.Register(handler.NewClient(healthy, httptest.NewRequest(http.MethodGet, "/ws", nil)))
+
+	done := make(chan int)
+	go func() { done <- hub.CloseAll([]byte(`{"type":"server-shutdown"}`)) }()
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+		healthy.mutex.Lock()
+		closed := healthy.closed
+		healthy.mutex.Unlock()
+		if closed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected a slow client not to hold back the others")
+		}
+	}
+	close(stuck.release)
+	if clients := <-done; clients != 2 {
+		t.Errorf("Expected 2 clients closed, got %d", clients)
+	}
+}
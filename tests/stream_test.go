@@ -0,0 +1,218 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"real-time-forum/data/models"
+	"real-time-forum/handler"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPollPresence(t *testing.T) {
+	user := createTestUser(t, "poller")
+	polls := handler.NewPollPresence(50 * time.Millisecond)
+
+	polls.Begin(user.ID)
+	if presence := handler.Presence(user.ID); presence != handler.PresenceOnline {
+		t.Fatalf("Expected a polling user to be online, got %s", presence)
+	}
+	polls.End(user.ID)
+	polls.Begin(user.ID) // polling again within the grace period
+	polls.Begin(user.ID) // from a second tab
+	polls.End(user.ID)
+	time.Sleep(100 * time.Millisecond)
+	if presence := handler.Presence(user.ID); presence != handler.PresenceOnline {
+		t.Fatalf("Expected a user still polling to stay online, got %s", presence)
+	}
+
+	polls.End(user.ID)
+	if presence := handler.Presence(user.ID); presence != handler.PresenceOnline {
+		t.Errorf("Expected the user to stay online between two polls, got %s", presence)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if presence := handler.Presence(user.ID); presence != handler.PresenceOffline {
+		t.Errorf("Expected the user to go offline once the polls stopped, got %s", presence)
+	}
+}
+
+// publishTestPost publishes a post on a category and returns its sequence number
+func publishTestPost(t *testing.T, author models.User, category, title string) uint64 {
+	t.Helper()
+	handler.SendPost(author.ID, models.PostItem{ID: uniqueName("post"), Title: title, ListOfCategories: []string{category}})
+	return handler.Events.Seq()
+}
+
+// readStreamEvent reads the next event of a Server-Sent Events stream, skipping
+// the comments
+func readStreamEvent(t *testing.T, reader *bufio.Reader) (string, map[string]any) {
+	t.Helper()
+	var id string
+	var event map[string]any
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Error reading stream: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event); err != nil {
+				t.Fatalf("Error decoding event %q: %v", line, err)
+			}
+		case line == "" && event != nil:
+			return id, event
+		}
+	}
+}
+
+// openStream opens an event stream on a topic, resuming from lastEventID if set
+func openStream(t *testing.T, server *httptest.Server, topic, lastEventID string) *bufio.Reader {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/events?topics="+topic, nil)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Error opening stream: %v", err)
+	}
+	t.Cleanup(func() { res.Body.Close() })
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %d %s", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+	return bufio.NewReader(res.Body)
+}
+
+func TestEventStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(handler.EventStream))
+	t.Cleanup(server.Close) // after the streams are closed
+	author := createTestUser(t, "streamer")
+	category := uniqueName("stream")
+	topic := "category:" + category
+
+	// A new stream starts from the current sequence number
+	stream := openStream(t, server, topic, "")
+	if _, event := readStreamEvent(t, stream); event["type"] != "hello" || uint64(event["seq"].(float64)) != handler.Events.Seq() {
+		t.Fatalf("Expected a hello, got %v", event)
+	}
+	seq := publishTestPost(t, author, category, "live")
+	if id, event := readStreamEvent(t, stream); id != fmt.Sprint(seq) || event["post"].(map[string]any)["title"] != "live" {
+		t.Errorf("Expected the live post with its id, got %s %v", id, event)
+	}
+
+	// A reconnection gets the events it missed, in order, then resumes
+	first := publishTestPost(t, author, category, "first missed")
+	second := publishTestPost(t, author, category, "second missed")
+	stream = openStream(t, server, topic, fmt.Sprint(seq))
+	for _, missed := range []struct {
+		seq   uint64
+		title string
+	}{{first, "first missed"}, {second, "second missed"}} {
+		if id, event := readStreamEvent(t, stream); id != fmt.Sprint(missed.seq) || event["post"].(map[string]any)["title"] != missed.title {
+			t.Errorf("Expected %q to be replayed, got %s %v", missed.title, id, event)
+		}
+	}
+	if _, event := readStreamEvent(t, stream); event["type"] != "resumed" || uint64(event["seq"].(float64)) != second {
+		t.Errorf("Expected the stream to resume at %d, got %v", second, event)
+	}
+
+	// Events from a previous run of the server can't be replayed
+	stream = openStream(t, server, topic, fmt.Sprint(handler.Events.Seq()+1000))
+	if _, event := readStreamEvent(t, stream); event["type"] != "resync-required" {
+		t.Errorf("Expected a resync to be required, got %v", event)
+	}
+}
+
+// poll makes a long poll on a topic, from lastSeq if set
+func poll(user *models.User, topic, lastSeq string) (uint64, []map[string]any, int) {
+	path := "/poll?topics=" + topic
+	if lastSeq != "" {
+		path += "&lastSeq=" + lastSeq
+	}
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if user != nil {
+		req = signedInRequest(*user, http.MethodGet, path, nil)
+	}
+	res := httptest.NewRecorder()
+	handler.Poll(res, req)
+	var body struct {
+		Events []map[string]any `json:"events"`
+		Seq    uint64           `json:"seq"`
+	}
+	json.Unmarshal(res.Body.Bytes(), &body)
+	return body.Seq, body.Events, res.Code
+}
+
+func TestPoll(t *testing.T) {
+	timeout := handler.PollTimeout
+	handler.PollTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { handler.PollTimeout = timeout })
+	author := createTestUser(t, "poll_author")
+	category := uniqueName("poll")
+	topic := "category:" + category
+
+	// Nothing happening, the poll answers empty after the timeout
+	current := handler.Events.Seq()
+	start := time.Now()
+	seq, events, code := poll(nil, topic, "")
+	if code != http.StatusOK || len(events) != 0 || seq != current {
+		t.Errorf("Expected an empty answer at %d, got %d %v %d", current, seq, events, code)
+	}
+	if elapsed := time.Since(start); elapsed < handler.PollTimeout {
+		t.Errorf("Expected the poll to wait for events, it answered after %s", elapsed)
+	}
+
+	// The events missed since lastSeq are answered right away
+	first := publishTestPost(t, author, category, "first")
+	second := publishTestPost(t, author, category, "second")
+	seq, events, _ = poll(nil, topic, fmt.Sprint(current))
+	if seq != second || len(events) != 2 || events[0]["post"].(map[string]any)["title"] != "first" || events[1]["post"].(map[string]any)["title"] != "second" {
+		t.Errorf("Expected the missed posts up to %d, got %d %v", second, seq, events)
+	}
+	if seq, events, _ = poll(nil, topic, fmt.Sprint(first)); len(events) != 1 || seq != second {
+		t.Errorf("Expected the post after %d only, got %d %v", first, seq, events)
+	}
+
+	// A poll from a sequence number that can't be replayed asks for a resync
+	seq, events, _ = poll(nil, topic, fmt.Sprint(second+1000))
+	if len(events) != 1 || events[0]["type"] != "resync-required" || seq != second {
+		t.Errorf("Expected a resync to be required at %d, got %d %v", second, seq, events)
+	}
+
+	// A waiting poll answers as soon as an event comes
+	handler.PollTimeout = 5 * time.Second
+	poller := createTestUser(t, "poller")
+	answered := make(chan []map[string]any)
+	go func() {
+		_, events, _ := poll(&poller, topic, fmt.Sprint(second))
+		answered <- events
+	}()
+	eventually(t, func() bool { return handler.Presence(poller.ID) == handler.PresenceOnline }, "Expected the poll to start")
+	start = time.Now()
+	publishTestPost(t, author, category, "awaited")
+	select {
+	case events := <-answered:
+		if len(events) != 1 || events[0]["post"].(map[string]any)["title"] != "awaited" {
+			t.Errorf("Expected the awaited post, got %v", events)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the poll to answer the new event")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the poll to answer without waiting for the timeout, took %s", elapsed)
+	}
+
+	if _, _, code := poll(nil, "moderation", ""); code != http.StatusForbidden {
+		t.Errorf("Expected a visitor not to poll the moderation topic, got %d", code)
+	}
+}
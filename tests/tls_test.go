@@ -0,0 +1,89 @@
+package tests
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"real-time-forum/lib"
+	"testing"
+	"time"
+)
+
+func TestTLSConfig(t *testing.T) {
+	config, err := lib.NewTLSConfig("", nil, nil)
+	if err != nil || config.MinVersion != tls.VersionTLS12 || config.CipherSuites != nil {
+		t.Errorf("Expected TLS 1.2 and the suites of Go by default, got %v", err)
+	}
+	config, err = lib.NewTLSConfig("1.3", []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}, nil)
+	if err != nil || config.MinVersion != tls.VersionTLS13 || len(config.CipherSuites) != 1 {
+		t.Errorf("Expected TLS 1.3 and one suite, got %v", err)
+	}
+	if _, err := lib.NewTLSConfig("1.0", nil, nil); err == nil {
+		t.Error("Expected TLS 1.0 to be refused")
+	}
+	if _, err := lib.NewTLSConfig("", []string{"TLS_RSA_WITH_RC4_128_SHA"}, nil); err == nil {
+		t.Error("Expected an insecure cipher suite to be refused")
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	writeCert := func(host string) {
+		cert, err := lib.NewSelfSignedCertificate([]string{host})
+		if err != nil {
+			t.Fatalf("Error generating certificate: %v", err)
+		}
+		key, _ := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+		os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o600)
+		os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0o600)
+	}
+	leafHosts := func(reloader *lib.CertReloader) []string {
+		cert, _ := reloader.GetCertificate(nil)
+		leaf, _ := x509.ParseCertificate(cert.Certificate[0])
+		return leaf.DNSNames
+	}
+
+	writeCert("first.test")
+	reloader, err := lib.NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("Error loading certificate: %v", err)
+	}
+	if hosts := leafHosts(reloader); len(hosts) != 2 || hosts[1] != "first.test" {
+		t.Fatalf("Expected a certificate for localhost and first.test, got %v", hosts)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go reloader.Watch(10*time.Millisecond, stop)
+	writeCert("second.test")
+	later := time.Now().Add(time.Second) // the file system may not tell apart close writes
+	os.Chtimes(certFile, later, later)
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if hosts := leafHosts(reloader); hosts[1] == "second.test" {
+			return
+		}
+	}
+	t.Error("Expected the renewed certificate to be reloaded")
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	served := http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {})
+	for _, tt := range []struct{ port, host, want string }{
+		{":443", "forum.test:80", "https://forum.test/posts?page=2"},
+		{":8443", "forum.test:8080", "https://forum.test:8443/posts?page=2"},
+		{":8443", "[::1]:8080", "https://[::1]:8443/posts?page=2"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/posts?page=2", nil)
+		req.Host = tt.host
+		res := httptest.NewRecorder()
+		lib.RedirectToHTTPS(tt.port, served).ServeHTTP(res, req)
+		if res.Code != http.StatusPermanentRedirect || res.Header().Get("Location") != tt.want {
+			t.Errorf("Expected a redirect to %s, got %d %s", tt.want, res.Code, res.Header().Get("Location"))
+		}
+	}
+}
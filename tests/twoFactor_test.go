@@ -0,0 +1,79 @@
+package tests
+
+import (
+	"real-time-forum/data/models"
+	"real-time-forum/lib"
+	"testing"
+	"time"
+)
+
+func TestTwoFactorRepository(t *testing.T) {
+	user := createTestUser(t, "cautious")
+
+	secret, _ := lib.NewTOTPSecret()
+	if stored, err := models.TwoFactorRepo.SetPending(user.ID, secret); !stored {
+		t.Fatalf("Expected the secret to be stored (%v)", err)
+	}
+	if enabled, _ := models.TwoFactorRepo.IsEnabled(user.ID); enabled {
+		t.Error("Expected a pending secret not to enable two-factor authentication")
+	}
+	code, _ := lib.TOTPCode(secret, time.Now())
+	step, valid := lib.ValidateTOTP(secret, code, time.Now())
+	if !valid {
+		t.Fatal("Expected the current code to be valid")
+	}
+	if enabled, err := models.TwoFactorRepo.Enable(user.ID, step, []string{"aaaaa-bbbbb", "ccccc-ddddd"}); !enabled {
+		t.Fatalf("Expected two-factor authentication to be enabled (%v)", err)
+	}
+	if stored, _ := models.TwoFactorRepo.SetPending(user.ID, secret); stored {
+		t.Error("Expected an enabled secret not to be replaced")
+	}
+
+	if used, _ := models.TwoFactorRepo.UseStep(user.ID, step); used {
+		t.Error("Expected the code confirming the secret not to sign in again")
+	}
+	if used, _ := models.TwoFactorRepo.UseStep(user.ID, step+1); !used {
+		t.Error("Expected the next code to sign in")
+	}
+
+	if used, _ := models.TwoFactorRepo.UseRecoveryCode(user.ID, "AAAAABBBBB"); !used {
+		t.Error("Expected the recovery code to sign in")
+	}
+	if used, _ := models.TwoFactorRepo.UseRecoveryCode(user.ID, "aaaaa-bbbbb"); used {
+		t.Error("Expected a used recovery code not to sign in again")
+	}
+	if left, _ := models.TwoFactorRepo.RecoveryCodesLeft(user.ID); left != 1 {
+		t.Errorf("Expected 1 recovery code left, got %d", left)
+	}
+
+	if err := models.TwoFactorRepo.Disable(user.ID); err != nil {
+		t.Fatalf("Error disabling two-factor authentication: %v", err)
+	}
+	if twoFactor, _ := models.TwoFactorRepo.Get(user.ID); twoFactor != nil {
+		t.Error("Expected the secret to be forgotten")
+	}
+	if used, _ := models.TwoFactorRepo.UseRecoveryCode(user.ID, "ccccc-ddddd"); used {
+		t.Error("Expected the recovery codes to be forgotten")
+	}
+}
+
+func TestPreAuthToken(t *testing.T) {
+	token, err := models.NewPreAuthToken("some-user", "someone")
+	if err != nil {
+		t.Fatalf("Error creating pre-auth token: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		if preAuth, ok := models.TryPreAuthToken(token); !ok || preAuth.UserID != "some-user" {
+			t.Fatalf("Expected attempt %d to be allowed", i+1)
+		}
+	}
+	if _, ok := models.TryPreAuthToken(token); ok {
+		t.Error("Expected the token to run out of attempts")
+	}
+
+	token, _ = models.NewPreAuthToken("some-user", "someone")
+	models.DeletePreAuthToken(token)
+	if _, ok := models.TryPreAuthToken(token); ok {
+		t.Error("Expected a deleted token to be invalid")
+	}
+}
@@ -0,0 +1,40 @@
+package tests
+
+import (
+	"net/http"
+	"real-time-forum/handler"
+	"testing"
+)
+
+func TestTypingTracker(t *testing.T) {
+	sender, first, second := createTestUser(t, "typist"), createTestUser(t, "reader"), createTestUser(t, "reader")
+	firstClient, secondClient := connectTestClient(t, first), connectTestClient(t, second)
+	typing := handler.NewTypingTracker()
+	client := handler.NewClient(&recordingTransport{}, signedInRequest(sender, http.MethodGet, "/ws", nil))
+
+	if err := typing.Typing(client, sender.ID, []string{first.ID}, true); err != nil {
+		t.Fatalf("Error typing: %v", err)
+	}
+	if events := firstClient.received("typing"); len(events) != 1 || events[0]["isTyping"] != true {
+		t.Fatalf("Expected the recipient to see the user typing, got %v", events)
+	}
+	// The throttle is per user, changing the recipients doesn't bypass it
+	if err := typing.Typing(client, sender.ID, []string{second.ID}, true); err != nil {
+		t.Fatalf("Error typing: %v", err)
+	}
+	if events := secondClient.received("typing"); len(events) != 0 {
+		t.Errorf("Expected the typing events of the user to be throttled, got %v", events)
+	}
+
+	typing.StopAll(client)
+	if events := firstClient.received("typing"); len(events) != 2 || events[1]["isTyping"] != false {
+		t.Errorf("Expected the indicator to stop with the connection, got %v", events)
+	}
+	if events := secondClient.received("typing"); len(events) != 1 || events[0]["isTyping"] != false {
+		t.Errorf("Expected the indicator to stop with the connection, got %v", events)
+	}
+
+	if err := typing.Typing(client, sender.ID, []string{"unknown-user"}, true); err != handler.ErrInvalidRecipients {
+		t.Errorf("Expected an unknown recipient to be refused, got %v", err)
+	}
+}
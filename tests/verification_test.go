@@ -0,0 +1,54 @@
+package tests
+
+import (
+	"real-time-forum/data/models"
+	"testing"
+	"time"
+)
+
+func TestEmailVerification(t *testing.T) {
+	user := createTestUser(t, "newcomer")
+	created, _ := models.UserRepo.GetUserByID(user.ID)
+	if created.EmailVerified {
+		t.Fatal("Expected a new account to be unverified")
+	}
+	if created.Can(models.PermissionMessage) || !created.NeedsVerification(models.PermissionMessage) {
+		t.Error("Expected an unverified user not to send messages")
+	}
+	if !created.Can(models.PermissionRead) {
+		t.Error("Expected an unverified user to read")
+	}
+
+	if last, _ := models.VerificationRepo.LastCreated(user.ID); !last.IsZero() {
+		t.Errorf("Expected no token yet, got one from %v", last)
+	}
+	first, err := models.VerificationRepo.CreateToken(user.ID, user.Email, time.Hour)
+	if err != nil {
+		t.Fatalf("Error creating token: %v", err)
+	}
+	if last, _ := models.VerificationRepo.LastCreated(user.ID); time.Since(last) > time.Minute {
+		t.Errorf("Expected a token created just now, got %v", last)
+	}
+	second, _ := models.VerificationRepo.CreateToken(user.ID, user.Email, time.Hour)
+	if _, _, err := models.VerificationRepo.UseToken(first); err != models.ErrInvalidVerificationToken {
+		t.Errorf("Expected a replaced token to be invalid, got %v", err)
+	}
+	userID, email, err := models.VerificationRepo.UseToken(second)
+	if err != nil || userID != user.ID || email != user.Email {
+		t.Fatalf("Expected the token to verify %s of %s, got %q of %q (%v)", user.Email, user.ID, email, userID, err)
+	}
+	if _, _, err := models.VerificationRepo.UseToken(second); err != models.ErrInvalidVerificationToken {
+		t.Errorf("Expected a used token to be invalid, got %v", err)
+	}
+
+	if verified, _ := models.UserRepo.VerifyEmail(user.ID, "previous@example.com"); verified {
+		t.Error("Expected a token for a previous email not to verify the current one")
+	}
+	if verified, err := models.UserRepo.VerifyEmail(user.ID, email); !verified {
+		t.Fatalf("Expected the email to be verified (%v)", err)
+	}
+	verified, _ := models.UserRepo.GetUserByID(user.ID)
+	if !verified.EmailVerified || !verified.Can(models.PermissionMessage) {
+		t.Error("Expected a verified user to send messages")
+	}
+}